@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"context"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	trailKey
+)
+
+// trail collects what the handler learnt about the request while serving it
+type trail struct {
+	accountID     int64
+	transactionID int64
+}
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// RecordAccount notes the account the request acted on in its audit entry
+func RecordAccount(ctx context.Context, accountID int64) {
+	if t, ok := ctx.Value(trailKey).(*trail); ok {
+		t.accountID = accountID
+	}
+}
+
+// RecordTransaction notes the transaction the request posted in its audit entry
+func RecordTransaction(ctx context.Context, transactionID int64) {
+	if t, ok := ctx.Value(trailKey).(*trail); ok {
+		t.transactionID = transactionID
+	}
+}
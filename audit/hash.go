@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"pismo/models"
+)
+
+// GenesisHash is the previous hash of the very first entry in the chain
+var GenesisHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+var ErrChainBroken = errors.New("audit chain broken")
+
+// hashedFields fixes the order and format of what goes into an entry's hash,
+// changing it invalidates every existing chain
+type hashedFields struct {
+	PrevHash      string                 `json:"prev_hash"`
+	OccurredAt    string                 `json:"occurred_at"`
+	Kind          string                 `json:"kind"`
+	PrincipalID   string                 `json:"principal_id"`
+	RequestID     string                 `json:"request_id"`
+	Method        string                 `json:"method"`
+	Route         string                 `json:"route"`
+	AccountID     int64                  `json:"account_id"`
+	TransactionID int64                  `json:"transaction_id"`
+	PayloadHash   string                 `json:"payload_hash"`
+	Outcome       int                    `json:"outcome"`
+	Changes       []models.BalanceChange `json:"changes"`
+}
+
+// Timestamp truncates to what the database keeps, so hashes still match once
+// the entry has been read back
+func Timestamp(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// Hash is the hex SHA-256 of the entry chained onto its PrevHash
+func Hash(entry models.AuditEntry) string {
+	contents, _ := json.Marshal(hashedFields{
+		PrevHash:      entry.PrevHash,
+		OccurredAt:    Timestamp(entry.OccurredAt).Format(time.RFC3339Nano),
+		Kind:          entry.Kind,
+		PrincipalID:   entry.PrincipalID,
+		RequestID:     entry.RequestID,
+		Method:        entry.Method,
+		Route:         entry.Route,
+		AccountID:     entry.AccountID,
+		TransactionID: entry.TransactionID,
+		PayloadHash:   entry.PayloadHash,
+		Outcome:       entry.Outcome,
+		Changes:       entry.Changes,
+	})
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks the entries of every chain, in ID order as they were appended.
+// last holds the hash each chain carries on from and is moved along with every
+// entry checked, chains not in it start from GenesisHash. It returns an
+// ErrChainBroken naming the first entry that was altered, removed or reordered.
+func Verify(entries []models.AuditEntry, last map[int64]string) error {
+	for _, entry := range entries {
+		prevHash, ok := last[entry.ChainID]
+		if !ok {
+			prevHash = GenesisHash
+		}
+		if entry.PrevHash != prevHash {
+			return fmt.Errorf("%w: entry %d does not follow the one before it", ErrChainBroken, entry.ID)
+		}
+		if Hash(entry) != entry.Hash {
+			return fmt.Errorf("%w: entry %d has been modified", ErrChainBroken, entry.ID)
+		}
+		last[entry.ChainID] = entry.Hash
+	}
+	return nil
+}
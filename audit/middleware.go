@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"pismo/auth"
+	"pismo/models"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+)
+
+// Appender adds entries to the end of the audit log
+type Appender interface {
+	AppendAuditEntry(entry models.AuditEntry) (models.AuditEntry, error)
+}
+
+// Recorder writes an audit entry for every state changing request
+type Recorder struct {
+	log Appender
+	now func() time.Time
+}
+
+func NewRecorder(log Appender) *Recorder {
+	return &Recorder{log: log, now: time.Now}
+}
+
+// Middleware gives every request an ID, echoed in the X-Request-ID header, and
+// once a POST, PUT, PATCH or DELETE has been served records who made it, a hash
+// of its payload and the status it got. It has to run after the auth middleware.
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" || len(requestID) > 64 {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		ctx := WithRequestID(r.Context(), requestID)
+
+		if !changesState(r.Method) {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		// hash the payload as the handler reads it rather than buffering it here,
+		// batch uploads can be large
+		var payload *hashingBody
+		if r.Body != nil && r.Body != http.NoBody {
+			payload = &hashingBody{body: r.Body, hash: sha256.New()}
+			r.Body = payload
+		}
+
+		t := &trail{}
+		ctx = context.WithValue(ctx, trailKey, t)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		payloadHash := ""
+		if payload != nil {
+			payloadHash = payload.sum()
+		}
+
+		principal, _ := auth.PrincipalFromContext(r.Context())
+		entry := models.AuditEntry{
+			OccurredAt:    rec.now(),
+			Kind:          models.AuditKindRequest,
+			PrincipalID:   principal.ID,
+			RequestID:     requestID,
+			Method:        r.Method,
+			Route:         routeTemplate(r),
+			AccountID:     t.accountID,
+			TransactionID: t.transactionID,
+			PayloadHash:   payloadHash,
+			Outcome:       sw.status,
+		}
+		// the response has already been sent, a failed append can only be logged
+		if _, err := rec.log.AppendAuditEntry(entry); err != nil {
+			log.Printf("failed to write audit entry for request %s: %v", requestID, err)
+		}
+	})
+}
+
+func changesState(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// hashingBody hashes a request body as it is read. Whatever the handler left
+// unread, up to maxUnreadPayload, is hashed too so the hash covers the whole payload.
+type hashingBody struct {
+	body io.ReadCloser
+	hash hash.Hash
+}
+
+const maxUnreadPayload = 1 << 20
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.hash.Write(p[:n])
+	return n, err
+}
+
+func (b *hashingBody) Close() error {
+	return b.body.Close()
+}
+
+func (b *hashingBody) sum() string {
+	io.Copy(b.hash, io.LimitReader(b.body, maxUnreadPayload))
+	return hex.EncodeToString(b.hash.Sum(nil))
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
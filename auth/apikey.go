@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"pismo/models"
+)
+
+// ErrCredentialLookup is returned when the API key could not be looked up at all,
+// a store failure rather than a bad credential
+var ErrCredentialLookup = errors.New("failed to look up credentials")
+
+// APIKeyStore looks up issued API keys by their public key ID
+type APIKeyStore interface {
+	GetAPIKeyByID(keyID string) (models.APIKey, error)
+}
+
+// HashAPIKeySecret is the hash stored in ApiKeys.key_hash for a secret
+func HashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyAPIKey checks a key presented as "<key_id>.<secret>"
+func verifyAPIKey(keys APIKeyStore, presented string) (Principal, error) {
+	keyID, secret, ok := strings.Cut(presented, ".")
+	if !ok || keyID == "" || secret == "" {
+		return Principal{}, errors.New("malformed API key")
+	}
+
+	key, err := keys.GetAPIKeyByID(keyID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Principal{}, errors.New("invalid API key")
+		}
+		return Principal{}, fmt.Errorf("%w: %w", ErrCredentialLookup, err)
+	}
+
+	hash := HashAPIKeySecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(key.KeyHash)) != 1 {
+		return Principal{}, errors.New("invalid API key")
+	}
+	if key.RevokedAt != nil {
+		return Principal{}, errors.New("API key has been revoked")
+	}
+
+	return Principal{
+		ID:       key.ClientID,
+		Roles:    key.Roles,
+		TenantID: key.TenantID,
+		Method:   MethodAPIKey,
+	}, nil
+}
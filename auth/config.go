@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type issuerConfig struct {
+	Issuer        string `json:"issuer"`
+	Algorithm     string `json:"algorithm"`
+	Secret        string `json:"secret"`          // HS256
+	PublicKeyFile string `json:"public_key_file"` // RS256, PEM encoded, relative to the config file
+	Audience      string `json:"audience"`
+}
+
+type config struct {
+	Issuers []issuerConfig `json:"issuers"`
+}
+
+// LoadIssuers reads the trusted JWT issuers from a JSON config file, e.g.
+// {"issuers": [{"issuer": "https://idp.example.com", "algorithm": "RS256", "public_key_file": "idp.pem"}]}
+func LoadIssuers(path string) ([]Issuer, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth config: %w", err)
+	}
+
+	var cfg config
+	if err := json.Unmarshal(contents, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse auth config: %w", err)
+	}
+
+	var issuers []Issuer
+	for _, ic := range cfg.Issuers {
+		issuer := Issuer{Name: ic.Issuer, Algorithm: ic.Algorithm, Audience: ic.Audience}
+		switch ic.Algorithm {
+		case AlgorithmHS256:
+			if ic.Secret == "" {
+				return nil, fmt.Errorf("issuer %s: HS256 requires a secret", ic.Issuer)
+			}
+			issuer.Secret = []byte(ic.Secret)
+		case AlgorithmRS256:
+			keyPath := ic.PublicKeyFile
+			if !filepath.IsAbs(keyPath) {
+				keyPath = filepath.Join(filepath.Dir(path), keyPath)
+			}
+			issuer.PublicKey, err = loadRSAPublicKey(keyPath)
+			if err != nil {
+				return nil, fmt.Errorf("issuer %s: %w", ic.Issuer, err)
+			}
+		default:
+			return nil, fmt.Errorf("issuer %s: unsupported algorithm %q", ic.Issuer, ic.Algorithm)
+		}
+		issuers = append(issuers, issuer)
+	}
+	return issuers, nil
+}
+
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an RSA key")
+	}
+	return key, nil
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	APIKeyHeader = "X-API-Key"
+)
+
+var errMissingCredentials = errors.New("missing credentials, send an X-API-Key header or an Authorization: Bearer token")
+
+// Authenticator resolves the principal of a request from either an API key in
+// the X-API-Key header or a bearer JWT in the Authorization header
+type Authenticator struct {
+	apiKeys APIKeyStore
+	issuers map[string]Issuer
+	now     func() time.Time
+}
+
+func NewAuthenticator(apiKeys APIKeyStore, issuers []Issuer) *Authenticator {
+	byName := make(map[string]Issuer, len(issuers))
+	for _, issuer := range issuers {
+		byName[issuer.Name] = issuer
+	}
+	return &Authenticator{apiKeys: apiKeys, issuers: byName, now: time.Now}
+}
+
+// Middleware rejects unauthenticated requests with 401 and stores the principal
+// on the request context for everything else. Failing to look the credentials up
+// is a 500, and the store error stays in the log rather than the response.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.authenticate(r)
+		if errors.Is(err, ErrCredentialLookup) {
+			log.Printf("failed to authenticate request: %v", err)
+			WriteError(w, http.StatusInternalServerError, "could not verify credentials, try again later")
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pismo"`)
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (Principal, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return verifyAPIKey(a.apiKeys, key)
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return Principal{}, errMissingCredentials
+	}
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return Principal{}, errMissingCredentials
+	}
+	return verifyJWT(a.issuers, strings.TrimSpace(token), a.now())
+}
+
+// RequireAnyRole rejects authenticated principals that carry none of the given
+// roles with 403. It has to run after the authenticator middleware.
+func RequireAnyRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				WriteError(w, http.StatusUnauthorized, errMissingCredentials.Error())
+				return
+			}
+			for _, role := range roles {
+				if principal.HasRole(role) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			WriteError(w, http.StatusForbidden, "principal is not allowed to access this resource")
+		})
+	}
+}
+
+// WriteError writes the {"error": "..."} body documented in the README
+func WriteError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: msg})
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"pismo/models"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Action is something a principal can be allowed to do
+type Action string
+
+const (
+	ActionReadAccount         Action = "account:read"
+	ActionCreateAccount       Action = "account:create"
+	ActionUpdateAccount       Action = "account:update"
+	ActionReadTransaction     Action = "transaction:read"
+	ActionCreateTransaction   Action = "transaction:create"
+	ActionReverseTransaction  Action = "transaction:reverse"
+	ActionCreateOperationType Action = "operation_type:create"
+	ActionReadAuthorization   Action = "authorization:read"
+	ActionManageAuthorization Action = "authorization:manage"
+	ActionReadAuditLog        Action = "audit_log:read"
+	ActionVerifyAuditLog      Action = "audit_log:verify"
+	ActionReadLedger          Action = "ledger:read"
+)
+
+// what each role may do, a principal may do anything any of its roles allows
+var rolePermissions = map[string][]Action{
+	RoleAdmin: {
+		ActionReadAccount, ActionCreateAccount, ActionUpdateAccount,
+		ActionReadTransaction, ActionCreateTransaction, ActionReverseTransaction,
+		ActionCreateOperationType,
+		ActionReadAuthorization, ActionManageAuthorization,
+		ActionReadAuditLog, ActionVerifyAuditLog,
+		ActionReadLedger,
+	},
+	RoleSupport: {
+		ActionReadAccount,
+		ActionReadTransaction,
+		ActionReadAuthorization,
+		ActionReadAuditLog,
+		ActionReadLedger,
+	},
+	RolePartner: {
+		ActionReadAccount, ActionCreateAccount, ActionUpdateAccount,
+		ActionReadTransaction, ActionCreateTransaction,
+		ActionReadAuthorization, ActionManageAuthorization,
+	},
+}
+
+// roles that only ever see accounts belonging to their own tenant
+var tenantScopedRoles = map[string]bool{
+	RolePartner: true,
+}
+
+// Policy decides what an authenticated principal is allowed to do
+type Policy struct{}
+
+func NewPolicy() *Policy {
+	return &Policy{}
+}
+
+// Authorize checks the principal has a role that allows the action at all
+func (p *Policy) Authorize(principal Principal, action Action) error {
+	for _, role := range principal.Roles {
+		if roleAllows(role, action) {
+			return nil
+		}
+	}
+	return forbidden(principal, action)
+}
+
+// IsTenantScoped reports whether the principal may only perform the action on
+// accounts of its own tenant, i.e. no unscoped role of theirs allows it
+func (p *Policy) IsTenantScoped(principal Principal, action Action) bool {
+	for _, role := range principal.Roles {
+		if roleAllows(role, action) && !tenantScopedRoles[role] {
+			return false
+		}
+	}
+	return true
+}
+
+// AuthorizeAccount checks the principal may perform the action on this specific account
+func (p *Policy) AuthorizeAccount(principal Principal, action Action, account models.Account) error {
+	if err := p.Authorize(principal, action); err != nil {
+		return err
+	}
+	if !p.IsTenantScoped(principal, action) {
+		return nil
+	}
+	if principal.TenantID == "" || principal.TenantID != account.TenantID {
+		return fmt.Errorf("%w: %s cannot access account %s", ErrForbidden, principal.ID, account.PublicID)
+	}
+	return nil
+}
+
+func roleAllows(role string, action Action) bool {
+	for _, allowed := range rolePermissions[role] {
+		if allowed == action {
+			return true
+		}
+	}
+	return false
+}
+
+func forbidden(principal Principal, action Action) error {
+	if principal.ID == "" {
+		return fmt.Errorf("%w: no authenticated principal", ErrForbidden)
+	}
+	return fmt.Errorf("%w: %s is not allowed to %s", ErrForbidden, principal.ID, action)
+}
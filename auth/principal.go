@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+)
+
+// roles a principal can carry, what each one is allowed to do is up to the caller
+const (
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+	RolePartner = "partner"
+)
+
+// authentication methods a principal can come from
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	ID       string   `json:"id"` // API key client ID or JWT subject
+	Roles    []string `json:"roles"`
+	TenantID string   `json:"tenant_id"`
+	Method   string   `json:"method"`
+}
+
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal the auth middleware stored on the request context
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,224 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"pismo/helpers"
+	"pismo/models"
+	"pismo/publicid"
+)
+
+const (
+	FormatJSON  = "json"  // a JSON array of transactions
+	FormatCSV   = "csv"   // a header row, then one transaction per row
+	FormatJSONL = "jsonl" // one JSON transaction per line
+
+	MaxRows = 10000 // larger uploads have to be split
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported batch format")
+	ErrTooManyRows       = fmt.Errorf("batch has more than %d rows", MaxRows)
+)
+
+// FormatFromContentType maps the Content-Type of an upload to its format
+func FormatFromContentType(contentType string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, contentType)
+	}
+	switch mediaType {
+	case "application/json":
+		return FormatJSON, nil
+	case "text/csv":
+		return FormatCSV, nil
+	case "application/jsonl", "application/x-ndjson", "application/x-jsonlines":
+		return FormatJSONL, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, mediaType)
+}
+
+// FormatFromPath picks the format from a file extension
+func FormatFromPath(path string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		return FormatJSON, nil
+	case ".csv":
+		return FormatCSV, nil
+	case ".jsonl", ".ndjson":
+		return FormatJSONL, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, ext)
+	}
+}
+
+// Parse reads every row of an upload. Rows that can't be parsed or fail
+// validation come back with Error set, the error returned is only for uploads
+// that can't be read at all.
+func Parse(r io.Reader, format string) ([]models.BatchRow, error) {
+	var rows []models.BatchRow
+	var err error
+	switch format {
+	case FormatJSON:
+		rows, err = parseJSON(r)
+	case FormatCSV:
+		rows, err = parseCSV(r)
+	case FormatJSONL:
+		rows, err = parseJSONL(r)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		if rows[i].Error != "" {
+			continue
+		}
+		if err := validate(rows[i].Transaction); err != nil {
+			rows[i].Error = err.Error()
+		}
+	}
+	return rows, nil
+}
+
+func validate(t models.Transaction) error {
+	if err := helpers.ValidateTransactionRequest(t); err != nil {
+		return err
+	}
+	return helpers.ValidateOperationDirection(t.OperationTypeID, t.Amount)
+}
+
+func parseJSON(r io.Reader) ([]models.BatchRow, error) {
+	// decode rows one by one so a bad row doesn't sink the whole upload
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON batch, expected an array of transactions: %w", err)
+	}
+	if len(raw) > MaxRows {
+		return nil, ErrTooManyRows
+	}
+
+	rows := make([]models.BatchRow, len(raw))
+	for i, message := range raw {
+		rows[i] = decodeJSONRow(i+1, message)
+	}
+	return rows, nil
+}
+
+func parseJSONL(r io.Reader) ([]models.BatchRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var rows []models.BatchRow
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(rows) == MaxRows {
+			return nil, ErrTooManyRows
+		}
+		rows = append(rows, decodeJSONRow(len(rows)+1, line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read JSONL batch: %w", err)
+	}
+	return rows, nil
+}
+
+func decodeJSONRow(row int, message []byte) models.BatchRow {
+	var t models.Transaction
+	if err := json.Unmarshal(message, &t); err != nil {
+		return models.BatchRow{Row: row, Error: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	return models.BatchRow{Row: row, Transaction: t}
+}
+
+func parseCSV(r io.Reader) ([]models.BatchRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // short rows are reported per row
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"account_id", "operation_type_id", "amount"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %s column", required)
+		}
+	}
+
+	var rows []models.BatchRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if len(rows) == MaxRows {
+			return nil, ErrTooManyRows
+		}
+		row := models.BatchRow{Row: len(rows) + 1}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("failed to read CSV batch: %w", err)
+			}
+			row.Error = err.Error()
+		} else {
+			row.Transaction, err = csvTransaction(record, columns)
+			if err != nil {
+				row.Error = err.Error()
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func csvTransaction(record []string, columns map[string]int) (models.Transaction, error) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var t models.Transaction
+	var err error
+	t.AccountPublicID = models.PublicID(field("account_id"))
+	if _, ok := t.AccountPublicID.Legacy(); !ok && !publicid.Valid(field("account_id"), publicid.Account) {
+		return models.Transaction{}, fmt.Errorf("Invalid account ID: %q", field("account_id"))
+	}
+	if t.OperationTypeID, err = strconv.Atoi(field("operation_type_id")); err != nil {
+		return models.Transaction{}, fmt.Errorf("Invalid operation type ID: %q", field("operation_type_id"))
+	}
+	if t.Amount, err = strconv.ParseFloat(field("amount"), 64); err != nil {
+		return models.Transaction{}, fmt.Errorf("Invalid amount: %q", field("amount"))
+	}
+	t.Currency = field("currency")
+	if v := field("event_date"); v != "" {
+		if t.EventDate, err = time.Parse(time.RFC3339, v); err != nil {
+			return models.Transaction{}, fmt.Errorf("Invalid event_date, must be an RFC 3339 timestamp: %q", v)
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"pismo/batch"
+	"pismo/loadtest"
+	"pismo/models"
+	"pismo/services"
+)
+
+const usage = `usage: pismo [command] [flags]
+
+Without a command the API server is started. Commands:
+  import [-format json|csv|jsonl] [-concurrency n] <file>   post a file of transactions
+  reconcile [-account id] [-repair]                          check stored balances against a replay
+  archive [-account id] [-older-than duration]               move settled transactions to the archive tables
+  loadtest [-accounts 1,2|-new-accounts n] [-workers n] [-operations n] [-credit-ratio r] [-max-amount a] [-seed s]
+                                                             post a concurrent workload and check the balances add up
+`
+
+// runCommand runs an offline command and returns the process exit code
+func runCommand(name string, args []string) int {
+	switch name {
+	case "import":
+		return runImport(args)
+	case "reconcile":
+		return runReconcile(args)
+	case "archive":
+		return runArchive(args)
+	case "loadtest":
+		return runLoadTest(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+}
+
+// runImport posts every row of a settlement file the same way POST
+// /transactions/batch does, printing the per row report as JSON. It exits 1
+// when any row wasn't created.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "file format, json, csv or jsonl, defaults to the file extension")
+	concurrency := flags.Int("concurrency", services.DefaultBatchConcurrency, "accounts posted to at the same time")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	path := flags.Arg(0)
+
+	if *format == "" {
+		var err error
+		if *format, err = batch.FormatFromPath(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	rows, err := batch.Parse(file, *format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	conn, db := openRepository()
+	defer conn.Close()
+
+	transactionService := services.NewTransactionService(db, loadRates(), backdatingWindow())
+	report := services.NewBatchService(transactionService, services.NewAccountService(db), *concurrency).ProcessBatch(rows)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d rows: %d created, %d rejected, %d failed\n", report.Total, report.Created, report.Rejected, report.Failed)
+	if report.Created != report.Total {
+		return 1
+	}
+	return 0
+}
+
+// runReconcile replays the transactions of one or every account through the
+// discharge rules and prints the balances that don't match as JSON. With
+// -repair the stored balances, allocations and running totals are rebuilt,
+// otherwise it exits 1 when anything doesn't match.
+func runReconcile(args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	accountID := flags.Int64("account", 0, "only reconcile this account")
+	repair := flags.Bool("repair", false, "overwrite the stored balances with the replayed ones")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	conn, db := openRepository()
+	defer conn.Close()
+
+	service := services.NewReconciliationService(db)
+	var report models.ReconciliationReport
+	if *accountID != 0 {
+		result, err := service.ReconcileAccount(*accountID, *repair)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		report = models.ReconciliationReport{Accounts: 1, Transactions: result.Transactions, Discrepancies: result.Discrepancies, StaleAllocations: []int64{}, StaleTotals: []int64{}, Repaired: *repair}
+		if result.StaleAllocations {
+			report.StaleAllocations = append(report.StaleAllocations, *accountID)
+		}
+		if result.StaleTotals {
+			report.StaleTotals = append(report.StaleTotals, *accountID)
+		}
+	} else {
+		var err error
+		if report, err = service.Reconcile(*repair); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d accounts, %d transactions: %d discrepancies, %d stale allocation histories, %d stale totals, %d accounts failed\n",
+		report.Accounts, report.Transactions, len(report.Discrepancies), len(report.StaleAllocations), len(report.StaleTotals), len(report.Errors))
+	if len(report.Errors) > 0 || (len(report.Discrepancies)+len(report.StaleAllocations)+len(report.StaleTotals) > 0 && !*repair) {
+		return 1
+	}
+	return 0
+}
+
+// runArchive moves the settled transactions of one or every account posted more
+// than -older-than ago out of the hot tables, printing the report as JSON. It
+// exits 1 when any account failed.
+func runArchive(args []string) int {
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	accountID := flags.Int64("account", 0, "only archive this account")
+	olderThan := flags.Duration("older-than", services.DefaultArchiveAge, "how long ago transactions must have been posted")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 || *olderThan <= 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	conn, db := openRepository()
+	defer conn.Close()
+
+	service := services.NewArchiveService(db)
+	postedBefore := time.Now().UTC().Add(-*olderThan)
+	var report models.ArchiveReport
+	if *accountID != 0 {
+		archived, err := service.ArchiveAccount(*accountID, postedBefore)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		report = models.ArchiveReport{PostedBefore: postedBefore, Accounts: 1, Transactions: archived}
+	} else {
+		var err error
+		if report, err = service.Archive(postedBefore); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d accounts: %d transactions archived, %d accounts failed\n", report.Accounts, report.Transactions, len(report.Errors))
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runLoadTest posts a random mix of debits and credits to a few accounts from
+// many goroutines at once, then checks no balance update was lost and the
+// ledger still balances, printing the report as JSON. It exits 1 when any
+// invariant doesn't hold. Without -accounts it opens fresh accounts to post to,
+// accounts with transactions from before discharge allocations were recorded
+// need a reconcile -repair first.
+func runLoadTest(args []string) int {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	accounts := flags.String("accounts", "", "comma separated IDs of the accounts to post to")
+	newAccounts := flags.Int("new-accounts", 4, "fresh accounts to open and post to when -accounts isn't set")
+	workers := flags.Int("workers", loadtest.DefaultWorkers, "transactions posted at the same time")
+	operations := flags.Int("operations", loadtest.DefaultOperations, "transactions to post")
+	creditRatio := flags.Float64("credit-ratio", loadtest.DefaultCreditRatio, "share of the transactions that are credit vouchers")
+	maxAmount := flags.Float64("max-amount", loadtest.DefaultMaxAmount, "largest amount posted")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "seed of the workload, the same seed posts the same transactions")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 || *creditRatio < 0 || *creditRatio > 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	var accountIDs []int64
+	if *accounts != "" {
+		for _, id := range strings.Split(*accounts, ",") {
+			accountID, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+			if err != nil || accountID <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid account ID %q\n", id)
+				return 2
+			}
+			accountIDs = append(accountIDs, accountID)
+		}
+	}
+
+	conn, db := openRepository()
+	defer conn.Close()
+
+	if len(accountIDs) == 0 {
+		accountService := services.NewAccountService(db)
+		for i := 0; i < *newAccounts; i++ {
+			// passports only need to be alphanumeric, so they're easy to make up
+			account, err := accountService.CreateAccount(models.Account{
+				DocumentType:   models.DocumentTypePassport,
+				DocumentNumber: fmt.Sprintf("LT%07d", rand.Intn(10000000)),
+				HolderName:     "Load Test",
+			})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			accountIDs = append(accountIDs, account.ID)
+		}
+	}
+
+	config := loadtest.Config{
+		Accounts:    accountIDs,
+		Workers:     *workers,
+		Operations:  *operations,
+		CreditRatio: *creditRatio,
+		MaxAmount:   *maxAmount,
+		Seed:        *seed,
+	}
+	transactionService := services.NewTransactionService(db, loadRates(), backdatingWindow())
+	report, err := loadtest.Run(config, transactionService, db, services.NewLedgerService(db))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d transactions on %d accounts with seed %d: %d created, %d failed, %.0f/s, %d violations\n",
+		report.Operations, len(report.Accounts), *seed, report.Created, report.Failed, report.PerSecond, len(report.Violations))
+	if !report.Passed {
+		return 1
+	}
+	return 0
+}
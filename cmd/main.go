@@ -1,41 +1,213 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 
+	"pismo/audit"
+	"pismo/auth"
 	"pismo/database"
+	"pismo/fx"
 	"pismo/handlers"
+	"pismo/models"
+	"pismo/ratelimit"
 	"pismo/services"
 	"pismo/store"
 )
 
 func main() {
-	conn, err := database.Connect()
-	if err != nil {
-		log.Fatal(err)
+	// go run ./cmd starts the API, go run ./cmd <command> runs one of the offline commands
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
 	}
+	serve()
+}
+
+func serve() {
+	conn, db := openRepository()
 	defer conn.Close()
 
-	db := store.NewRepository(conn)
+	// numeric account ids keep working until ACCEPT_LEGACY_IDS=false
+	if v := os.Getenv("ACCEPT_LEGACY_IDS"); v != "" {
+		accept, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("invalid ACCEPT_LEGACY_IDS: %s", v)
+		}
+		models.AcceptLegacyIDs = accept
+	}
+
+	var err error
+	var issuers []auth.Issuer
+	if path := os.Getenv("AUTH_CONFIG_FILE"); path != "" {
+		issuers, err = auth.LoadIssuers(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	authenticator := auth.NewAuthenticator(db, issuers)
+
+	limits := ratelimit.DefaultConfig()
+	if path := os.Getenv("RATE_LIMIT_CONFIG_FILE"); path != "" {
+		limits, err = ratelimit.LoadConfig(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	recorder := audit.NewRecorder(db)
+
+	// what each role may do once authenticated, partners only on their own tenant's accounts
+	policy := auth.NewPolicy()
+
+	// account and ledger reads may come from a replica, what's written goes through
+	// the primary and so do the reads deciding what to write
+	replicated, closeReplicas := openReplicas(db)
+	defer closeReplicas()
+
+	accountService := services.NewAccountService(replicated)
+	accountHandler := handlers.NewAccountHandler(accountService, policy)
+
+	// accounts are limited by their internal id, whichever id the client named them by
+	throttler := ratelimit.NewThrottler(limits, accountService)
 
 	r := mux.NewRouter()
+	// every address is limited before auth so failed attempts count too. Every route
+	// needs an authenticated caller with at least one known role, audit entries and
+	// the other limits are keyed by that caller so they come after auth
+	r.Use(throttler.IPMiddleware, authenticator.Middleware, auth.RequireAnyRole(auth.RoleAdmin, auth.RoleSupport, auth.RolePartner), recorder.Middleware, throttler.Middleware)
+
+	rates := loadRates()
+
+	transactionService := services.NewTransactionService(db, rates, backdatingWindow())
+	transactionHandler := handlers.NewTransactionHandler(transactionService, accountService, policy)
+
+	// rows may name accounts opened moments ago, those aren't on the replicas yet
+	batchService := services.NewBatchService(transactionService, accountService.ReadYourWrites(), services.DefaultBatchConcurrency)
+	batchHandler := handlers.NewBatchHandler(batchService, accountService, policy)
+
+	authorizationService := services.NewAuthorizationService(db, transactionService, rates, services.DefaultHoldTTL)
+	authorizationHandler := handlers.NewAuthorizationHandler(authorizationService, accountService, policy)
+
+	auditService := services.NewAuditService(db)
+	auditHandler := handlers.NewAuditHandler(auditService, policy)
 
-	accountService := services.NewAccountService(db)
-	accountHandler := handlers.NewAccountHandler(accountService)
+	ledgerService := services.NewLedgerService(replicated)
+	ledgerHandler := handlers.NewLedgerHandler(ledgerService, policy)
 
-	transactionService := services.NewTransactionService(db)
-	transactionHandler := handlers.NewTransactionHandler(transactionService)
+	// pending holds past their expiry stop counting against the limit straight away,
+	// the sweeper just keeps their status up to date
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go services.RunAuthorizationExpirySweeper(ctx, authorizationService, time.Minute)
+
+	// balances are overwritten in place by discharge, optionally keep checking they
+	// still add up, e.g. RECONCILE_INTERVAL=1h
+	if interval := os.Getenv("RECONCILE_INTERVAL"); interval != "" {
+		every, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("invalid RECONCILE_INTERVAL: %v", err)
+		}
+		go services.RunReconciliationJob(ctx, services.NewReconciliationService(db), every)
+	}
 
 	r.HandleFunc("/accounts/{id}", accountHandler.HandleGetAccount).Methods("GET")
+	r.HandleFunc("/accounts/{id}/balance", accountHandler.HandleGetAccountBalance).Methods("GET")
+	r.HandleFunc("/accounts/{id}/totals", accountHandler.HandleGetAccountTotals).Methods("GET")
+	r.HandleFunc("/accounts/{id}", accountHandler.HandleUpdateAccount).Methods("PATCH")
 	r.HandleFunc("/accounts", accountHandler.HandleCreateAccount).Methods("POST")
 	r.HandleFunc("/transactions", transactionHandler.HandleCreateTransaction).Methods("POST")
-	r.HandleFunc("/transactions-race-condition", transactionHandler.HandleCreateTransactionRaceCondition).Methods("POST")
+	r.HandleFunc("/transactions/batch", batchHandler.HandleCreateTransactionBatch).Methods("POST")
+	r.HandleFunc("/authorizations", authorizationHandler.HandleCreateAuthorization).Methods("POST")
+	r.HandleFunc("/authorizations/{id}", authorizationHandler.HandleGetAuthorization).Methods("GET")
+	r.HandleFunc("/authorizations/{id}/capture", authorizationHandler.HandleCaptureAuthorization).Methods("POST")
+	r.HandleFunc("/authorizations/{id}/void", authorizationHandler.HandleVoidAuthorization).Methods("POST")
+	r.HandleFunc("/audit-log", auditHandler.HandleListAuditEntries).Methods("GET")
+	r.HandleFunc("/audit-log/verify", auditHandler.HandleVerifyAuditLog).Methods("GET")
+	r.HandleFunc("/ledger/trial-balance", ledgerHandler.HandleGetTrialBalance).Methods("GET")
+	r.Handle("/debug/vars", expvar.Handler()).Methods("GET")
 
 	fmt.Println("Server is running on port 8080...")
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
+
+// openRepository connects to the database and brings its schema up to date
+func openRepository() (*sql.DB, *store.Repository) {
+	config := database.ConfigFromEnv()
+	dialect, err := store.DialectFor(config.Driver)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn, err := database.Connect(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := database.Migrate(conn, config); err != nil {
+		conn.Close()
+		log.Fatal(err)
+	}
+	return conn, store.NewRepository(conn, dialect)
+}
+
+// openReplicas is the repository with the replicas in DATABASE_REPLICA_URLS to
+// read from, or repo itself when there are none, and what closes them.
+// DB_REPLICA_MAX_STALENESS is how far behind the primary they may be, e.g. 500ms,
+// a second by default. MySQL replicas only report their lag in whole seconds,
+// any lag they report counts as a second more and less than a second can't be
+// told apart from none, so on MySQL bounds under 2s only take replicas that
+// report no lag at all, and those may still be up to a second behind.
+func openReplicas(repo *store.Repository) (*store.Repository, func()) {
+	replicas, err := database.ConnectReplicas(database.ConfigFromEnv())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(replicas) == 0 {
+		return repo, func() {}
+	}
+
+	maxStaleness := time.Second
+	if v := os.Getenv("DB_REPLICA_MAX_STALENESS"); v != "" {
+		maxStaleness, err = time.ParseDuration(v)
+		if err != nil || maxStaleness <= 0 {
+			log.Fatalf("invalid DB_REPLICA_MAX_STALENESS: %s", v)
+		}
+	}
+	closeReplicas := func() {
+		for _, replica := range replicas {
+			replica.Close()
+		}
+	}
+	return store.NewRepository(repo.DB, repo.Dialect, store.WithReplicas(maxStaleness, replicas...)), closeReplicas
+}
+
+func loadRates() fx.RateProvider {
+	if path := os.Getenv("FX_RATES_FILE"); path != "" {
+		rates, err := fx.LoadStaticRateProvider(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return rates
+	}
+	return fx.NewStaticRateProvider(nil)
+}
+
+// backdatingWindow is how far back clients may date transactions, e.g. BACKDATING_WINDOW=168h
+func backdatingWindow() time.Duration {
+	window := os.Getenv("BACKDATING_WINDOW")
+	if window == "" {
+		return services.DefaultBackdatingWindow
+	}
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration < 0 {
+		log.Fatalf("invalid BACKDATING_WINDOW: %s", window)
+	}
+	return duration
+}
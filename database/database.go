@@ -4,18 +4,84 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net/url"
+	"os"
+	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
-func Connect() (*sql.DB, error) {
-    user := "root"
-    password := "root"
-	hostname := "localhost:3306" // using localhost for now
-    dbname := "pismo_db"
+// the database/sql drivers the service can run on
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// used when DATABASE_URL isn't set, the local docker MySQL and a file next to the binary
+const (
+	defaultMySQLDSN  = "root:root@tcp(localhost:3306)/pismo_db?parseTime=true"
+	defaultSQLiteDSN = "file:pismo.db"
+)
+
+// what every SQLite connection needs on top of the DSN it was given. Foreign keys
+// are off by default, BEGIN IMMEDIATE stands in for the row locks SQLite doesn't
+// have and times have to be written in a format that sorts as text.
+var sqliteParams = url.Values{
+	"_pragma":      {"foreign_keys(1)", "busy_timeout(5000)", "journal_mode(WAL)"},
+	"_txlock":      {"immediate"},
+	"_time_format": {"sqlite"},
+}
+
+// Config is which database to connect to. SeedFile is SQL run on a new database
+// right after its baseline, see ApplyMigrations. ReplicaDSNs are read replicas of
+// the database, of the same driver.
+type Config struct {
+	Driver      string
+	DSN         string
+	SeedFile    string
+	ReplicaDSNs []string
+}
+
+// ConfigFromEnv reads the database from DB_DRIVER, DATABASE_URL and DB_SEED_FILE,
+// falling back to the local MySQL, and its replicas from the comma separated
+// DATABASE_REPLICA_URLS
+func ConfigFromEnv() Config {
+	config := Config{Driver: os.Getenv("DB_DRIVER"), DSN: os.Getenv("DATABASE_URL"), SeedFile: os.Getenv("DB_SEED_FILE")}
+	for _, dsn := range strings.Split(os.Getenv("DATABASE_REPLICA_URLS"), ",") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			config.ReplicaDSNs = append(config.ReplicaDSNs, dsn)
+		}
+	}
+	if config.Driver == "" {
+		config.Driver = DriverMySQL
+	}
+	if config.DSN == "" {
+		switch config.Driver {
+		case DriverMySQL:
+			config.DSN = defaultMySQLDSN
+		case DriverSQLite:
+			config.DSN = defaultSQLiteDSN
+		}
+	}
+	return config
+}
+
+func Connect(config Config) (*sql.DB, error) {
+	if !supported(config.Driver) {
+		return nil, fmt.Errorf("unsupported database driver: %s", config.Driver)
+	}
+	if config.DSN == "" {
+		return nil, fmt.Errorf("DATABASE_URL is required for the %s driver", config.Driver)
+	}
+	dsn := config.DSN
+	if config.Driver == DriverSQLite {
+		dsn = sqliteDSN(dsn)
+	}
 
-    connStr := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", user, password, hostname, dbname)
-    conn, err := sql.Open("mysql", connStr)
+	conn, err := sql.Open(config.Driver, dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -29,3 +95,64 @@ func Connect() (*sql.DB, error) {
 	}
 	return conn, err
 }
+
+// ConnectReplicas opens the replicas of the database. They aren't pinged, a replica
+// that is down is left out of the reads until it's back, nor migrated, they get
+// their schema from the primary.
+func ConnectReplicas(config Config) ([]*sql.DB, error) {
+	if len(config.ReplicaDSNs) == 0 {
+		return nil, nil
+	}
+	if config.Driver != DriverMySQL && config.Driver != DriverPostgres {
+		return nil, fmt.Errorf("read replicas aren't supported on the %s driver", config.Driver)
+	}
+
+	var replicas []*sql.DB
+	for _, dsn := range config.ReplicaDSNs {
+		conn, err := sql.Open(config.Driver, dsn)
+		if err != nil {
+			for _, replica := range replicas {
+				replica.Close()
+			}
+			return nil, fmt.Errorf("failed to open replica: %w", err)
+		}
+		replicas = append(replicas, conn)
+	}
+	return replicas, nil
+}
+
+func supported(driver string) bool {
+	return driver == DriverMySQL || driver == DriverPostgres || driver == DriverSQLite
+}
+
+// sqliteDSN adds the sqliteParams the DSN doesn't set itself
+func sqliteDSN(dsn string) string {
+	path, query, _ := strings.Cut(dsn, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return dsn
+	}
+	for key, values := range sqliteParams {
+		if key == "_pragma" {
+			// pragmas are all under the one key, only add the ones not set already
+			for _, pragma := range values {
+				name, _, _ := strings.Cut(pragma, "(")
+				if !hasPragma(params["_pragma"], name) {
+					params.Add(key, pragma)
+				}
+			}
+		} else if !params.Has(key) {
+			params[key] = values
+		}
+	}
+	return path + "?" + params.Encode()
+}
+
+func hasPragma(pragmas []string, name string) bool {
+	for _, pragma := range pragmas {
+		if strings.HasPrefix(pragma, name+"(") || strings.HasPrefix(pragma, name+"=") {
+			return true
+		}
+	}
+	return false
+}
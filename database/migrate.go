@@ -0,0 +1,190 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// each driver has its own copy of every migration, under the same version
+//
+//go:embed migrations/mysql/*.sql migrations/postgres/*.sql migrations/sqlite/*.sql
+var embeddedMigrations embed.FS
+
+// Migrate applies the embedded migrations of the configured driver, seeding a new
+// database with the configured seed file
+func Migrate(conn *sql.DB, config Config) error {
+	if !supported(config.Driver) {
+		return fmt.Errorf("unsupported database driver: %s", config.Driver)
+	}
+	migrations, err := fs.Sub(embeddedMigrations, "migrations/"+config.Driver)
+	if err != nil {
+		return err
+	}
+	var seed string
+	if config.SeedFile != "" {
+		contents, err := os.ReadFile(config.SeedFile)
+		if err != nil {
+			return fmt.Errorf("failed to read seed file: %w", err)
+		}
+		seed = string(contents)
+	}
+	return ApplyMigrations(conn, config.Driver, migrations, seed)
+}
+
+// ApplyMigrations applies every .sql file in migrations that has not been
+// recorded in SchemaMigrations yet, in file name order. When the first one, the
+// baseline, is applied the seed runs right after it, before the migrations that
+// backfill from the data it inserts. That is the order docker runs init.sql in,
+// so leave the seed out on databases docker created.
+func ApplyMigrations(conn *sql.DB, driver string, migrations fs.FS, seed string) error {
+	appliedAtType := "DATETIME"
+	if driver == DriverPostgres {
+		appliedAtType = "TIMESTAMP"
+	}
+	createVersionTable := `CREATE TABLE IF NOT EXISTS SchemaMigrations (
+		version VARCHAR(255) PRIMARY KEY,
+		applied_at ` + appliedAtType + ` DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := conn.Exec(createVersionTable); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return err
+	}
+
+	files, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for i, file := range files {
+		version := strings.TrimSuffix(file, ".sql")
+		if applied[version] {
+			continue
+		}
+
+		contents, err := fs.ReadFile(migrations, file)
+		if err != nil {
+			return err
+		}
+		if driver == DriverMySQL {
+			err = applyMySQLMigration(conn, version, string(contents))
+		} else {
+			err = applyTransactionalMigration(conn, driver, version, string(contents))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied migration %s\n", version)
+
+		if i == 0 && seed != "" {
+			if err := runScript(conn, driver, seed); err != nil {
+				return fmt.Errorf("failed to seed the database: %w", err)
+			}
+			fmt.Println("Seeded the database")
+		}
+	}
+	return nil
+}
+
+func applyMySQLMigration(conn *sql.DB, version, contents string) error {
+	// DDL is not transactional in MySQL, so a failure part way through a file
+	// has to be fixed by hand before the migration can be rerun
+	if err := runScript(conn, DriverMySQL, contents); err != nil {
+		return fmt.Errorf("failed to apply migration %s: %w", version, err)
+	}
+	if _, err := conn.Exec("INSERT INTO SchemaMigrations (version) VALUES (?)", version); err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", version, err)
+	}
+	return nil
+}
+
+// applyTransactionalMigration runs the whole file at once, function and trigger
+// bodies have semicolons of their own so it can't be split. DDL is transactional
+// in Postgres and SQLite, a file is either applied and recorded or not at all.
+func applyTransactionalMigration(conn *sql.DB, driver, version, contents string) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %s: %w", version, err)
+	}
+	defer tx.Rollback()
+
+	record := "INSERT INTO SchemaMigrations (version) VALUES (?)"
+	if driver == DriverPostgres {
+		record = "INSERT INTO SchemaMigrations (version) VALUES ($1)"
+	}
+	if _, err := tx.Exec(contents); err != nil {
+		return fmt.Errorf("failed to apply migration %s: %w", version, err)
+	}
+	if _, err := tx.Exec(record, version); err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %s: %w", version, err)
+	}
+	return nil
+}
+
+// runScript runs every statement of contents, the MySQL driver only takes one
+// statement per Exec
+func runScript(conn *sql.DB, driver, contents string) error {
+	if driver != DriverMySQL {
+		_, err := conn.Exec(contents)
+		return err
+	}
+	for _, statement := range SplitStatements(contents) {
+		if _, err := conn.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appliedMigrations(conn *sql.DB) (map[string]bool, error) {
+	rows, err := conn.Query("SELECT version FROM SchemaMigrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// SplitStatements splits a migration file into single statements, since the
+// driver doesn't run multiple statements per Exec. Comment lines are dropped.
+func SplitStatements(contents string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(contents, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
@@ -0,0 +1,36 @@
+-- baseline schema, every statement here must stay idempotent because docker
+-- also runs this file when it first creates the database
+CREATE TABLE IF NOT EXISTS Products (
+    product_id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    credit_limit DECIMAL(10, 2) NOT NULL DEFAULT 0.0
+);
+
+CREATE TABLE IF NOT EXISTS Accounts (
+    account_id INT AUTO_INCREMENT PRIMARY KEY,
+    document_number VARCHAR(20),
+    document_type VARCHAR(10) NOT NULL DEFAULT 'CPF',
+    holder_name VARCHAR(100) NOT NULL DEFAULT '',
+    country CHAR(2) NOT NULL DEFAULT 'BR',
+    product_id INT NOT NULL DEFAULT 1,
+    currency CHAR(3) NOT NULL DEFAULT 'BRL',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES Products(product_id)
+);
+
+CREATE TABLE IF NOT EXISTS OperationTypes (
+    operation_type_id INT AUTO_INCREMENT PRIMARY KEY,
+    description0 VARCHAR(50)
+);
+
+CREATE TABLE IF NOT EXISTS Transactions (
+    transaction_id INT AUTO_INCREMENT PRIMARY KEY,
+    account_id INT,
+    operation_type_id INT,
+    amount DECIMAL(10, 2),
+    balance DECIMAL(10, 2) DEFAULT 0.0,
+    event_date DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (account_id) REFERENCES Accounts(account_id),
+    FOREIGN KEY (operation_type_id) REFERENCES OperationTypes(operation_type_id)
+);
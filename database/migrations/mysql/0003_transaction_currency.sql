@@ -0,0 +1,14 @@
+-- amount and balance are always in the account currency, the original_* columns keep what the
+-- merchant actually charged along with the rate it was converted at
+ALTER TABLE Transactions
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'BRL',
+    ADD COLUMN original_amount DECIMAL(10, 2),
+    ADD COLUMN original_currency CHAR(3),
+    ADD COLUMN fx_rate DECIMAL(18, 8) NOT NULL DEFAULT 1.0;
+
+UPDATE Transactions t
+JOIN Accounts a ON a.account_id = t.account_id
+SET t.currency = a.currency,
+    t.original_amount = t.amount,
+    t.original_currency = a.currency
+WHERE t.original_amount IS NULL;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS Authorizations (
+    authorization_id INT AUTO_INCREMENT PRIMARY KEY,
+    account_id INT NOT NULL,
+    operation_type_id INT NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    original_amount DECIMAL(10, 2) NOT NULL,
+    original_currency CHAR(3) NOT NULL,
+    fx_rate DECIMAL(18, 8) NOT NULL DEFAULT 1.0,
+    status VARCHAR(10) NOT NULL DEFAULT 'PENDING',
+    transaction_id INT,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (account_id) REFERENCES Accounts(account_id),
+    FOREIGN KEY (operation_type_id) REFERENCES OperationTypes(operation_type_id),
+    FOREIGN KEY (transaction_id) REFERENCES Transactions(transaction_id),
+    -- the sweeper and the available credit calculation both look for pending holds by expiry
+    INDEX ix_authorizations_pending (status, expires_at),
+    INDEX ix_authorizations_account (account_id, status)
+);
@@ -0,0 +1,17 @@
+-- how much of each credit discharge applied to which debit and when, balance is
+-- overwritten in place so this is what balances at an earlier time are rebuilt from.
+-- Transactions posted before this table existed get theirs from `reconcile -repair`.
+CREATE TABLE IF NOT EXISTS DischargeAllocations (
+    allocation_id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    account_id INT NOT NULL,
+    debit_transaction_id INT NOT NULL,
+    credit_transaction_id INT NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    allocated_at DATETIME(6) NOT NULL,
+    INDEX ix_allocation_account (account_id, allocated_at),
+    INDEX ix_allocation_debit (debit_transaction_id, allocated_at),
+    INDEX ix_allocation_credit (credit_transaction_id, allocated_at),
+    FOREIGN KEY (account_id) REFERENCES Accounts(account_id),
+    FOREIGN KEY (debit_transaction_id) REFERENCES Transactions(transaction_id),
+    FOREIGN KEY (credit_transaction_id) REFERENCES Transactions(transaction_id)
+);
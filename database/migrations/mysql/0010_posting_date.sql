@@ -0,0 +1,11 @@
+-- event_date is when the transaction happened, as told by the client, posting_date
+-- is when it reached the ledger and is always set by the server. Discharge settles
+-- in posting_date order. Earlier transactions were posted when they happened.
+ALTER TABLE Transactions
+    ADD COLUMN posting_date DATETIME(6) NULL;
+
+UPDATE Transactions SET posting_date = COALESCE(event_date, CURRENT_TIMESTAMP(6)) WHERE posting_date IS NULL;
+
+ALTER TABLE Transactions
+    MODIFY COLUMN posting_date DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    ADD INDEX ix_transactions_account_posting (account_id, posting_date);
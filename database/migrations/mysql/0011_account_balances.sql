@@ -0,0 +1,25 @@
+-- running totals of every account, kept up to date in the same database transaction
+-- as every transaction and discharge so reads don't have to add up Transactions.
+-- The row is also the one lock everything writing to the account takes first.
+-- available_limit is the product credit limit minus total_debt, pending holds are
+-- left out as they expire on their own. `reconcile -repair` rebuilds the totals.
+CREATE TABLE IF NOT EXISTS AccountBalances (
+    account_id INT PRIMARY KEY,
+    total_debt DECIMAL(15, 2) NOT NULL DEFAULT 0.0,
+    total_credit DECIMAL(15, 2) NOT NULL DEFAULT 0.0,
+    available_limit DECIMAL(15, 2) NOT NULL DEFAULT 0.0,
+    version BIGINT NOT NULL DEFAULT 0,
+    updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+    FOREIGN KEY (account_id) REFERENCES Accounts(account_id)
+);
+
+INSERT INTO AccountBalances (account_id, total_debt, total_credit, available_limit)
+SELECT a.account_id,
+    COALESCE((SELECT -SUM(t.balance) FROM Transactions t WHERE t.account_id = a.account_id AND t.operation_type_id < 4 AND t.balance < 0), 0),
+    COALESCE((SELECT SUM(t.balance) FROM Transactions t WHERE t.account_id = a.account_id AND t.operation_type_id = 4 AND t.balance > 0), 0),
+    p.credit_limit + COALESCE((SELECT SUM(t.balance) FROM Transactions t WHERE t.account_id = a.account_id AND t.operation_type_id < 4 AND t.balance < 0), 0)
+FROM Accounts a JOIN Products p ON p.product_id = a.product_id
+WHERE NOT EXISTS (SELECT 1 FROM AccountBalances b WHERE b.account_id = a.account_id);
+
+-- every new account starts with nothing owed and its product's full limit
+CREATE TRIGGER account_balances_open AFTER INSERT ON Accounts FOR EACH ROW INSERT INTO AccountBalances (account_id, available_limit) SELECT NEW.account_id, credit_limit FROM Products WHERE product_id = NEW.product_id;
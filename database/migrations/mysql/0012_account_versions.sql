@@ -0,0 +1,11 @@
+-- settings clients can change on an account. credit_limit overrides the limit of
+-- the product when set, status decides what the account may still post.
+-- version goes up on every change, updates only apply on top of the version the
+-- client read so concurrent changes can't silently overwrite each other.
+ALTER TABLE Accounts
+    ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'ACTIVE',
+    ADD COLUMN credit_limit DECIMAL(10, 2) NULL,
+    ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+
+-- holds change status too, the version tells clients which state they last saw
+ALTER TABLE Authorizations ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,88 @@
+-- account and transaction ids outgrow INT, they are BIGINT from here on. The
+-- foreign keys on them have to go while their columns change type.
+ALTER TABLE Transactions
+    DROP FOREIGN KEY Transactions_ibfk_1,
+    DROP FOREIGN KEY Transactions_ibfk_2;
+
+ALTER TABLE Authorizations
+    DROP FOREIGN KEY Authorizations_ibfk_1,
+    DROP FOREIGN KEY Authorizations_ibfk_3;
+
+ALTER TABLE LedgerAccounts DROP FOREIGN KEY LedgerAccounts_ibfk_1;
+
+ALTER TABLE JournalEntries DROP FOREIGN KEY JournalEntries_ibfk_1;
+
+ALTER TABLE DischargeAllocations
+    DROP FOREIGN KEY DischargeAllocations_ibfk_1,
+    DROP FOREIGN KEY DischargeAllocations_ibfk_2,
+    DROP FOREIGN KEY DischargeAllocations_ibfk_3;
+
+ALTER TABLE AccountBalances DROP FOREIGN KEY AccountBalances_ibfk_1;
+
+ALTER TABLE Accounts MODIFY COLUMN account_id BIGINT NOT NULL AUTO_INCREMENT;
+
+ALTER TABLE Authorizations
+    MODIFY COLUMN account_id BIGINT NOT NULL,
+    MODIFY COLUMN transaction_id BIGINT NULL,
+    ADD CONSTRAINT fk_authorizations_account FOREIGN KEY (account_id) REFERENCES Accounts(account_id);
+
+ALTER TABLE LedgerAccounts
+    MODIFY COLUMN account_id BIGINT NULL,
+    ADD CONSTRAINT fk_ledger_accounts_account FOREIGN KEY (account_id) REFERENCES Accounts(account_id);
+
+ALTER TABLE JournalEntries MODIFY COLUMN transaction_id BIGINT NULL;
+
+ALTER TABLE DischargeAllocations
+    MODIFY COLUMN account_id BIGINT NOT NULL,
+    MODIFY COLUMN debit_transaction_id BIGINT NOT NULL,
+    MODIFY COLUMN credit_transaction_id BIGINT NOT NULL,
+    ADD CONSTRAINT fk_allocations_account FOREIGN KEY (account_id) REFERENCES Accounts(account_id);
+
+ALTER TABLE AccountBalances
+    MODIFY COLUMN account_id BIGINT NOT NULL,
+    ADD CONSTRAINT fk_account_balances_account FOREIGN KEY (account_id) REFERENCES Accounts(account_id);
+
+ALTER TABLE AuditLog MODIFY COLUMN account_id BIGINT NULL;
+
+-- Transactions is split by account, every query on it names the account so only
+-- its partition is read. The primary key has to hold the partitioning column and
+-- the auto increment needs an index of its own. Partitioned tables can't have
+-- foreign keys either way, so the ones to Transactions stay dropped and the
+-- service checks the account and operation type before it posts.
+ALTER TABLE Transactions
+    MODIFY COLUMN transaction_id BIGINT NOT NULL AUTO_INCREMENT,
+    MODIFY COLUMN account_id BIGINT NOT NULL,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (account_id, transaction_id),
+    ADD INDEX ix_transactions_id (transaction_id);
+
+ALTER TABLE Transactions PARTITION BY HASH (account_id) PARTITIONS 16;
+
+-- the archive command moves settled transactions, and the allocations between
+-- them, out of the hot tables once they're old enough. Balances as of an earlier
+-- time still read them from here.
+CREATE TABLE IF NOT EXISTS TransactionsArchive (
+    transaction_id BIGINT NOT NULL,
+    account_id BIGINT NOT NULL,
+    operation_type_id INT,
+    amount DECIMAL(10, 2),
+    balance DECIMAL(10, 2),
+    event_date DATETIME,
+    posting_date DATETIME(6) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    original_amount DECIMAL(10, 2),
+    original_currency CHAR(3),
+    fx_rate DECIMAL(18, 8) NOT NULL,
+    archived_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (account_id, transaction_id)
+);
+
+CREATE TABLE IF NOT EXISTS DischargeAllocationsArchive (
+    allocation_id BIGINT PRIMARY KEY,
+    account_id BIGINT NOT NULL,
+    debit_transaction_id BIGINT NOT NULL,
+    credit_transaction_id BIGINT NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    allocated_at DATETIME(6) NOT NULL,
+    INDEX ix_archived_allocation_account (account_id, allocated_at)
+);
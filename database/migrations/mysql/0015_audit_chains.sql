@@ -0,0 +1,8 @@
+-- the audit log is one hash chain per account rather than a single one, so an
+-- append only locks the head of its account's chain and postings to different
+-- accounts don't queue behind each other. Entries without an account go on chain
+-- 0, which is where everything appended before carries on. Heads of account
+-- chains are created by their first append.
+ALTER TABLE AuditLog ADD COLUMN chain_id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE AuditChainHead MODIFY COLUMN chain_id BIGINT NOT NULL;
+UPDATE AuditChainHead SET chain_id = 0 WHERE chain_id = 1;
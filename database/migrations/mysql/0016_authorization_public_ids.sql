@@ -0,0 +1,8 @@
+-- holds are known outside the service by public ids too, auth_ and a ULID, so
+-- their sequential ids stop giving away how many purchases are authorized. The
+-- prefix is a letter longer than acc_ and txn_, hence the wider column. Holds
+-- from before get random ids of the same shape, like accounts did in 0014.
+ALTER TABLE Authorizations ADD COLUMN public_id VARCHAR(31) NULL;
+UPDATE Authorizations SET public_id = CONCAT('auth_', UPPER(HEX(RANDOM_BYTES(13)))) WHERE public_id IS NULL;
+ALTER TABLE Authorizations MODIFY COLUMN public_id VARCHAR(31) NOT NULL;
+CREATE UNIQUE INDEX ux_authorizations_public_id ON Authorizations (public_id);
@@ -0,0 +1,44 @@
+-- baseline schema, every statement here must stay idempotent because docker
+-- also runs this file when it first creates the database.
+-- Postgres has no ON UPDATE for columns, touch_updated_at keeps updated_at
+-- current on the tables that have one.
+CREATE OR REPLACE FUNCTION touch_updated_at() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at = CURRENT_TIMESTAMP;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TABLE IF NOT EXISTS Products (
+    product_id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    credit_limit NUMERIC(10, 2) NOT NULL DEFAULT 0.0
+);
+
+CREATE TABLE IF NOT EXISTS Accounts (
+    account_id SERIAL PRIMARY KEY,
+    document_number VARCHAR(20),
+    document_type VARCHAR(10) NOT NULL DEFAULT 'CPF',
+    holder_name VARCHAR(100) NOT NULL DEFAULT '',
+    country CHAR(2) NOT NULL DEFAULT 'BR',
+    product_id INT NOT NULL DEFAULT 1 REFERENCES Products(product_id),
+    currency CHAR(3) NOT NULL DEFAULT 'BRL',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE OR REPLACE TRIGGER accounts_updated_at BEFORE UPDATE ON Accounts FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+
+CREATE TABLE IF NOT EXISTS OperationTypes (
+    operation_type_id SERIAL PRIMARY KEY,
+    description0 VARCHAR(50)
+);
+
+CREATE TABLE IF NOT EXISTS Transactions (
+    transaction_id SERIAL PRIMARY KEY,
+    account_id INT REFERENCES Accounts(account_id),
+    operation_type_id INT REFERENCES OperationTypes(operation_type_id),
+    amount NUMERIC(10, 2),
+    balance NUMERIC(10, 2) DEFAULT 0.0,
+    event_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+-- document numbers identify the account holder, so there can only ever be one account per number
+CREATE UNIQUE INDEX ux_accounts_document_number ON Accounts (document_number);
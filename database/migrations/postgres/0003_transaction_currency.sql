@@ -0,0 +1,14 @@
+-- amount and balance are always in the account currency, the original_* columns keep what the
+-- merchant actually charged along with the rate it was converted at
+ALTER TABLE Transactions
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'BRL',
+    ADD COLUMN original_amount NUMERIC(10, 2),
+    ADD COLUMN original_currency CHAR(3),
+    ADD COLUMN fx_rate NUMERIC(18, 8) NOT NULL DEFAULT 1.0;
+
+UPDATE Transactions t
+SET currency = a.currency,
+    original_amount = t.amount,
+    original_currency = a.currency
+FROM Accounts a
+WHERE a.account_id = t.account_id AND t.original_amount IS NULL;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS Authorizations (
+    authorization_id SERIAL PRIMARY KEY,
+    account_id INT NOT NULL REFERENCES Accounts(account_id),
+    operation_type_id INT NOT NULL REFERENCES OperationTypes(operation_type_id),
+    amount NUMERIC(10, 2) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    original_amount NUMERIC(10, 2) NOT NULL,
+    original_currency CHAR(3) NOT NULL,
+    fx_rate NUMERIC(18, 8) NOT NULL DEFAULT 1.0,
+    status VARCHAR(10) NOT NULL DEFAULT 'PENDING',
+    transaction_id INT REFERENCES Transactions(transaction_id),
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- the sweeper and the available credit calculation both look for pending holds by expiry
+CREATE INDEX IF NOT EXISTS ix_authorizations_pending ON Authorizations (status, expires_at);
+CREATE INDEX IF NOT EXISTS ix_authorizations_account ON Authorizations (account_id, status);
+
+CREATE TRIGGER authorizations_updated_at BEFORE UPDATE ON Authorizations FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
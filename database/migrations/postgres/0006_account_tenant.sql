@@ -0,0 +1,4 @@
+-- the tenant that owns the account, partners can only see and transact on accounts of their own tenant
+ALTER TABLE Accounts ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX ix_accounts_tenant ON Accounts (tenant_id);
@@ -0,0 +1,78 @@
+-- double-entry ledger beneath Transactions, every transaction is recorded as a
+-- journal entry whose postings sum to zero. Debits are positive, credits negative.
+CREATE TABLE IF NOT EXISTS LedgerAccounts (
+    ledger_account_id BIGSERIAL PRIMARY KEY,
+    code VARCHAR(64) NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    account_id INT NULL REFERENCES Accounts(account_id),
+    currency CHAR(3) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT ux_ledger_account_code UNIQUE (code)
+);
+
+CREATE TABLE IF NOT EXISTS JournalEntries (
+    journal_entry_id BIGSERIAL PRIMARY KEY,
+    transaction_id INT NULL REFERENCES Transactions(transaction_id),
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    currency CHAR(3) NOT NULL,
+    posted_at TIMESTAMP(6) NOT NULL,
+    CONSTRAINT ux_journal_transaction UNIQUE (transaction_id)
+);
+
+CREATE TABLE IF NOT EXISTS Postings (
+    posting_id BIGSERIAL PRIMARY KEY,
+    journal_entry_id BIGINT NOT NULL REFERENCES JournalEntries(journal_entry_id),
+    ledger_account_id BIGINT NOT NULL REFERENCES LedgerAccounts(ledger_account_id),
+    amount NUMERIC(12, 2) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS ix_posting_ledger_account ON Postings (ledger_account_id);
+
+-- record the transactions posted before the ledger existed, the same way the service does
+INSERT INTO LedgerAccounts (code, type, account_id, currency)
+SELECT DISTINCT CONCAT('customer:', account_id), 'ASSET', account_id, currency FROM Transactions
+ON CONFLICT DO NOTHING;
+
+INSERT INTO LedgerAccounts (code, type, account_id, currency)
+SELECT DISTINCT CONCAT('settlement:', currency), 'LIABILITY', NULL::INT, currency FROM Transactions WHERE operation_type_id IN (1, 2)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO LedgerAccounts (code, type, account_id, currency)
+SELECT DISTINCT CONCAT('cash:', currency), 'ASSET', NULL::INT, currency FROM Transactions WHERE operation_type_id IN (3, 4)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO JournalEntries (transaction_id, description, currency, posted_at)
+SELECT t.transaction_id,
+    CASE t.operation_type_id WHEN 3 THEN 'withdrawal' WHEN 4 THEN 'payment' ELSE 'purchase' END,
+    t.currency, COALESCE(t.event_date, LOCALTIMESTAMP)
+FROM Transactions t
+WHERE t.amount <> 0 AND t.operation_type_id IN (1, 2, 3, 4)
+    AND NOT EXISTS (SELECT 1 FROM JournalEntries j WHERE j.transaction_id = t.transaction_id);
+
+INSERT INTO Postings (journal_entry_id, ledger_account_id, amount, currency)
+SELECT j.journal_entry_id, debit.ledger_account_id, ABS(t.amount), t.currency
+FROM JournalEntries j
+JOIN Transactions t ON t.transaction_id = j.transaction_id
+JOIN LedgerAccounts debit ON debit.code = CASE WHEN t.operation_type_id = 4 THEN CONCAT('cash:', t.currency) ELSE CONCAT('customer:', t.account_id) END
+WHERE NOT EXISTS (SELECT 1 FROM Postings p WHERE p.journal_entry_id = j.journal_entry_id);
+
+INSERT INTO Postings (journal_entry_id, ledger_account_id, amount, currency)
+SELECT j.journal_entry_id, credit.ledger_account_id, -ABS(t.amount), t.currency
+FROM JournalEntries j
+JOIN Transactions t ON t.transaction_id = j.transaction_id
+JOIN LedgerAccounts credit ON credit.code = CASE
+    WHEN t.operation_type_id = 4 THEN CONCAT('customer:', t.account_id)
+    WHEN t.operation_type_id = 3 THEN CONCAT('cash:', t.currency)
+    ELSE CONCAT('settlement:', t.currency) END
+WHERE (SELECT COUNT(*) FROM Postings p WHERE p.journal_entry_id = j.journal_entry_id) = 1;
+
+-- the journal is never edited, mistakes are corrected by posting another entry
+CREATE TRIGGER journal_entries_no_update BEFORE UPDATE ON JournalEntries FOR EACH ROW EXECUTE FUNCTION refuse_change();
+
+CREATE TRIGGER journal_entries_no_delete BEFORE DELETE ON JournalEntries FOR EACH ROW EXECUTE FUNCTION refuse_change();
+
+CREATE TRIGGER postings_no_update BEFORE UPDATE ON Postings FOR EACH ROW EXECUTE FUNCTION refuse_change();
+
+CREATE TRIGGER postings_no_delete BEFORE DELETE ON Postings FOR EACH ROW EXECUTE FUNCTION refuse_change();
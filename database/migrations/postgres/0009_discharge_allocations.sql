@@ -0,0 +1,15 @@
+-- how much of each credit discharge applied to which debit and when, balance is
+-- overwritten in place so this is what balances at an earlier time are rebuilt from.
+-- Transactions posted before this table existed get theirs from `reconcile -repair`.
+CREATE TABLE IF NOT EXISTS DischargeAllocations (
+    allocation_id BIGSERIAL PRIMARY KEY,
+    account_id INT NOT NULL REFERENCES Accounts(account_id),
+    debit_transaction_id INT NOT NULL REFERENCES Transactions(transaction_id),
+    credit_transaction_id INT NOT NULL REFERENCES Transactions(transaction_id),
+    amount NUMERIC(10, 2) NOT NULL,
+    allocated_at TIMESTAMP(6) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ix_allocation_account ON DischargeAllocations (account_id, allocated_at);
+CREATE INDEX IF NOT EXISTS ix_allocation_debit ON DischargeAllocations (debit_transaction_id, allocated_at);
+CREATE INDEX IF NOT EXISTS ix_allocation_credit ON DischargeAllocations (credit_transaction_id, allocated_at);
@@ -0,0 +1,12 @@
+-- event_date is when the transaction happened, as told by the client, posting_date
+-- is when it reached the ledger and is always set by the server. Discharge settles
+-- in posting_date order. Earlier transactions were posted when they happened.
+ALTER TABLE Transactions ADD COLUMN posting_date TIMESTAMP(6) NULL;
+
+UPDATE Transactions SET posting_date = COALESCE(event_date, LOCALTIMESTAMP) WHERE posting_date IS NULL;
+
+ALTER TABLE Transactions
+    ALTER COLUMN posting_date SET NOT NULL,
+    ALTER COLUMN posting_date SET DEFAULT LOCALTIMESTAMP;
+
+CREATE INDEX ix_transactions_account_posting ON Transactions (account_id, posting_date);
@@ -0,0 +1,93 @@
+-- account and transaction ids outgrow INT, they are BIGINT from here on
+ALTER TABLE Accounts ALTER COLUMN account_id TYPE BIGINT;
+ALTER SEQUENCE accounts_account_id_seq AS BIGINT;
+
+ALTER TABLE Authorizations
+    ALTER COLUMN account_id TYPE BIGINT,
+    ALTER COLUMN transaction_id TYPE BIGINT;
+ALTER TABLE LedgerAccounts ALTER COLUMN account_id TYPE BIGINT;
+ALTER TABLE JournalEntries ALTER COLUMN transaction_id TYPE BIGINT;
+ALTER TABLE DischargeAllocations
+    ALTER COLUMN account_id TYPE BIGINT,
+    ALTER COLUMN debit_transaction_id TYPE BIGINT,
+    ALTER COLUMN credit_transaction_id TYPE BIGINT;
+ALTER TABLE AccountBalances ALTER COLUMN account_id TYPE BIGINT;
+ALTER TABLE AuditLog ALTER COLUMN account_id TYPE BIGINT;
+
+-- Transactions is split by account, every query on it names the account so only
+-- its partition is read. A table can't be partitioned in place, the rows move to a
+-- new one. Its primary key has to hold the partitioning column, so nothing can
+-- reference a transaction by its id alone anymore, and archived transactions
+-- leave the table altogether, the foreign keys to it go.
+ALTER TABLE Authorizations DROP CONSTRAINT IF EXISTS authorizations_transaction_id_fkey;
+ALTER TABLE JournalEntries DROP CONSTRAINT IF EXISTS journalentries_transaction_id_fkey;
+ALTER TABLE DischargeAllocations
+    DROP CONSTRAINT IF EXISTS dischargeallocations_debit_transaction_id_fkey,
+    DROP CONSTRAINT IF EXISTS dischargeallocations_credit_transaction_id_fkey;
+
+ALTER TABLE Transactions RENAME TO TransactionsUnpartitioned;
+ALTER TABLE TransactionsUnpartitioned RENAME CONSTRAINT transactions_pkey TO transactions_unpartitioned_pkey;
+DROP INDEX IF EXISTS ix_transactions_account_posting;
+ALTER SEQUENCE transactions_transaction_id_seq AS BIGINT OWNED BY NONE;
+
+CREATE TABLE Transactions (
+    transaction_id BIGINT NOT NULL DEFAULT nextval('transactions_transaction_id_seq'),
+    account_id BIGINT NOT NULL REFERENCES Accounts(account_id),
+    operation_type_id INT REFERENCES OperationTypes(operation_type_id),
+    amount NUMERIC(10, 2),
+    balance NUMERIC(10, 2) DEFAULT 0.0,
+    event_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    currency CHAR(3) NOT NULL DEFAULT 'BRL',
+    original_amount NUMERIC(10, 2),
+    original_currency CHAR(3),
+    fx_rate NUMERIC(18, 8) NOT NULL DEFAULT 1.0,
+    posting_date TIMESTAMP(6) NOT NULL DEFAULT LOCALTIMESTAMP,
+    PRIMARY KEY (account_id, transaction_id)
+) PARTITION BY HASH (account_id);
+
+DO $$
+BEGIN
+    FOR i IN 0..15 LOOP
+        EXECUTE format('CREATE TABLE transactions_p%s PARTITION OF Transactions FOR VALUES WITH (MODULUS 16, REMAINDER %s)', i, i);
+    END LOOP;
+END;
+$$;
+
+INSERT INTO Transactions (transaction_id, account_id, operation_type_id, amount, balance, event_date, currency, original_amount, original_currency, fx_rate, posting_date)
+SELECT transaction_id, account_id, operation_type_id, amount, balance, event_date, currency, original_amount, original_currency, fx_rate, posting_date
+FROM TransactionsUnpartitioned;
+
+ALTER SEQUENCE transactions_transaction_id_seq OWNED BY Transactions.transaction_id;
+DROP TABLE TransactionsUnpartitioned;
+
+CREATE INDEX IF NOT EXISTS ix_transactions_account_posting ON Transactions (account_id, posting_date);
+
+-- the archive command moves settled transactions, and the allocations between
+-- them, out of the hot tables once they're old enough. Balances as of an earlier
+-- time still read them from here.
+CREATE TABLE IF NOT EXISTS TransactionsArchive (
+    transaction_id BIGINT NOT NULL,
+    account_id BIGINT NOT NULL,
+    operation_type_id INT,
+    amount NUMERIC(10, 2),
+    balance NUMERIC(10, 2),
+    event_date TIMESTAMP,
+    posting_date TIMESTAMP(6) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    original_amount NUMERIC(10, 2),
+    original_currency CHAR(3),
+    fx_rate NUMERIC(18, 8) NOT NULL,
+    archived_at TIMESTAMP(6) NOT NULL DEFAULT LOCALTIMESTAMP,
+    PRIMARY KEY (account_id, transaction_id)
+);
+
+CREATE TABLE IF NOT EXISTS DischargeAllocationsArchive (
+    allocation_id BIGINT PRIMARY KEY,
+    account_id BIGINT NOT NULL,
+    debit_transaction_id BIGINT NOT NULL,
+    credit_transaction_id BIGINT NOT NULL,
+    amount NUMERIC(10, 2) NOT NULL,
+    allocated_at TIMESTAMP(6) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ix_archived_allocation_account ON DischargeAllocationsArchive (account_id, allocated_at);
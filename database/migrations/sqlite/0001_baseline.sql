@@ -0,0 +1,38 @@
+-- baseline schema. SQLite has no ON UPDATE for columns, the *_updated_at
+-- triggers keep updated_at current instead.
+CREATE TABLE IF NOT EXISTS Products (
+    product_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(50) NOT NULL,
+    credit_limit DECIMAL(10, 2) NOT NULL DEFAULT 0.0
+);
+
+CREATE TABLE IF NOT EXISTS Accounts (
+    account_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    document_number VARCHAR(20),
+    document_type VARCHAR(10) NOT NULL DEFAULT 'CPF',
+    holder_name VARCHAR(100) NOT NULL DEFAULT '',
+    country CHAR(2) NOT NULL DEFAULT 'BR',
+    product_id INT NOT NULL DEFAULT 1 REFERENCES Products(product_id),
+    currency CHAR(3) NOT NULL DEFAULT 'BRL',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER IF NOT EXISTS accounts_updated_at AFTER UPDATE ON Accounts FOR EACH ROW
+BEGIN
+    UPDATE Accounts SET updated_at = CURRENT_TIMESTAMP WHERE account_id = NEW.account_id;
+END;
+
+CREATE TABLE IF NOT EXISTS OperationTypes (
+    operation_type_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    description0 VARCHAR(50)
+);
+
+CREATE TABLE IF NOT EXISTS Transactions (
+    transaction_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account_id INT REFERENCES Accounts(account_id),
+    operation_type_id INT REFERENCES OperationTypes(operation_type_id),
+    amount DECIMAL(10, 2),
+    balance DECIMAL(10, 2) DEFAULT 0.0,
+    event_date DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,13 @@
+-- amount and balance are always in the account currency, the original_* columns keep what the
+-- merchant actually charged along with the rate it was converted at
+ALTER TABLE Transactions ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'BRL';
+ALTER TABLE Transactions ADD COLUMN original_amount DECIMAL(10, 2);
+ALTER TABLE Transactions ADD COLUMN original_currency CHAR(3);
+ALTER TABLE Transactions ADD COLUMN fx_rate DECIMAL(18, 8) NOT NULL DEFAULT 1.0;
+
+UPDATE Transactions
+SET currency = a.currency,
+    original_amount = Transactions.amount,
+    original_currency = a.currency
+FROM Accounts a
+WHERE a.account_id = Transactions.account_id AND Transactions.original_amount IS NULL;
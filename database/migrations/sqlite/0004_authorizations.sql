@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS Authorizations (
+    authorization_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account_id INT NOT NULL REFERENCES Accounts(account_id),
+    operation_type_id INT NOT NULL REFERENCES OperationTypes(operation_type_id),
+    amount DECIMAL(10, 2) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    original_amount DECIMAL(10, 2) NOT NULL,
+    original_currency CHAR(3) NOT NULL,
+    fx_rate DECIMAL(18, 8) NOT NULL DEFAULT 1.0,
+    status VARCHAR(10) NOT NULL DEFAULT 'PENDING',
+    transaction_id INT REFERENCES Transactions(transaction_id),
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- the sweeper and the available credit calculation both look for pending holds by expiry
+CREATE INDEX IF NOT EXISTS ix_authorizations_pending ON Authorizations (status, expires_at);
+CREATE INDEX IF NOT EXISTS ix_authorizations_account ON Authorizations (account_id, status);
+
+CREATE TRIGGER authorizations_updated_at AFTER UPDATE ON Authorizations FOR EACH ROW
+BEGIN
+    UPDATE Authorizations SET updated_at = CURRENT_TIMESTAMP WHERE authorization_id = NEW.authorization_id;
+END;
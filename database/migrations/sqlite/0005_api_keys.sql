@@ -0,0 +1,10 @@
+-- keys are presented as "<key_id>.<secret>", only the sha256 of the secret is kept
+CREATE TABLE IF NOT EXISTS ApiKeys (
+    key_id VARCHAR(32) PRIMARY KEY,
+    client_id VARCHAR(64) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    roles VARCHAR(255) NOT NULL DEFAULT '',
+    tenant_id VARCHAR(64) NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    revoked_at DATETIME NULL
+);
@@ -0,0 +1,33 @@
+-- append only audit log, each entry carries the hash of the one before it so
+-- altered or removed rows break the chain
+CREATE TABLE IF NOT EXISTS AuditLog (
+    audit_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    occurred_at DATETIME NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    principal_id VARCHAR(128) NOT NULL DEFAULT '',
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    method VARCHAR(8) NOT NULL DEFAULT '',
+    route VARCHAR(255) NOT NULL DEFAULT '',
+    account_id INT NULL,
+    transaction_id BIGINT NULL,
+    payload_hash CHAR(64) NOT NULL DEFAULT '',
+    outcome INT NOT NULL DEFAULT 0,
+    changes TEXT NULL,
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ix_audit_account ON AuditLog (account_id, occurred_at);
+CREATE INDEX IF NOT EXISTS ix_audit_occurred_at ON AuditLog (occurred_at);
+
+-- the tip of the chain, locked by every append so entries are chained one at a time
+CREATE TABLE IF NOT EXISTS AuditChainHead (
+    chain_id TINYINT PRIMARY KEY,
+    last_hash CHAR(64) NOT NULL
+);
+
+INSERT INTO AuditChainHead (chain_id, last_hash) VALUES (1, '0000000000000000000000000000000000000000000000000000000000000000');
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON AuditLog FOR EACH ROW BEGIN SELECT RAISE(ABORT, 'AuditLog is append only'); END;
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON AuditLog FOR EACH ROW BEGIN SELECT RAISE(ABORT, 'AuditLog is append only'); END;
@@ -0,0 +1,75 @@
+-- double-entry ledger beneath Transactions, every transaction is recorded as a
+-- journal entry whose postings sum to zero. Debits are positive, credits negative.
+CREATE TABLE IF NOT EXISTS LedgerAccounts (
+    ledger_account_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code VARCHAR(64) NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    account_id INT NULL REFERENCES Accounts(account_id),
+    currency CHAR(3) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT ux_ledger_account_code UNIQUE (code)
+);
+
+CREATE TABLE IF NOT EXISTS JournalEntries (
+    journal_entry_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    transaction_id INT NULL REFERENCES Transactions(transaction_id),
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    currency CHAR(3) NOT NULL,
+    posted_at DATETIME NOT NULL,
+    CONSTRAINT ux_journal_transaction UNIQUE (transaction_id)
+);
+
+CREATE TABLE IF NOT EXISTS Postings (
+    posting_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    journal_entry_id BIGINT NOT NULL REFERENCES JournalEntries(journal_entry_id),
+    ledger_account_id BIGINT NOT NULL REFERENCES LedgerAccounts(ledger_account_id),
+    amount DECIMAL(12, 2) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS ix_posting_ledger_account ON Postings (ledger_account_id);
+
+-- record the transactions posted before the ledger existed, the same way the service does
+INSERT OR IGNORE INTO LedgerAccounts (code, type, account_id, currency)
+SELECT DISTINCT 'customer:' || account_id, 'ASSET', account_id, currency FROM Transactions;
+
+INSERT OR IGNORE INTO LedgerAccounts (code, type, account_id, currency)
+SELECT DISTINCT 'settlement:' || currency, 'LIABILITY', NULL, currency FROM Transactions WHERE operation_type_id IN (1, 2);
+
+INSERT OR IGNORE INTO LedgerAccounts (code, type, account_id, currency)
+SELECT DISTINCT 'cash:' || currency, 'ASSET', NULL, currency FROM Transactions WHERE operation_type_id IN (3, 4);
+
+INSERT INTO JournalEntries (transaction_id, description, currency, posted_at)
+SELECT t.transaction_id,
+    CASE t.operation_type_id WHEN 3 THEN 'withdrawal' WHEN 4 THEN 'payment' ELSE 'purchase' END,
+    t.currency, COALESCE(t.event_date, CURRENT_TIMESTAMP)
+FROM Transactions t
+WHERE t.amount <> 0 AND t.operation_type_id IN (1, 2, 3, 4)
+    AND NOT EXISTS (SELECT 1 FROM JournalEntries j WHERE j.transaction_id = t.transaction_id);
+
+INSERT INTO Postings (journal_entry_id, ledger_account_id, amount, currency)
+SELECT j.journal_entry_id, debit.ledger_account_id, ABS(t.amount), t.currency
+FROM JournalEntries j
+JOIN Transactions t ON t.transaction_id = j.transaction_id
+JOIN LedgerAccounts debit ON debit.code = CASE WHEN t.operation_type_id = 4 THEN 'cash:' || t.currency ELSE 'customer:' || t.account_id END
+WHERE NOT EXISTS (SELECT 1 FROM Postings p WHERE p.journal_entry_id = j.journal_entry_id);
+
+INSERT INTO Postings (journal_entry_id, ledger_account_id, amount, currency)
+SELECT j.journal_entry_id, credit.ledger_account_id, -ABS(t.amount), t.currency
+FROM JournalEntries j
+JOIN Transactions t ON t.transaction_id = j.transaction_id
+JOIN LedgerAccounts credit ON credit.code = CASE
+    WHEN t.operation_type_id = 4 THEN 'customer:' || t.account_id
+    WHEN t.operation_type_id = 3 THEN 'cash:' || t.currency
+    ELSE 'settlement:' || t.currency END
+WHERE (SELECT COUNT(*) FROM Postings p WHERE p.journal_entry_id = j.journal_entry_id) = 1;
+
+-- the journal is never edited, mistakes are corrected by posting another entry
+CREATE TRIGGER journal_entries_no_update BEFORE UPDATE ON JournalEntries FOR EACH ROW BEGIN SELECT RAISE(ABORT, 'JournalEntries are append only'); END;
+
+CREATE TRIGGER journal_entries_no_delete BEFORE DELETE ON JournalEntries FOR EACH ROW BEGIN SELECT RAISE(ABORT, 'JournalEntries are append only'); END;
+
+CREATE TRIGGER postings_no_update BEFORE UPDATE ON Postings FOR EACH ROW BEGIN SELECT RAISE(ABORT, 'Postings are append only'); END;
+
+CREATE TRIGGER postings_no_delete BEFORE DELETE ON Postings FOR EACH ROW BEGIN SELECT RAISE(ABORT, 'Postings are append only'); END;
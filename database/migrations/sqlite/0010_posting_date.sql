@@ -0,0 +1,15 @@
+-- event_date is when the transaction happened, as told by the client, posting_date
+-- is when it reached the ledger and is always set by the server. Discharge settles
+-- in posting_date order. Earlier transactions were posted when they happened.
+-- SQLite can't add a column defaulting to the current time or make one NOT NULL
+-- later, the trigger fills it in for inserts that leave it out.
+ALTER TABLE Transactions ADD COLUMN posting_date DATETIME NULL;
+
+UPDATE Transactions SET posting_date = COALESCE(event_date, CURRENT_TIMESTAMP) WHERE posting_date IS NULL;
+
+CREATE TRIGGER transactions_posting_date AFTER INSERT ON Transactions FOR EACH ROW WHEN NEW.posting_date IS NULL
+BEGIN
+    UPDATE Transactions SET posting_date = CURRENT_TIMESTAMP WHERE transaction_id = NEW.transaction_id;
+END;
+
+CREATE INDEX ix_transactions_account_posting ON Transactions (account_id, posting_date);
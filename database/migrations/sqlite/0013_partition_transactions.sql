@@ -0,0 +1,30 @@
+-- SQLite integers are 64 bit already and it has no partitioning, the archive
+-- tables are only here so the queries reading them work the same. Archiving itself
+-- isn't supported, the foreign keys to Transactions would have to go first and
+-- SQLite can only drop those by rebuilding every table that has one.
+CREATE TABLE IF NOT EXISTS TransactionsArchive (
+    transaction_id INTEGER NOT NULL,
+    account_id INTEGER NOT NULL,
+    operation_type_id INT,
+    amount DECIMAL(10, 2),
+    balance DECIMAL(10, 2),
+    event_date DATETIME,
+    posting_date DATETIME NOT NULL,
+    currency CHAR(3) NOT NULL,
+    original_amount DECIMAL(10, 2),
+    original_currency CHAR(3),
+    fx_rate DECIMAL(18, 8) NOT NULL,
+    archived_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (account_id, transaction_id)
+);
+
+CREATE TABLE IF NOT EXISTS DischargeAllocationsArchive (
+    allocation_id INTEGER PRIMARY KEY,
+    account_id INTEGER NOT NULL,
+    debit_transaction_id INTEGER NOT NULL,
+    credit_transaction_id INTEGER NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    allocated_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ix_archived_allocation_account ON DischargeAllocationsArchive (account_id, allocated_at);
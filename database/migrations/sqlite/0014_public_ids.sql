@@ -0,0 +1,27 @@
+-- accounts and transactions are known outside the service by public ids, acc_ or
+-- txn_ and a ULID the service makes, rather than their sequential ids that give
+-- away how many there are and what the ids of the others are. Rows from before
+-- get random ids of the same shape instead of a ULID, they only have to be unique
+-- and impossible to guess. SQLite can't make a column NOT NULL after the fact,
+-- the service always sets them.
+ALTER TABLE Accounts ADD COLUMN public_id VARCHAR(30) NULL;
+UPDATE Accounts SET public_id = 'acc_' || upper(hex(randomblob(13))) WHERE public_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS ux_accounts_public_id ON Accounts (public_id);
+
+-- public ids are unique within each account. The account comes first so the index
+-- fits the partitioning of Transactions, which needs it in every unique key.
+ALTER TABLE Transactions ADD COLUMN public_id VARCHAR(30) NULL;
+UPDATE Transactions SET public_id = 'txn_' || upper(hex(randomblob(13))) WHERE public_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS ux_transactions_public_id ON Transactions (account_id, public_id);
+
+ALTER TABLE TransactionsArchive ADD COLUMN public_id VARCHAR(30) NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS ux_transactions_archive_public_id ON TransactionsArchive (account_id, public_id);
+
+-- holds answer with the public ids of their account and of the transaction a
+-- capture posted. They never change, so they're kept on the hold rather than
+-- looked up whenever it's read.
+ALTER TABLE Authorizations ADD COLUMN account_public_id VARCHAR(30) NULL;
+ALTER TABLE Authorizations ADD COLUMN transaction_public_id VARCHAR(30) NULL;
+UPDATE Authorizations SET account_public_id = (SELECT a.public_id FROM Accounts a WHERE a.account_id = Authorizations.account_id);
+UPDATE Authorizations SET transaction_public_id = (SELECT t.public_id FROM Transactions t WHERE t.account_id = Authorizations.account_id AND t.transaction_id = Authorizations.transaction_id)
+WHERE transaction_id IS NOT NULL;
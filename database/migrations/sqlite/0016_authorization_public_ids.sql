@@ -0,0 +1,8 @@
+-- holds are known outside the service by public ids too, auth_ and a ULID, so
+-- their sequential ids stop giving away how many purchases are authorized. The
+-- prefix is a letter longer than acc_ and txn_, hence the wider column. Holds
+-- from before get random ids of the same shape, like accounts did in 0014.
+-- SQLite can't make the column NOT NULL after the fact, the service always sets it.
+ALTER TABLE Authorizations ADD COLUMN public_id VARCHAR(31) NULL;
+UPDATE Authorizations SET public_id = 'auth_' || upper(hex(randomblob(13))) WHERE public_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS ux_authorizations_public_id ON Authorizations (public_id);
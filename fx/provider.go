@@ -0,0 +1,30 @@
+package fx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var ErrRateNotFound = errors.New("no exchange rate available")
+
+// RateProvider returns how many units of `to` one unit of `from` buys
+type RateProvider interface {
+	Rate(from, to string) (float64, error)
+}
+
+// Convert applies a rate to an amount, rounding to cents since that's the
+// precision amounts are stored with
+func Convert(amount, rate float64) float64 {
+	return math.Round(amount*rate*100) / 100
+}
+
+// PairKey is the key a currency pair is looked up by, e.g. "USD/BRL"
+func PairKey(from, to string) string {
+	return strings.ToUpper(from) + "/" + strings.ToUpper(to)
+}
+
+func rateNotFound(from, to string) error {
+	return fmt.Errorf("%w: %s", ErrRateNotFound, PairKey(from, to))
+}
@@ -0,0 +1,68 @@
+package fx
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// StaticRateProvider serves rates from a fixed table. It is meant for local
+// development and tests, production should plug in a provider backed by a rates feed.
+type StaticRateProvider struct {
+	mu    sync.RWMutex
+	rates map[string]float64
+}
+
+func NewStaticRateProvider(rates map[string]float64) *StaticRateProvider {
+	provider := &StaticRateProvider{rates: make(map[string]float64)}
+	for pair, rate := range rates {
+		provider.rates[strings.ToUpper(pair)] = rate
+	}
+	return provider
+}
+
+// LoadStaticRateProvider reads a JSON object of pairs to rates, e.g. {"USD/BRL": 5.42}
+func LoadStaticRateProvider(path string) (*StaticRateProvider, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fx rates file: %w", err)
+	}
+
+	var rates map[string]float64
+	if err := json.Unmarshal(contents, &rates); err != nil {
+		return nil, fmt.Errorf("failed to parse fx rates file: %w", err)
+	}
+	for pair, rate := range rates {
+		if rate <= 0 {
+			return nil, fmt.Errorf("invalid fx rate %f for %s", rate, pair)
+		}
+	}
+	return NewStaticRateProvider(rates), nil
+}
+
+// Rate looks up the direct pair first and falls back to inverting the reverse pair
+func (p *StaticRateProvider) Rate(from, to string) (float64, error) {
+	if strings.EqualFold(from, to) {
+		return 1, nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if rate, ok := p.rates[PairKey(from, to)]; ok {
+		return rate, nil
+	}
+	if rate, ok := p.rates[PairKey(to, from)]; ok {
+		return 1 / rate, nil
+	}
+	return 0, rateNotFound(from, to)
+}
+
+// SetRate adds or replaces the rate for a pair
+func (p *StaticRateProvider) SetRate(from, to string, rate float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rates[PairKey(from, to)] = rate
+}
@@ -5,36 +5,43 @@ import (
 	"encoding/json"
     "fmt"
 	"net/http"
-	"strconv"
 	"errors"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 
+	"pismo/audit"
+	"pismo/auth"
+	"pismo/helpers"
 	"pismo/models"
+	"pismo/publicid"
 	"pismo/services"
+	"pismo/store"
+	"pismo/validation"
 )
 
 type AccountHandler struct {
 	accountService services.AccountServicer
+	policy         *auth.Policy
 }
 
-func NewAccountHandler(accountService services.AccountServicer) *AccountHandler {
-    return &AccountHandler{accountService: accountService}
+func NewAccountHandler(accountService services.AccountServicer, policy *auth.Policy) *AccountHandler {
+    return &AccountHandler{accountService: accountService, policy: policy}
 }
 
 func (h *AccountHandler) HandleGetAccount(w http.ResponseWriter, r *http.Request) {
-    vars := mux.Vars(r)
-    idString := vars["id"]
-    
-    idInt, err := strconv.Atoi(idString)
-    if err != nil {
-        msg := fmt.Sprintf("Invalid account ID: %s", idString)
-        http.Error(w, msg, http.StatusBadRequest) // 400
+    ref, ok := accountRefFromPath(w, r)
+    if !ok {
+        return
+    }
+
+    principal, ok := authorizeAction(w, r, h.policy, auth.ActionReadAccount)
+    if !ok {
         return
     }
 
-    account, err := h.accountService.GetAccountByID(idInt)
+    account, err := accountsFor(r, h.accountService).GetAccountByRef(ref)
     if err != nil {
         if errors.Is(err, sql.ErrNoRows) {
             http.Error(w, "Account not found", http.StatusNotFound) // 404
@@ -43,15 +50,119 @@ func (h *AccountHandler) HandleGetAccount(w http.ResponseWriter, r *http.Request
         }
         return
     }
+    if err := h.policy.AuthorizeAccount(principal, auth.ActionReadAccount, account); err != nil {
+        auth.WriteError(w, http.StatusForbidden, err.Error()) // 403
+        return
+    }
 
     w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("ETag", etag(account.Version))
     err = json.NewEncoder(w).Encode(account)
 	if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
     }
 }
 
+// HandleGetAccountBalance answers what the balance of the account was at as_of,
+// an RFC 3339 timestamp, or now when it's left out
+func (h *AccountHandler) HandleGetAccountBalance(w http.ResponseWriter, r *http.Request) {
+    ref, ok := accountRefFromPath(w, r)
+    if !ok {
+        return
+    }
+
+    asOf := time.Now().UTC()
+    if v := r.URL.Query().Get("as_of"); v != "" {
+        var err error
+        if asOf, err = time.Parse(time.RFC3339, v); err != nil {
+            msg := fmt.Sprintf("Invalid as_of, must be an RFC 3339 timestamp: %s", v)
+            http.Error(w, msg, http.StatusBadRequest) // 400
+            return
+        }
+    }
+
+    principal, ok := authorizeAction(w, r, h.policy, auth.ActionReadAccount)
+    if !ok {
+        return
+    }
+
+    accounts := accountsFor(r, h.accountService)
+    account, err := accounts.GetAccountByRef(ref)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            http.Error(w, "Account not found", http.StatusNotFound) // 404
+        } else {
+            http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+        }
+        return
+    }
+    if err := h.policy.AuthorizeAccount(principal, auth.ActionReadAccount, account); err != nil {
+        auth.WriteError(w, http.StatusForbidden, err.Error()) // 403
+        return
+    }
+
+    balance, err := accounts.GetAccountBalance(account.ID, asOf)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+        return
+    }
+    balance.AccountPublicID = account.PublicID
+
+    w.Header().Set("Content-Type", "application/json")
+    err = json.NewEncoder(w).Encode(balance)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+// HandleGetAccountTotals returns the running totals of the account, cheaper than
+// its balance as nothing is added up
+func (h *AccountHandler) HandleGetAccountTotals(w http.ResponseWriter, r *http.Request) {
+    ref, ok := accountRefFromPath(w, r)
+    if !ok {
+        return
+    }
+
+    principal, ok := authorizeAction(w, r, h.policy, auth.ActionReadAccount)
+    if !ok {
+        return
+    }
+
+    accounts := accountsFor(r, h.accountService)
+    account, err := accounts.GetAccountByRef(ref)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            http.Error(w, "Account not found", http.StatusNotFound) // 404
+        } else {
+            http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+        }
+        return
+    }
+    if err := h.policy.AuthorizeAccount(principal, auth.ActionReadAccount, account); err != nil {
+        auth.WriteError(w, http.StatusForbidden, err.Error()) // 403
+        return
+    }
+
+    totals, err := accounts.GetAccountTotals(account.ID)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+        return
+    }
+    totals.AccountPublicID = account.PublicID
+
+    w.Header().Set("Content-Type", "application/json")
+    err = json.NewEncoder(w).Encode(totals)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
 func (h *AccountHandler) HandleCreateAccount(w http.ResponseWriter, r *http.Request) {
+    principal, ok := authorizeAction(w, r, h.policy, auth.ActionCreateAccount)
+    if !ok {
+        return
+    }
+
     var req models.Account
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
         http.Error(w, err.Error(), http.StatusBadRequest) // 400
@@ -62,23 +173,209 @@ func (h *AccountHandler) HandleCreateAccount(w http.ResponseWriter, r *http.Requ
         http.Error(w, "No document number provided", http.StatusBadRequest) // 400
         return
     }
-    _, err := strconv.Atoi(req.DocumentNumber)
-    if err != nil {
-        msg := fmt.Sprintf("Invalid document number in payload, must be of type int/long: %s", req.DocumentNumber)
-        http.Error(w, msg, http.StatusBadRequest) // 400
+    if req.HolderName == "" {
+        http.Error(w, "No holder name provided", http.StatusBadRequest) // 400
+        return
+    }
+    if err := validateAccountRequest(req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest) // 400
         return
     }
 
-    accountID, err := h.accountService.CreateAccount(req.DocumentNumber)
+    // tenant scoped callers always open accounts under their own tenant
+    if h.policy.IsTenantScoped(principal, auth.ActionCreateAccount) {
+        if principal.TenantID == "" {
+            auth.WriteError(w, http.StatusForbidden, "principal has no tenant to open accounts under") // 403
+            return
+        }
+        req.TenantID = principal.TenantID
+    }
+
+    account, err := h.accountService.CreateAccount(req)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+        var conflict *store.ConflictError
+        if errors.As(err, &conflict) {
+            h.writeAccountConflict(w, principal, conflict)
+        } else if isAccountValidationError(err) {
+            http.Error(w, err.Error(), http.StatusBadRequest) // 400
+        } else {
+            http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+        }
         return
     }
 
+    audit.RecordAccount(r.Context(), account.ID)
+
     w.Header().Set("Content-Type", "application/json")
-    resp := fmt.Sprintf("successfully created new account with ID %d", accountID)
+    resp := fmt.Sprintf("successfully created new account with ID %s", account.PublicID)
     err = json.NewEncoder(w).Encode(resp)
     if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
     }
 }
+
+// HandleUpdateAccount changes the holder name, status or credit limit of the
+// account. Only the fields in the body are changed, a null credit_limit goes back
+// to the limit of the product. The client has to send the ETag of the version it
+// read in If-Match, updates made against an older version get 412.
+func (h *AccountHandler) HandleUpdateAccount(w http.ResponseWriter, r *http.Request) {
+    ref, ok := accountRefFromPath(w, r)
+    if !ok {
+        return
+    }
+
+    principal, ok := authorizeAction(w, r, h.policy, auth.ActionUpdateAccount)
+    if !ok {
+        return
+    }
+
+    version, ok := versionFromIfMatch(w, r)
+    if !ok {
+        return
+    }
+
+    update, err := decodeAccountUpdate(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest) // 400
+        return
+    }
+
+    // the version has to be compared with the account as it is now, not as a replica has it
+    account, err := h.accountService.ReadYourWrites().GetAccountByRef(ref)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            http.Error(w, "Account not found", http.StatusNotFound) // 404
+        } else {
+            http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+        }
+        return
+    }
+    audit.RecordAccount(r.Context(), account.ID)
+    if err := h.policy.AuthorizeAccount(principal, auth.ActionUpdateAccount, account); err != nil {
+        auth.WriteError(w, http.StatusForbidden, err.Error()) // 403
+        return
+    }
+    if account.Version != version {
+        w.Header().Set("ETag", etag(account.Version))
+        http.Error(w, "If-Match doesn't match the current version", http.StatusPreconditionFailed) // 412
+        return
+    }
+
+    account, err = h.accountService.UpdateAccount(account.ID, update, version)
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            http.Error(w, "Account not found", http.StatusNotFound) // 404
+        case errors.Is(err, store.ErrVersionMismatch):
+            http.Error(w, "If-Match doesn't match the current version", http.StatusPreconditionFailed) // 412
+        case errors.Is(err, services.ErrInvalidAccountUpdate):
+            http.Error(w, err.Error(), http.StatusBadRequest) // 400
+        default:
+            http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+        }
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("ETag", etag(account.Version))
+    err = json.NewEncoder(w).Encode(account)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+// accountRefFromPath reads the account named in the path, by its public ID or,
+// while clients move over to those, by its internal ID. It writes the 400 and
+// returns false when it is neither.
+func accountRefFromPath(w http.ResponseWriter, r *http.Request) (models.PublicID, bool) {
+    idString := mux.Vars(r)["id"]
+    ref := models.PublicID(idString)
+    if _, ok := ref.Legacy(); !ok && !publicid.Valid(idString, publicid.Account) {
+        msg := fmt.Sprintf("Invalid account ID: %s", idString)
+        http.Error(w, msg, http.StatusBadRequest) // 400
+        return "", false
+    }
+    return ref, true
+}
+
+// decodeAccountUpdate reads the fields of the account to change, telling a null
+// credit_limit apart from one that isn't in the body
+func decodeAccountUpdate(r *http.Request) (models.AccountUpdate, error) {
+    var fields map[string]json.RawMessage
+    if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+        return models.AccountUpdate{}, err
+    }
+    if len(fields) == 0 {
+        return models.AccountUpdate{}, errors.New("Nothing to update, send holder_name, status or credit_limit")
+    }
+
+    var update models.AccountUpdate
+    for name, value := range fields {
+        var err error
+        switch name {
+        case "holder_name":
+            err = json.Unmarshal(value, &update.HolderName)
+        case "status":
+            err = json.Unmarshal(value, &update.Status)
+        case "credit_limit":
+            if string(value) == "null" {
+                update.ClearCreditLimit = true
+            } else {
+                err = json.Unmarshal(value, &update.CreditLimit)
+            }
+        default:
+            return models.AccountUpdate{}, fmt.Errorf("%s can't be changed, only holder_name, status and credit_limit can", name)
+        }
+        if err != nil {
+            return models.AccountUpdate{}, fmt.Errorf("Invalid %s: %w", name, err)
+        }
+    }
+    return update, nil
+}
+
+// validateAccountRequest checks the format of the optional holder fields, empty
+// values are left for the service to default. Document numbers are validated by
+// the service against the rules of their document type.
+func validateAccountRequest(req models.Account) error {
+    if req.Country != "" && !helpers.IsCountryCode(req.Country) {
+        return fmt.Errorf("Invalid country, must be an ISO 3166 alpha-2 code: %s", req.Country)
+    }
+    if req.Currency != "" && !helpers.IsCurrencyCode(req.Currency) {
+        return fmt.Errorf("Invalid currency, must be an ISO 4217 code: %s", req.Currency)
+    }
+    if req.ProductID < 0 {
+        return fmt.Errorf("Invalid product ID: %d", req.ProductID)
+    }
+    return nil
+}
+
+// writeAccountConflict responds with 409 and the public ID of the account that
+// already holds the document number, so clients can recover from a retried create.
+// The ID is left out unless the caller may read that account, a partner must not
+// learn that another tenant's customer exists, let alone its ID.
+func (h *AccountHandler) writeAccountConflict(w http.ResponseWriter, principal auth.Principal, conflict *store.ConflictError) {
+    var accountID models.PublicID
+    if conflict.ExistingID != 0 {
+        existing, err := h.accountService.GetAccountByID(conflict.ExistingID)
+        if err == nil && h.policy.AuthorizeAccount(principal, auth.ActionReadAccount, existing) == nil {
+            accountID = existing.PublicID
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusConflict) // 409
+    resp := struct {
+        Error     string          `json:"error"`
+        AccountID models.PublicID `json:"account_id,omitempty"`
+    }{
+        Error:     conflict.Error(),
+        AccountID: accountID,
+    }
+    json.NewEncoder(w).Encode(resp)
+}
+
+func isAccountValidationError(err error) bool {
+    return errors.Is(err, services.ErrProductNotFound) ||
+        errors.Is(err, validation.ErrInvalidDocument) ||
+        errors.Is(err, validation.ErrUnsupportedDocumentType)
+}
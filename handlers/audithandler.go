@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"pismo/auth"
+	"pismo/models"
+	"pismo/services"
+)
+
+type AuditHandler struct {
+	auditService services.AuditServicer
+	policy       *auth.Policy
+}
+
+func NewAuditHandler(auditService services.AuditServicer, policy *auth.Policy) *AuditHandler {
+	return &AuditHandler{auditService: auditService, policy: policy}
+}
+
+// HandleListAuditEntries returns audit entries in chain order, optionally only
+// those for an account and/or within [from, to). Page with after_id and limit.
+func (h *AuditHandler) HandleListAuditEntries(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeAction(w, r, h.policy, auth.ActionReadAuditLog); !ok {
+		return
+	}
+
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // 400
+		return
+	}
+
+	entries, err := h.auditService.ListEntries(filter)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAuditFilter) {
+			http.Error(w, err.Error(), http.StatusBadRequest) // 400
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// HandleVerifyAuditLog walks the whole chain, the answer is 200 either way and
+// says whether the log is intact
+func (h *AuditHandler) HandleVerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeAction(w, r, h.policy, auth.ActionVerifyAuditLog); !ok {
+		return
+	}
+
+	result, err := h.auditService.VerifyChain()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func auditFilterFromQuery(r *http.Request) (models.AuditFilter, error) {
+	query := r.URL.Query()
+	var filter models.AuditFilter
+	var err error
+
+	if v := query.Get("account_id"); v != "" {
+		if filter.AccountID, err = strconv.ParseInt(v, 10, 64); err != nil || filter.AccountID <= 0 {
+			return models.AuditFilter{}, fmt.Errorf("Invalid account ID: %s", v)
+		}
+	}
+	if v := query.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return models.AuditFilter{}, fmt.Errorf("Invalid from, must be an RFC 3339 timestamp: %s", v)
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return models.AuditFilter{}, fmt.Errorf("Invalid to, must be an RFC 3339 timestamp: %s", v)
+		}
+	}
+	if v := query.Get("after_id"); v != "" {
+		if filter.AfterID, err = strconv.ParseInt(v, 10, 64); err != nil || filter.AfterID < 0 {
+			return models.AuditFilter{}, fmt.Errorf("Invalid after_id: %s", v)
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			return models.AuditFilter{}, fmt.Errorf("Invalid limit: %s", v)
+		}
+	}
+	return filter, nil
+}
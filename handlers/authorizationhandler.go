@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"pismo/audit"
+	"pismo/auth"
+	"pismo/fx"
+	"pismo/helpers"
+	"pismo/models"
+	"pismo/publicid"
+	"pismo/services"
+)
+
+const (
+	maxCaptureBytes = 1 << 10 // 1KB, a capture body only carries an amount
+)
+
+type AuthorizationHandler struct {
+	authorizationService services.AuthorizationServicer
+	accountService       services.AccountServicer
+	policy               *auth.Policy
+}
+
+func NewAuthorizationHandler(authorizationService services.AuthorizationServicer, accountService services.AccountServicer, policy *auth.Policy) *AuthorizationHandler {
+	return &AuthorizationHandler{authorizationService: authorizationService, accountService: accountService, policy: policy}
+}
+
+type captureRequest struct {
+	Amount float64 `json:"amount"` // optional, defaults to the authorized amount
+}
+
+func (h *AuthorizationHandler) HandleCreateAuthorization(w http.ResponseWriter, r *http.Request) {
+	var req models.Authorization
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // 400
+		return
+	}
+
+	if req.AccountPublicID == "" {
+		http.Error(w, "No Account ID provided", http.StatusBadRequest) // 400
+		return
+	}
+	if _, ok := req.AccountPublicID.Legacy(); !ok && !publicid.Valid(string(req.AccountPublicID), publicid.Account) {
+		msg := fmt.Sprintf("Invalid account ID: %s", req.AccountPublicID)
+		http.Error(w, msg, http.StatusBadRequest) // 400
+		return
+	}
+	if req.OperationTypeID == 0 {
+		http.Error(w, "No Operation Type ID provided", http.StatusBadRequest) // 400
+		return
+	}
+	if req.Amount == 0.0 {
+		http.Error(w, "No Amount provided", http.StatusBadRequest) // 400
+		return
+	}
+	if req.Currency != "" && !helpers.IsCurrencyCode(req.Currency) {
+		msg := fmt.Sprintf("Invalid currency, must be an ISO 4217 code: %s", req.Currency)
+		http.Error(w, msg, http.StatusBadRequest) // 400
+		return
+	}
+
+	accountID, ok := authorizeAccountAction(w, r, h.policy, h.accountService, auth.ActionManageAuthorization, req.AccountPublicID)
+	if !ok {
+		return
+	}
+	req.AccountID = accountID
+	audit.RecordAccount(r.Context(), req.AccountID)
+
+	authorization, err := h.authorizationService.Authorize(req)
+	if err != nil {
+		writeAuthorizationError(w, err)
+		return
+	}
+	writeAuthorization(w, authorization)
+}
+
+func (h *AuthorizationHandler) HandleGetAuthorization(w http.ResponseWriter, r *http.Request) {
+	authorization, ok := h.holdFromPath(w, r, auth.ActionReadAuthorization)
+	if !ok {
+		return
+	}
+	writeAuthorization(w, authorization)
+}
+
+func (h *AuthorizationHandler) HandleCaptureAuthorization(w http.ResponseWriter, r *http.Request) {
+	hold, ok := h.holdFromPath(w, r, auth.ActionManageAuthorization)
+	if !ok {
+		return
+	}
+
+	var req captureRequest
+	// the body is optional, an empty one captures the full amount. Chunked
+	// requests have no ContentLength, so look for EOF instead of trusting it
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCaptureBytes)).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge) // 413
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest) // 400
+		return
+	}
+
+	authorization, err := h.authorizationService.Capture(hold.ID, req.Amount)
+	if err != nil {
+		writeAuthorizationError(w, err)
+		return
+	}
+	audit.RecordAccount(r.Context(), authorization.AccountID)
+	audit.RecordTransaction(r.Context(), authorization.TransactionID)
+	writeAuthorization(w, authorization)
+}
+
+func (h *AuthorizationHandler) HandleVoidAuthorization(w http.ResponseWriter, r *http.Request) {
+	hold, ok := h.holdFromPath(w, r, auth.ActionManageAuthorization)
+	if !ok {
+		return
+	}
+
+	authorization, err := h.authorizationService.Void(hold.ID)
+	if err != nil {
+		writeAuthorizationError(w, err)
+		return
+	}
+	audit.RecordAccount(r.Context(), authorization.AccountID)
+	writeAuthorization(w, authorization)
+}
+
+// holdFromPath reads the hold named in the path and checks the caller may perform
+// the action on it, tenant scoped callers only on holds against their own
+// accounts. It writes the error response and returns false when they may not.
+func (h *AuthorizationHandler) holdFromPath(w http.ResponseWriter, r *http.Request, action auth.Action) (models.Authorization, bool) {
+	ref, ok := authorizationRefFromPath(w, r)
+	if !ok {
+		return models.Authorization{}, false
+	}
+	principal, ok := authorizeAction(w, r, h.policy, action)
+	if !ok {
+		return models.Authorization{}, false
+	}
+
+	authorization, err := h.authorizationService.GetAuthorizationByRef(ref)
+	if err != nil {
+		writeAuthorizationError(w, err)
+		return models.Authorization{}, false
+	}
+	if h.policy.IsTenantScoped(principal, action) {
+		if _, ok := authorizeAccountAction(w, r, h.policy, h.accountService, action, authorization.AccountPublicID); !ok {
+			return models.Authorization{}, false
+		}
+	}
+	return authorization, true
+}
+
+// authorizationRefFromPath reads the hold named in the path, by its public ID or,
+// while clients move over to those, by its internal ID. It writes the 400 and
+// returns false when it is neither.
+func authorizationRefFromPath(w http.ResponseWriter, r *http.Request) (models.PublicID, bool) {
+	idString := mux.Vars(r)["id"]
+	ref := models.PublicID(idString)
+	if _, ok := ref.Legacy(); !ok && !publicid.Valid(idString, publicid.Authorization) {
+		msg := fmt.Sprintf("Invalid authorization ID: %s", idString)
+		http.Error(w, msg, http.StatusBadRequest) // 400
+		return "", false
+	}
+	return ref, true
+}
+
+func writeAuthorization(w http.ResponseWriter, authorization models.Authorization) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(authorization.Version))
+	if err := json.NewEncoder(w).Encode(authorization); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeAuthorizationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrAuthorizationNotFound):
+		http.Error(w, "Authorization not found", http.StatusNotFound) // 404
+	case errors.Is(err, services.ErrAccountNotFound):
+		http.Error(w, "Account not found", http.StatusNotFound) // 404
+	case errors.Is(err, services.ErrAuthorizationNotPending), errors.Is(err, services.ErrAuthorizationExpired):
+		http.Error(w, err.Error(), http.StatusConflict) // 409
+	case errors.Is(err, services.ErrInsufficientCredit), errors.Is(err, services.ErrAccountNotActive):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity) // 422
+	case errors.Is(err, services.ErrNotADebit), errors.Is(err, services.ErrInvalidCapture), errors.Is(err, fx.ErrRateNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest) // 400
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"pismo/auth"
+	"pismo/batch"
+	"pismo/models"
+	"pismo/services"
+)
+
+const (
+	maxBatchBytes = 10 << 20 // 10MB, about the size of batch.MaxRows rows of JSON
+)
+
+type BatchHandler struct {
+	batchService   services.BatchServicer
+	accountService services.AccountServicer
+	policy         *auth.Policy
+}
+
+func NewBatchHandler(batchService services.BatchServicer, accountService services.AccountServicer, policy *auth.Policy) *BatchHandler {
+	return &BatchHandler{batchService: batchService, accountService: accountService, policy: policy}
+}
+
+// HandleCreateTransactionBatch posts a JSON array, CSV or JSONL upload of
+// transactions, picked by Content-Type or the format query parameter, and
+// answers with a result per row. Bad rows don't stop the rest of the upload.
+func (h *BatchHandler) HandleCreateTransactionBatch(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAction(w, r, h.policy, auth.ActionCreateTransaction)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		var err error
+		if format, err = batch.FormatFromContentType(r.Header.Get("Content-Type")); err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType) // 415
+			return
+		}
+	}
+
+	rows, err := batch.Parse(http.MaxBytesReader(w, r.Body, maxBatchBytes), format)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, batch.ErrUnsupportedFormat):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType) // 415
+		case errors.Is(err, batch.ErrTooManyRows), errors.As(err, &tooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge) // 413
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest) // 400
+		}
+		return
+	}
+
+	if h.policy.IsTenantScoped(principal, auth.ActionCreateTransaction) {
+		if err := h.rejectForeignAccounts(principal, rows); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+			return
+		}
+	}
+
+	report := h.batchService.ProcessBatch(rows)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// rejectForeignAccounts marks the rows for accounts outside the caller's tenant,
+// looking each account up once however the rows name it
+func (h *BatchHandler) rejectForeignAccounts(principal auth.Principal, rows []models.BatchRow) error {
+	allowed := make(map[models.PublicID]string)
+	for i, row := range rows {
+		if row.Error != "" {
+			continue
+		}
+		ref := row.Transaction.AccountPublicID
+		problem, seen := allowed[ref]
+		if !seen {
+			account, err := h.accountService.GetAccountByRef(ref)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				problem = services.ErrAccountNotFound.Error()
+			case err != nil:
+				return fmt.Errorf("failed to look up account %s: %w", ref, err)
+			default:
+				if err := h.policy.AuthorizeAccount(principal, auth.ActionCreateTransaction, account); err != nil {
+					problem = err.Error()
+				}
+			}
+			allowed[ref] = problem
+		}
+		rows[i].Error = problem
+	}
+	return nil
+}
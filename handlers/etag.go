@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag is the entity tag of a version of a resource, strong as versions only
+// ever go up
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// versionFromIfMatch reads the version the client is updating from the If-Match
+// header. Updates have to name the version they were made against, so a missing
+// header gets 428 and anything but one of our entity tags 412. It writes the
+// error response and returns false when the update can't go ahead.
+func versionFromIfMatch(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		http.Error(w, "If-Match with the ETag of the version being updated is required", http.StatusPreconditionRequired) // 428
+		return 0, false
+	}
+
+	tag, ok := strings.CutPrefix(ifMatch, `"`)
+	if ok {
+		tag, ok = strings.CutSuffix(tag, `"`)
+	}
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if !ok || err != nil {
+		http.Error(w, "If-Match doesn't match the current version", http.StatusPreconditionFailed) // 412
+		return 0, false
+	}
+	return version, true
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"pismo/auth"
+	"pismo/services"
+)
+
+type LedgerHandler struct {
+	ledgerService services.LedgerServicer
+	policy        *auth.Policy
+}
+
+func NewLedgerHandler(ledgerService services.LedgerServicer, policy *auth.Policy) *LedgerHandler {
+	return &LedgerHandler{ledgerService: ledgerService, policy: policy}
+}
+
+// HandleGetTrialBalance returns the totals of every ledger account, one trial
+// balance per currency
+func (h *LedgerHandler) HandleGetTrialBalance(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeAction(w, r, h.policy, auth.ActionReadLedger); !ok {
+		return
+	}
+
+	balances, err := h.ledgerService.TrialBalance()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(balances); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
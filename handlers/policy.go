@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"pismo/auth"
+	"pismo/models"
+	"pismo/services"
+)
+
+// authorizeAction checks the caller's roles allow the action at all. It writes
+// the 403 and returns false when they don't.
+func authorizeAction(w http.ResponseWriter, r *http.Request, policy *auth.Policy, action auth.Action) (auth.Principal, bool) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if err := policy.Authorize(principal, action); err != nil {
+		auth.WriteError(w, http.StatusForbidden, err.Error()) // 403
+		return principal, false
+	}
+	return principal, true
+}
+
+// authorizeAccountAction checks the caller may perform the action on the account
+// named by ref, its public ID or its internal one, and returns its internal ID.
+// The account is only looked up when it's named by its public ID or the caller
+// is limited to accounts of its own tenant, on the primary as clients act on
+// accounts they have only just opened. It writes the error response and returns
+// false when the caller may not go ahead.
+func authorizeAccountAction(w http.ResponseWriter, r *http.Request, policy *auth.Policy, accounts services.AccountServicer, action auth.Action, ref models.PublicID) (int64, bool) {
+	principal, ok := authorizeAction(w, r, policy, action)
+	if !ok {
+		return 0, false
+	}
+	tenantScoped := policy.IsTenantScoped(principal, action)
+	if id, ok := ref.Legacy(); ok && !tenantScoped {
+		return id, true
+	}
+
+	account, err := accounts.ReadYourWrites().GetAccountByRef(ref)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Account not found", http.StatusNotFound) // 404
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError) // 500
+		}
+		return 0, false
+	}
+	if tenantScoped {
+		if err := policy.AuthorizeAccount(principal, action, account); err != nil {
+			auth.WriteError(w, http.StatusForbidden, err.Error()) // 403
+			return 0, false
+		}
+	}
+	return account.ID, true
+}
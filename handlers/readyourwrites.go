@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"pismo/services"
+)
+
+// ReadYourWritesHeader set to true has the reads of the request go to the primary
+// database, for clients that have to see a write they've just made. Otherwise
+// they may be answered by a replica that is a little behind.
+const ReadYourWritesHeader = "X-Read-Your-Writes"
+
+// accountsFor is the account service to answer the reads of the request with
+func accountsFor(r *http.Request, accounts services.AccountServicer) services.AccountServicer {
+	if readYourWrites, _ := strconv.ParseBool(r.Header.Get(ReadYourWritesHeader)); readYourWrites {
+		return accounts.ReadYourWrites()
+	}
+	return accounts
+}
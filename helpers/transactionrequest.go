@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+
+	"pismo/models"
+	"pismo/publicid"
+)
+
+// ValidateTransactionRequest checks a transaction as sent by a client has
+// everything needed to post it, before any lookups are done. The account is
+// named by its public id, or still by its internal id, see models.PublicID.
+func ValidateTransactionRequest(t models.Transaction) error {
+	if t.AccountID == 0 {
+		if t.AccountPublicID == "" {
+			return errors.New("No Account ID provided")
+		}
+		if _, ok := t.AccountPublicID.Legacy(); !ok && !publicid.Valid(string(t.AccountPublicID), publicid.Account) {
+			return fmt.Errorf("Invalid account ID: %s", t.AccountPublicID)
+		}
+	}
+	if t.OperationTypeID == 0 {
+		return errors.New("No Operation Type ID provided")
+	}
+	if t.Amount == 0.0 {
+		return errors.New("No Amount provided")
+	}
+	if t.Currency != "" && !IsCurrencyCode(t.Currency) {
+		return fmt.Errorf("Invalid currency, must be an ISO 4217 code: %s", t.Currency)
+	}
+	return nil
+}
+
+// IsCurrencyCode reports whether s looks like an ISO 4217 code, three ASCII letters
+func IsCurrencyCode(s string) bool {
+	return isASCIILetters(s, 3)
+}
+
+// IsCountryCode reports whether s looks like an ISO 3166 alpha-2 code, two ASCII letters
+func IsCountryCode(s string) bool {
+	return isASCIILetters(s, 2)
+}
+
+func isASCIILetters(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if !unicode.IsLetter(c) || c > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
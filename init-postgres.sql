@@ -0,0 +1,6 @@
+-- init.sql inserts its sample rows with explicit ids, which Postgres doesn't count
+-- towards the id sequences, so move them past those rows
+SELECT setval(pg_get_serial_sequence('Products', 'product_id'), (SELECT MAX(product_id) FROM Products));
+SELECT setval(pg_get_serial_sequence('Accounts', 'account_id'), (SELECT MAX(account_id) FROM Accounts));
+SELECT setval(pg_get_serial_sequence('OperationTypes', 'operation_type_id'), (SELECT MAX(operation_type_id) FROM OperationTypes));
+SELECT setval(pg_get_serial_sequence('Transactions', 'transaction_id'), (SELECT MAX(transaction_id) FROM Transactions));
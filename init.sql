@@ -1,26 +1,11 @@
-CREATE TABLE IF NOT EXISTS Accounts (
-    account_id INT AUTO_INCREMENT PRIMARY KEY,
-    document_number VARCHAR(20)
-);
+-- sample data, the schema is created by database/migrations
+INSERT INTO Products (product_id, name, credit_limit)
+VALUES
+(1, 'Standard', 1000.00),
+(2, 'Gold', 5000.00);
 
-CREATE TABLE IF NOT EXISTS OperationTypes (
-    operation_type_id INT AUTO_INCREMENT PRIMARY KEY,
-    description0 VARCHAR(50)
-);
-
-CREATE TABLE IF NOT EXISTS Transactions (
-    transaction_id INT AUTO_INCREMENT PRIMARY KEY,
-    account_id INT,
-    operation_type_id INT,
-    amount DECIMAL(10, 2),
-    balance DECIMAL(10, 2) DEFAULT 0.0,
-    event_date DATETIME DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (account_id) REFERENCES Accounts(account_id),
-    FOREIGN KEY (operation_type_id) REFERENCES OperationTypes(operation_type_id)
-);
-
-INSERT INTO Accounts (account_id, document_number) 
-VALUES (1, '12345678900');
+INSERT INTO Accounts (account_id, document_number, document_type, holder_name, country, product_id, currency)
+VALUES (1, '12345678909', 'CPF', 'Maria Silva', 'BR', 1, 'BRL');
 
 INSERT INTO OperationTypes (operation_type_id, description0)
 VALUES 
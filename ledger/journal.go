@@ -0,0 +1,95 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"pismo/models"
+)
+
+var ErrInvalidEntry = errors.New("invalid journal entry")
+
+// CustomerAccount is what the customer owes us, debited by purchases and
+// withdrawals and credited by payments
+func CustomerAccount(accountID int64, currency string) models.LedgerAccount {
+	return models.LedgerAccount{Code: "customer:" + strconv.FormatInt(accountID, 10), Type: models.LedgerAsset, AccountID: accountID, Currency: currency}
+}
+
+// SettlementAccount is what we owe the card network for purchases made by customers
+func SettlementAccount(currency string) models.LedgerAccount {
+	return models.LedgerAccount{Code: "settlement:" + currency, Type: models.LedgerLiability, Currency: currency}
+}
+
+// CashAccount is the money actually paid out for withdrawals and received as payments
+func CashAccount(currency string) models.LedgerAccount {
+	return models.LedgerAccount{Code: "cash:" + currency, Type: models.LedgerAsset, Currency: currency}
+}
+
+// ForTransaction builds the journal entry recording a transaction, in the account
+// currency the transaction has already been converted to
+func ForTransaction(transaction models.Transaction) (models.JournalEntry, error) {
+	customer := CustomerAccount(transaction.AccountID, transaction.Currency)
+	amount := math.Abs(transaction.Amount)
+
+	var counterpart models.LedgerAccount
+	var description string
+	switch transaction.OperationTypeID {
+	case 1, 2:
+		counterpart, description = SettlementAccount(transaction.Currency), "purchase"
+	case 3:
+		counterpart, description = CashAccount(transaction.Currency), "withdrawal"
+	case 4:
+		counterpart, description = CashAccount(transaction.Currency), "payment"
+	default:
+		return models.JournalEntry{}, fmt.Errorf("%w: no postings for operation type %d", ErrInvalidEntry, transaction.OperationTypeID)
+	}
+
+	// debits pull money into the customer's debt, payments pay it back off
+	debit, credit := customer, counterpart
+	if transaction.OperationTypeID == 4 {
+		debit, credit = counterpart, customer
+	}
+
+	entry := models.JournalEntry{
+		TransactionID: transaction.ID,
+		Description:   description,
+		Currency:      transaction.Currency,
+		Postings: []models.Posting{
+			{LedgerAccount: debit, Amount: amount},
+			{LedgerAccount: credit, Amount: -amount},
+		},
+	}
+	return entry, Validate(entry)
+}
+
+// Validate checks the invariants every journal entry must hold before it's
+// written: at least two postings, none of them zero, all in the entry's
+// currency and summing to exactly zero
+func Validate(entry models.JournalEntry) error {
+	if len(entry.Postings) < 2 {
+		return fmt.Errorf("%w: needs at least two postings, has %d", ErrInvalidEntry, len(entry.Postings))
+	}
+
+	var sum int64
+	for _, posting := range entry.Postings {
+		cents := ToCents(posting.Amount)
+		if cents == 0 {
+			return fmt.Errorf("%w: posting to %s has no amount", ErrInvalidEntry, posting.LedgerAccount.Code)
+		}
+		if posting.LedgerAccount.Currency != entry.Currency {
+			return fmt.Errorf("%w: posting to %s is in %s, entry is in %s", ErrInvalidEntry, posting.LedgerAccount.Code, posting.LedgerAccount.Currency, entry.Currency)
+		}
+		sum += cents
+	}
+	if sum != 0 {
+		return fmt.Errorf("%w: postings are off balance by %.2f", ErrInvalidEntry, float64(sum)/100)
+	}
+	return nil
+}
+
+// ToCents rounds an amount to the cents it's stored as
+func ToCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
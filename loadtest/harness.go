@@ -0,0 +1,257 @@
+// Package loadtest fires concurrent mixed debit and credit workloads at the
+// transaction service and then checks the stored balances still add up, to catch
+// lost updates and discharges that were applied twice or not at all.
+package loadtest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"pismo/ledger"
+	"pismo/models"
+	"pismo/services"
+	"pismo/store"
+)
+
+const (
+	DefaultWorkers     = 16
+	DefaultOperations  = 1000
+	DefaultCreditRatio = 0.4
+	DefaultMaxAmount   = 100.0
+)
+
+var ErrNoAccounts = errors.New("load test needs at least one account")
+
+// Config describes the workload. Operations transactions are spread at random over
+// Accounts and posted by Workers goroutines at the same time, CreditRatio of them
+// credit vouchers and the rest debits, each for up to MaxAmount.
+type Config struct {
+	Accounts    []int64
+	Workers     int
+	Operations  int
+	CreditRatio float64
+	MaxAmount   float64
+	Seed        int64
+}
+
+// posted is a transaction the service accepted
+type posted struct {
+	id          int64
+	transaction models.Transaction
+}
+
+// Run posts the workload through transactions, then reads every account back
+// from db and checks its invariants and the ledger's
+func Run(config Config, transactions services.TransactionServicer, db store.ReconciliationRepositoryer, ledgers services.LedgerServicer) (models.LoadTestReport, error) {
+	if len(config.Accounts) == 0 {
+		return models.LoadTestReport{}, ErrNoAccounts
+	}
+	applyDefaults(&config)
+
+	workload := Workload(config)
+	report := models.LoadTestReport{Operations: len(workload), Errors: map[string]int{}}
+
+	var mu sync.Mutex
+	accepted := make(map[int64][]posted)
+	jobs := make(chan models.Transaction)
+	var wg sync.WaitGroup
+
+	started := time.Now()
+	for w := 0; w < config.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for transaction := range jobs {
+				created, err := transactions.CreateTransaction(transaction)
+
+				mu.Lock()
+				if err != nil {
+					report.Failed++
+					report.Errors[err.Error()]++
+				} else {
+					report.Created++
+					accepted[transaction.AccountID] = append(accepted[transaction.AccountID], posted{id: created.ID, transaction: transaction})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, transaction := range workload {
+		jobs <- transaction
+	}
+	close(jobs)
+	wg.Wait()
+
+	elapsed := time.Since(started)
+	report.DurationMillis = elapsed.Milliseconds()
+	if elapsed > 0 {
+		report.PerSecond = float64(report.Created) / elapsed.Seconds()
+	}
+
+	for _, accountID := range uniqueAccounts(config.Accounts) {
+		invariants, violations, err := checkAccount(db, accountID, accepted[accountID])
+		if err != nil {
+			return report, fmt.Errorf("failed to check account %d: %w", accountID, err)
+		}
+		report.Accounts = append(report.Accounts, invariants)
+		report.Violations = append(report.Violations, violations...)
+	}
+
+	balances, err := ledgers.TrialBalance()
+	if err != nil {
+		return report, fmt.Errorf("failed to get the trial balance: %w", err)
+	}
+	report.LedgerBalanced = true
+	for _, balance := range balances {
+		if !balance.Balanced {
+			report.LedgerBalanced = false
+			report.Violations = append(report.Violations, fmt.Sprintf("ledger: %s debits %.2f don't match credits %.2f", balance.Currency, balance.TotalDebits, balance.TotalCredits))
+		}
+	}
+
+	if report.Violations == nil {
+		report.Violations = []string{}
+	}
+	report.Passed = len(report.Violations) == 0
+	return report, nil
+}
+
+// Workload is the transactions Run posts, the same config always gives the same workload
+func Workload(config Config) []models.Transaction {
+	applyDefaults(&config)
+	random := rand.New(rand.NewSource(config.Seed))
+
+	workload := make([]models.Transaction, config.Operations)
+	maxCents := int64(math.Round(config.MaxAmount * 100))
+	for i := range workload {
+		amount := float64(random.Int63n(maxCents)+1) / 100
+		transaction := models.Transaction{AccountID: config.Accounts[random.Intn(len(config.Accounts))]}
+		if random.Float64() < config.CreditRatio {
+			transaction.OperationTypeID = 4
+			transaction.Amount = amount
+		} else {
+			// purchases, installments and withdrawals
+			transaction.OperationTypeID = random.Intn(3) + 1
+			transaction.Amount = -amount
+		}
+		workload[i] = transaction
+	}
+	return workload
+}
+
+// checkAccount compares what is stored for the account with what the load test
+// posted to it. Every accepted transaction must be there with its amount, every
+// balance between zero and its amount, what was paid off the debits must be what
+// left the credits, and the balances and running totals must be the ones a
+// replay gives.
+func checkAccount(db store.ReconciliationRepositoryer, accountID int64, accepted []posted) (models.AccountInvariants, []string, error) {
+	invariants := models.AccountInvariants{AccountID: accountID, Posted: len(accepted)}
+	var violations []string
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf("account %d: ", accountID)+fmt.Sprintf(format, args...))
+	}
+
+	transactions, err := db.ListAccountTransactions(accountID)
+	if err != nil {
+		return invariants, nil, err
+	}
+	allocations, err := db.ListAccountAllocations(accountID)
+	if err != nil {
+		return invariants, nil, err
+	}
+	totals, err := db.GetAccountTotals(accountID)
+	if err != nil {
+		return invariants, nil, err
+	}
+	invariants.Transactions = len(transactions)
+
+	stored := make(map[int64]models.Transaction, len(transactions))
+	for _, transaction := range transactions {
+		stored[transaction.ID] = transaction
+	}
+	for _, p := range accepted {
+		transaction, ok := stored[p.id]
+		if !ok {
+			violate("transaction %d was accepted but isn't stored", p.id)
+			continue
+		}
+		if ledger.ToCents(transaction.Amount) != ledger.ToCents(p.transaction.Amount) {
+			violate("transaction %d was posted for %.2f but is stored for %.2f", p.id, p.transaction.Amount, transaction.Amount)
+		}
+	}
+
+	var discharged, credited, allocated int64
+	for _, transaction := range transactions {
+		amount, balance := ledger.ToCents(transaction.Amount), ledger.ToCents(transaction.Balance)
+		if transaction.OperationTypeID < 4 {
+			if balance > 0 || balance < amount {
+				violate("debit %d has balance %.2f outside of %.2f and 0", transaction.ID, transaction.Balance, transaction.Amount)
+			}
+			discharged += balance - amount
+		} else {
+			if balance < 0 || balance > amount {
+				violate("credit %d has balance %.2f outside of 0 and %.2f", transaction.ID, transaction.Balance, transaction.Amount)
+			}
+			credited += amount - balance
+		}
+	}
+	for _, allocation := range allocations {
+		allocated += ledger.ToCents(allocation.Amount)
+	}
+	invariants.Discharged = float64(discharged) / 100
+	invariants.Credited = float64(credited) / 100
+	invariants.Allocated = float64(allocated) / 100
+	if discharged != credited {
+		violate("%.2f was discharged from debits but %.2f was credited", invariants.Discharged, invariants.Credited)
+	}
+	if allocated != credited {
+		violate("allocations add up to %.2f but %.2f was credited", invariants.Allocated, invariants.Credited)
+	}
+
+	for i, expected := range services.ReplayBalances(transactions) {
+		if ledger.ToCents(transactions[i].Balance) != ledger.ToCents(expected) {
+			invariants.Discrepancies++
+		}
+	}
+	expected := services.ReplayTotals(transactions)
+	if ledger.ToCents(totals.TotalDebt) != ledger.ToCents(expected.TotalDebt) || ledger.ToCents(totals.TotalCredit) != ledger.ToCents(expected.TotalCredit) {
+		violate("running totals say %.2f owed and %.2f credit but the transactions say %.2f and %.2f", totals.TotalDebt, totals.TotalCredit, expected.TotalDebt, expected.TotalCredit)
+	}
+	if invariants.Discrepancies > 0 {
+		violate("%d balances don't match a replay, run reconcile -account %d for the details", invariants.Discrepancies, accountID)
+	}
+	return invariants, violations, nil
+}
+
+func applyDefaults(config *Config) {
+	if config.Workers <= 0 {
+		config.Workers = DefaultWorkers
+	}
+	if config.Operations <= 0 {
+		config.Operations = DefaultOperations
+	}
+	if config.CreditRatio < 0 || config.CreditRatio > 1 {
+		config.CreditRatio = DefaultCreditRatio
+	}
+	if config.MaxAmount < 0.01 {
+		config.MaxAmount = DefaultMaxAmount
+	}
+}
+
+func uniqueAccounts(accounts []int64) []int64 {
+	seen := make(map[int64]bool)
+	var unique []int64
+	for _, accountID := range accounts {
+		if !seen[accountID] {
+			seen[accountID] = true
+			unique = append(unique, accountID)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool { return unique[i] < unique[j] })
+	return unique
+}
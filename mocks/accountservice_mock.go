@@ -1,20 +1,49 @@
 package mocks
 
 import (
+	"time"
+
 	"github.com/stretchr/testify/mock"
 	"pismo/models"
+	"pismo/services"
 )
 
 type MockAccountService struct {
 	mock.Mock
 }
 
-func (m *MockAccountService) GetAccountByID(id int) (models.Account, error) {
+// ReadYourWrites returns the mock itself, the same expectations hold for reads
+// that have to see the latest writes
+func (m *MockAccountService) ReadYourWrites() services.AccountServicer {
+	return m
+}
+
+func (m *MockAccountService) GetAccountByID(id int64) (models.Account, error) {
 	args := m.Called(id)
 	return args.Get(0).(models.Account), args.Error(1)
 }
 
-func (m *MockAccountService) CreateAccount(documentNumber string) (int64, error) {
-	args := m.Called(documentNumber)
-	return args.Get(0).(int64), args.Error(1)
+func (m *MockAccountService) GetAccountByRef(ref models.PublicID) (models.Account, error) {
+	args := m.Called(ref)
+	return args.Get(0).(models.Account), args.Error(1)
+}
+
+func (m *MockAccountService) CreateAccount(account models.Account) (models.Account, error) {
+	args := m.Called(account)
+	return args.Get(0).(models.Account), args.Error(1)
+}
+
+func (m *MockAccountService) GetAccountBalance(accountID int64, asOf time.Time) (models.AccountBalance, error) {
+	args := m.Called(accountID, asOf)
+	return args.Get(0).(models.AccountBalance), args.Error(1)
+}
+
+func (m *MockAccountService) GetAccountTotals(accountID int64) (models.AccountTotals, error) {
+	args := m.Called(accountID)
+	return args.Get(0).(models.AccountTotals), args.Error(1)
+}
+
+func (m *MockAccountService) UpdateAccount(id int64, update models.AccountUpdate, version int64) (models.Account, error) {
+	args := m.Called(id, update, version)
+	return args.Get(0).(models.Account), args.Error(1)
 }
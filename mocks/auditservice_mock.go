@@ -0,0 +1,22 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+
+	"pismo/models"
+	"pismo/services"
+)
+
+type MockAuditService struct {
+	mock.Mock
+}
+
+func (m *MockAuditService) ListEntries(filter models.AuditFilter) ([]models.AuditEntry, error) {
+	args := m.Called(filter)
+	return args.Get(0).([]models.AuditEntry), args.Error(1)
+}
+
+func (m *MockAuditService) VerifyChain() (services.AuditVerification, error) {
+	args := m.Called()
+	return args.Get(0).(services.AuditVerification), args.Error(1)
+}
@@ -0,0 +1,36 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+
+	"pismo/models"
+)
+
+type MockAuthorizationService struct {
+	mock.Mock
+}
+
+func (m *MockAuthorizationService) Authorize(authorization models.Authorization) (models.Authorization, error) {
+	args := m.Called(authorization)
+	return args.Get(0).(models.Authorization), args.Error(1)
+}
+
+func (m *MockAuthorizationService) GetAuthorizationByRef(ref models.PublicID) (models.Authorization, error) {
+	args := m.Called(ref)
+	return args.Get(0).(models.Authorization), args.Error(1)
+}
+
+func (m *MockAuthorizationService) Capture(id int64, amount float64) (models.Authorization, error) {
+	args := m.Called(id, amount)
+	return args.Get(0).(models.Authorization), args.Error(1)
+}
+
+func (m *MockAuthorizationService) Void(id int64) (models.Authorization, error) {
+	args := m.Called(id)
+	return args.Get(0).(models.Authorization), args.Error(1)
+}
+
+func (m *MockAuthorizationService) ExpireAuthorizations() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
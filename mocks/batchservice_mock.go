@@ -0,0 +1,16 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+
+	"pismo/models"
+)
+
+type MockBatchService struct {
+	mock.Mock
+}
+
+func (m *MockBatchService) ProcessBatch(rows []models.BatchRow) models.BatchReport {
+	args := m.Called(rows)
+	return args.Get(0).(models.BatchReport)
+}
@@ -0,0 +1,16 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+
+	"pismo/models"
+)
+
+type MockLedgerService struct {
+	mock.Mock
+}
+
+func (m *MockLedgerService) TrialBalance() ([]models.TrialBalance, error) {
+	args := m.Called()
+	return args.Get(0).([]models.TrialBalance), args.Error(1)
+}
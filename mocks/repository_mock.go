@@ -1,43 +1,152 @@
 package mocks
 
 import (
-	"database/sql"
+	"context"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 
 	"pismo/models"
+	"pismo/store"
 )
 
+// MockRepository is both the repository and the TxRepo of its units of work,
+// WithinTx runs fn on the mock itself so what fn calls is mocked the same way
 type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) GetAccountByID(id int) (models.Account, error) {
+func (m *MockRepository) WithinTx(ctx context.Context, fn func(store.TxRepo) error, opts ...store.TxOption) error {
+	return fn(m)
+}
+
+// ReadYourWrites returns the mock itself, so reads expected of it are expected
+// whichever way they are routed
+func (m *MockRepository) ReadYourWrites() store.Repositoryer {
+	return m
+}
+
+func (m *MockRepository) GetAccountByID(id int64) (models.Account, error) {
 	args := m.Called(id)
 	return args.Get(0).(models.Account), args.Error(1)
 }
 
+func (m *MockRepository) UpdateAccount(account models.Account) error {
+	args := m.Called(account)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetAccountByPublicID(publicID models.PublicID) (models.Account, error) {
+	args := m.Called(publicID)
+	return args.Get(0).(models.Account), args.Error(1)
+}
+
 func (m *MockRepository) GetAccountByDocumentNumber(documentNumber string) (models.Account, error) {
 	args := m.Called(documentNumber)
 	return args.Get(0).(models.Account), args.Error(1)
 }
 
-func (m *MockRepository) CreateAccount(documentNumber string) (int64, error) {
-	args := m.Called(documentNumber)
+func (m *MockRepository) CreateAccount(account models.Account) (int64, error) {
+	args := m.Called(account)
 	return args.Get(0).(int64), args.Error(1)
 }
 
-func (m *MockRepository) BeginTransaction() (*sql.Tx, error) {
-	args := m.Called()
-	return args.Get(0).(*sql.Tx), args.Error(1)
+func (m *MockRepository) GetProductByID(id int) (models.Product, error) {
+	args := m.Called(id)
+	return args.Get(0).(models.Product), args.Error(1)
 }
 
-func (m *MockRepository) CreateTransactionWithTx(tx *sql.Tx, transaction models.Transaction) (int64, error) {
+func (m *MockRepository) LockAccountBalance(accountID int64) (models.AccountTotals, error) {
+	args := m.Called(accountID)
+	return args.Get(0).(models.AccountTotals), args.Error(1)
+}
+
+func (m *MockRepository) UpdateAccountBalance(totals models.AccountTotals) error {
+	args := m.Called(totals)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetAccountTotals(accountID int64) (models.AccountTotals, error) {
+	args := m.Called(accountID)
+	return args.Get(0).(models.AccountTotals), args.Error(1)
+}
+
+func (m *MockRepository) CreateTransaction(transaction models.Transaction) (int64, error) {
+	args := m.Called(transaction)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) ProcessDischargeTransaction(transaction models.Transaction) (float64, error) {
 	args := m.Called(transaction)
+	return args.Get(0).(float64), args.Error(1)
+}
+
+func (m *MockRepository) CreateJournalEntry(entry models.JournalEntry) (int64, error) {
+	args := m.Called(entry)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) GetTransactionBalancesAsOf(accountID int64, asOf time.Time) ([]models.TransactionBalance, error) {
+	args := m.Called(accountID, asOf)
+	return args.Get(0).([]models.TransactionBalance), args.Error(1)
+}
+
+func (m *MockRepository) GetAvailableCredit(accountID int64, at time.Time) (float64, error) {
+	args := m.Called(accountID, at)
+	return args.Get(0).(float64), args.Error(1)
+}
+
+func (m *MockRepository) CreateAuthorization(authorization models.Authorization) (int64, error) {
+	args := m.Called(authorization)
 	return args.Get(0).(int64), args.Error(1)
 }
 
-func (m *MockRepository) ProcessDischargeTransactionWithTx(*sql.Tx, models.Transaction) error {
+func (m *MockRepository) GetAuthorizationForUpdate(id int64) (models.Authorization, error) {
+	args := m.Called(id)
+	return args.Get(0).(models.Authorization), args.Error(1)
+}
+
+func (m *MockRepository) UpdateAuthorizationStatus(id int64, status string, transaction models.Transaction) error {
+	args := m.Called(id, status, transaction)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ListAccountTransactions(accountID int64) ([]models.Transaction, error) {
+	args := m.Called(accountID)
+	return args.Get(0).([]models.Transaction), args.Error(1)
+}
+
+func (m *MockRepository) ListAccountTransactionsForUpdate(accountID int64) ([]models.Transaction, error) {
+	args := m.Called(accountID)
+	return args.Get(0).([]models.Transaction), args.Error(1)
+}
+
+func (m *MockRepository) UpdateTransactionBalance(accountID int64, transactionID int64, balance float64) error {
+	args := m.Called(accountID, transactionID, balance)
+	return args.Error(0)
+}
+
+func (m *MockRepository) AppendAuditEntry(entry models.AuditEntry) (models.AuditEntry, error) {
+	args := m.Called(entry)
+	return args.Get(0).(models.AuditEntry), args.Error(1)
+}
+
+func (m *MockRepository) ListAccountAllocations(accountID int64) ([]models.DischargeAllocation, error) {
+	args := m.Called(accountID)
+	return args.Get(0).([]models.DischargeAllocation), args.Error(1)
+}
+
+func (m *MockRepository) ReplaceAccountAllocations(accountID int64, allocations []models.DischargeAllocation) error {
+	args := m.Called(accountID, allocations)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ListTransactionAccountIDs() ([]int64, error) {
 	args := m.Called()
-	return args.Error(1)
+	return args.Get(0).([]int64), args.Error(1)
+}
+
+func (m *MockRepository) ArchiveTransactions(accountID int64, postedBefore time.Time, limit int) (int, error) {
+	args := m.Called(accountID, postedBefore, limit)
+	return args.Int(0), args.Error(1)
 }
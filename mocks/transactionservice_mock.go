@@ -2,6 +2,7 @@ package mocks
 
 import (
 	"pismo/models"
+	"pismo/store"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -10,12 +11,12 @@ type MockTransactionService struct {
 	mock.Mock
 }
 
-func (m *MockTransactionService) CreateTransaction(transaction models.Transaction) (int64, error) {
+func (m *MockTransactionService) CreateTransaction(transaction models.Transaction) (models.Transaction, error) {
 	args := m.Called(transaction)
-	return args.Get(0).(int64), args.Error(1)
+	return args.Get(0).(models.Transaction), args.Error(1)
 }
 
-func (m *MockTransactionService) CreateTransactionsConcurrently(req models.Transaction, numTransactions int) ([]int64, error) {
-	args := m.Called(req)
-	return args.Get(0).([]int64), args.Error(1)
+func (m *MockTransactionService) CreateTransactionWithin(tx store.TxRepo, transaction models.Transaction) (models.Transaction, error) {
+	args := m.Called(tx, transaction)
+	return args.Get(0).(models.Transaction), args.Error(1)
 }
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKey is a credential issued to an API client. Only the SHA-256 hash of the
+// secret is stored, the secret itself is shown once when the key is issued.
+type APIKey struct {
+	ID        string     `json:"key_id"`
+	ClientID  string     `json:"client_id"`
+	KeyHash   string     `json:"-"`
+	Roles     []string   `json:"roles"`
+	TenantID  string     `json:"tenant_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
@@ -1,6 +1,49 @@
 package models
 
+import (
+	"time"
+)
+
+// document types accepted for account holders
+const (
+	DocumentTypeCPF      = "CPF"
+	DocumentTypeCNPJ     = "CNPJ"
+	DocumentTypePassport = "PASSPORT"
+)
+
+// account statuses, blocked accounts only take payments and closed ones nothing
+const (
+	AccountActive  = "ACTIVE"
+	AccountBlocked = "BLOCKED"
+	AccountClosed  = "CLOSED"
+)
+
+// Account is a card holder's account. ID is internal, clients know the account
+// by its PublicID. CreditLimit overrides the credit limit of the product when
+// set, Version goes up with every change to the account.
 type Account struct {
-	ID             int    `json:"account_id"`
-	DocumentNumber string `json:"document_number"`
+	ID             int64     `json:"-"`
+	PublicID       PublicID  `json:"account_id"`
+	DocumentNumber string    `json:"document_number"`
+	DocumentType   string    `json:"document_type"`
+	HolderName     string    `json:"holder_name"`
+	Country        string    `json:"country"`
+	ProductID      int       `json:"product_id"`
+	Currency       string    `json:"currency"`
+	TenantID       string    `json:"tenant_id"`
+	Status         string    `json:"status"`
+	CreditLimit    *float64  `json:"credit_limit,omitempty"`
+	Version        int64     `json:"version"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// AccountUpdate is a change to the settings of an account, fields left nil stay
+// as they are. ClearCreditLimit drops the override so the account goes back to
+// the credit limit of its product.
+type AccountUpdate struct {
+	HolderName       *string
+	Status           *string
+	CreditLimit      *float64
+	ClearCreditLimit bool
 }
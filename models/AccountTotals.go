@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// AccountTotals are the running totals of an account kept in AccountBalances.
+// TotalDebt is what the holder still owes, TotalCredit the credit not used to pay
+// anything off yet and AvailableLimit the product credit limit minus TotalDebt.
+// Version goes up by one with every change. AccountPublicID is only filled in
+// for clients, AccountBalances doesn't keep it.
+type AccountTotals struct {
+	AccountID       int64     `json:"-"`
+	AccountPublicID PublicID  `json:"account_id"`
+	TotalDebt       float64   `json:"total_debt"`
+	TotalCredit     float64   `json:"total_credit"`
+	AvailableLimit  float64   `json:"available_limit"`
+	Version         int64     `json:"version"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
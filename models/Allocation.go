@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// DischargeAllocation is the part of a credit that discharge applied to one debit
+type DischargeAllocation struct {
+	ID                  int64     `json:"allocation_id"`
+	AccountID           int64     `json:"account_id"`
+	DebitTransactionID  int64     `json:"debit_transaction_id"`
+	CreditTransactionID int64     `json:"credit_transaction_id"`
+	Amount              float64   `json:"amount"`
+	AllocatedAt         time.Time `json:"allocated_at"`
+}
+
+// TransactionBalance is what was still open on a transaction at some point in
+// time, owed on debits and left to spend on credits
+type TransactionBalance struct {
+	TransactionID   int64     `json:"-"`
+	PublicID        PublicID  `json:"transaction_id"`
+	OperationTypeID int       `json:"operation_type_id"`
+	EventDate       time.Time `json:"event_date"`
+	Amount          float64   `json:"amount"`
+	Balance         float64   `json:"balance"`
+}
+
+// AccountBalance is the state of an account at a point in time, Balance is the
+// net of every transaction by then, negative when the holder owes money
+type AccountBalance struct {
+	AccountID       int64                `json:"-"`
+	AccountPublicID PublicID             `json:"account_id"`
+	AsOf            time.Time            `json:"as_of"`
+	Balance         float64              `json:"balance"`
+	OpenDebt        float64              `json:"open_debt"`
+	OpenCredit      float64              `json:"open_credit"`
+	Transactions    []TransactionBalance `json:"transactions"`
+}
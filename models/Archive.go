@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// ArchiveReport is what one run of the archive moved out of the hot tables
+type ArchiveReport struct {
+	PostedBefore time.Time `json:"posted_before"`
+	Accounts     int       `json:"accounts"`
+	Transactions int       `json:"transactions"`
+	// accounts that couldn't be archived, by account ID
+	Errors map[int64]string `json:"errors,omitempty"`
+}
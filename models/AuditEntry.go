@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+)
+
+// audit entry kinds
+const (
+	AuditKindRequest       = "request"        // a state changing API call
+	AuditKindBalanceChange = "balance_change" // balances updated in place by a discharge
+)
+
+// AuditEntry is one link in the append only, hash chained audit log. Every
+// account has a chain of its own, entries without one go on chain 0. Hash covers
+// every field but the ID and the chain, plus the hash of the entry before it.
+type AuditEntry struct {
+	ID            int64           `json:"audit_id"`
+	ChainID       int64           `json:"chain_id"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Kind          string          `json:"kind"`
+	PrincipalID   string          `json:"principal_id,omitempty"`
+	RequestID     string          `json:"request_id,omitempty"`
+	Method        string          `json:"method,omitempty"`
+	Route         string          `json:"route"`
+	AccountID     int64           `json:"account_id,omitempty"`
+	TransactionID int64           `json:"transaction_id,omitempty"`
+	PayloadHash   string          `json:"payload_hash,omitempty"`
+	Outcome       int             `json:"outcome,omitempty"` // HTTP status of requests
+	Changes       []BalanceChange `json:"changes,omitempty"`
+	PrevHash      string          `json:"prev_hash"`
+	Hash          string          `json:"hash"`
+}
+
+// BalanceChange is the before and after of one Transactions row balance
+type BalanceChange struct {
+	TransactionID int64   `json:"transaction_id"`
+	Before        float64 `json:"before"`
+	After         float64 `json:"after"`
+}
+
+// AuditFilter narrows down audit log reads, zero values don't filter
+type AuditFilter struct {
+	AccountID int64
+	From      time.Time
+	To        time.Time
+	AfterID   int64
+	Limit     int
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// authorization statuses, only PENDING holds count against the credit limit
+const (
+	AuthorizationPending  = "PENDING"
+	AuthorizationCaptured = "CAPTURED"
+	AuthorizationVoided   = "VOIDED"
+	AuthorizationExpired  = "EXPIRED"
+)
+
+// Authorization is a hold on available credit for a purchase that hasn't been
+// posted yet. Amount is in the account currency and negative like any debit.
+// Clients know the hold by PublicID, name the account by AccountPublicID, see
+// PublicID, and see the captured transaction by TransactionPublicID.
+type Authorization struct {
+	ID                  int64     `json:"-"`
+	PublicID            PublicID  `json:"authorization_id"`
+	AccountID           int64     `json:"-"`
+	AccountPublicID     PublicID  `json:"account_id"`
+	OperationTypeID     int       `json:"operation_type_id"`
+	Amount              float64   `json:"amount"`
+	Currency            string    `json:"currency"`
+	OriginalAmount      float64   `json:"original_amount"`
+	OriginalCurrency    string    `json:"original_currency"`
+	FXRate              float64   `json:"fx_rate"`
+	Status              string    `json:"status"`
+	TransactionID       int64     `json:"-"`
+	TransactionPublicID PublicID  `json:"transaction_id,omitempty"`
+	ExpiresAt           time.Time `json:"expires_at"`
+	Version             int64     `json:"version"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
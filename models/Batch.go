@@ -0,0 +1,35 @@
+package models
+
+// batch row outcomes
+const (
+	BatchRowCreated  = "created"  // posted, TransactionPublicID is set
+	BatchRowRejected = "rejected" // the row itself is wrong, sending it again won't help
+	BatchRowFailed   = "failed"   // posting failed, the row may succeed if sent again
+)
+
+// BatchRow is one parsed row of a bulk upload. Row is its 1-based position among
+// the data rows of the upload, Error is set when it couldn't be parsed or validated.
+type BatchRow struct {
+	Row         int
+	Transaction Transaction
+	Error       string
+}
+
+// BatchResult is the outcome of one row. The account is the one the row named,
+// by its public id once it has been found.
+type BatchResult struct {
+	Row                 int      `json:"row"`
+	AccountPublicID     PublicID `json:"account_id,omitempty"`
+	Status              string   `json:"status"`
+	TransactionPublicID PublicID `json:"transaction_id,omitempty"`
+	Error               string   `json:"error,omitempty"`
+}
+
+// BatchReport has one result per row, in the order the rows were sent
+type BatchReport struct {
+	Total    int           `json:"total"`
+	Created  int           `json:"created"`
+	Rejected int           `json:"rejected"`
+	Failed   int           `json:"failed"`
+	Results  []BatchResult `json:"results"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+const (
+	LedgerAsset     = "ASSET"
+	LedgerLiability = "LIABILITY"
+)
+
+// LedgerAccount is an account of the double-entry ledger, either one per customer
+// account or one of the system accounts money moves in and out through
+type LedgerAccount struct {
+	ID        int64  `json:"ledger_account_id"`
+	Code      string `json:"code"`
+	Type      string `json:"type"`
+	AccountID int64  `json:"account_id,omitempty"`
+	Currency  string `json:"currency"`
+}
+
+// Posting moves money in or out of one ledger account, debits are positive and
+// credits negative so the postings of an entry always sum to zero
+type Posting struct {
+	ID            int64         `json:"posting_id"`
+	LedgerAccount LedgerAccount `json:"ledger_account"`
+	Amount        float64       `json:"amount"`
+}
+
+// JournalEntry is the set of balanced postings recording one transaction
+type JournalEntry struct {
+	ID            int64     `json:"journal_entry_id"`
+	TransactionID int64     `json:"transaction_id"`
+	Description   string    `json:"description"`
+	Currency      string    `json:"currency"`
+	PostedAt      time.Time `json:"posted_at"`
+	Postings      []Posting `json:"postings"`
+}
+
+type TrialBalanceLine struct {
+	Code      string  `json:"code"`
+	Type      string  `json:"type"`
+	AccountID int64   `json:"account_id,omitempty"`
+	Debits    float64 `json:"debits"`
+	Credits   float64 `json:"credits"`
+	Balance   float64 `json:"balance"`
+}
+
+// TrialBalance lists the totals of every ledger account in one currency, its
+// debits and credits must match for the ledger to be balanced
+type TrialBalance struct {
+	Currency     string             `json:"currency"`
+	Lines        []TrialBalanceLine `json:"lines"`
+	TotalDebits  float64            `json:"total_debits"`
+	TotalCredits float64            `json:"total_credits"`
+	Balanced     bool               `json:"balanced"`
+}
@@ -0,0 +1,29 @@
+package models
+
+// AccountInvariants is what the load test found on one account once the workload
+// was done. Discharged is what the debits have had paid off, Credited what the
+// credits have given away and Allocated what the discharge allocations add up
+// to, the three must match.
+type AccountInvariants struct {
+	AccountID     int64   `json:"account_id"`
+	Posted        int     `json:"posted"`
+	Transactions  int     `json:"transactions"`
+	Discharged    float64 `json:"discharged"`
+	Credited      float64 `json:"credited"`
+	Allocated     float64 `json:"allocated"`
+	Discrepancies int     `json:"discrepancies"`
+}
+
+// LoadTestReport sums up a load test run, it passed when nothing is in Violations
+type LoadTestReport struct {
+	Operations     int                 `json:"operations"`
+	Created        int                 `json:"created"`
+	Failed         int                 `json:"failed"`
+	Errors         map[string]int      `json:"errors,omitempty"`
+	DurationMillis int64               `json:"duration_ms"`
+	PerSecond      float64             `json:"per_second"`
+	Accounts       []AccountInvariants `json:"accounts"`
+	LedgerBalanced bool                `json:"ledger_balanced"`
+	Violations     []string            `json:"violations"`
+	Passed         bool                `json:"passed"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// Product is the account product an account is opened under. It drives the
+// credit limit and discharge rules applied to the account.
+type Product struct {
+	ID          int     `json:"product_id"`
+	Name        string  `json:"name"`
+	CreditLimit float64 `json:"credit_limit"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// PublicID is the id accounts, transactions and holds are known by outside the
+// service, made by the publicid package. While clients move over from the internal
+// ids a request may still name an account or a hold by its internal id, sent as a
+// number or a string of digits, which decodes to those digits. A 0 is no id at
+// all, as it was for the internal ids.
+type PublicID string
+
+func (id *PublicID) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = PublicID(s)
+		return nil
+	}
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid id %s, must be a public id or an internal id", data)
+	}
+	if n != 0 {
+		*id = PublicID(strconv.FormatInt(n, 10))
+	}
+	return nil
+}
+
+// AcceptLegacyIDs is whether internal ids are still taken in place of public ids.
+// It is on while clients move over, ACCEPT_LEGACY_IDS=false turns it off and from
+// then on a number is just an invalid id. Set it once at startup.
+var AcceptLegacyIDs = true
+
+// Legacy is the internal id the client sent in place of the public id, if it did
+// and those are still accepted
+func (id PublicID) Legacy() (int64, bool) {
+	if !AcceptLegacyIDs {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	return n, err == nil && n > 0
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// BalanceDiscrepancy is a transaction whose stored balance isn't what replaying
+// the account's transactions through discharge gives
+type BalanceDiscrepancy struct {
+	AccountID       int64     `json:"account_id"`
+	TransactionID   int64     `json:"transaction_id"`
+	OperationTypeID int       `json:"operation_type_id"`
+	EventDate       time.Time `json:"event_date"`
+	Stored          float64   `json:"stored"`
+	Expected        float64   `json:"expected"`
+}
+
+// AccountReconciliation is the outcome of replaying one account, its allocations
+// are stale when the recorded allocation history isn't what the replay made, its
+// totals when the running totals in AccountBalances don't add up to the replay
+type AccountReconciliation struct {
+	AccountID        int64                `json:"account_id"`
+	Transactions     int                  `json:"transactions"`
+	Discrepancies    []BalanceDiscrepancy `json:"discrepancies"`
+	StaleAllocations bool                 `json:"stale_allocations"`
+	StaleTotals      bool                 `json:"stale_totals"`
+}
+
+type ReconciliationReport struct {
+	Accounts      int                  `json:"accounts"`
+	Transactions  int                  `json:"transactions"`
+	Discrepancies []BalanceDiscrepancy `json:"discrepancies"`
+	// accounts whose allocation history doesn't match the replay
+	StaleAllocations []int64 `json:"stale_allocations"`
+	// accounts whose running totals don't match the replay
+	StaleTotals []int64 `json:"stale_totals"`
+	Repaired    bool    `json:"repaired"`
+	// accounts that couldn't be checked, by account ID
+	Errors map[int64]string `json:"errors,omitempty"`
+}
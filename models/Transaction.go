@@ -4,11 +4,21 @@ import (
 	"time"
 )
 
+// Transaction is a posting to an account. Clients only see the public ids of the
+// transaction and its account, in requests AccountPublicID may be the internal
+// id of the account instead, see PublicID.
 type Transaction struct {
-	ID              int64     `json:"id"`
-	AccountID       int       `json:"account_id"`
-	OperationTypeID int       `json:"operation_type_id"`
-	Amount          float64   `json:"amount"`
-	Balance         float64   `json:"balance"`
-	EventDate       time.Time `json:"event_date"`
+	ID               int64     `json:"-"`
+	PublicID         PublicID  `json:"id"`
+	AccountID        int64     `json:"-"`
+	AccountPublicID  PublicID  `json:"account_id"`
+	OperationTypeID  int       `json:"operation_type_id"`
+	Amount           float64   `json:"amount"`
+	Balance          float64   `json:"balance"`
+	EventDate        time.Time `json:"event_date"`
+	PostingDate      time.Time `json:"posting_date"`
+	Currency         string    `json:"currency"`
+	OriginalAmount   float64   `json:"original_amount"`
+	OriginalCurrency string    `json:"original_currency"`
+	FXRate           float64   `json:"fx_rate"`
 }
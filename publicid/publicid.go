@@ -0,0 +1,68 @@
+package publicid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// prefixes of the public ids, they tell what an id is for at a glance
+const (
+	Account       = "acc"
+	Transaction   = "txn"
+	Authorization = "auth"
+)
+
+// Crockford's base32, the alphabet of ULIDs. It leaves out I, L, O and U so ids
+// read back over the phone can't be mistaken.
+const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// length of the ULID after the prefix, 128 bits at 5 bits a character
+const length = 26
+
+// New is a public id with the prefix, like acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3. The
+// id is a ULID, the time in milliseconds followed by 80 random bits, so ids of
+// the same kind sort roughly in the order they were made but can't be guessed
+// from one another.
+func New(prefix string) string {
+	var id [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+	if _, err := rand.Read(id[6:]); err != nil {
+		// crypto/rand only fails when the system has no randomness to give,
+		// nothing can be done without it
+		panic("publicid: " + err.Error())
+	}
+	return prefix + "_" + encode(id)
+}
+
+// Valid reports whether id looks like a public id with the prefix. It doesn't
+// tell whether anything has the id.
+func Valid(id, prefix string) bool {
+	rest, ok := strings.CutPrefix(id, prefix+"_")
+	if !ok || len(rest) != length {
+		return false
+	}
+	for i := 0; i < len(rest); i++ {
+		if strings.IndexByte(alphabet, rest[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encode writes the 128 bits of the id 5 at a time from the lowest, the first
+// character only holds the top 3
+func encode(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	var out [length]byte
+	for i := length - 1; i >= 0; i-- {
+		out[i] = alphabet[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// RouteLimits are the limits applied to one route, a nil limit means that key
+// isn't limited on the route
+type RouteLimits struct {
+	Client  *Limit `json:"client"`
+	Account *Limit `json:"account"`
+}
+
+// Config holds the limits per route, keyed by method and path template like
+// "POST /transactions". Routes without an entry get the default. IP is the
+// limit of each address on every route, before the caller is authenticated.
+type Config struct {
+	IP      *Limit                 `json:"ip"`
+	Default RouteLimits            `json:"default"`
+	Routes  map[string]RouteLimits `json:"routes"`
+}
+
+// DefaultConfig is used when no config file is given. Transaction creation takes
+// row locks in the database so it is limited per account as well as per client.
+func DefaultConfig() Config {
+	return Config{
+		IP:      &Limit{Rate: 100, Burst: 200},
+		Default: RouteLimits{Client: &Limit{Rate: 50, Burst: 100}},
+		Routes: map[string]RouteLimits{
+			"POST /transactions": {
+				Client:  &Limit{Rate: 20, Burst: 40},
+				Account: &Limit{Rate: 5, Burst: 10},
+			},
+			// each upload is up to thousands of transactions
+			"POST /transactions/batch": {
+				Client: &Limit{Rate: 0.2, Burst: 2},
+			},
+			"POST /authorizations": {
+				Client:  &Limit{Rate: 20, Burst: 40},
+				Account: &Limit{Rate: 5, Burst: 10},
+			},
+		},
+	}
+}
+
+// LoadConfig reads the rate limits from a JSON config file, e.g.
+// {"default": {"client": {"rate": 50, "burst": 100}}, "routes": {"POST /transactions": {"account": {"rate": 5, "burst": 10}}}}
+func LoadConfig(path string) (Config, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read rate limit config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(contents, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse rate limit config: %w", err)
+	}
+
+	if err := validateLimit("ip", cfg.IP); err != nil {
+		return Config{}, err
+	}
+	if err := validateLimits("default", cfg.Default); err != nil {
+		return Config{}, err
+	}
+	for route, limits := range cfg.Routes {
+		if err := validateLimits(route, limits); err != nil {
+			return Config{}, err
+		}
+	}
+	return cfg, nil
+}
+
+func validateLimits(route string, limits RouteLimits) error {
+	for _, limit := range []*Limit{limits.Client, limits.Account} {
+		if err := validateLimit("route "+route, limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLimit(name string, limit *Limit) error {
+	if limit != nil && (limit.Rate <= 0 || limit.Burst < 1) {
+		return fmt.Errorf("%s: rate must be positive and burst at least 1", name)
+	}
+	return nil
+}
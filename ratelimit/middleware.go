@@ -0,0 +1,223 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"pismo/auth"
+	"pismo/models"
+)
+
+// Throttled counts rejected requests by route and key, e.g. "POST /transactions account",
+// served with the rest of the expvars on /debug/vars
+var Throttled = expvar.NewMap("ratelimit_throttled")
+
+const (
+	scopeIP      = "ip"
+	scopeClient  = "client"
+	scopeAccount = "account"
+)
+
+// maxPeekBytes is how much of a body the throttler reads looking for the account,
+// the bodies of routes limited per account are a single transaction or hold
+const maxPeekBytes = 1 << 20
+
+// AccountResolver finds the account a client named, by its public id or its
+// internal id, so both count against the same bucket
+type AccountResolver interface {
+	GetAccountByRef(ref models.PublicID) (models.Account, error)
+}
+
+type routeLimiters struct {
+	client  *Limiter
+	account *Limiter
+}
+
+// Throttler applies the configured token bucket limits to each request, keyed
+// by the address it came from, by the authenticated client and by the account
+// the request is for
+type Throttler struct {
+	ip       *Limiter
+	routes   map[string]routeLimiters
+	fallback routeLimiters
+	accounts AccountResolver
+}
+
+func NewThrottler(config Config, accounts AccountResolver) *Throttler {
+	t := &Throttler{
+		routes:   make(map[string]routeLimiters, len(config.Routes)),
+		fallback: newRouteLimiters(config.Default),
+		accounts: accounts,
+	}
+	if config.IP != nil {
+		t.ip = NewLimiter(*config.IP)
+	}
+	for route, limits := range config.Routes {
+		t.routes[route] = newRouteLimiters(limits)
+	}
+	return t
+}
+
+func newRouteLimiters(limits RouteLimits) routeLimiters {
+	var rl routeLimiters
+	if limits.Client != nil {
+		rl.client = NewLimiter(*limits.Client)
+	}
+	if limits.Account != nil {
+		rl.account = NewLimiter(*limits.Account)
+	}
+	return rl
+}
+
+// IPMiddleware rejects requests over the limit of the address they came from,
+// with 429 and a Retry-After header. It runs before the auth middleware, so
+// callers that fail to authenticate are limited too.
+func (t *Throttler) IPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t.ip != nil {
+			if allowed, wait := t.ip.Allow(remoteIP(r)); !allowed {
+				throttle(w, routeKey(r), scopeIP, wait)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware rejects requests over their limits with 429 and a Retry-After header.
+// It has to run after the auth middleware so the client is known.
+func (t *Throttler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeKey(r)
+		limiters, ok := t.routes[route]
+		if !ok {
+			limiters = t.fallback
+		}
+
+		if limiters.client != nil {
+			if allowed, wait := limiters.client.Allow(clientKey(r)); !allowed {
+				throttle(w, route, scopeClient, wait)
+				return
+			}
+		}
+		if limiters.account != nil {
+			accountID, ok, err := t.accountKey(w, r)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				auth.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is over %d bytes", tooLarge.Limit))
+				return
+			}
+			if ok {
+				if allowed, wait := limiters.account.Allow(accountID); !allowed {
+					throttle(w, route, scopeAccount, wait)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func throttle(w http.ResponseWriter, route, scope string, wait time.Duration) {
+	Throttled.Add(route+" "+scope, 1)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+	auth.WriteError(w, http.StatusTooManyRequests, fmt.Sprintf("rate limit exceeded for %s, retry later", scope))
+}
+
+// Retry-After is in whole seconds, always round up so clients don't come back too early
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := math.Ceil(wait.Seconds())
+	if seconds < 1 {
+		return 1
+	}
+	if seconds > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(seconds)
+}
+
+func routeKey(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return r.Method + " " + template
+		}
+	}
+	return r.Method + " " + r.URL.Path
+}
+
+func clientKey(r *http.Request) string {
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok && principal.ID != "" {
+		return principal.ID
+	}
+	return remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// accountKey finds the account a request is for, either the {id} of an
+// /accounts/{id} path or the account_id field of a JSON body, and keys it on the
+// internal id whichever way the client named it. Refs that don't resolve are
+// keyed as they are, the handler turns them away. The body is put back so the
+// handler can still read it, bodies over maxPeekBytes aren't read any further.
+func (t *Throttler) accountKey(w http.ResponseWriter, r *http.Request) (string, bool, error) {
+	ref, ok, err := accountRef(w, r)
+	if !ok || err != nil {
+		return "", false, err
+	}
+	if id, ok := ref.Legacy(); ok {
+		return strconv.FormatInt(id, 10), true, nil
+	}
+	if t.accounts != nil {
+		if account, err := t.accounts.GetAccountByRef(ref); err == nil {
+			return strconv.FormatInt(account.ID, 10), true, nil
+		}
+	}
+	return string(ref), true, nil
+}
+
+// accountRef is the account the request names, its public id or, from clients
+// not yet moved over, its internal id
+func accountRef(w http.ResponseWriter, r *http.Request) (models.PublicID, bool, error) {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil && strings.HasPrefix(template, "/accounts/{id}") {
+			id, ok := mux.Vars(r)["id"]
+			return models.PublicID(id), ok, nil
+		}
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", false, nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPeekBytes))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", false, err
+	}
+
+	var req struct {
+		AccountID models.PublicID `json:"account_id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.AccountID == "" {
+		return "", false, nil
+	}
+	return req.AccountID, true, nil
+}
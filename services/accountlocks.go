@@ -0,0 +1,46 @@
+package services
+
+import "sync"
+
+// accountLocks hands out one mutex per account, so work on the same account runs
+// one at a time while different accounts run in parallel. Waiters are not
+// queued, whichever gets the mutex next goes next, so work on an account is not
+// done in the order it arrived. Mutexes are created on first use and dropped
+// once nobody holds or waits on them.
+type accountLocks struct {
+	mu    sync.Mutex
+	locks map[int64]*accountLock
+}
+
+type accountLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newAccountLocks() *accountLocks {
+	return &accountLocks{locks: make(map[int64]*accountLock)}
+}
+
+// lock blocks until the account is free and returns the func that frees it again
+func (l *accountLocks) lock(accountID int64) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[accountID]
+	if !ok {
+		lock = &accountLock{}
+		l.locks[accountID] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, accountID)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -1,16 +1,40 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 
+	"pismo/ledger"
 	"pismo/models"
+	"pismo/publicid"
 	"pismo/store"
+	"pismo/validation"
+)
+
+const (
+	defaultProductID    = 1
+	defaultDocumentType = models.DocumentTypeCPF
+	defaultCountry      = "BR"
+	defaultCurrency     = "BRL"
+)
+
+var (
+	ErrProductNotFound      = errors.New("account product not found")
+	ErrInvalidAccountUpdate = errors.New("invalid account update")
 )
 
 type AccountServicer interface {
-	GetAccountByID(id int) (models.Account, error)
-	CreateAccount(documentNumber string) (int64, error)
+	ReadYourWrites() AccountServicer
+	GetAccountByID(id int64) (models.Account, error)
+	GetAccountByRef(ref models.PublicID) (models.Account, error)
+	CreateAccount(account models.Account) (models.Account, error)
+	GetAccountBalance(accountID int64, asOf time.Time) (models.AccountBalance, error)
+	GetAccountTotals(accountID int64) (models.AccountTotals, error)
+	UpdateAccount(id int64, update models.AccountUpdate, version int64) (models.Account, error)
 }
 
 type AccountService struct {
@@ -21,7 +45,14 @@ func NewAccountService(db store.Repositoryer) AccountServicer {
 	return &AccountService{db: db}
 }
 
-func (s *AccountService) GetAccountByID(id int) (models.Account, error) {
+// ReadYourWrites is the service with its reads going to the primary database, for
+// callers that have to see what they have just written rather than what the
+// replicas have caught up with
+func (s *AccountService) ReadYourWrites() AccountServicer {
+	return &AccountService{db: s.db.ReadYourWrites()}
+}
+
+func (s *AccountService) GetAccountByID(id int64) (models.Account, error) {
 	account, err := s.db.GetAccountByID(id)
 	if err != nil {
 		return models.Account{}, err
@@ -29,20 +60,179 @@ func (s *AccountService) GetAccountByID(id int) (models.Account, error) {
 	return account, nil
 }
 
-func (s *AccountService) CreateAccount(documentNumber string) (int64, error) {
-	// are document numbers unique?
-	// if they are, we first need to check that we arent creating another account with the same document number
-	account, err := s.db.GetAccountByDocumentNumber(documentNumber)
-	if err != nil && err != sql.ErrNoRows { // if there is a db error and not a no record found error
-		return 0, err
+// GetAccountByRef reads the account a client named, by its public id or, while
+// clients move over to those, by its internal id
+func (s *AccountService) GetAccountByRef(ref models.PublicID) (models.Account, error) {
+	if id, ok := ref.Legacy(); ok {
+		return s.db.GetAccountByID(id)
+	}
+	return s.db.GetAccountByPublicID(ref)
+}
+
+// GetAccountBalance rebuilds the balance of the account and of each of its
+// transactions as they were at asOf, from the allocations discharge recorded
+// rather than the balances it has overwritten since
+func (s *AccountService) GetAccountBalance(accountID int64, asOf time.Time) (models.AccountBalance, error) {
+	transactions, err := s.db.GetTransactionBalancesAsOf(accountID, asOf)
+	if err != nil {
+		return models.AccountBalance{}, err
+	}
+
+	var total, debt, credit int64
+	for _, transaction := range transactions {
+		total += ledger.ToCents(transaction.Amount)
+		if open := ledger.ToCents(transaction.Balance); open < 0 {
+			debt -= open
+		} else {
+			credit += open
+		}
+	}
+
+	return models.AccountBalance{
+		AccountID:    accountID,
+		AsOf:         asOf,
+		Balance:      float64(total) / 100,
+		OpenDebt:     float64(debt) / 100,
+		OpenCredit:   float64(credit) / 100,
+		Transactions: transactions,
+	}, nil
+}
+
+// GetAccountTotals reads the running totals kept for the account, what it owes,
+// its unused credit and its available limit, without adding up its transactions
+func (s *AccountService) GetAccountTotals(accountID int64) (models.AccountTotals, error) {
+	return s.db.GetAccountTotals(accountID)
+}
+
+// CreateAccount opens the account and returns it with its ids
+func (s *AccountService) CreateAccount(account models.Account) (models.Account, error) {
+	applyAccountDefaults(&account)
+
+	documentNumber, err := validation.NormalizeDocument(account.DocumentType, account.DocumentNumber)
+	if err != nil {
+		return models.Account{}, err
 	}
-	if account != (models.Account{}) { // struct is not empty, so account with that document number already exists
-		return 0, errors.New("an account with that document number already exists")
+	account.DocumentNumber = documentNumber
+
+	// the product drives the limits of the account, so it has to exist before we can open one
+	if _, err := s.db.GetProductByID(account.ProductID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Account{}, ErrProductNotFound
+		}
+		return models.Account{}, err
 	}
 
-	accountID, err := s.db.CreateAccount(documentNumber)
+	// document numbers are unique, which is enforced by the db rather than a lookup here so two
+	// concurrent creates can't both pass the check. Duplicates come back as a *store.ConflictError
+	account.PublicID = models.PublicID(publicid.New(publicid.Account))
+	accountID, err := s.db.CreateAccount(account)
 	if err != nil {
-		return 0, err
+		return models.Account{}, err
+	}
+	account.ID = accountID
+	return account, nil
+}
+
+// UpdateAccount changes the settings of the account, as long as it is still at the
+// version the caller last read. A stale version comes back as store.ErrVersionMismatch
+// and nothing is changed. A new credit limit moves the available limit along in
+// the same database transaction.
+func (s *AccountService) UpdateAccount(id int64, update models.AccountUpdate, version int64) (models.Account, error) {
+	var account models.Account
+	err := s.db.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		// the running totals are the lock everything writing to the account takes first,
+		// so nothing posts against the old available limit while it is being changed
+		totals, err := tx.LockAccountBalance(id)
+		if err != nil {
+			return err
+		}
+		account, err = tx.GetAccountByID(id)
+		if err != nil {
+			return err
+		}
+		if account.Version != version {
+			return fmt.Errorf("%w: account %d is at version %d, not %d", store.ErrVersionMismatch, id, account.Version, version)
+		}
+
+		limitChanged, err := applyAccountUpdate(&account, update)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateAccount(account); err != nil {
+			return err
+		}
+		if limitChanged {
+			if err := tx.UpdateAccountBalance(totals); err != nil {
+				return err
+			}
+		}
+
+		// read it back for the new version and updated_at
+		account, err = tx.GetAccountByID(id)
+		return err
+	})
+	if err != nil {
+		return models.Account{}, err
+	}
+	return account, nil
+}
+
+// applyAccountUpdate validates the update and applies it to the account, and
+// reports whether the credit limit of the account changed
+func applyAccountUpdate(account *models.Account, update models.AccountUpdate) (bool, error) {
+	if update.HolderName != nil {
+		if strings.TrimSpace(*update.HolderName) == "" {
+			return false, fmt.Errorf("%w: holder_name can't be empty", ErrInvalidAccountUpdate)
+		}
+		account.HolderName = *update.HolderName
+	}
+
+	if update.Status != nil {
+		status := strings.ToUpper(*update.Status)
+		switch status {
+		case models.AccountActive, models.AccountBlocked, models.AccountClosed:
+		default:
+			return false, fmt.Errorf("%w: unknown status %s", ErrInvalidAccountUpdate, *update.Status)
+		}
+		if account.Status == models.AccountClosed && status != models.AccountClosed {
+			return false, fmt.Errorf("%w: account %s is closed", ErrInvalidAccountUpdate, account.PublicID)
+		}
+		account.Status = status
+	}
+
+	previous := account.CreditLimit
+	switch {
+	case update.ClearCreditLimit:
+		account.CreditLimit = nil
+	case update.CreditLimit != nil:
+		if *update.CreditLimit < 0 {
+			return false, fmt.Errorf("%w: credit_limit can't be negative", ErrInvalidAccountUpdate)
+		}
+		limit := *update.CreditLimit
+		account.CreditLimit = &limit
+	}
+	changed := (previous == nil) != (account.CreditLimit == nil) ||
+		previous != nil && *previous != *account.CreditLimit
+	return changed, nil
+}
+
+// applyAccountDefaults fills in the optional holder and product fields so older
+// clients that only send a document number keep working
+func applyAccountDefaults(account *models.Account) {
+	account.DocumentType = strings.ToUpper(account.DocumentType)
+	account.Country = strings.ToUpper(account.Country)
+	account.Currency = strings.ToUpper(account.Currency)
+
+	if account.DocumentType == "" {
+		account.DocumentType = defaultDocumentType
+	}
+	if account.Country == "" {
+		account.Country = defaultCountry
+	}
+	if account.Currency == "" {
+		account.Currency = defaultCurrency
+	}
+	if account.ProductID == 0 {
+		account.ProductID = defaultProductID
 	}
-	return accountID, nil
 }
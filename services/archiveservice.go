@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"pismo/models"
+	"pismo/store"
+)
+
+const (
+	DefaultArchiveAge = 180 * 24 * time.Hour // how long settled transactions stay in the hot tables
+
+	// how many transactions one unit of work archives, so no account is locked for long
+	archiveBatchSize = 500
+)
+
+type ArchiveServicer interface {
+	Archive(postedBefore time.Time) (models.ArchiveReport, error)
+	ArchiveAccount(accountID int64, postedBefore time.Time) (int, error)
+}
+
+type ArchiveService struct {
+	db store.ArchiveRepositoryer
+}
+
+func NewArchiveService(db store.ArchiveRepositoryer) ArchiveServicer {
+	return &ArchiveService{db: db}
+}
+
+// Archive archives every account with transactions, carrying on past accounts
+// that fail so one bad account doesn't hold up the others
+func (s *ArchiveService) Archive(postedBefore time.Time) (models.ArchiveReport, error) {
+	report := models.ArchiveReport{PostedBefore: postedBefore}
+
+	accountIDs, err := s.db.ListTransactionAccountIDs()
+	if err != nil {
+		return report, err
+	}
+
+	for _, accountID := range accountIDs {
+		archived, err := s.ArchiveAccount(accountID, postedBefore)
+		report.Transactions += archived
+		if err != nil {
+			if report.Errors == nil {
+				report.Errors = make(map[int64]string)
+			}
+			report.Errors[accountID] = err.Error()
+			continue
+		}
+		report.Accounts++
+	}
+	return report, nil
+}
+
+// ArchiveAccount moves the account's settled transactions posted before
+// postedBefore to the archive tables a batch at a time, each batch in a unit of
+// work of its own holding the account's balance lock, and returns how many it
+// moved. What the failing batch would have moved stays where it was.
+func (s *ArchiveService) ArchiveAccount(accountID int64, postedBefore time.Time) (int, error) {
+	total := 0
+	for {
+		var archived int
+		err := s.db.WithinTx(context.Background(), func(tx store.TxRepo) error {
+			// the account's AccountBalances row first, like posting does
+			if _, err := tx.LockAccountBalance(accountID); err != nil {
+				return err
+			}
+			var err error
+			archived, err = tx.ArchiveTransactions(accountID, postedBefore, archiveBatchSize)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		if archived == 0 {
+			return total, nil
+		}
+		total += archived
+	}
+}
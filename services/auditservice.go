@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"pismo/audit"
+	"pismo/models"
+	"pismo/store"
+)
+
+// how many entries VerifyChain reads at a time
+const auditVerifyPageSize = 500
+
+var ErrInvalidAuditFilter = errors.New("invalid audit filter")
+
+type AuditServicer interface {
+	ListEntries(filter models.AuditFilter) ([]models.AuditEntry, error)
+	VerifyChain() (AuditVerification, error)
+}
+
+// AuditVerification is the outcome of walking every audit chain
+type AuditVerification struct {
+	Valid   bool   `json:"valid"`
+	Entries int64  `json:"entries"`
+	Chains  int    `json:"chains"`
+	Problem string `json:"problem,omitempty"`
+}
+
+type AuditService struct {
+	db store.AuditRepositoryer
+}
+
+func NewAuditService(db store.AuditRepositoryer) AuditServicer {
+	return &AuditService{db: db}
+}
+
+func (s *AuditService) ListEntries(filter models.AuditFilter) ([]models.AuditEntry, error) {
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidAuditFilter)
+	}
+	return s.db.ListAuditEntries(filter)
+}
+
+// VerifyChain recomputes every hash from the first entry on and checks the last
+// one of each chain is still its head, so rows altered, removed or cut off the
+// end show up
+func (s *AuditService) VerifyChain() (AuditVerification, error) {
+	var result AuditVerification
+	last := map[int64]string{}
+
+	var afterID int64
+	for {
+		entries, err := s.db.ListAuditEntries(models.AuditFilter{AfterID: afterID, Limit: auditVerifyPageSize})
+		if err != nil {
+			return AuditVerification{}, err
+		}
+
+		if err := audit.Verify(entries, last); err != nil {
+			result.Problem = err.Error()
+			return result, nil
+		}
+		result.Entries += int64(len(entries))
+
+		if len(entries) < auditVerifyPageSize {
+			break
+		}
+		afterID = entries[len(entries)-1].ID
+	}
+
+	heads, err := s.db.ListAuditChainHeads()
+	if err != nil {
+		return AuditVerification{}, err
+	}
+	chains := make([]int64, 0, len(heads))
+	for chainID := range heads {
+		chains = append(chains, chainID)
+	}
+	for chainID := range last {
+		if _, ok := heads[chainID]; !ok {
+			chains = append(chains, chainID)
+		}
+	}
+	sort.Slice(chains, func(i, j int) bool { return chains[i] < chains[j] })
+
+	for _, chainID := range chains {
+		lastHash, ok := last[chainID]
+		if !ok {
+			lastHash = audit.GenesisHash
+		}
+		if heads[chainID] != lastHash {
+			result.Problem = fmt.Sprintf("%s: chain %d ends before its head, entries were removed", audit.ErrChainBroken, chainID)
+			return result, nil
+		}
+	}
+	result.Chains = len(chains)
+	result.Valid = true
+	return result, nil
+}
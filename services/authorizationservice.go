@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"pismo/fx"
+	"pismo/helpers"
+	"pismo/models"
+	"pismo/publicid"
+	"pismo/store"
+)
+
+const (
+	DefaultHoldTTL = 7 * 24 * time.Hour // how long a hold reserves credit if it's never captured or voided
+)
+
+var (
+	ErrAuthorizationNotFound   = errors.New("authorization not found")
+	ErrAuthorizationNotPending = errors.New("authorization is no longer pending")
+	ErrAuthorizationExpired    = errors.New("authorization has expired")
+	ErrInsufficientCredit      = errors.New("insufficient available credit")
+	ErrNotADebit               = errors.New("only debit operations can be authorized")
+	ErrInvalidCapture          = errors.New("invalid capture amount")
+)
+
+type AuthorizationServicer interface {
+	Authorize(authorization models.Authorization) (models.Authorization, error)
+	GetAuthorizationByRef(ref models.PublicID) (models.Authorization, error)
+	Capture(id int64, amount float64) (models.Authorization, error)
+	Void(id int64) (models.Authorization, error)
+	ExpireAuthorizations() (int64, error)
+}
+
+type AuthorizationService struct {
+	db           store.AuthorizationRepositoryer
+	transactions TransactionPoster
+	rates        fx.RateProvider
+	holdTTL      time.Duration
+}
+
+func NewAuthorizationService(db store.AuthorizationRepositoryer, transactions TransactionPoster, rates fx.RateProvider, holdTTL time.Duration) AuthorizationServicer {
+	if holdTTL <= 0 {
+		holdTTL = DefaultHoldTTL
+	}
+	return &AuthorizationService{db: db, transactions: transactions, rates: rates, holdTTL: holdTTL}
+}
+
+// Authorize reserves available credit for a purchase. The amount and currency are
+// what the merchant asked for, the hold itself is kept in the account currency.
+func (s *AuthorizationService) Authorize(authorization models.Authorization) (models.Authorization, error) {
+	if err := helpers.ValidateOperationDirection(authorization.OperationTypeID, authorization.Amount); err != nil {
+		return models.Authorization{}, err
+	}
+	if authorization.Amount >= 0 {
+		return models.Authorization{}, ErrNotADebit
+	}
+
+	account, err := s.db.GetAccountByID(authorization.AccountID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Authorization{}, ErrAccountNotFound
+		}
+		return models.Authorization{}, err
+	}
+	if err := checkAccountAccepts(account, authorization.OperationTypeID); err != nil {
+		return models.Authorization{}, err
+	}
+
+	converted, err := convertToAccountCurrency(s.rates, account, models.Transaction{
+		Amount:   authorization.Amount,
+		Currency: authorization.Currency,
+	})
+	if err != nil {
+		return models.Authorization{}, err
+	}
+
+	now := time.Now().UTC()
+	authorization.Amount = converted.Amount
+	authorization.Currency = converted.Currency
+	authorization.OriginalAmount = converted.OriginalAmount
+	authorization.OriginalCurrency = converted.OriginalCurrency
+	authorization.FXRate = converted.FXRate
+	authorization.PublicID = models.PublicID(publicid.New(publicid.Authorization))
+	authorization.AccountPublicID = account.PublicID
+	authorization.Status = models.AuthorizationPending
+	authorization.TransactionID = 0
+	authorization.TransactionPublicID = ""
+	authorization.ExpiresAt = now.Add(s.holdTTL)
+
+	err = s.db.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		// lock the account first so two holds can't both see the same available credit
+		if _, err := tx.LockAccountBalance(authorization.AccountID); err != nil {
+			return err
+		}
+		available, err := tx.GetAvailableCredit(authorization.AccountID, now)
+		if err != nil {
+			return err
+		}
+		if -authorization.Amount > available {
+			return fmt.Errorf("%w: requested %.2f, available %.2f", ErrInsufficientCredit, -authorization.Amount, available)
+		}
+
+		authorization.ID, err = tx.CreateAuthorization(authorization)
+		authorization.Version = 1
+		return err
+	})
+	if err != nil {
+		return models.Authorization{}, err
+	}
+	return authorization, nil
+}
+
+func (s *AuthorizationService) GetAuthorizationByID(id int64) (models.Authorization, error) {
+	authorization, err := s.db.GetAuthorizationByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Authorization{}, ErrAuthorizationNotFound
+		}
+		return models.Authorization{}, err
+	}
+	return authorization, nil
+}
+
+// GetAuthorizationByRef reads the hold a client named, by its public id or, while
+// clients move over to those, by its internal id
+func (s *AuthorizationService) GetAuthorizationByRef(ref models.PublicID) (models.Authorization, error) {
+	if id, ok := ref.Legacy(); ok {
+		return s.GetAuthorizationByID(id)
+	}
+	authorization, err := s.db.GetAuthorizationByPublicID(ref)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Authorization{}, ErrAuthorizationNotFound
+		}
+		return models.Authorization{}, err
+	}
+	return authorization, nil
+}
+
+// Capture posts the held purchase through the transaction service. The amount is in
+// the original currency of the authorization, zero captures the full hold and
+// more than the hold is refused. Claiming the hold, posting the purchase and
+// linking the two are one unit of work, so either all of it happens or none.
+func (s *AuthorizationService) Capture(id int64, amount float64) (models.Authorization, error) {
+	// the account's lock is taken before the hold's, like everything else that
+	// writes to the account, and the account of a hold never changes
+	hold, err := s.GetAuthorizationByID(id)
+	if err != nil {
+		return models.Authorization{}, err
+	}
+
+	var authorization models.Authorization
+	var expired bool
+	err = s.db.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		if _, err := tx.LockAccountBalance(hold.AccountID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrAccountNotFound
+			}
+			return err
+		}
+		authorization, expired, err = claim(tx, id)
+		if err != nil || expired {
+			return err
+		}
+
+		if amount == 0 {
+			amount = authorization.OriginalAmount
+		}
+		if err := helpers.ValidateOperationDirection(authorization.OperationTypeID, amount); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCapture, err)
+		}
+		if amount < authorization.OriginalAmount {
+			return fmt.Errorf("%w: %.2f is more than the %.2f held", ErrInvalidCapture, -amount, -authorization.OriginalAmount)
+		}
+
+		transaction, err := s.transactions.CreateTransactionWithin(tx, models.Transaction{
+			AccountID:       authorization.AccountID,
+			OperationTypeID: authorization.OperationTypeID,
+			Amount:          amount,
+			Currency:        authorization.OriginalCurrency,
+		})
+		if err != nil {
+			return err
+		}
+		authorization.Status = models.AuthorizationCaptured
+		authorization.TransactionID = transaction.ID
+		authorization.TransactionPublicID = transaction.PublicID
+		authorization.Version++
+		return tx.UpdateAuthorizationStatus(id, models.AuthorizationCaptured, transaction)
+	})
+	if err != nil {
+		return models.Authorization{}, err
+	}
+	if expired {
+		return models.Authorization{}, ErrAuthorizationExpired
+	}
+	return authorization, nil
+}
+
+// Void releases the hold without posting anything
+func (s *AuthorizationService) Void(id int64) (models.Authorization, error) {
+	var authorization models.Authorization
+	var expired bool
+	err := s.db.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		var err error
+		authorization, expired, err = claim(tx, id)
+		if err != nil || expired {
+			return err
+		}
+		authorization.Status = models.AuthorizationVoided
+		authorization.Version++
+		return tx.UpdateAuthorizationStatus(id, models.AuthorizationVoided, models.Transaction{})
+	})
+	if err != nil {
+		return models.Authorization{}, err
+	}
+	if expired {
+		return models.Authorization{}, ErrAuthorizationExpired
+	}
+	return authorization, nil
+}
+
+func (s *AuthorizationService) ExpireAuthorizations() (int64, error) {
+	return s.db.ExpireAuthorizations(time.Now().UTC())
+}
+
+// claim locks a pending authorization for the caller to move on. Holds found
+// past their expiry are expired on the spot instead, and reported as expired for
+// the caller to commit and give up on.
+func claim(tx store.TxRepo, id int64) (models.Authorization, bool, error) {
+	authorization, err := tx.GetAuthorizationForUpdate(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Authorization{}, false, ErrAuthorizationNotFound
+		}
+		return models.Authorization{}, false, err
+	}
+	if authorization.Status != models.AuthorizationPending {
+		return models.Authorization{}, false, fmt.Errorf("%w: authorization %d is %s", ErrAuthorizationNotPending, id, authorization.Status)
+	}
+
+	if !authorization.ExpiresAt.After(time.Now().UTC()) {
+		return authorization, true, tx.UpdateAuthorizationStatus(id, models.AuthorizationExpired, models.Transaction{})
+	}
+	return authorization, false, nil
+}
+
+// RunAuthorizationExpirySweeper expires stale holds every interval until the context is cancelled
+func RunAuthorizationExpirySweeper(ctx context.Context, service AuthorizationServicer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := service.ExpireAuthorizations()
+			if err != nil {
+				fmt.Printf("Failed to expire authorizations: %v\n", err)
+				continue
+			}
+			if expired > 0 {
+				fmt.Printf("Expired %d authorizations\n", expired)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"pismo/fx"
+	"pismo/models"
+)
+
+const (
+	DefaultBatchConcurrency = 4 // accounts posted to at the same time
+)
+
+type BatchServicer interface {
+	ProcessBatch(rows []models.BatchRow) models.BatchReport
+}
+
+type BatchService struct {
+	transactions TransactionServicer
+	accounts     AccountServicer
+	concurrency  int
+}
+
+func NewBatchService(transactions TransactionServicer, accounts AccountServicer, concurrency int) BatchServicer {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	return &BatchService{transactions: transactions, accounts: accounts, concurrency: concurrency}
+}
+
+// ProcessBatch posts every valid row through CreateTransaction. Rows for the same
+// account are posted one after the other in the order they were sent, so credits
+// discharge the debits sent before them, while different accounts are posted
+// concurrently. Rows that already carry an error are rejected without posting.
+func (s *BatchService) ProcessBatch(rows []models.BatchRow) models.BatchReport {
+	report := models.BatchReport{Total: len(rows), Results: make([]models.BatchResult, len(rows))}
+	found := make(map[models.PublicID]accountLookup)
+
+	// indexes of the rows of each account, accounts in the order they first appear
+	var accounts [][]int
+	byAccount := make(map[int64]int)
+	for i, row := range rows {
+		report.Results[i] = models.BatchResult{Row: row.Row, AccountPublicID: row.Transaction.AccountPublicID}
+		if row.Error != "" {
+			report.Results[i].Status = models.BatchRowRejected
+			report.Results[i].Error = row.Error
+			continue
+		}
+
+		// rows name their account by its public id, or its internal id, and the same
+		// account may be named either way, they're grouped by the account they find
+		if rows[i].Transaction.AccountID == 0 {
+			ref := row.Transaction.AccountPublicID
+			lookup, ok := found[ref]
+			if !ok {
+				lookup = s.lookupAccount(ref)
+				found[ref] = lookup
+			}
+			if lookup.status != "" {
+				report.Results[i].Status = lookup.status
+				report.Results[i].Error = lookup.err.Error()
+				continue
+			}
+			rows[i].Transaction.AccountID = lookup.accountID
+		}
+		accountID := rows[i].Transaction.AccountID
+		group, ok := byAccount[accountID]
+		if !ok {
+			group = len(accounts)
+			byAccount[accountID] = group
+			accounts = append(accounts, nil)
+		}
+		accounts[group] = append(accounts[group], i)
+	}
+
+	groups := make(chan []int)
+	var wg sync.WaitGroup
+	for w := 0; w < s.concurrency && w < len(accounts); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range groups {
+				for _, i := range group {
+					// each row has its own result slot, workers never share one
+					report.Results[i] = s.post(rows[i], report.Results[i])
+				}
+			}
+		}()
+	}
+	for _, group := range accounts {
+		groups <- group
+	}
+	close(groups)
+	wg.Wait()
+
+	for _, result := range report.Results {
+		switch result.Status {
+		case models.BatchRowCreated:
+			report.Created++
+		case models.BatchRowRejected:
+			report.Rejected++
+		default:
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// accountLookup is the account a batch names, or why it can't be posted to
+type accountLookup struct {
+	accountID int64
+	status    string
+	err       error
+}
+
+// lookupAccount finds the account a row names. Internal ids are taken as they
+// are, the transaction service finds out soon enough if they don't exist.
+func (s *BatchService) lookupAccount(ref models.PublicID) accountLookup {
+	if id, ok := ref.Legacy(); ok {
+		return accountLookup{accountID: id}
+	}
+	account, err := s.accounts.GetAccountByRef(ref)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return accountLookup{status: models.BatchRowRejected, err: ErrAccountNotFound}
+	case err != nil:
+		return accountLookup{status: models.BatchRowFailed, err: err}
+	}
+	return accountLookup{accountID: account.ID}
+}
+
+func (s *BatchService) post(row models.BatchRow, result models.BatchResult) models.BatchResult {
+	transaction, err := s.transactions.CreateTransaction(row.Transaction)
+	switch {
+	case err == nil:
+		result.Status = models.BatchRowCreated
+		result.AccountPublicID = transaction.AccountPublicID
+		result.TransactionPublicID = transaction.PublicID
+	case errors.Is(err, ErrAccountNotFound), errors.Is(err, fx.ErrRateNotFound), errors.Is(err, ErrInvalidEventDate), errors.Is(err, ErrAccountNotActive):
+		result.Status = models.BatchRowRejected
+		result.Error = err.Error()
+	default:
+		result.Status = models.BatchRowFailed
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"pismo/ledger"
+	"pismo/models"
+	"pismo/store"
+)
+
+type LedgerServicer interface {
+	TrialBalance() ([]models.TrialBalance, error)
+}
+
+type LedgerService struct {
+	db store.LedgerRepositoryer
+}
+
+func NewLedgerService(db store.LedgerRepositoryer) LedgerServicer {
+	return &LedgerService{db: db}
+}
+
+// TrialBalance returns the trial balance of every currency, each is balanced when
+// its debits and credits add up to the same amount to the cent
+func (s *LedgerService) TrialBalance() ([]models.TrialBalance, error) {
+	balances, err := s.db.GetTrialBalance()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range balances {
+		var debits, credits int64
+		for j, line := range balances[i].Lines {
+			lineDebits, lineCredits := ledger.ToCents(line.Debits), ledger.ToCents(line.Credits)
+			balances[i].Lines[j].Balance = float64(lineDebits-lineCredits) / 100
+			debits += lineDebits
+			credits += lineCredits
+		}
+		balances[i].TotalDebits = float64(debits) / 100
+		balances[i].TotalCredits = float64(credits) / 100
+		balances[i].Balanced = debits == credits
+	}
+	return balances, nil
+}
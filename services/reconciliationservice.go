@@ -0,0 +1,301 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"pismo/ledger"
+	"pismo/models"
+	"pismo/store"
+)
+
+type ReconciliationServicer interface {
+	Reconcile(repair bool) (models.ReconciliationReport, error)
+	ReconcileAccount(accountID int64, repair bool) (models.AccountReconciliation, error)
+}
+
+type ReconciliationService struct {
+	db store.ReconciliationRepositoryer
+}
+
+func NewReconciliationService(db store.ReconciliationRepositoryer) ReconciliationServicer {
+	return &ReconciliationService{db: db}
+}
+
+// Reconcile checks every account with transactions, carrying on past accounts
+// that fail so one bad account doesn't hide the others
+func (s *ReconciliationService) Reconcile(repair bool) (models.ReconciliationReport, error) {
+	report := models.ReconciliationReport{Discrepancies: []models.BalanceDiscrepancy{}, StaleAllocations: []int64{}, StaleTotals: []int64{}, Repaired: repair}
+
+	accountIDs, err := s.db.ListTransactionAccountIDs()
+	if err != nil {
+		return report, err
+	}
+
+	for _, accountID := range accountIDs {
+		result, err := s.ReconcileAccount(accountID, repair)
+		if err != nil {
+			if report.Errors == nil {
+				report.Errors = make(map[int64]string)
+			}
+			report.Errors[accountID] = err.Error()
+			continue
+		}
+		report.Accounts++
+		report.Transactions += result.Transactions
+		report.Discrepancies = append(report.Discrepancies, result.Discrepancies...)
+		if result.StaleAllocations {
+			report.StaleAllocations = append(report.StaleAllocations, accountID)
+		}
+		if result.StaleTotals {
+			report.StaleTotals = append(report.StaleTotals, accountID)
+		}
+	}
+	return report, nil
+}
+
+// ReconcileAccount replays the account's transactions and reports the ones whose
+// stored balance is off, and whether the allocation history and running totals
+// are. Without repair they're all read from one snapshot, so a posting made in
+// between can't pass for a discrepancy. With repair the account is locked for
+// the replay, the stored balances are overwritten with the replayed ones,
+// recording the change in the audit log, and the allocation history and running
+// totals are rebuilt.
+func (s *ReconciliationService) ReconcileAccount(accountID int64, repair bool) (models.AccountReconciliation, error) {
+	var result models.AccountReconciliation
+	if !repair {
+		err := s.db.WithinTx(context.Background(), func(tx store.TxRepo) error {
+			transactions, err := tx.ListAccountTransactions(accountID)
+			if err != nil {
+				return err
+			}
+			allocations, err := tx.ListAccountAllocations(accountID)
+			if err != nil {
+				return err
+			}
+			totals, err := tx.GetAccountTotals(accountID)
+			if err != nil {
+				return err
+			}
+			result = reconcile(accountID, transactions, allocations, totals)
+			return nil
+		}, store.ReadOnly(), store.WithIsolation(store.RepeatableRead))
+		if err != nil {
+			return models.AccountReconciliation{}, err
+		}
+		return result, nil
+	}
+
+	err := s.db.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		// the account's AccountBalances row first, like posting does, then its transactions
+		totals, err := tx.LockAccountBalance(accountID)
+		if err != nil {
+			return err
+		}
+		transactions, err := tx.ListAccountTransactionsForUpdate(accountID)
+		if err != nil {
+			return err
+		}
+		allocations, err := tx.ListAccountAllocations(accountID)
+		if err != nil {
+			return err
+		}
+		result = reconcile(accountID, transactions, allocations, totals)
+
+		if len(result.Discrepancies) > 0 {
+			changes := make([]models.BalanceChange, 0, len(result.Discrepancies))
+			for _, discrepancy := range result.Discrepancies {
+				if err := tx.UpdateTransactionBalance(accountID, discrepancy.TransactionID, discrepancy.Expected); err != nil {
+					return err
+				}
+				changes = append(changes, models.BalanceChange{TransactionID: discrepancy.TransactionID, Before: discrepancy.Stored, After: discrepancy.Expected})
+			}
+
+			_, err := tx.AppendAuditEntry(models.AuditEntry{
+				Kind:      models.AuditKindBalanceChange,
+				Route:     "reconcile",
+				AccountID: accountID,
+				Changes:   changes,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if result.StaleAllocations {
+			if err := tx.ReplaceAccountAllocations(accountID, ReplayAllocations(transactions)); err != nil {
+				return err
+			}
+		}
+
+		if result.StaleTotals {
+			expected := ReplayTotals(transactions)
+			totals.TotalDebt, totals.TotalCredit = expected.TotalDebt, expected.TotalCredit
+			if err := tx.UpdateAccountBalance(totals); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return models.AccountReconciliation{}, err
+	}
+	return result, nil
+}
+
+// ReplayBalances runs the transactions of one account, in the order they were
+// posted, which is the order discharge sees them in, through the discharge
+// rules and returns the balance each should have: debits start owing their full
+// amount, and every credit pays off the oldest debts still owing before it,
+// keeping whatever is left over. Balances are worked out in cents, the
+// precision they're stored at.
+func ReplayBalances(transactions []models.Transaction) []float64 {
+	balances, _ := replay(transactions)
+	replayed := make([]float64, len(balances))
+	for i, cents := range balances {
+		replayed[i] = float64(cents) / 100
+	}
+	return replayed
+}
+
+// ReplayAllocations returns what each credit pays off in ReplayBalances, dated
+// when the credit was posted
+func ReplayAllocations(transactions []models.Transaction) []models.DischargeAllocation {
+	_, allocations := replay(transactions)
+	return allocations
+}
+
+func replay(transactions []models.Transaction) ([]int64, []models.DischargeAllocation) {
+	balances := make([]int64, len(transactions))
+	allocations := []models.DischargeAllocation{}
+	var owing []int // indexes of debits with a balance still owing, oldest first
+
+	for i, transaction := range transactions {
+		balances[i] = ledger.ToCents(transaction.Amount)
+
+		if transaction.OperationTypeID < 4 {
+			if balances[i] < 0 {
+				owing = append(owing, i)
+			}
+			continue
+		}
+
+		remaining := balances[i]
+		for len(owing) > 0 && remaining > 0 {
+			debt := owing[0]
+			applied := remaining
+			if remaining >= -balances[debt] {
+				applied = -balances[debt]
+				owing = owing[1:]
+			}
+			balances[debt] += applied
+			remaining -= applied
+
+			allocations = append(allocations, models.DischargeAllocation{
+				AccountID:           transaction.AccountID,
+				DebitTransactionID:  transactions[debt].ID,
+				CreditTransactionID: transaction.ID,
+				Amount:              float64(applied) / 100,
+				AllocatedAt:         transaction.PostingDate,
+			})
+		}
+		balances[i] = remaining
+	}
+	return balances, allocations
+}
+
+// ReplayTotals is the debt still owed and the credit still unused once the
+// transactions have gone through ReplayBalances
+func ReplayTotals(transactions []models.Transaction) models.AccountTotals {
+	balances, _ := replay(transactions)
+	var debt, credit int64
+	for i, balance := range balances {
+		if transactions[i].OperationTypeID < 4 {
+			debt -= balance
+		} else {
+			credit += balance
+		}
+	}
+	return models.AccountTotals{TotalDebt: float64(debt) / 100, TotalCredit: float64(credit) / 100}
+}
+
+func reconcile(accountID int64, transactions []models.Transaction, allocations []models.DischargeAllocation, totals models.AccountTotals) models.AccountReconciliation {
+	result := models.AccountReconciliation{
+		AccountID:     accountID,
+		Transactions:  len(transactions),
+		Discrepancies: []models.BalanceDiscrepancy{},
+	}
+
+	for i, expected := range ReplayBalances(transactions) {
+		transaction := transactions[i]
+		if ledger.ToCents(transaction.Balance) == ledger.ToCents(expected) {
+			continue
+		}
+		result.Discrepancies = append(result.Discrepancies, models.BalanceDiscrepancy{
+			AccountID:       transaction.AccountID,
+			TransactionID:   transaction.ID,
+			OperationTypeID: transaction.OperationTypeID,
+			EventDate:       transaction.EventDate,
+			Stored:          transaction.Balance,
+			Expected:        expected,
+		})
+	}
+
+	result.StaleAllocations = !sameAllocations(allocations, ReplayAllocations(transactions))
+	expected := ReplayTotals(transactions)
+	result.StaleTotals = ledger.ToCents(totals.TotalDebt) != ledger.ToCents(expected.TotalDebt) || ledger.ToCents(totals.TotalCredit) != ledger.ToCents(expected.TotalCredit)
+	return result
+}
+
+// sameAllocations compares what was applied where, not when or in which order it was recorded
+func sameAllocations(stored, replayed []models.DischargeAllocation) bool {
+	if len(stored) != len(replayed) {
+		return false
+	}
+	type key struct {
+		debit, credit, cents int64
+	}
+	counts := make(map[key]int)
+	for _, allocation := range stored {
+		counts[key{allocation.DebitTransactionID, allocation.CreditTransactionID, ledger.ToCents(allocation.Amount)}]++
+	}
+	for _, allocation := range replayed {
+		k := key{allocation.DebitTransactionID, allocation.CreditTransactionID, ledger.ToCents(allocation.Amount)}
+		if counts[k] == 0 {
+			return false
+		}
+		counts[k]--
+	}
+	return true
+}
+
+// RunReconciliationJob checks every account each interval until the context is
+// cancelled, it only reports, repairs are left to the reconcile command
+func RunReconciliationJob(ctx context.Context, service ReconciliationServicer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := service.Reconcile(false)
+			if err != nil {
+				fmt.Printf("Failed to reconcile balances: %v\n", err)
+				continue
+			}
+			for _, discrepancy := range report.Discrepancies {
+				fmt.Printf("Balance discrepancy on account %d transaction %d: stored %.2f, expected %.2f\n",
+					discrepancy.AccountID, discrepancy.TransactionID, discrepancy.Stored, discrepancy.Expected)
+			}
+			for _, accountID := range report.StaleAllocations {
+				fmt.Printf("Allocation history of account %d doesn't match its transactions\n", accountID)
+			}
+			for accountID, problem := range report.Errors {
+				fmt.Printf("Failed to reconcile account %d: %s\n", accountID, problem)
+			}
+		}
+	}
+}
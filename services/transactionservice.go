@@ -1,130 +1,275 @@
 package services
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
-	"math/rand"
+	"strings"
 	"time"
-	"sync"
-	
-	"github.com/go-sql-driver/mysql"
 
+	"pismo/fx"
 	"pismo/helpers"
+	"pismo/ledger"
 	"pismo/models"
+	"pismo/publicid"
 	"pismo/store"
 )
 
-const (
-    ErrCodeDeadlock = 1213
-)
-
 type TransactionServicer interface {
-	CreateTransaction(transaction models.Transaction) (int64, error)
-	CreateTransactionsConcurrently(req models.Transaction, count int) ([]int64, error)
-}
-
-type TransactionService struct {
-	db store.Repositoryer
+	CreateTransaction(transaction models.Transaction) (models.Transaction, error)
 }
 
-func NewTransactionService(db store.Repositoryer) TransactionServicer {
-	return &TransactionService{db: db}
+// TransactionPoster also posts transactions in a unit of work of the caller's
+type TransactionPoster interface {
+	TransactionServicer
+	CreateTransactionWithin(tx store.TxRepo, transaction models.Transaction) (models.Transaction, error)
 }
 
-func (s *TransactionService) CreateTransactionsConcurrently(req models.Transaction, numTransactions int) ([]int64, error) {
-    var wg sync.WaitGroup
-    var transactionIDs []int64
-    var err error
-
-    create := func() {
-        defer wg.Done()
-        time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
-
-        transactionID, tempErr := s.CreateTransaction(req)
-        if tempErr != nil {
-            err = tempErr
-            return
-        }
-
-        transactionIDs = append(transactionIDs, transactionID)
-    }
+const (
+	// how far back a client may date a transaction, e.g. for purchases settled late
+	DefaultBackdatingWindow = 30 * 24 * time.Hour
+	// event dates this little in the future are let through for client clock drift
+	maxEventDateSkew = 5 * time.Minute
+)
 
-    for i := 0; i < numTransactions; i++ {
-        wg.Add(1)
-        go create()
-    }
+var (
+	ErrAccountNotFound  = errors.New("account not found")
+	ErrInvalidEventDate = errors.New("invalid event_date")
+	ErrAccountNotActive = errors.New("account not active")
+)
 
-    wg.Wait()
+type TransactionService struct {
+	db               store.Repositoryer
+	rates            fx.RateProvider
+	backdatingWindow time.Duration
+	accounts         *accountLocks
+}
 
-    if err != nil {
-        return nil, err
-    }
-    return transactionIDs, nil
+func NewTransactionService(db store.Repositoryer, rates fx.RateProvider, backdatingWindow time.Duration) TransactionPoster {
+	return &TransactionService{db: db, rates: rates, backdatingWindow: backdatingWindow, accounts: newAccountLocks()}
 }
 
-func (s *TransactionService) CreateTransaction(transaction models.Transaction) (int64, error) {
-	var transactionID int64
-	err := helpers.ValidateOperationDirection(transaction.OperationTypeID, transaction.Amount)
+// CreateTransaction posts the transaction to the account AccountID names and
+// returns it as it was posted, with its ids
+func (s *TransactionService) CreateTransaction(transaction models.Transaction) (models.Transaction, error) {
+	var posted models.Transaction
+	transaction, err := s.prepare(s.db, transaction)
 	if err != nil {
-		return 0, err
+		return models.Transaction{}, err
 	}
 
-	// there are cases where theres no race conditions but a transaction fails to execute due to deadlocks
-	// this gives three attempts to create a transaction. For 10 concurrent transaction, this timeout is
-	// plenty to make sure all three transactions are met. In a prod scenario, it may not be so simple.
-	// for example, if 1000 rows are selected, they would all be locked by the FOR UPDATE sql statement,
-	// increasing deadlocks
+	// transactions of the same account are posted one at a time, queued here rather
+	// than each holding a connection while it waits on the account row lock. Other
+	// accounts aren't held up.
+	unlock := s.accounts.lock(transaction.AccountID)
+	defer unlock()
+
+	// every attempt locks the account row before anything else, so same account work
+	// from other instances waits its turn instead of deadlocking on the Transactions
+	// rows. The retry is left as a safety net, e.g. for gap locks between accounts.
 	for i := 0; i < 3; i++ {
-		transactionID, err = s.attemptTransactionCreationWithRollback(transaction)
+		posted, err = s.attemptTransactionCreation(transaction)
 		if err != nil {
-			var mysqlErr *mysql.MySQLError
-			// errors are wrapped with my custom error messages, so I specifically need to use errors.As
-			if errors.As(err, &mysqlErr) && mysqlErr.Number == ErrCodeDeadlock {
-				fmt.Printf("Deadlock detected, retrying transaction: attempt %d\n", i+1)
+			// a deadlock or serialization failure rolled the whole attempt back, errors.As in
+			// IsRetryable still finds the driver error under our wrapping
+			if store.IsRetryable(err) {
+				fmt.Printf("Deadlock or serialization failure, retrying transaction: attempt %d\n", i+1)
 				time.Sleep(time.Duration(i+1) * time.Second) // exponential back-off
 				continue
 			}
-			return 0, err
+			return models.Transaction{}, err
 		}
 		break // transaction was finally processed
 	}
-	return transactionID, err
+	return posted, err
 }
 
-func (s *TransactionService) attemptTransactionCreationWithRollback(transaction models.Transaction) (int64, error) {
-	// this is the db transaction that will be used to commit to db, and rollback everything
-	// in case of any failures
-	tx, err := s.db.BeginTransaction()
+// CreateTransactionWithin posts the transaction in a unit of work of the caller's,
+// along with whatever else the caller does in it, and is only kept if that is
+// committed. Retrying the unit of work when it deadlocks is left to the caller.
+func (s *TransactionService) CreateTransactionWithin(tx store.TxRepo, transaction models.Transaction) (models.Transaction, error) {
+	transaction, err := s.prepare(tx, transaction)
 	if err != nil {
-		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+		return models.Transaction{}, err
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
+	return s.post(tx, transaction)
+}
+
+// accountReader reads accounts, from the repository or a unit of work
+type accountReader interface {
+	GetAccountByID(id int64) (models.Account, error)
+}
+
+// prepare checks the transaction can be posted and converts it into the currency
+// of its account, which everything from the discharge on works in
+func (s *TransactionService) prepare(accounts accountReader, transaction models.Transaction) (models.Transaction, error) {
+	if err := helpers.ValidateOperationDirection(transaction.OperationTypeID, transaction.Amount); err != nil {
+		return models.Transaction{}, err
+	}
+	if err := s.validateEventDate(transaction.EventDate, time.Now().UTC()); err != nil {
+		return models.Transaction{}, err
+	}
+	transaction, err := s.convertToAccountCurrency(accounts, transaction)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	// made once, an attempt that is retried left nothing behind with it
+	transaction.PublicID = models.PublicID(publicid.New(publicid.Transaction))
+	return transaction, nil
+}
+
+// attemptTransactionCreation posts the transaction in one unit of work, nothing of
+// it is kept unless all of it is
+func (s *TransactionService) attemptTransactionCreation(transaction models.Transaction) (models.Transaction, error) {
+	var posted models.Transaction
+	err := s.db.WithinTx(context.Background(), func(tx store.TxRepo) (err error) {
+		posted, err = s.post(tx, transaction)
+		return err
+	})
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	return posted, nil
+}
+
+// post writes the transaction, its journal entry and what it discharges in the
+// unit of work tx
+func (s *TransactionService) post(tx store.TxRepo, transaction models.Transaction) (models.Transaction, error) {
+	// the single lock point of an account, discharge, reconciliation and holds all take
+	// its AccountBalances row first. Posting dates are set once it's held so they
+	// follow the order the account's transactions were actually written in.
+	totals, err := tx.LockAccountBalance(transaction.AccountID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Transaction{}, ErrAccountNotFound
 		}
-	}()
+		return models.Transaction{}, err
+	}
 
 	// Insert the deposit transaction into the db using the same db transaction context
-	// (not the monetary transaction)
+	// (not the monetary transaction). The posting date is always ours, the event
+	// date is the client's when it sent one
 	transaction.Balance = transaction.Amount
-	transactionID, err := s.db.CreateTransactionWithTx(tx, transaction)
+	transaction.PostingDate = time.Now().UTC()
+	if transaction.EventDate.IsZero() {
+		transaction.EventDate = transaction.PostingDate
+	}
+	transaction.ID, err = tx.CreateTransaction(transaction)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	// the ledger records every transaction as balanced postings, in the same db transaction
+	entry, err := ledger.ForTransaction(transaction)
 	if err != nil {
-		return 0, err
+		return models.Transaction{}, err
+	}
+	if _, err := tx.CreateJournalEntry(entry); err != nil {
+		return models.Transaction{}, err
 	}
-	transaction.ID = transactionID
 
-	// discharge the transaction only if its a deposit
-	if transaction.OperationTypeID == 4 {
-		err = s.db.ProcessDischargeTransactionWithTx(tx, transaction)
+	// discharge the transaction only if its a deposit, and only when there's debt to pay off
+	var discharged float64
+	if transaction.OperationTypeID == 4 && totals.TotalDebt > 0 {
+		discharged, err = tx.ProcessDischargeTransaction(transaction)
 		if err != nil {
-			return 0, err
+			return models.Transaction{}, err
 		}
 	}
 
-	// only commit if there are no errors with updating any of those in the db
-	if err = tx.Commit(); err != nil {
-		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	// the running totals move with the transaction, in the same db transaction
+	if err := tx.UpdateAccountBalance(applyToTotals(totals, transaction, discharged)); err != nil {
+		return models.Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// applyToTotals adds a posted transaction to the account totals: debits add to the
+// debt, credits pay off what they discharged and keep the rest as credit. Totals
+// are worked out in cents, the precision they're stored at.
+func applyToTotals(totals models.AccountTotals, transaction models.Transaction, discharged float64) models.AccountTotals {
+	debt, credit := ledger.ToCents(totals.TotalDebt), ledger.ToCents(totals.TotalCredit)
+	amount := ledger.ToCents(transaction.Amount)
+	if transaction.OperationTypeID < 4 {
+		debt -= amount
+	} else {
+		paid := ledger.ToCents(discharged)
+		debt -= paid
+		credit += amount - paid
+	}
+	totals.TotalDebt = float64(debt) / 100
+	totals.TotalCredit = float64(credit) / 100
+	return totals
+}
+
+// validateEventDate refuses event dates in the future, give or take some clock
+// drift, and further back than the backdating window
+func (s *TransactionService) validateEventDate(eventDate, now time.Time) error {
+	if eventDate.IsZero() {
+		return nil
+	}
+	if eventDate.After(now.Add(maxEventDateSkew)) {
+		return fmt.Errorf("%w: %s is in the future", ErrInvalidEventDate, eventDate.Format(time.RFC3339))
 	}
+	if eventDate.Before(now.Add(-s.backdatingWindow)) {
+		return fmt.Errorf("%w: %s is more than %s in the past", ErrInvalidEventDate, eventDate.Format(time.RFC3339), s.backdatingWindow)
+	}
+	return nil
+}
 
-	return transactionID, nil
+// convertToAccountCurrency keeps what the client sent as the original amount and
+// currency, and converts the amount into the currency of the account
+func (s *TransactionService) convertToAccountCurrency(accounts accountReader, transaction models.Transaction) (models.Transaction, error) {
+	account, err := accounts.GetAccountByID(transaction.AccountID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Transaction{}, ErrAccountNotFound
+		}
+		return models.Transaction{}, err
+	}
+	if err := checkAccountAccepts(account, transaction.OperationTypeID); err != nil {
+		return models.Transaction{}, err
+	}
+	transaction.AccountPublicID = account.PublicID
+	return convertToAccountCurrency(s.rates, account, transaction)
+}
+
+// checkAccountAccepts turns away transactions the status of the account doesn't
+// allow, blocked accounts can still be paid into but closed ones take nothing
+func checkAccountAccepts(account models.Account, operationTypeID int) error {
+	switch account.Status {
+	case models.AccountBlocked:
+		if operationTypeID != 4 {
+			return fmt.Errorf("%w: account %s is %s", ErrAccountNotActive, account.PublicID, account.Status)
+		}
+	case models.AccountClosed:
+		return fmt.Errorf("%w: account %s is %s", ErrAccountNotActive, account.PublicID, account.Status)
+	}
+	return nil
+}
+
+func convertToAccountCurrency(rates fx.RateProvider, account models.Account, transaction models.Transaction) (models.Transaction, error) {
+	if transaction.Currency == "" {
+		transaction.Currency = account.Currency
+	}
+	transaction.OriginalAmount = transaction.Amount
+	transaction.OriginalCurrency = strings.ToUpper(transaction.Currency)
+	transaction.Currency = account.Currency
+	transaction.FXRate = 1
+
+	if transaction.OriginalCurrency == account.Currency {
+		return transaction, nil
+	}
+
+	rate, err := rates.Rate(transaction.OriginalCurrency, account.Currency)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	transaction.FXRate = rate
+	transaction.Amount = fx.Convert(transaction.OriginalAmount, rate)
+	if transaction.Amount == 0 {
+		return models.Transaction{}, fmt.Errorf("amount %.2f %s is too small to convert to %s", transaction.OriginalAmount, transaction.OriginalCurrency, account.Currency)
+	}
+	return transaction, nil
 }
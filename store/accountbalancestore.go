@@ -0,0 +1,67 @@
+package store
+
+import (
+	"fmt"
+
+	"pismo/models"
+)
+
+const accountTotalsColumns = "account_id, total_debt, total_credit, available_limit, version, updated_at"
+
+func scanAccountTotals(row rowScanner) (models.AccountTotals, error) {
+	var totals models.AccountTotals
+	err := row.Scan(&totals.AccountID, &totals.TotalDebt, &totals.TotalCredit, &totals.AvailableLimit, &totals.Version, &totals.UpdatedAt)
+	return totals, err
+}
+
+// GetAccountTotals reads the running totals of the account, from a replica when
+// there is one close enough behind the primary
+func (repo *Repository) GetAccountTotals(accountID int64) (models.AccountTotals, error) {
+	query := "SELECT " + accountTotalsColumns + " FROM AccountBalances WHERE account_id = ?"
+	var totals models.AccountTotals
+	err := repo.read(func(q querier) (err error) {
+		totals, err = scanAccountTotals(repo.queryRow(q, query, accountID))
+		return err
+	})
+	return totals, err
+}
+
+// GetAccountTotals is Repository.GetAccountTotals within the unit of work, without
+// taking the lock LockAccountBalance does
+func (tx *txRepository) GetAccountTotals(accountID int64) (models.AccountTotals, error) {
+	query := "SELECT " + accountTotalsColumns + " FROM AccountBalances WHERE account_id = ?"
+	return scanAccountTotals(tx.queryRow(query, accountID))
+}
+
+// LockAccountBalance takes a row lock on the account's running totals and
+// returns them. It is the one lock everything that posts to the account, checks
+// its available credit or rewrites its balances takes first, so they are
+// serialized until the transaction completes.
+func (tx *txRepository) LockAccountBalance(accountID int64) (models.AccountTotals, error) {
+	query := "SELECT " + accountTotalsColumns + " FROM AccountBalances WHERE account_id = ? FOR UPDATE"
+	return scanAccountTotals(tx.queryRow(query, accountID))
+}
+
+// UpdateAccountBalance stores new totals for the account, the available limit
+// follows from the credit limit of the account, or of its product when it has none. It only applies on top of the version the
+// totals were read at.
+func (tx *txRepository) UpdateAccountBalance(totals models.AccountTotals) error {
+	query := `UPDATE AccountBalances
+		SET total_debt = ?, total_credit = ?, available_limit = (
+			SELECT COALESCE(a.credit_limit, p.credit_limit) FROM Accounts a JOIN Products p ON p.product_id = a.product_id
+			WHERE a.account_id = AccountBalances.account_id
+		) - ?, version = version + 1
+		WHERE account_id = ? AND version = ?`
+	result, err := tx.exec(query, totals.TotalDebt, totals.TotalCredit, totals.TotalDebt, totals.AccountID, totals.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update balance of account %d: %w", totals.AccountID, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return fmt.Errorf("%w: balance of account %d is no longer at version %d", ErrVersionMismatch, totals.AccountID, totals.Version)
+	}
+	return nil
+}
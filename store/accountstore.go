@@ -2,45 +2,122 @@ package store
 
 import (
 	"database/sql"
+	"fmt"
+
 	"pismo/models"
 )
 
-func (repo *Repository) GetAccountByID(id int) (models.Account, error) {
-	query := "SELECT * FROM Accounts WHERE account_id = ?"
-	row := repo.DB.QueryRow(query, id)
+const accountColumns = "account_id, public_id, document_number, document_type, holder_name, country, product_id, currency, tenant_id, status, credit_limit, version, created_at, updated_at"
 
+func scanAccount(row *sql.Row) (models.Account, error) {
 	var account models.Account
-	if err := row.Scan(&account.ID, &account.DocumentNumber); err != nil {
-		if err == sql.ErrNoRows {
-			return models.Account{}, err
-		}
+	var creditLimit sql.NullFloat64
+	err := row.Scan(
+		&account.ID,
+		&account.PublicID,
+		&account.DocumentNumber,
+		&account.DocumentType,
+		&account.HolderName,
+		&account.Country,
+		&account.ProductID,
+		&account.Currency,
+		&account.TenantID,
+		&account.Status,
+		&creditLimit,
+		&account.Version,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+	if err != nil {
 		return models.Account{}, err
 	}
-
+	if creditLimit.Valid {
+		account.CreditLimit = &creditLimit.Float64
+	}
 	return account, nil
 }
 
-func (repo *Repository) GetAccountByDocumentNumber(id string) (models.Account, error) {
-	query := "SELECT account_id, document_number FROM Accounts WHERE document_number = ?"
-	row := repo.DB.QueryRow(query, id)
-
+// GetAccountByID reads the account, from a replica when there is one close enough
+// behind the primary
+func (repo *Repository) GetAccountByID(id int64) (models.Account, error) {
+	query := "SELECT " + accountColumns + " FROM Accounts WHERE account_id = ?"
 	var account models.Account
-	if err := row.Scan(&account.ID, &account.DocumentNumber); err != nil {
-		if err == sql.ErrNoRows {
-			return models.Account{}, err
-		}
-		return models.Account{}, err
-	}
+	err := repo.read(func(q querier) (err error) {
+		account, err = scanAccount(repo.queryRow(q, query, id))
+		return err
+	})
+	return account, err
+}
 
-	return account, nil
+func (tx *txRepository) GetAccountByID(id int64) (models.Account, error) {
+	query := "SELECT " + accountColumns + " FROM Accounts WHERE account_id = ?"
+	return scanAccount(tx.queryRow(query, id))
+}
+
+// GetAccountByPublicID reads the account clients know by publicID, from a replica
+// like GetAccountByID
+func (repo *Repository) GetAccountByPublicID(publicID models.PublicID) (models.Account, error) {
+	query := "SELECT " + accountColumns + " FROM Accounts WHERE public_id = ?"
+	var account models.Account
+	err := repo.read(func(q querier) (err error) {
+		account, err = scanAccount(repo.queryRow(q, query, publicID))
+		return err
+	})
+	return account, err
 }
 
+func (repo *Repository) GetAccountByDocumentNumber(documentNumber string) (models.Account, error) {
+	query := "SELECT " + accountColumns + " FROM Accounts WHERE document_number = ?"
+	return scanAccount(repo.queryRow(repo.DB, query, documentNumber))
+}
 
-func (repo *Repository) CreateAccount(documentNumber string) (int64, error) {
-	query := "INSERT INTO Accounts (document_number) VALUES (?)"
-	row, err := repo.DB.Exec(query, documentNumber)
+func (repo *Repository) CreateAccount(account models.Account) (int64, error) {
+	query := "INSERT INTO Accounts (public_id, document_number, document_type, holder_name, country, product_id, currency, tenant_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	accountID, err := repo.insert(repo.DB, query, "account_id",
+		account.PublicID,
+		account.DocumentNumber,
+		account.DocumentType,
+		account.HolderName,
+		account.Country,
+		account.ProductID,
+		account.Currency,
+		account.TenantID,
+	)
 	if err != nil {
+		if isDuplicateEntry(err) {
+			// the unique index on document_number is what guarantees a single account per holder,
+			// so look up who won the race to give the caller something to work with
+			conflict := &ConflictError{Entity: "account", Key: "document number"}
+			if existing, lookupErr := repo.GetAccountByDocumentNumber(account.DocumentNumber); lookupErr == nil {
+				conflict.ExistingID = int64(existing.ID)
+				conflict.ExistingPublicID = existing.PublicID
+			}
+			return 0, conflict
+		}
 		return 0, err
 	}
-	return row.LastInsertId()
+	return accountID, nil
+}
+
+// UpdateAccount stores the settings of the account if it is still at the
+// version it was read at, and moves it to the next version. It returns
+// ErrVersionMismatch when someone else changed the account in the meantime.
+func (tx *txRepository) UpdateAccount(account models.Account) error {
+	query := "UPDATE Accounts SET holder_name = ?, status = ?, credit_limit = ?, version = version + 1 WHERE account_id = ? AND version = ?"
+	var creditLimit sql.NullFloat64
+	if account.CreditLimit != nil {
+		creditLimit = sql.NullFloat64{Float64: *account.CreditLimit, Valid: true}
+	}
+	result, err := tx.exec(query, account.HolderName, account.Status, creditLimit, account.ID, account.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update account %d: %w", account.ID, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return fmt.Errorf("%w: account %d is no longer at version %d", ErrVersionMismatch, account.ID, account.Version)
+	}
+	return nil
 }
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"pismo/models"
+)
+
+const allocationColumns = "allocation_id, account_id, debit_transaction_id, credit_transaction_id, amount, allocated_at"
+
+func (tx *txRepository) createDischargeAllocation(allocation models.DischargeAllocation) error {
+	query := "INSERT INTO DischargeAllocations (account_id, debit_transaction_id, credit_transaction_id, amount, allocated_at) VALUES (?, ?, ?, ?, ?)"
+	_, err := tx.exec(query, allocation.AccountID, allocation.DebitTransactionID, allocation.CreditTransactionID, allocation.Amount, allocation.AllocatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record allocation of transaction %d to transaction %d: %w", allocation.CreditTransactionID, allocation.DebitTransactionID, err)
+	}
+	return nil
+}
+
+// ListAccountAllocations returns every allocation recorded on the account, oldest first
+func (repo *Repository) ListAccountAllocations(accountID int64) ([]models.DischargeAllocation, error) {
+	rows, err := repo.query(repo.DB, "SELECT "+allocationColumns+" FROM DischargeAllocations WHERE account_id = ? ORDER BY allocation_id ASC", accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query allocations: %w", err)
+	}
+	return scanAllocations(rows)
+}
+
+// ListAccountAllocations is Repository.ListAccountAllocations within the unit of work
+func (tx *txRepository) ListAccountAllocations(accountID int64) ([]models.DischargeAllocation, error) {
+	rows, err := tx.query("SELECT "+allocationColumns+" FROM DischargeAllocations WHERE account_id = ? ORDER BY allocation_id ASC", accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query allocations: %w", err)
+	}
+	return scanAllocations(rows)
+}
+
+// ReplaceAccountAllocations swaps the allocation history of the account for
+// the given one, used when reconciliation rebuilds it
+func (tx *txRepository) ReplaceAccountAllocations(accountID int64, allocations []models.DischargeAllocation) error {
+	if _, err := tx.exec("DELETE FROM DischargeAllocations WHERE account_id = ?", accountID); err != nil {
+		return fmt.Errorf("failed to clear allocations of account %d: %w", accountID, err)
+	}
+	for _, allocation := range allocations {
+		if err := tx.createDischargeAllocation(allocation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTransactionBalancesAsOf returns every transaction of the account posted by
+// asOf with the balance it had at that time, undoing the allocations made since.
+// Archived transactions are read along with the rest.
+func (repo *Repository) GetTransactionBalancesAsOf(accountID int64, asOf time.Time) ([]models.TransactionBalance, error) {
+	balancesFrom := func(transactions, allocations string) string {
+		return `SELECT t.transaction_id, t.public_id, t.operation_type_id, t.event_date, t.amount,
+			t.amount + CASE WHEN t.operation_type_id < 4 THEN 1 ELSE -1 END * COALESCE((
+				SELECT SUM(a.amount) FROM ` + allocations + ` a
+				WHERE a.account_id = t.account_id AND (a.debit_transaction_id = t.transaction_id OR a.credit_transaction_id = t.transaction_id) AND a.allocated_at <= ?
+			), 0) AS balance, t.posting_date
+			FROM ` + transactions + ` t
+			WHERE t.account_id = ? AND t.posting_date <= ?`
+	}
+	query := `SELECT transaction_id, public_id, operation_type_id, event_date, amount, balance FROM (
+		` + balancesFrom("Transactions", "DischargeAllocations") + `
+		UNION ALL
+		` + balancesFrom("TransactionsArchive", "DischargeAllocationsArchive") + `
+		) balances ` + dischargeOrder
+
+	var balances []models.TransactionBalance
+	err := repo.read(func(q querier) error {
+		rows, err := repo.query(q, query, asOf, accountID, asOf, asOf, accountID, asOf)
+		if err != nil {
+			return fmt.Errorf("failed to query transactions: %w", err)
+		}
+		balances, err = scanTransactionBalances(rows)
+		return err
+	})
+	return balances, err
+}
+
+func scanTransactionBalances(rows *sql.Rows) ([]models.TransactionBalance, error) {
+	defer rows.Close()
+
+	balances := []models.TransactionBalance{}
+	for rows.Next() {
+		var balance models.TransactionBalance
+		if err := rows.Scan(&balance.TransactionID, &balance.PublicID, &balance.OperationTypeID, &balance.EventDate, &balance.Amount, &balance.Balance); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		balances = append(balances, balance)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %w", err)
+	}
+	return balances, nil
+}
+
+func scanAllocations(rows *sql.Rows) ([]models.DischargeAllocation, error) {
+	defer rows.Close()
+
+	var allocations []models.DischargeAllocation
+	for rows.Next() {
+		var allocation models.DischargeAllocation
+		err := rows.Scan(&allocation.ID, &allocation.AccountID, &allocation.DebitTransactionID, &allocation.CreditTransactionID, &allocation.Amount, &allocation.AllocatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		allocations = append(allocations, allocation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %w", err)
+	}
+	return allocations, nil
+}
@@ -0,0 +1,29 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+
+	"pismo/models"
+)
+
+func (repo *Repository) GetAPIKeyByID(keyID string) (models.APIKey, error) {
+	query := "SELECT key_id, client_id, key_hash, roles, tenant_id, created_at, revoked_at FROM ApiKeys WHERE key_id = ?"
+	row := repo.queryRow(repo.DB, query, keyID)
+
+	var key models.APIKey
+	var roles string
+	var revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.ClientID, &key.KeyHash, &roles, &key.TenantID, &key.CreatedAt, &revokedAt); err != nil {
+		return models.APIKey{}, err
+	}
+
+	// roles are stored comma separated, e.g. "admin,support"
+	if roles != "" {
+		key.Roles = strings.Split(roles, ",")
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
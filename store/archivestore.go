@@ -0,0 +1,154 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"pismo/ledger"
+	"pismo/models"
+)
+
+// ArchiveTransactions moves settled transactions of the account posted before
+// postedBefore to TransactionsArchive, along with the allocations between them,
+// and returns how many it moved, at most limit unless the first group it can move
+// is bigger, see archivable. The caller holds the account's AccountBalances row
+// lock, like for discharge.
+func (tx *txRepository) ArchiveTransactions(accountID int64, postedBefore time.Time, limit int) (int, error) {
+	if !tx.repo.dialect().archives() {
+		return 0, ErrArchiveUnsupported
+	}
+
+	query := "SELECT " + transactionColumns + " FROM Transactions WHERE account_id = ? AND balance = 0 AND posting_date < ? " + dischargeOrder + " FOR UPDATE"
+	rows, err := tx.query(query, accountID, postedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query transactions: %w", err)
+	}
+	candidates, err := scanTransactions(rows)
+	if err != nil || len(candidates) == 0 {
+		return 0, err
+	}
+	allocations, err := tx.ListAccountAllocations(accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	transactionIDs, allocationIDs := archivable(candidates, allocations, limit)
+	if len(transactionIDs) == 0 {
+		return 0, nil
+	}
+
+	if len(allocationIDs) > 0 {
+		in, args := inList(accountID, allocationIDs)
+		query := "INSERT INTO DischargeAllocationsArchive (" + allocationColumns + ") SELECT " + allocationColumns + " FROM DischargeAllocations WHERE account_id = ? AND allocation_id IN " + in
+		if _, err := tx.exec(query, args...); err != nil {
+			return 0, fmt.Errorf("failed to archive allocations of account %d: %w", accountID, err)
+		}
+		if _, err := tx.exec("DELETE FROM DischargeAllocations WHERE account_id = ? AND allocation_id IN "+in, args...); err != nil {
+			return 0, fmt.Errorf("failed to archive allocations of account %d: %w", accountID, err)
+		}
+	}
+
+	in, args := inList(accountID, transactionIDs)
+	query = "INSERT INTO TransactionsArchive (" + transactionColumns + ") SELECT " + transactionColumns + " FROM Transactions WHERE account_id = ? AND transaction_id IN " + in
+	if _, err := tx.exec(query, args...); err != nil {
+		return 0, fmt.Errorf("failed to archive transactions of account %d: %w", accountID, err)
+	}
+	if _, err := tx.exec("DELETE FROM Transactions WHERE account_id = ? AND transaction_id IN "+in, args...); err != nil {
+		return 0, fmt.Errorf("failed to archive transactions of account %d: %w", accountID, err)
+	}
+	return len(transactionIDs), nil
+}
+
+// archivable picks which of the candidates, the settled transactions of one
+// account in discharge order, can be archived, and the allocations that go with
+// them. A transaction whose allocations don't add up to its amount, like one
+// posted before allocations were recorded, stays. The rest go together with every
+// transaction they have an allocation with, or not at all, so what stays behind
+// still replays to the balances and allocations it has: the debits of a group
+// were paid off in full by the credits of the same group. Whole groups are taken,
+// oldest first, as long as they fit in limit, the first one whatever its size.
+func archivable(candidates []models.Transaction, allocations []models.DischargeAllocation, limit int) (transactionIDs, allocationIDs []int64) {
+	allocated := map[int64]int64{}
+	for _, allocation := range allocations {
+		allocated[allocation.DebitTransactionID] += ledger.ToCents(allocation.Amount)
+		allocated[allocation.CreditTransactionID] += ledger.ToCents(allocation.Amount)
+	}
+	in := map[int64]bool{}
+	for _, t := range candidates {
+		if amount := ledger.ToCents(t.Amount); allocated[t.ID] == amount || allocated[t.ID] == -amount {
+			in[t.ID] = true
+		}
+	}
+
+	// leave out what has an allocation with a transaction that stays, until
+	// nothing does
+	for changed := true; changed; {
+		changed = false
+		for _, allocation := range allocations {
+			if in[allocation.DebitTransactionID] != in[allocation.CreditTransactionID] {
+				delete(in, allocation.DebitTransactionID)
+				delete(in, allocation.CreditTransactionID)
+				changed = true
+			}
+		}
+	}
+
+	// the groups of transactions linked by allocations, by the transaction they
+	// lead up to
+	parent := map[int64]int64{}
+	group := func(id int64) int64 {
+		for parent[id] != 0 && parent[id] != id {
+			id = parent[id]
+		}
+		return id
+	}
+	for _, allocation := range allocations {
+		if in[allocation.DebitTransactionID] {
+			parent[group(allocation.DebitTransactionID)] = group(allocation.CreditTransactionID)
+		}
+	}
+	size := map[int64]int{}
+	for _, t := range candidates {
+		if in[t.ID] {
+			size[group(t.ID)]++
+		}
+	}
+
+	taken := map[int64]bool{}
+	count := 0
+	for _, t := range candidates {
+		g := group(t.ID)
+		if !in[t.ID] || taken[g] {
+			continue
+		}
+		if count > 0 && count+size[g] > limit {
+			break
+		}
+		taken[g] = true
+		count += size[g]
+	}
+
+	for _, t := range candidates {
+		if in[t.ID] && taken[group(t.ID)] {
+			transactionIDs = append(transactionIDs, t.ID)
+		}
+	}
+	for _, allocation := range allocations {
+		if in[allocation.DebitTransactionID] && taken[group(allocation.DebitTransactionID)] {
+			allocationIDs = append(allocationIDs, allocation.ID)
+		}
+	}
+	return transactionIDs, allocationIDs
+}
+
+// inList is the placeholders of an IN list of the ids, and the arguments of a
+// query filtering on the account first
+func inList(accountID int64, ids []int64) (string, []any) {
+	args := make([]any, 0, len(ids)+1)
+	args = append(args, accountID)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ")", args
+}
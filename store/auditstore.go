@@ -0,0 +1,211 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"pismo/audit"
+	"pismo/models"
+)
+
+const auditColumns = "audit_id, chain_id, occurred_at, kind, principal_id, request_id, method, route, account_id, transaction_id, payload_hash, outcome, changes, prev_hash, hash"
+
+// audit log reads are paged, never return more than this at once
+const maxAuditEntries = 1000
+
+func scanAuditEntry(row rowScanner) (models.AuditEntry, error) {
+	var entry models.AuditEntry
+	var accountID, transactionID sql.NullInt64
+	var changes sql.NullString
+	err := row.Scan(
+		&entry.ID,
+		&entry.ChainID,
+		&entry.OccurredAt,
+		&entry.Kind,
+		&entry.PrincipalID,
+		&entry.RequestID,
+		&entry.Method,
+		&entry.Route,
+		&accountID,
+		&transactionID,
+		&entry.PayloadHash,
+		&entry.Outcome,
+		&changes,
+		&entry.PrevHash,
+		&entry.Hash,
+	)
+	if err != nil {
+		return models.AuditEntry{}, err
+	}
+	entry.AccountID = accountID.Int64
+	entry.TransactionID = transactionID.Int64
+	if changes.Valid && changes.String != "" {
+		if err := json.Unmarshal([]byte(changes.String), &entry.Changes); err != nil {
+			return models.AuditEntry{}, fmt.Errorf("failed to decode changes of audit entry %d: %w", entry.ID, err)
+		}
+	}
+	return entry, nil
+}
+
+// AppendAuditEntry chains the entry onto the log in a unit of work of its own
+func (repo *Repository) AppendAuditEntry(entry models.AuditEntry) (models.AuditEntry, error) {
+	err := repo.WithinTx(context.Background(), func(tx TxRepo) error {
+		var err error
+		entry, err = tx.AppendAuditEntry(entry)
+		return err
+	})
+	if err != nil {
+		return models.AuditEntry{}, err
+	}
+	return entry, nil
+}
+
+// AppendAuditEntry chains the entry onto the log as part of the unit of work, so
+// it is only kept if the change it records is. Entries are chained per account,
+// the head of the account's chain stays locked until the unit of work completes,
+// which serializes appends to the account but leaves other accounts alone.
+func (tx *txRepository) AppendAuditEntry(entry models.AuditEntry) (models.AuditEntry, error) {
+	entry.ChainID = entry.AccountID
+	prevHash, err := tx.lockAuditChain(entry.ChainID)
+	if err != nil {
+		return models.AuditEntry{}, err
+	}
+	entry.PrevHash = prevHash
+
+	if entry.OccurredAt.IsZero() {
+		entry.OccurredAt = time.Now()
+	}
+	entry.OccurredAt = audit.Timestamp(entry.OccurredAt)
+	entry.Hash = audit.Hash(entry)
+
+	var changes any
+	if len(entry.Changes) > 0 {
+		encoded, err := json.Marshal(entry.Changes)
+		if err != nil {
+			return models.AuditEntry{}, err
+		}
+		changes = string(encoded)
+	}
+
+	query := "INSERT INTO AuditLog (chain_id, occurred_at, kind, principal_id, request_id, method, route, account_id, transaction_id, payload_hash, outcome, changes, prev_hash, hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	id, err := tx.insert(query, "audit_id",
+		entry.ChainID, entry.OccurredAt, entry.Kind, entry.PrincipalID, entry.RequestID, entry.Method, entry.Route,
+		nullableID(entry.AccountID), nullableID(entry.TransactionID),
+		entry.PayloadHash, entry.Outcome, changes, entry.PrevHash, entry.Hash)
+	if err != nil {
+		return models.AuditEntry{}, fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	entry.ID = id
+
+	if _, err := tx.exec("UPDATE AuditChainHead SET last_hash = ? WHERE chain_id = ?", entry.Hash, entry.ChainID); err != nil {
+		return models.AuditEntry{}, fmt.Errorf("failed to move audit chain head: %w", err)
+	}
+	return entry, nil
+}
+
+// lockAuditChain locks the head of the chain and returns its last hash, starting
+// the chain from the genesis hash the first time it's appended to
+func (tx *txRepository) lockAuditChain(chainID int64) (string, error) {
+	query := "SELECT last_hash FROM AuditChainHead WHERE chain_id = ? FOR UPDATE"
+
+	var lastHash string
+	err := tx.queryRow(query, chainID).Scan(&lastHash)
+	if err == nil {
+		return lastHash, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to lock audit chain %d: %w", chainID, err)
+	}
+
+	// a concurrent append may have just started it, then this is a no-op
+	insert := tx.repo.dialect().insertIgnore("INSERT INTO AuditChainHead (chain_id, last_hash) VALUES (?, ?)")
+	if _, err := tx.exec(insert, chainID, audit.GenesisHash); err != nil {
+		return "", fmt.Errorf("failed to start audit chain %d: %w", chainID, err)
+	}
+	if err := tx.queryRow(query, chainID).Scan(&lastHash); err != nil {
+		return "", fmt.Errorf("failed to lock audit chain %d: %w", chainID, err)
+	}
+	return lastHash, nil
+}
+
+// ListAuditChainHeads is the hash of the last entry appended to every chain
+func (repo *Repository) ListAuditChainHeads() (map[int64]string, error) {
+	rows, err := repo.query(repo.DB, "SELECT chain_id, last_hash FROM AuditChainHead")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit chain heads: %w", err)
+	}
+	defer rows.Close()
+
+	heads := map[int64]string{}
+	for rows.Next() {
+		var chainID int64
+		var lastHash string
+		if err := rows.Scan(&chainID, &lastHash); err != nil {
+			return nil, err
+		}
+		heads[chainID] = lastHash
+	}
+	return heads, rows.Err()
+}
+
+// ListAuditEntries returns entries matching the filter in chain order
+func (repo *Repository) ListAuditEntries(filter models.AuditFilter) ([]models.AuditEntry, error) {
+	var conditions []string
+	var args []any
+	if filter.AccountID != 0 {
+		conditions = append(conditions, "account_id = ?")
+		args = append(args, filter.AccountID)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "occurred_at >= ?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "occurred_at < ?")
+		args = append(args, filter.To)
+	}
+	if filter.AfterID != 0 {
+		conditions = append(conditions, "audit_id > ?")
+		args = append(args, filter.AfterID)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > maxAuditEntries {
+		limit = maxAuditEntries
+	}
+
+	query := "SELECT " + auditColumns + " FROM AuditLog"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY audit_id ASC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := repo.query(repo.DB, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func nullableID(id int64) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"pismo/models"
+)
+
+const authorizationColumns = "authorization_id, public_id, account_id, account_public_id, operation_type_id, amount, currency, original_amount, original_currency, fx_rate, status, transaction_id, transaction_public_id, expires_at, version, created_at, updated_at"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAuthorization(row rowScanner) (models.Authorization, error) {
+	var authorization models.Authorization
+	var transactionID sql.NullInt64
+	var transactionPublicID sql.NullString
+	err := row.Scan(
+		&authorization.ID,
+		&authorization.PublicID,
+		&authorization.AccountID,
+		&authorization.AccountPublicID,
+		&authorization.OperationTypeID,
+		&authorization.Amount,
+		&authorization.Currency,
+		&authorization.OriginalAmount,
+		&authorization.OriginalCurrency,
+		&authorization.FXRate,
+		&authorization.Status,
+		&transactionID,
+		&transactionPublicID,
+		&authorization.ExpiresAt,
+		&authorization.Version,
+		&authorization.CreatedAt,
+		&authorization.UpdatedAt,
+	)
+	if err != nil {
+		return models.Authorization{}, err
+	}
+	authorization.TransactionID = transactionID.Int64
+	authorization.TransactionPublicID = models.PublicID(transactionPublicID.String)
+	return authorization, nil
+}
+
+// GetAvailableCredit is the available limit of the account, its product credit
+// limit minus its open debt, less the pending holds that haven't expired at the given time
+func (tx *txRepository) GetAvailableCredit(accountID int64, at time.Time) (float64, error) {
+	query := `SELECT b.available_limit
+		+ COALESCE((SELECT SUM(h.amount) FROM Authorizations h WHERE h.account_id = b.account_id AND h.status = 'PENDING' AND h.expires_at > ?), 0)
+		FROM AccountBalances b WHERE b.account_id = ?`
+
+	var available float64
+	if err := tx.queryRow(query, at, accountID).Scan(&available); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
+
+func (tx *txRepository) CreateAuthorization(a models.Authorization) (int64, error) {
+	query := "INSERT INTO Authorizations (public_id, account_id, account_public_id, operation_type_id, amount, currency, original_amount, original_currency, fx_rate, status, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	return tx.insert(query, "authorization_id", a.PublicID, a.AccountID, a.AccountPublicID, a.OperationTypeID, a.Amount, a.Currency, a.OriginalAmount, a.OriginalCurrency, a.FXRate, a.Status, a.ExpiresAt)
+}
+
+func (repo *Repository) GetAuthorizationByID(id int64) (models.Authorization, error) {
+	query := "SELECT " + authorizationColumns + " FROM Authorizations WHERE authorization_id = ?"
+	var authorization models.Authorization
+	err := repo.read(func(q querier) (err error) {
+		authorization, err = scanAuthorization(repo.queryRow(q, query, id))
+		return err
+	})
+	return authorization, err
+}
+
+// GetAuthorizationByPublicID reads the hold clients know by publicID
+func (repo *Repository) GetAuthorizationByPublicID(publicID models.PublicID) (models.Authorization, error) {
+	query := "SELECT " + authorizationColumns + " FROM Authorizations WHERE public_id = ?"
+	var authorization models.Authorization
+	err := repo.read(func(q querier) (err error) {
+		authorization, err = scanAuthorization(repo.queryRow(q, query, publicID))
+		return err
+	})
+	return authorization, err
+}
+
+func (tx *txRepository) GetAuthorizationForUpdate(id int64) (models.Authorization, error) {
+	query := "SELECT " + authorizationColumns + " FROM Authorizations WHERE authorization_id = ? FOR UPDATE"
+	return scanAuthorization(tx.queryRow(query, id))
+}
+
+// UpdateAuthorizationStatus moves the hold to status, linking it to the
+// transaction a capture posted. A zero transaction clears the link.
+func (tx *txRepository) UpdateAuthorizationStatus(id int64, status string, transaction models.Transaction) error {
+	query := "UPDATE Authorizations SET status = ?, transaction_id = ?, transaction_public_id = ?, version = version + 1 WHERE authorization_id = ?"
+	var txnID sql.NullInt64
+	var txnPublicID sql.NullString
+	if transaction.ID != 0 {
+		txnID = sql.NullInt64{Int64: transaction.ID, Valid: true}
+		txnPublicID = sql.NullString{String: string(transaction.PublicID), Valid: true}
+	}
+	_, err := tx.exec(query, status, txnID, txnPublicID, id)
+	return err
+}
+
+// ExpireAuthorizations flips every pending hold past its expiry to EXPIRED and
+// returns how many were expired
+func (repo *Repository) ExpireAuthorizations(at time.Time) (int64, error) {
+	query := "UPDATE Authorizations SET status = 'EXPIRED', version = version + 1 WHERE status = 'PENDING' AND expires_at <= ?"
+	result, err := repo.exec(repo.DB, query, at)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
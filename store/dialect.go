@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect is what the repository needs to know about the database it runs on.
+// Queries are written once for MySQL, with ? placeholders and row locks, and the
+// dialect rewrites them into its own. Generated ids and inserts that skip
+// duplicates differ too.
+type Dialect interface {
+	// Name is the database/sql driver name of the dialect
+	Name() string
+	rewrite(query string) string
+	insertID(q querier, query, idColumn string, args ...any) (int64, error)
+	insertIgnore(query string) string
+	// replicationLag is how far behind its primary the replica db is
+	replicationLag(ctx context.Context, db *sql.DB) (time.Duration, error)
+	// archives reports whether transactions can be moved out to the archive tables
+	archives() bool
+}
+
+var (
+	MySQL    Dialect = mysqlDialect{}
+	Postgres Dialect = postgresDialect{}
+	SQLite   Dialect = sqliteDialect{}
+)
+
+// DialectFor finds the dialect of a database/sql driver name
+func DialectFor(driver string) (Dialect, error) {
+	for _, dialect := range []Dialect{MySQL, Postgres, SQLite} {
+		if dialect.Name() == driver {
+			return dialect, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported database driver: %s", driver)
+}
+
+// querier is what *sql.DB and *sql.Tx have in common, so a query can run on either
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return "mysql" }
+
+func (mysqlDialect) rewrite(query string) string { return query }
+
+func (mysqlDialect) insertID(q querier, query, idColumn string, args ...any) (int64, error) {
+	return lastInsertID(q, query, args...)
+}
+
+func (mysqlDialect) insertIgnore(query string) string {
+	return strings.Replace(query, "INSERT INTO", "INSERT IGNORE INTO", 1)
+}
+
+func (mysqlDialect) archives() bool { return true }
+
+// replicationLag reads Seconds_Behind_Source from the replica status, it is NULL
+// while replication is stopped. It is rounded down to whole seconds, a replica
+// reported N seconds behind may be up to N+1 behind, so it's taken to be that
+// far. Only a replica reported 0 seconds behind is taken to be up to date,
+// though it may be up to a second behind too.
+func (mysqlDialect) replicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errNotReplicating
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" {
+			continue
+		}
+		if !values[i].Valid {
+			return 0, errNotReplicating
+		}
+		seconds, err := strconv.ParseInt(values[i].String, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid Seconds_Behind_Source %q: %w", values[i].String, err)
+		}
+		if seconds > 0 {
+			seconds++
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, fmt.Errorf("replica status has no Seconds_Behind_Source")
+}
+
+// postgresDialect numbers its placeholders and has no LastInsertId, generated ids
+// come back through RETURNING instead
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+// rewrite numbers the ? placeholders $1, $2, ... leaving string literals alone
+func (postgresDialect) rewrite(query string) string {
+	var rebound strings.Builder
+	n := 0
+	inString := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			inString = !inString
+		case c == '?' && !inString:
+			n++
+			rebound.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		rebound.WriteRune(c)
+	}
+	return rebound.String()
+}
+
+func (d postgresDialect) insertID(q querier, query, idColumn string, args ...any) (int64, error) {
+	var id int64
+	err := q.QueryRow(d.rewrite(query)+" RETURNING "+idColumn, args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) insertIgnore(query string) string {
+	return query + " ON CONFLICT DO NOTHING"
+}
+
+func (postgresDialect) archives() bool { return true }
+
+// replicationLag is how long ago the last transaction the standby replayed was
+// committed on the primary, or none at all when it has replayed everything it
+// received. Outside recovery the database is a primary and has no lag to report.
+func (postgresDialect) replicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	query := `SELECT CASE
+		WHEN NOT pg_is_in_recovery() THEN NULL
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+		END`
+	var seconds sql.NullFloat64
+	if err := db.QueryRowContext(ctx, query).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	if !seconds.Valid {
+		return 0, errNotReplicating
+	}
+	return time.Duration(seconds.Float64 * float64(time.Second)), nil
+}
+
+// sqliteDialect takes ? placeholders as they are but has no row locks. Its
+// connections begin every transaction IMMEDIATE instead, which takes the one
+// write lock of the database up front, so whatever the locks were protecting is
+// serialized all the same.
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+// rewrite drops the FOR UPDATE or FOR SHARE the query ends with
+func (sqliteDialect) rewrite(query string) string {
+	for _, lock := range []string{" FOR UPDATE", " FOR SHARE"} {
+		query = strings.TrimSuffix(query, lock)
+	}
+	return query
+}
+
+func (sqliteDialect) insertID(q querier, query, idColumn string, args ...any) (int64, error) {
+	return lastInsertID(q, query, args...)
+}
+
+func (sqliteDialect) insertIgnore(query string) string {
+	return strings.Replace(query, "INSERT INTO", "INSERT OR IGNORE INTO", 1)
+}
+
+// archives is false, the foreign keys to Transactions are still there, see the
+// 0013 migration
+func (sqliteDialect) archives() bool { return false }
+
+// replicationLag is never known, SQLite has no replicas of its own
+func (sqliteDialect) replicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	return 0, errNotReplicating
+}
+
+func lastInsertID(q querier, query string, args ...any) (int64, error) {
+	result, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
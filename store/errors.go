@@ -0,0 +1,83 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+
+	"pismo/models"
+)
+
+const (
+	ErrCodeDuplicateEntry = 1062
+	ErrCodeDeadlock       = 1213
+
+	// Postgres reports errors by SQLSTATE
+	SQLStateUniqueViolation      = "23505"
+	SQLStateDeadlockDetected     = "40P01"
+	SQLStateSerializationFailure = "40001"
+
+	// SQLite extended result codes
+	SQLiteBusy                 = 5
+	SQLiteConstraintPrimaryKey = 1555
+	SQLiteConstraintUnique     = 2067
+)
+
+// ErrVersionMismatch is returned by updates that only apply on top of the version
+// the row was read at, when the row has moved on since
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// ErrArchiveUnsupported is returned by ArchiveTransactions on databases whose
+// schema still has foreign keys to Transactions
+var ErrArchiveUnsupported = errors.New("archiving transactions is not supported on this database")
+
+// ConflictError is returned when a write would violate a unique constraint.
+// ExistingID is the ID of the row that already holds the key, when it could be found,
+// and ExistingPublicID the id clients know it by, for rows that have one.
+type ConflictError struct {
+	Entity           string
+	Key              string
+	ExistingID       int64
+	ExistingPublicID models.PublicID
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("an %s with that %s already exists", e.Entity, e.Key)
+}
+
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == ErrCodeDuplicateEntry
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == SQLStateUniqueViolation
+	}
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code() == SQLiteConstraintUnique || sqliteErr.Code() == SQLiteConstraintPrimaryKey)
+}
+
+// IsRetryable reports whether the database rolled the transaction back because it
+// deadlocked or couldn't be serialized with a concurrent one, so running it again
+// may well succeed. SQLite never deadlocks, it gives up waiting for the write lock
+// with SQLITE_BUSY instead.
+func IsRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == ErrCodeDeadlock
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == SQLStateDeadlockDetected || pqErr.Code == SQLStateSerializationFailure
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// the extended codes of SQLITE_BUSY keep it in their low byte
+		return sqliteErr.Code()&0xff == SQLiteBusy
+	}
+	return false
+}
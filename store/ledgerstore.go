@@ -0,0 +1,122 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"pismo/ledger"
+	"pismo/models"
+)
+
+// CreateJournalEntry writes the entry and its postings, creating any ledger
+// account the postings go to that doesn't exist yet. Entries that break the
+// ledger invariants are refused before anything is written.
+func (tx *txRepository) CreateJournalEntry(entry models.JournalEntry) (int64, error) {
+	if err := ledger.Validate(entry); err != nil {
+		return 0, err
+	}
+
+	ledgerAccountIDs := make([]int64, len(entry.Postings))
+	for i, posting := range entry.Postings {
+		id, err := tx.ensureLedgerAccount(posting.LedgerAccount)
+		if err != nil {
+			return 0, err
+		}
+		ledgerAccountIDs[i] = id
+	}
+
+	postedAt := entry.PostedAt
+	if postedAt.IsZero() {
+		postedAt = time.Now()
+	}
+	entryID, err := tx.insert("INSERT INTO JournalEntries (transaction_id, description, currency, posted_at) VALUES (?, ?, ?, ?)", "journal_entry_id",
+		nullableID(entry.TransactionID), entry.Description, entry.Currency, postedAt.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert journal entry: %w", err)
+	}
+
+	for i, posting := range entry.Postings {
+		_, err := tx.exec("INSERT INTO Postings (journal_entry_id, ledger_account_id, amount, currency) VALUES (?, ?, ?, ?)",
+			entryID, ledgerAccountIDs[i], posting.Amount, posting.LedgerAccount.Currency)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert posting to %s: %w", posting.LedgerAccount.Code, err)
+		}
+	}
+	return entryID, nil
+}
+
+// ensureLedgerAccount finds the ledger account by its code, creating it the
+// first time it's posted to. System accounts are posted to by every transaction,
+// so they're only ever share locked to keep transactions from queueing on them.
+func (tx *txRepository) ensureLedgerAccount(account models.LedgerAccount) (int64, error) {
+	query := "SELECT ledger_account_id FROM LedgerAccounts WHERE code = ? FOR SHARE"
+
+	var id int64
+	err := tx.queryRow(query, account.Code).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to query ledger account %s: %w", account.Code, err)
+	}
+
+	// a concurrent transaction may have just created it, then this is a no-op
+	insert := tx.repo.dialect().insertIgnore("INSERT INTO LedgerAccounts (code, type, account_id, currency) VALUES (?, ?, ?, ?)")
+	_, err = tx.exec(insert,
+		account.Code, account.Type, nullableID(account.AccountID), account.Currency)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create ledger account %s: %w", account.Code, err)
+	}
+	if err := tx.queryRow(query, account.Code).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to query ledger account %s: %w", account.Code, err)
+	}
+	return id, nil
+}
+
+// GetTrialBalance totals the debits and credits posted to every ledger account,
+// one trial balance per currency with its lines ordered by code
+func (repo *Repository) GetTrialBalance() ([]models.TrialBalance, error) {
+	query := `SELECT la.currency, la.code, la.type, la.account_id,
+		SUM(CASE WHEN p.amount > 0 THEN p.amount ELSE 0 END),
+		SUM(CASE WHEN p.amount < 0 THEN -p.amount ELSE 0 END)
+		FROM LedgerAccounts la JOIN Postings p ON p.ledger_account_id = la.ledger_account_id
+		GROUP BY la.ledger_account_id, la.currency, la.code, la.type, la.account_id
+		ORDER BY la.currency ASC, la.code ASC`
+
+	var balances []models.TrialBalance
+	err := repo.read(func(q querier) error {
+		rows, err := repo.query(q, query)
+		if err != nil {
+			return fmt.Errorf("failed to query trial balance: %w", err)
+		}
+		balances, err = scanTrialBalances(rows)
+		return err
+	})
+	return balances, err
+}
+
+func scanTrialBalances(rows *sql.Rows) ([]models.TrialBalance, error) {
+	defer rows.Close()
+
+	balances := []models.TrialBalance{}
+	for rows.Next() {
+		var currency string
+		var accountID sql.NullInt64
+		var line models.TrialBalanceLine
+		if err := rows.Scan(&currency, &line.Code, &line.Type, &accountID, &line.Debits, &line.Credits); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		line.AccountID = accountID.Int64
+		if len(balances) == 0 || balances[len(balances)-1].Currency != currency {
+			balances = append(balances, models.TrialBalance{Currency: currency})
+		}
+		last := &balances[len(balances)-1]
+		last.Lines = append(last.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %w", err)
+	}
+	return balances, nil
+}
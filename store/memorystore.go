@@ -0,0 +1,901 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"pismo/audit"
+	"pismo/ledger"
+	"pismo/models"
+)
+
+// MemoryRepository implements Repositoryer, AuthorizationRepositoryer,
+// ReconciliationRepositoryer, ArchiveRepositoryer and AuditRepositoryer in
+// memory, for tests of the services that want a working store rather than mocks.
+// It keeps to what the database guarantees Repository: generated ids, the
+// defaults and foreign keys of the schema, the balance every account opens with,
+// version checks, amounts stored in cents, discharge and the audit chain.
+//
+// Units of work run one at a time, one holds the whole store until it commits or
+// rolls back, and rolling back undoes everything it wrote. Calls outside of a unit
+// of work wait for it, so they mustn't be made from inside one.
+type MemoryRepository struct {
+	mu sync.Mutex
+
+	products       map[int]models.Product
+	accounts       map[int64]models.Account
+	balances       map[int64]models.AccountTotals
+	transactions   []models.Transaction // by transaction id
+	allocations    []models.DischargeAllocation
+	archived       []models.Transaction
+	archivedAllocs []models.DischargeAllocation
+	authorizations map[int64]models.Authorization
+	ledgerAccounts map[string]models.LedgerAccount
+	journal        []models.JournalEntry
+	auditLog       []models.AuditEntry
+	auditHeads     map[int64]string // by chain id
+
+	// ids are never handed out twice, not even after a rollback
+	lastAccountID, lastTransactionID, lastAllocationID, lastAuthorizationID int64
+	lastLedgerAccountID, lastEntryID, lastPostingID, lastAuditID            int64
+}
+
+func NewMemoryRepository(products ...models.Product) *MemoryRepository {
+	repo := &MemoryRepository{
+		products:       map[int]models.Product{},
+		accounts:       map[int64]models.Account{},
+		balances:       map[int64]models.AccountTotals{},
+		authorizations: map[int64]models.Authorization{},
+		ledgerAccounts: map[string]models.LedgerAccount{},
+		auditHeads:     map[int64]string{0: audit.GenesisHash},
+	}
+	for _, product := range products {
+		repo.products[product.ID] = product
+	}
+	return repo
+}
+
+// WithinTx runs fn with the store to itself, see UnitOfWork. The options are
+// accepted and left at that, nothing else runs while fn does.
+func (repo *MemoryRepository) WithinTx(ctx context.Context, fn func(TxRepo) error, opts ...TxOption) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	repo.mu.Lock()
+	tx := &memoryTx{repo: repo}
+	return runUnitOfWork(tx, tx, fn)
+}
+
+// memoryTx is a unit of work of a MemoryRepository, undo reverts its writes in the
+// reverse order they were made
+type memoryTx struct {
+	repo *MemoryRepository
+	undo []func()
+	done bool
+}
+
+func (tx *memoryTx) Commit() error {
+	tx.done = true
+	tx.repo.mu.Unlock()
+	return nil
+}
+
+func (tx *memoryTx) Rollback() error {
+	tx.rollbackTo(0)
+	tx.done = true
+	tx.repo.mu.Unlock()
+	return nil
+}
+
+func (tx *memoryTx) rollbackTo(savepoint int) {
+	for i := len(tx.undo) - 1; i >= savepoint; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = tx.undo[:savepoint]
+}
+
+// onRollback registers how to undo a write made in the unit of work
+func (tx *memoryTx) onRollback(undo func()) {
+	tx.undo = append(tx.undo, undo)
+}
+
+// usable refuses the unit of work once it has been committed or rolled back, like
+// a finished *sql.Tx would
+func (tx *memoryTx) usable() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	return nil
+}
+
+func (tx *memoryTx) WithinTx(ctx context.Context, fn func(TxRepo) error, opts ...TxOption) error {
+	if len(opts) > 0 {
+		return ErrNestedTxOptions
+	}
+	if err := tx.usable(); err != nil {
+		return err
+	}
+	return runUnitOfWork(&memorySavepoint{tx: tx, mark: len(tx.undo)}, tx, fn)
+}
+
+// memorySavepoint is a nested unit of work, rolling it back only undoes the writes
+// made since mark
+type memorySavepoint struct {
+	tx   *memoryTx
+	mark int
+}
+
+func (s *memorySavepoint) Commit() error {
+	return nil
+}
+
+func (s *memorySavepoint) Rollback() error {
+	s.tx.rollbackTo(s.mark)
+	return nil
+}
+
+// ReadYourWrites is the store itself, it has no replicas to lag behind
+func (repo *MemoryRepository) ReadYourWrites() Repositoryer {
+	return repo
+}
+
+func (repo *MemoryRepository) GetProductByID(id int) (models.Product, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	product, ok := repo.products[id]
+	if !ok {
+		return models.Product{}, sql.ErrNoRows
+	}
+	return product, nil
+}
+
+func (repo *MemoryRepository) GetAccountByID(id int64) (models.Account, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.account(id)
+}
+
+func (tx *memoryTx) GetAccountByID(id int64) (models.Account, error) {
+	if err := tx.usable(); err != nil {
+		return models.Account{}, err
+	}
+	return tx.repo.account(id)
+}
+
+func (repo *MemoryRepository) GetAccountByPublicID(publicID models.PublicID) (models.Account, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, account := range repo.accounts {
+		if account.PublicID == publicID {
+			return copyAccount(account), nil
+		}
+	}
+	return models.Account{}, sql.ErrNoRows
+}
+
+func (repo *MemoryRepository) GetAccountByDocumentNumber(documentNumber string) (models.Account, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, account := range repo.accounts {
+		if account.DocumentNumber == documentNumber {
+			return copyAccount(account), nil
+		}
+	}
+	return models.Account{}, sql.ErrNoRows
+}
+
+func (repo *MemoryRepository) account(id int64) (models.Account, error) {
+	account, ok := repo.accounts[id]
+	if !ok {
+		return models.Account{}, sql.ErrNoRows
+	}
+	return copyAccount(account), nil
+}
+
+// CreateAccount stores the account active at its first version, and opens its
+// balance with the full credit limit of its product
+func (repo *MemoryRepository) CreateAccount(account models.Account) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	product, ok := repo.products[account.ProductID]
+	if !ok {
+		return 0, fmt.Errorf("failed to create account: product %d does not exist", account.ProductID)
+	}
+	for _, existing := range repo.accounts {
+		if existing.DocumentNumber == account.DocumentNumber {
+			return 0, &ConflictError{Entity: "account", Key: "document number", ExistingID: int64(existing.ID), ExistingPublicID: existing.PublicID}
+		}
+	}
+
+	repo.lastAccountID++
+	now := time.Now().UTC()
+	account.ID = repo.lastAccountID
+	account.Status = models.AccountActive
+	account.CreditLimit = nil
+	account.Version = 1
+	account.CreatedAt, account.UpdatedAt = now, now
+	repo.accounts[account.ID] = account
+	repo.balances[account.ID] = models.AccountTotals{AccountID: account.ID, AvailableLimit: product.CreditLimit, UpdatedAt: now}
+	return account.ID, nil
+}
+
+// UpdateAccount stores the settings of the account if it is still at the version
+// it was read at, see Repository
+func (tx *memoryTx) UpdateAccount(account models.Account) error {
+	if err := tx.usable(); err != nil {
+		return err
+	}
+	repo := tx.repo
+	stored, ok := repo.accounts[account.ID]
+	if !ok || stored.Version != account.Version {
+		return fmt.Errorf("%w: account %d is no longer at version %d", ErrVersionMismatch, account.ID, account.Version)
+	}
+
+	updated := stored
+	updated.HolderName = account.HolderName
+	updated.Status = account.Status
+	updated.CreditLimit = nil
+	if account.CreditLimit != nil {
+		creditLimit := toDecimal(*account.CreditLimit)
+		updated.CreditLimit = &creditLimit
+	}
+	updated.Version++
+	updated.UpdatedAt = time.Now().UTC()
+	repo.accounts[account.ID] = updated
+	tx.onRollback(func() { repo.accounts[account.ID] = stored })
+	return nil
+}
+
+func (repo *MemoryRepository) GetAccountTotals(accountID int64) (models.AccountTotals, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	totals, ok := repo.balances[accountID]
+	if !ok {
+		return models.AccountTotals{}, sql.ErrNoRows
+	}
+	return totals, nil
+}
+
+// GetAccountTotals is LockAccountBalance, nothing is locked in the memory store
+func (tx *memoryTx) GetAccountTotals(accountID int64) (models.AccountTotals, error) {
+	return tx.LockAccountBalance(accountID)
+}
+
+// LockAccountBalance returns the running totals of the account, the unit of work
+// already holds the store so there is nothing left to lock
+func (tx *memoryTx) LockAccountBalance(accountID int64) (models.AccountTotals, error) {
+	if err := tx.usable(); err != nil {
+		return models.AccountTotals{}, err
+	}
+	totals, ok := tx.repo.balances[accountID]
+	if !ok {
+		return models.AccountTotals{}, sql.ErrNoRows
+	}
+	return totals, nil
+}
+
+// UpdateAccountBalance stores new totals for the account on top of the version
+// they were read at, see Repository
+func (tx *memoryTx) UpdateAccountBalance(totals models.AccountTotals) error {
+	if err := tx.usable(); err != nil {
+		return err
+	}
+	repo := tx.repo
+	stored, ok := repo.balances[totals.AccountID]
+	if !ok || stored.Version != totals.Version {
+		return fmt.Errorf("%w: balance of account %d is no longer at version %d", ErrVersionMismatch, totals.AccountID, totals.Version)
+	}
+
+	account := repo.accounts[totals.AccountID]
+	creditLimit := repo.products[account.ProductID].CreditLimit
+	if account.CreditLimit != nil {
+		creditLimit = *account.CreditLimit
+	}
+
+	updated := stored
+	updated.TotalDebt = toDecimal(totals.TotalDebt)
+	updated.TotalCredit = toDecimal(totals.TotalCredit)
+	updated.AvailableLimit = toDecimal(creditLimit - totals.TotalDebt)
+	updated.Version++
+	updated.UpdatedAt = time.Now().UTC()
+	repo.balances[totals.AccountID] = updated
+	tx.onRollback(func() { repo.balances[totals.AccountID] = stored })
+	return nil
+}
+
+func (tx *memoryTx) CreateTransaction(t models.Transaction) (int64, error) {
+	if err := tx.usable(); err != nil {
+		return 0, err
+	}
+	repo := tx.repo
+	if _, ok := repo.accounts[t.AccountID]; !ok {
+		return 0, fmt.Errorf("failed to create transaction: account %d does not exist", t.AccountID)
+	}
+	// OperationTypes holds the four operation types, 1 to 3 are debits and 4 is a credit
+	if t.OperationTypeID < 1 || t.OperationTypeID > 4 {
+		return 0, fmt.Errorf("failed to create transaction: operation type %d does not exist", t.OperationTypeID)
+	}
+
+	now := time.Now().UTC()
+	if t.EventDate.IsZero() {
+		t.EventDate = now
+	}
+	if t.PostingDate.IsZero() {
+		t.PostingDate = now
+	}
+	repo.lastTransactionID++
+	t.ID = repo.lastTransactionID
+	t.Amount = toDecimal(t.Amount)
+	t.Balance = toDecimal(t.Balance)
+	t.OriginalAmount = toDecimal(t.OriginalAmount)
+
+	n := len(repo.transactions)
+	repo.transactions = append(repo.transactions, t)
+	tx.onRollback(func() { repo.transactions = repo.transactions[:n] })
+	return t.ID, nil
+}
+
+// ProcessDischargeTransaction pays off the open debts of the account with the
+// credit and returns how much of it was used, see Repository
+func (tx *memoryTx) ProcessDischargeTransaction(depositTransaction models.Transaction) (float64, error) {
+	if err := tx.usable(); err != nil {
+		return 0, err
+	}
+
+	var debts []models.Transaction
+	for _, t := range tx.repo.transactions {
+		if t.AccountID == depositTransaction.AccountID && t.OperationTypeID < 4 && t.Balance < 0 {
+			debts = append(debts, t)
+		}
+	}
+	sortInDischargeOrder(debts)
+
+	d := newDischarge(depositTransaction)
+	for _, debt := range debts {
+		if !d.pay(debt) {
+			break
+		}
+	}
+
+	for _, update := range d.updated {
+		tx.setBalance(update.ID, update.Balance)
+	}
+	tx.setBalance(depositTransaction.ID, d.remaining)
+	d.changes = append(d.changes, models.BalanceChange{TransactionID: depositTransaction.ID, Before: depositTransaction.Amount, After: d.remaining})
+
+	for _, allocation := range d.allocations {
+		tx.createDischargeAllocation(allocation)
+	}
+
+	_, err := tx.AppendAuditEntry(models.AuditEntry{
+		Kind:          models.AuditKindBalanceChange,
+		Route:         "discharge",
+		AccountID:     depositTransaction.AccountID,
+		TransactionID: depositTransaction.ID,
+		Changes:       d.changes,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return d.used(), nil
+}
+
+// setBalance overwrites the balance of the transaction, there being no such
+// transaction changes nothing like an UPDATE matching no rows
+func (tx *memoryTx) setBalance(transactionID int64, balance float64) {
+	t := tx.repo.transaction(transactionID)
+	if t == nil {
+		return
+	}
+	before := t.Balance
+	t.Balance = toDecimal(balance)
+	// the row is looked up again on the way back, appends in between may have
+	// moved the transactions somewhere else
+	tx.onRollback(func() {
+		if t := tx.repo.transaction(transactionID); t != nil {
+			t.Balance = before
+		}
+	})
+}
+
+// transaction is the stored transaction with the id, or nil when there is none
+func (repo *MemoryRepository) transaction(id int64) *models.Transaction {
+	transactions := repo.transactions
+	i := sort.Search(len(transactions), func(i int) bool { return transactions[i].ID >= id })
+	if i == len(transactions) || transactions[i].ID != id {
+		return nil
+	}
+	return &transactions[i]
+}
+
+// UpdateTransactionBalance overwrites the balance of the transaction, as long as
+// it is one of the account's
+func (tx *memoryTx) UpdateTransactionBalance(accountID int64, transactionID int64, balance float64) error {
+	if err := tx.usable(); err != nil {
+		return err
+	}
+	for _, t := range tx.repo.transactions {
+		if t.ID == transactionID && t.AccountID == accountID {
+			tx.setBalance(transactionID, balance)
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) ListTransactionAccountIDs() ([]int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	seen := map[int64]bool{}
+	var accountIDs []int64
+	for _, t := range repo.transactions {
+		if !seen[t.AccountID] {
+			seen[t.AccountID] = true
+			accountIDs = append(accountIDs, t.AccountID)
+		}
+	}
+	sort.Slice(accountIDs, func(i, j int) bool { return accountIDs[i] < accountIDs[j] })
+	return accountIDs, nil
+}
+
+func (repo *MemoryRepository) ListAccountTransactions(accountID int64) ([]models.Transaction, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.accountTransactions(accountID), nil
+}
+
+// ListAccountTransactions is MemoryRepository.ListAccountTransactions within the unit of work
+func (tx *memoryTx) ListAccountTransactions(accountID int64) ([]models.Transaction, error) {
+	if err := tx.usable(); err != nil {
+		return nil, err
+	}
+	return tx.repo.accountTransactions(accountID), nil
+}
+
+// ListAccountTransactionsForUpdate is ListAccountTransactions, the unit of work
+// already holds the store
+func (tx *memoryTx) ListAccountTransactionsForUpdate(accountID int64) ([]models.Transaction, error) {
+	return tx.ListAccountTransactions(accountID)
+}
+
+// accountTransactions returns every transaction of the account in the order
+// discharge processes them
+func (repo *MemoryRepository) accountTransactions(accountID int64) []models.Transaction {
+	var transactions []models.Transaction
+	for _, t := range repo.transactions {
+		if t.AccountID == accountID {
+			transactions = append(transactions, t)
+		}
+	}
+	sortInDischargeOrder(transactions)
+	return transactions
+}
+
+func (tx *memoryTx) createDischargeAllocation(allocation models.DischargeAllocation) {
+	repo := tx.repo
+	repo.lastAllocationID++
+	allocation.ID = repo.lastAllocationID
+	allocation.Amount = toDecimal(allocation.Amount)
+
+	n := len(repo.allocations)
+	repo.allocations = append(repo.allocations, allocation)
+	tx.onRollback(func() { repo.allocations = repo.allocations[:n] })
+}
+
+func (repo *MemoryRepository) ListAccountAllocations(accountID int64) ([]models.DischargeAllocation, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.accountAllocations(accountID), nil
+}
+
+// ListAccountAllocations is MemoryRepository.ListAccountAllocations within the unit of work
+func (tx *memoryTx) ListAccountAllocations(accountID int64) ([]models.DischargeAllocation, error) {
+	if err := tx.usable(); err != nil {
+		return nil, err
+	}
+	return tx.repo.accountAllocations(accountID), nil
+}
+
+func (repo *MemoryRepository) accountAllocations(accountID int64) []models.DischargeAllocation {
+	var allocations []models.DischargeAllocation
+	for _, allocation := range repo.allocations {
+		if allocation.AccountID == accountID {
+			allocations = append(allocations, allocation)
+		}
+	}
+	return allocations
+}
+
+// ReplaceAccountAllocations swaps the allocation history of the account for the
+// given one
+func (tx *memoryTx) ReplaceAccountAllocations(accountID int64, allocations []models.DischargeAllocation) error {
+	if err := tx.usable(); err != nil {
+		return err
+	}
+	repo := tx.repo
+	before := repo.allocations
+	kept := []models.DischargeAllocation{}
+	for _, allocation := range before {
+		if allocation.AccountID != accountID {
+			kept = append(kept, allocation)
+		}
+	}
+	repo.allocations = kept
+	tx.onRollback(func() { repo.allocations = before })
+
+	for _, allocation := range allocations {
+		tx.createDischargeAllocation(allocation)
+	}
+	return nil
+}
+
+// ArchiveTransactions moves settled transactions of the account posted before
+// postedBefore out of the hot transactions, see Repository
+func (tx *memoryTx) ArchiveTransactions(accountID int64, postedBefore time.Time, limit int) (int, error) {
+	if err := tx.usable(); err != nil {
+		return 0, err
+	}
+
+	repo := tx.repo
+	var candidates []models.Transaction
+	for _, t := range repo.accountTransactions(accountID) {
+		if t.Balance == 0 && t.PostingDate.Before(postedBefore) {
+			candidates = append(candidates, t)
+		}
+	}
+	transactionIDs, allocationIDs := archivable(candidates, repo.accountAllocations(accountID), limit)
+	if len(transactionIDs) == 0 {
+		return 0, nil
+	}
+
+	moving := map[int64]bool{}
+	for _, id := range transactionIDs {
+		moving[id] = true
+	}
+	transactions, archived := repo.transactions, repo.archived
+	kept := []models.Transaction{}
+	for _, t := range transactions {
+		if moving[t.ID] {
+			repo.archived = append(repo.archived, t)
+		} else {
+			kept = append(kept, t)
+		}
+	}
+	repo.transactions = kept
+
+	movingAllocs := map[int64]bool{}
+	for _, id := range allocationIDs {
+		movingAllocs[id] = true
+	}
+	allocations, archivedAllocs := repo.allocations, repo.archivedAllocs
+	keptAllocs := []models.DischargeAllocation{}
+	for _, allocation := range allocations {
+		if movingAllocs[allocation.ID] {
+			repo.archivedAllocs = append(repo.archivedAllocs, allocation)
+		} else {
+			keptAllocs = append(keptAllocs, allocation)
+		}
+	}
+	repo.allocations = keptAllocs
+
+	tx.onRollback(func() {
+		repo.transactions, repo.archived = transactions, archived
+		repo.allocations, repo.archivedAllocs = allocations, archivedAllocs
+	})
+	return len(transactionIDs), nil
+}
+
+// GetTransactionBalancesAsOf returns every transaction of the account posted by
+// asOf with the balance it had at that time, undoing the allocations made since
+func (repo *MemoryRepository) GetTransactionBalancesAsOf(accountID int64, asOf time.Time) ([]models.TransactionBalance, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	allocated := map[int64]float64{}
+	for _, allocation := range append(repo.accountAllocations(accountID), repo.archivedAllocs...) {
+		if allocation.AccountID == accountID && !allocation.AllocatedAt.After(asOf) {
+			allocated[allocation.DebitTransactionID] += allocation.Amount
+			allocated[allocation.CreditTransactionID] += allocation.Amount
+		}
+	}
+
+	transactions := repo.accountTransactions(accountID)
+	for _, t := range repo.archived {
+		if t.AccountID == accountID {
+			transactions = append(transactions, t)
+		}
+	}
+	sortInDischargeOrder(transactions)
+
+	balances := []models.TransactionBalance{}
+	for _, t := range transactions {
+		if t.PostingDate.After(asOf) {
+			continue
+		}
+		balance := t.Amount - allocated[t.ID]
+		if t.OperationTypeID < 4 {
+			balance = t.Amount + allocated[t.ID]
+		}
+		balances = append(balances, models.TransactionBalance{
+			TransactionID:   t.ID,
+			PublicID:        t.PublicID,
+			OperationTypeID: t.OperationTypeID,
+			EventDate:       t.EventDate,
+			Amount:          t.Amount,
+			Balance:         toDecimal(balance),
+		})
+	}
+	return balances, nil
+}
+
+// CreateJournalEntry writes the entry and its postings, creating the ledger
+// accounts they go to, see Repository
+func (tx *memoryTx) CreateJournalEntry(entry models.JournalEntry) (int64, error) {
+	if err := tx.usable(); err != nil {
+		return 0, err
+	}
+	if err := ledger.Validate(entry); err != nil {
+		return 0, err
+	}
+
+	repo := tx.repo
+	postings := make([]models.Posting, len(entry.Postings))
+	for i, posting := range entry.Postings {
+		account, ok := repo.ledgerAccounts[posting.LedgerAccount.Code]
+		if !ok {
+			repo.lastLedgerAccountID++
+			account = posting.LedgerAccount
+			account.ID = repo.lastLedgerAccountID
+			repo.ledgerAccounts[account.Code] = account
+			tx.onRollback(func() { delete(repo.ledgerAccounts, account.Code) })
+		}
+		repo.lastPostingID++
+		postings[i] = models.Posting{ID: repo.lastPostingID, LedgerAccount: account, Amount: toDecimal(posting.Amount)}
+	}
+
+	if entry.PostedAt.IsZero() {
+		entry.PostedAt = time.Now()
+	}
+	repo.lastEntryID++
+	entry.ID = repo.lastEntryID
+	entry.PostedAt = entry.PostedAt.UTC()
+	entry.Postings = postings
+
+	n := len(repo.journal)
+	repo.journal = append(repo.journal, entry)
+	tx.onRollback(func() { repo.journal = repo.journal[:n] })
+	return entry.ID, nil
+}
+
+// GetAvailableCredit is the available limit of the account less its pending holds
+// that haven't expired at the given time
+func (tx *memoryTx) GetAvailableCredit(accountID int64, at time.Time) (float64, error) {
+	if err := tx.usable(); err != nil {
+		return 0, err
+	}
+	totals, ok := tx.repo.balances[accountID]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	available := totals.AvailableLimit
+	for _, authorization := range tx.repo.authorizations {
+		if authorization.AccountID == accountID && authorization.Status == models.AuthorizationPending && authorization.ExpiresAt.After(at) {
+			available += authorization.Amount
+		}
+	}
+	return toDecimal(available), nil
+}
+
+func (tx *memoryTx) CreateAuthorization(a models.Authorization) (int64, error) {
+	if err := tx.usable(); err != nil {
+		return 0, err
+	}
+	repo := tx.repo
+	if _, ok := repo.accounts[a.AccountID]; !ok {
+		return 0, fmt.Errorf("failed to create authorization: account %d does not exist", a.AccountID)
+	}
+
+	repo.lastAuthorizationID++
+	now := time.Now().UTC()
+	a.ID = repo.lastAuthorizationID
+	a.Amount = toDecimal(a.Amount)
+	a.OriginalAmount = toDecimal(a.OriginalAmount)
+	a.TransactionID = 0
+	a.TransactionPublicID = ""
+	a.Version = 1
+	a.CreatedAt, a.UpdatedAt = now, now
+	repo.authorizations[a.ID] = a
+	tx.onRollback(func() { delete(repo.authorizations, a.ID) })
+	return a.ID, nil
+}
+
+func (repo *MemoryRepository) GetAuthorizationByID(id int64) (models.Authorization, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	authorization, ok := repo.authorizations[id]
+	if !ok {
+		return models.Authorization{}, sql.ErrNoRows
+	}
+	return authorization, nil
+}
+
+func (repo *MemoryRepository) GetAuthorizationByPublicID(publicID models.PublicID) (models.Authorization, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, authorization := range repo.authorizations {
+		if authorization.PublicID == publicID {
+			return authorization, nil
+		}
+	}
+	return models.Authorization{}, sql.ErrNoRows
+}
+
+func (tx *memoryTx) GetAuthorizationForUpdate(id int64) (models.Authorization, error) {
+	if err := tx.usable(); err != nil {
+		return models.Authorization{}, err
+	}
+	authorization, ok := tx.repo.authorizations[id]
+	if !ok {
+		return models.Authorization{}, sql.ErrNoRows
+	}
+	return authorization, nil
+}
+
+func (tx *memoryTx) UpdateAuthorizationStatus(id int64, status string, transaction models.Transaction) error {
+	if err := tx.usable(); err != nil {
+		return err
+	}
+	repo := tx.repo
+	stored, ok := repo.authorizations[id]
+	if !ok {
+		return nil
+	}
+	updated := stored
+	updated.Status = status
+	updated.TransactionID = transaction.ID
+	updated.TransactionPublicID = transaction.PublicID
+	updated.Version++
+	updated.UpdatedAt = time.Now().UTC()
+	repo.authorizations[id] = updated
+	tx.onRollback(func() { repo.authorizations[id] = stored })
+	return nil
+}
+
+// ExpireAuthorizations flips every pending hold past its expiry to EXPIRED and
+// returns how many were expired
+func (repo *MemoryRepository) ExpireAuthorizations(at time.Time) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var expired int64
+	for id, authorization := range repo.authorizations {
+		if authorization.Status == models.AuthorizationPending && !authorization.ExpiresAt.After(at) {
+			authorization.Status = models.AuthorizationExpired
+			authorization.Version++
+			authorization.UpdatedAt = time.Now().UTC()
+			repo.authorizations[id] = authorization
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// AppendAuditEntry chains the entry onto the log in a unit of work of its own
+func (repo *MemoryRepository) AppendAuditEntry(entry models.AuditEntry) (models.AuditEntry, error) {
+	err := repo.WithinTx(context.Background(), func(tx TxRepo) error {
+		var err error
+		entry, err = tx.AppendAuditEntry(entry)
+		return err
+	})
+	if err != nil {
+		return models.AuditEntry{}, err
+	}
+	return entry, nil
+}
+
+// AppendAuditEntry chains the entry onto its account's chain, it is only kept if
+// the unit of work is
+func (tx *memoryTx) AppendAuditEntry(entry models.AuditEntry) (models.AuditEntry, error) {
+	if err := tx.usable(); err != nil {
+		return models.AuditEntry{}, err
+	}
+	repo := tx.repo
+	if entry.OccurredAt.IsZero() {
+		entry.OccurredAt = time.Now()
+	}
+	entry.OccurredAt = audit.Timestamp(entry.OccurredAt)
+	entry.ChainID = entry.AccountID
+	head, started := repo.auditHeads[entry.ChainID]
+	if !started {
+		head = audit.GenesisHash
+	}
+	entry.PrevHash = head
+	entry.Hash = audit.Hash(entry)
+	repo.lastAuditID++
+	entry.ID = repo.lastAuditID
+
+	n := len(repo.auditLog)
+	repo.auditLog = append(repo.auditLog, entry)
+	repo.auditHeads[entry.ChainID] = entry.Hash
+	tx.onRollback(func() {
+		repo.auditLog = repo.auditLog[:n]
+		if started {
+			repo.auditHeads[entry.ChainID] = head
+		} else {
+			delete(repo.auditHeads, entry.ChainID)
+		}
+	})
+	return entry, nil
+}
+
+// ListAuditChainHeads is the hash of the last entry appended to every chain
+func (repo *MemoryRepository) ListAuditChainHeads() (map[int64]string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	heads := make(map[int64]string, len(repo.auditHeads))
+	for chainID, head := range repo.auditHeads {
+		heads[chainID] = head
+	}
+	return heads, nil
+}
+
+// ListAuditEntries returns entries matching the filter in chain order
+func (repo *MemoryRepository) ListAuditEntries(filter models.AuditFilter) ([]models.AuditEntry, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	limit := filter.Limit
+	if limit <= 0 || limit > maxAuditEntries {
+		limit = maxAuditEntries
+	}
+
+	entries := []models.AuditEntry{}
+	for _, entry := range repo.auditLog {
+		if len(entries) == limit {
+			break
+		}
+		if filter.AccountID != 0 && entry.AccountID != filter.AccountID ||
+			!filter.From.IsZero() && entry.OccurredAt.Before(filter.From) ||
+			!filter.To.IsZero() && !entry.OccurredAt.Before(filter.To) ||
+			entry.ID <= filter.AfterID {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// sortInDischargeOrder sorts the transactions the way dischargeOrder does
+func sortInDischargeOrder(transactions []models.Transaction) {
+	sort.Slice(transactions, func(i, j int) bool {
+		if !transactions[i].PostingDate.Equal(transactions[j].PostingDate) {
+			return transactions[i].PostingDate.Before(transactions[j].PostingDate)
+		}
+		return transactions[i].ID < transactions[j].ID
+	})
+}
+
+// toDecimal rounds the amount to the cents the schema's DECIMAL columns keep
+func toDecimal(amount float64) float64 {
+	return float64(ledger.ToCents(amount)) / 100
+}
+
+// copyAccount keeps the caller from changing the stored credit limit through its pointer
+func copyAccount(account models.Account) models.Account {
+	if account.CreditLimit != nil {
+		creditLimit := *account.CreditLimit
+		account.CreditLimit = &creditLimit
+	}
+	return account
+}
@@ -0,0 +1,18 @@
+package store
+
+import (
+	"pismo/models"
+)
+
+func (repo *Repository) GetProductByID(id int) (models.Product, error) {
+	query := "SELECT product_id, name, credit_limit FROM Products WHERE product_id = ?"
+
+	var product models.Product
+	err := repo.read(func(q querier) error {
+		return repo.queryRow(q, query, id).Scan(&product.ID, &product.Name, &product.CreditLimit)
+	})
+	if err != nil {
+		return models.Product{}, err
+	}
+	return product, nil
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"pismo/models"
+)
+
+const transactionColumns = "transaction_id, public_id, account_id, operation_type_id, amount, balance, event_date, posting_date, currency, original_amount, original_currency, fx_rate"
+
+func scanTransaction(row rowScanner) (models.Transaction, error) {
+	var transaction models.Transaction
+	var originalAmount sql.NullFloat64
+	var originalCurrency sql.NullString
+	err := row.Scan(
+		&transaction.ID,
+		&transaction.PublicID,
+		&transaction.AccountID,
+		&transaction.OperationTypeID,
+		&transaction.Amount,
+		&transaction.Balance,
+		&transaction.EventDate,
+		&transaction.PostingDate,
+		&transaction.Currency,
+		&originalAmount,
+		&originalCurrency,
+		&transaction.FXRate,
+	)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	transaction.OriginalAmount = originalAmount.Float64
+	transaction.OriginalCurrency = originalCurrency.String
+	return transaction, nil
+}
+
+// ListTransactionAccountIDs returns every account that has at least one transaction
+func (repo *Repository) ListTransactionAccountIDs() ([]int64, error) {
+	rows, err := repo.query(repo.DB, "SELECT DISTINCT account_id FROM Transactions ORDER BY account_id ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accountIDs []int64
+	for rows.Next() {
+		var accountID int64
+		if err := rows.Scan(&accountID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		accountIDs = append(accountIDs, accountID)
+	}
+	return accountIDs, rows.Err()
+}
+
+// ListAccountTransactions returns every transaction of the account in the order
+// discharge processes them
+func (repo *Repository) ListAccountTransactions(accountID int64) ([]models.Transaction, error) {
+	query := "SELECT " + transactionColumns + " FROM Transactions WHERE account_id = ? " + dischargeOrder
+	rows, err := repo.query(repo.DB, query, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", err)
+	}
+	return scanTransactions(rows)
+}
+
+// ListAccountTransactions is Repository.ListAccountTransactions within the unit of work
+func (tx *txRepository) ListAccountTransactions(accountID int64) ([]models.Transaction, error) {
+	query := "SELECT " + transactionColumns + " FROM Transactions WHERE account_id = ? " + dischargeOrder
+	rows, err := tx.query(query, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", err)
+	}
+	return scanTransactions(rows)
+}
+
+// ListAccountTransactionsForUpdate is ListAccountTransactions, locking the
+// rows so no discharge can run on the account until the transaction completes
+func (tx *txRepository) ListAccountTransactionsForUpdate(accountID int64) ([]models.Transaction, error) {
+	query := "SELECT " + transactionColumns + " FROM Transactions WHERE account_id = ? " + dischargeOrder + " FOR UPDATE"
+	rows, err := tx.query(query, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", err)
+	}
+	return scanTransactions(rows)
+}
+
+func (tx *txRepository) UpdateTransactionBalance(accountID int64, transactionID int64, balance float64) error {
+	if _, err := tx.exec("UPDATE Transactions SET balance = ? WHERE account_id = ? AND transaction_id = ?", balance, accountID, transactionID); err != nil {
+		return fmt.Errorf("failed to update balance for transaction %d: %w", transactionID, err)
+	}
+	return nil
+}
+
+func scanTransactions(rows *sql.Rows) ([]models.Transaction, error) {
+	defer rows.Close()
+
+	var transactions []models.Transaction
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %w", err)
+	}
+	return transactions, nil
+}
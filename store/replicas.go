@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lagProbeTimeout is how long measuring the lag of a replica may take before the
+// replica is left out until it's measured again
+const lagProbeTimeout = 2 * time.Second
+
+// errNotReplicating is the lag of a database that isn't replicating from anything,
+// or whose replication has stopped
+var errNotReplicating = errors.New("database is not replicating")
+
+// RepositoryOption configures the Repository NewRepository makes
+type RepositoryOption func(*Repository)
+
+// WithReplicas sends the reads that can be a little behind to the replicas, taking
+// turns between them, as long as a replica is no more than maxStaleness behind the
+// primary. Reads in a unit of work and those that have to see the latest writes
+// stay on the primary, and so does everything else when no replica is close
+// enough or a replica fails the read.
+func WithReplicas(maxStaleness time.Duration, replicas ...*sql.DB) RepositoryOption {
+	return func(repo *Repository) {
+		if len(replicas) == 0 {
+			return
+		}
+		set := &replicaSet{maxStaleness: maxStaleness}
+		for _, db := range replicas {
+			set.replicas = append(set.replicas, &replica{db: db})
+		}
+		repo.replicas = set
+	}
+}
+
+// ReadYourWrites is the repository with every read going to the primary, for
+// callers that have to see what they just wrote
+func (repo *Repository) ReadYourWrites() Repositoryer {
+	if repo.replicas == nil {
+		return repo
+	}
+	return &Repository{DB: repo.DB, Dialect: repo.Dialect}
+}
+
+// read runs fn on a replica that is close enough behind the primary, or on the
+// primary when there is none. What a replica fails or doesn't find is asked of the
+// primary again, the replica may only not have caught up with it yet. Replicas
+// that fail are left out until their lag is next measured.
+func (repo *Repository) read(fn func(q querier) error) error {
+	if r := repo.replicas.pick(repo.dialect()); r != nil {
+		err := fn(r.db)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			r.failed(err)
+		}
+	}
+	return fn(repo.DB)
+}
+
+type replicaSet struct {
+	maxStaleness time.Duration
+	replicas     []*replica
+	next         atomic.Uint64
+}
+
+// pick takes the next replica in turn that is fresh enough to read from
+func (s *replicaSet) pick(dialect Dialect) *replica {
+	if s == nil {
+		return nil
+	}
+	start := s.next.Add(1)
+	for i := range s.replicas {
+		r := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		if r.fresh(dialect, s.maxStaleness) {
+			return r
+		}
+	}
+	return nil
+}
+
+// replica is a read only copy of the primary and how far behind it was last seen
+type replica struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lag       time.Duration
+	err       error // why the replica can't be read from, until it's measured again
+	checkedAt time.Time
+	probing   bool
+}
+
+// fresh reports whether the replica is no more than maxStaleness behind the
+// primary. Its lag is measured again once half of maxStaleness has gone by, in
+// between it is taken to have fallen further behind for as long as it's been.
+// Only the first measurement is waited on, for at most lagProbeTimeout, there is
+// nothing to go by before it. Later ones run in the background, one at a time,
+// and reads keep going by the last one until they're done.
+func (r *replica) fresh(dialect Dialect, maxStaleness time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.probing && time.Since(r.checkedAt) >= maxStaleness/2 {
+		r.probing = true
+		if !r.checkedAt.IsZero() {
+			go r.probe(dialect)
+		} else {
+			r.mu.Unlock()
+			r.probe(dialect)
+			r.mu.Lock()
+		}
+	}
+	return r.err == nil && !r.checkedAt.IsZero() && r.lag+time.Since(r.checkedAt) <= maxStaleness
+}
+
+// probe measures the lag of the replica, for the one that set probing
+func (r *replica) probe(dialect Dialect) {
+	ctx, cancel := context.WithTimeout(context.Background(), lagProbeTimeout)
+	lag, err := dialect.replicationLag(ctx, r.db)
+	cancel()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lag, r.err, r.checkedAt, r.probing = lag, err, time.Now(), false
+}
+
+// failed leaves the replica out until its lag is measured again
+func (r *replica) failed(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err, r.checkedAt = err, time.Now()
+}
@@ -5,27 +5,105 @@ import (
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 
 	"pismo/models"
 )
 
 type Repositoryer interface {
-	GetAccountByID(id int) (models.Account, error)
+	UnitOfWork
+	ReadYourWrites() Repositoryer
+	GetAccountByID(id int64) (models.Account, error)
+	GetAccountByPublicID(publicID models.PublicID) (models.Account, error)
 	GetAccountByDocumentNumber(documentNumber string) (models.Account, error)
-	CreateAccount(documentNumber string) (int64, error)
-	BeginTransaction() (*sql.Tx, error)
-	CreateTransactionWithTx(*sql.Tx, models.Transaction) (int64, error)
-	ProcessDischargeTransactionWithTx(*sql.Tx, models.Transaction) error
+	CreateAccount(account models.Account) (int64, error)
+	GetProductByID(id int) (models.Product, error)
+	GetAccountTotals(accountID int64) (models.AccountTotals, error)
+	GetTransactionBalancesAsOf(accountID int64, asOf time.Time) ([]models.TransactionBalance, error)
 }
 
+type AuthorizationRepositoryer interface {
+	UnitOfWork
+	GetAccountByID(id int64) (models.Account, error)
+	GetAuthorizationByID(id int64) (models.Authorization, error)
+	GetAuthorizationByPublicID(publicID models.PublicID) (models.Authorization, error)
+	ExpireAuthorizations(at time.Time) (int64, error)
+}
+
+type AuditRepositoryer interface {
+	AppendAuditEntry(entry models.AuditEntry) (models.AuditEntry, error)
+	ListAuditEntries(filter models.AuditFilter) ([]models.AuditEntry, error)
+	ListAuditChainHeads() (map[int64]string, error)
+}
+
+type ReconciliationRepositoryer interface {
+	UnitOfWork
+	GetAccountTotals(accountID int64) (models.AccountTotals, error)
+	ListTransactionAccountIDs() ([]int64, error)
+	ListAccountTransactions(accountID int64) ([]models.Transaction, error)
+	ListAccountAllocations(accountID int64) ([]models.DischargeAllocation, error)
+}
+
+type ArchiveRepositoryer interface {
+	UnitOfWork
+	ListTransactionAccountIDs() ([]int64, error)
+}
+
+type LedgerRepositoryer interface {
+	GetTrialBalance() ([]models.TrialBalance, error)
+}
+
+// Repository implements the repositories on top of a SQL database. Its queries are
+// written with ? placeholders, Dialect rewrites them for the database, MySQL when
+// it isn't set. DB is the primary, see WithReplicas for reading from replicas.
 type Repository struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Dialect  Dialect
+	replicas *replicaSet
+}
+
+func NewRepository(db *sql.DB, dialect Dialect, opts ...RepositoryOption) *Repository {
+	configurePool(db)
+
+	repo := &Repository{DB: db, Dialect: dialect}
+	for _, opt := range opts {
+		opt(repo)
+	}
+	if repo.replicas != nil {
+		for _, replica := range repo.replicas.replicas {
+			configurePool(replica.db)
+		}
+	}
+	return repo
 }
 
-func NewRepository(db *sql.DB) *Repository {
+func configurePool(db *sql.DB) {
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(time.Hour)
+}
+
+func (repo *Repository) dialect() Dialect {
+	if repo.Dialect == nil {
+		return MySQL
+	}
+	return repo.Dialect
+}
+
+func (repo *Repository) exec(q querier, query string, args ...any) (sql.Result, error) {
+	return q.Exec(repo.dialect().rewrite(query), args...)
+}
+
+func (repo *Repository) query(q querier, query string, args ...any) (*sql.Rows, error) {
+	return q.Query(repo.dialect().rewrite(query), args...)
+}
+
+func (repo *Repository) queryRow(q querier, query string, args ...any) *sql.Row {
+	return q.QueryRow(repo.dialect().rewrite(query), args...)
+}
 
-	return &Repository{DB: db}
+// insert runs the insert and returns the id the database generated in idColumn
+func (repo *Repository) insert(q querier, query, idColumn string, args ...any) (int64, error) {
+	return repo.dialect().insertID(q, query, idColumn, args...)
 }
@@ -3,84 +3,140 @@ package store
 import (
 	"fmt"
 	"math"
-
-	"database/sql"
+	"time"
 
 	"pismo/models"
 )
 
-func (repo *Repository) BeginTransaction() (*sql.Tx, error) {
-	return repo.DB.Begin()
-}
+// the order discharge settles in: credits pay off debts in the order they were
+// posted, not their event_date, so a backdated transaction never reorders the
+// ones already settled. transaction_id breaks ties.
+const dischargeOrder = "ORDER BY posting_date ASC, transaction_id ASC"
 
-func (repo *Repository) CreateTransactionWithTx(tx *sql.Tx, t models.Transaction) (int64, error) {
-	query := "INSERT INTO Transactions (account_id, operation_type_id, amount, balance) VALUES (?, ?, ?, ?)"
-	row, err := tx.Exec(query, t.AccountID, t.OperationTypeID, t.Amount, t.Balance)
-	if err != nil {
-		return 0, err
-	}
-	return row.LastInsertId()
+func (tx *txRepository) CreateTransaction(t models.Transaction) (int64, error) {
+	query := "INSERT INTO Transactions (public_id, account_id, operation_type_id, amount, balance, currency, original_amount, original_currency, fx_rate, event_date, posting_date) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	return tx.insert(query, "transaction_id", t.PublicID, t.AccountID, t.OperationTypeID, t.Amount, t.Balance, t.Currency, t.OriginalAmount, t.OriginalCurrency, t.FXRate, t.EventDate, t.PostingDate)
 }
 
-func (repo *Repository) ProcessDischargeTransactionWithTx(tx *sql.Tx, depositTransaction models.Transaction) error {
-	// `FOR UPDATE` locks all rows that are selected until transaction is complete
-	query := `SELECT transaction_id, balance FROM Transactions WHERE account_id = ? AND operation_type_id < 4 AND balance < 0 ORDER BY event_date ASC FOR UPDATE`
+// ProcessDischargeTransaction pays off the open debts of the account with the
+// credit and returns how much of it was used
+func (tx *txRepository) ProcessDischargeTransaction(depositTransaction models.Transaction) (float64, error) {
+	// the caller holds the account's AccountBalances row lock, so nothing else is paying
+	// these debts off, `FOR UPDATE` makes sure they're read as last committed.
+	// Debts are paid off in the order they were posted, whatever their event_date says
+	query := `SELECT transaction_id, balance FROM Transactions WHERE account_id = ? AND operation_type_id < 4 AND balance < 0 ` + dischargeOrder + ` FOR UPDATE`
 	// TEST: if you want to see race conditions, use this query below without the `FOR UPDATE`
-	// query := `SELECT transaction_id, balance FROM Transactions WHERE account_id = ? AND operation_type_id < 4 AND balance < 0 ORDER BY event_date ASC`
+	// and run the loadtest command, it reports the balances that no longer add up
+	// query := `SELECT transaction_id, balance FROM Transactions WHERE account_id = ? AND operation_type_id < 4 AND balance < 0 ` + dischargeOrder
 
-	rows, err := tx.Query(query, depositTransaction.AccountID)
+	rows, err := tx.query(query, depositTransaction.AccountID)
 	if err != nil {
-		return fmt.Errorf("failed to query transactions: %w", err)
+		return 0, fmt.Errorf("failed to query transactions: %w", err)
 	}
 	defer rows.Close()
 
-	remainingDeposit := depositTransaction.Amount
-	var updatedTransactions []models.Transaction
-
+	d := newDischarge(depositTransaction)
 	for rows.Next() {
 		var trans models.Transaction
 		if err := rows.Scan(&trans.ID, &trans.Balance); err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
-		}
-
-		absCurrentBalance := math.Abs(trans.Balance)
-		if remainingDeposit > absCurrentBalance {
-			remainingDeposit -= absCurrentBalance
-			trans.Balance = 0
-		} else {
-			trans.Balance += remainingDeposit
-			remainingDeposit = 0
+			return 0, fmt.Errorf("failed to scan row: %w", err)
 		}
-
-		updatedTransactions = append(updatedTransactions, trans)
-
-		if remainingDeposit <= 0 {
+		if !d.pay(trans) {
 			break
 		}
 	}
 
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("error during row iteration: %w", err)
+		return 0, fmt.Errorf("error during row iteration: %w", err)
 	}
 
 	// not redundant close, must close here before running INSERTS or UPDATES
 	rows.Close()
 
 	// UPDATE balances for the transactions
-	updateBalanceQuery := "UPDATE Transactions SET balance = ? WHERE transaction_id = ?"
-	for _, update := range updatedTransactions {
-		if _, err := tx.Exec(updateBalanceQuery, update.Balance, update.ID); err != nil {
-			return fmt.Errorf("failed to update balance for transaction %d: %w", update.ID, err)
+	// naming the account keeps the updates to its partition
+	updateBalanceQuery := "UPDATE Transactions SET balance = ? WHERE account_id = ? AND transaction_id = ?"
+	for _, update := range d.updated {
+		if _, err := tx.exec(updateBalanceQuery, update.Balance, depositTransaction.AccountID, update.ID); err != nil {
+			return 0, fmt.Errorf("failed to update balance for transaction %d: %w", update.ID, err)
 		}
 	}
 
 	// UPDATE the remaining balance for the deposit transaction
-	if _, err := tx.Exec(updateBalanceQuery, remainingDeposit, depositTransaction.ID); err != nil {
-		return fmt.Errorf("failed to update deposit transaction: %w", err)
+	if _, err := tx.exec(updateBalanceQuery, d.remaining, depositTransaction.AccountID, depositTransaction.ID); err != nil {
+		return 0, fmt.Errorf("failed to update deposit transaction: %w", err)
+	}
+	d.changes = append(d.changes, models.BalanceChange{TransactionID: depositTransaction.ID, Before: depositTransaction.Amount, After: d.remaining})
+
+	// keep what was applied where, so balances at any earlier time can be rebuilt
+	for _, allocation := range d.allocations {
+		if err := tx.createDischargeAllocation(allocation); err != nil {
+			return 0, err
+		}
+	}
+
+	// balances are overwritten in place, the audit log keeps what they were
+	_, err = tx.AppendAuditEntry(models.AuditEntry{
+		Kind:          models.AuditKindBalanceChange,
+		Route:         "discharge",
+		AccountID:     depositTransaction.AccountID,
+		TransactionID: depositTransaction.ID,
+		Changes:       d.changes,
+	})
+	if err != nil {
+		return 0, err
 	}
 
     // Test: uncomment this error to determine if the rollback is working properly after committing to db
-    // return fmt.Errorf("failure")
+    // return 0, fmt.Errorf("failure")
+
+	return d.used(), nil
+}
+
+// discharge is a credit paying off debts, one at a time in discharge order, and
+// what it changed on the way
+type discharge struct {
+	credit      models.Transaction
+	remaining   float64
+	updated     []models.Transaction
+	changes     []models.BalanceChange
+	allocations []models.DischargeAllocation
+}
+
+func newDischarge(credit models.Transaction) *discharge {
+	// the allocations are dated with the credit, balances as of any earlier time undo them
+	if credit.PostingDate.IsZero() {
+		credit.PostingDate = time.Now().UTC()
+	}
+	return &discharge{credit: credit, remaining: credit.Amount}
+}
+
+// pay applies what's left of the credit to the debt and reports whether there is
+// any left for the next one
+func (d *discharge) pay(debt models.Transaction) bool {
+	before := debt.Balance
+	absCurrentBalance := math.Abs(debt.Balance)
+	if d.remaining > absCurrentBalance {
+		d.remaining -= absCurrentBalance
+		debt.Balance = 0
+	} else {
+		debt.Balance += d.remaining
+		d.remaining = 0
+	}
+
+	d.updated = append(d.updated, debt)
+	d.changes = append(d.changes, models.BalanceChange{TransactionID: debt.ID, Before: before, After: debt.Balance})
+	d.allocations = append(d.allocations, models.DischargeAllocation{
+		AccountID:           d.credit.AccountID,
+		DebitTransactionID:  debt.ID,
+		CreditTransactionID: d.credit.ID,
+		Amount:              debt.Balance - before,
+		AllocatedAt:         d.credit.PostingDate,
+	})
+	return d.remaining > 0
+}
 
-	return nil
+// used is how much of the credit went to paying debts off
+func (d *discharge) used() float64 {
+	return d.credit.Amount - d.remaining
 }
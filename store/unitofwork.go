@@ -0,0 +1,209 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"pismo/models"
+)
+
+// UnitOfWork runs work that has to be kept all together or not at all
+type UnitOfWork interface {
+	// WithinTx runs fn in a transaction, committed when fn returns nil and rolled
+	// back when it returns an error or panics, the panic carries on once rolled
+	// back. Nothing fn does through the TxRepo is kept unless it is committed.
+	WithinTx(ctx context.Context, fn func(TxRepo) error, opts ...TxOption) error
+}
+
+// TxRepo is a repository within a unit of work. Its WithinTx runs fn in a
+// savepoint, rolling back only what fn did when it fails, and leaves committing
+// to the unit of work it's in, so it takes no options.
+type TxRepo interface {
+	UnitOfWork
+	GetAccountByID(id int64) (models.Account, error)
+	UpdateAccount(account models.Account) error
+	GetAccountTotals(accountID int64) (models.AccountTotals, error)
+	LockAccountBalance(accountID int64) (models.AccountTotals, error)
+	UpdateAccountBalance(totals models.AccountTotals) error
+	CreateTransaction(models.Transaction) (int64, error)
+	ProcessDischargeTransaction(models.Transaction) (float64, error)
+	CreateJournalEntry(models.JournalEntry) (int64, error)
+	GetAvailableCredit(accountID int64, at time.Time) (float64, error)
+	CreateAuthorization(authorization models.Authorization) (int64, error)
+	GetAuthorizationForUpdate(id int64) (models.Authorization, error)
+	UpdateAuthorizationStatus(id int64, status string, transaction models.Transaction) error
+	ListAccountTransactions(accountID int64) ([]models.Transaction, error)
+	ListAccountTransactionsForUpdate(accountID int64) ([]models.Transaction, error)
+	UpdateTransactionBalance(accountID int64, transactionID int64, balance float64) error
+	AppendAuditEntry(entry models.AuditEntry) (models.AuditEntry, error)
+	ListAccountAllocations(accountID int64) ([]models.DischargeAllocation, error)
+	ReplaceAccountAllocations(accountID int64, allocations []models.DischargeAllocation) error
+	ArchiveTransactions(accountID int64, postedBefore time.Time, limit int) (int, error)
+}
+
+// Isolation is the isolation level a unit of work runs at
+type Isolation int
+
+const (
+	IsolationDefault Isolation = iota // whatever the database defaults to
+	ReadCommitted
+	RepeatableRead
+	Serializable
+)
+
+// TxOption configures the transaction of a unit of work
+type TxOption func(*txOptions)
+
+type txOptions struct {
+	isolation Isolation
+	readOnly  bool
+}
+
+// WithIsolation runs the unit of work at the isolation level. SQLite transactions
+// are always serializable, and so are those of the MemoryRepository.
+func WithIsolation(isolation Isolation) TxOption {
+	return func(options *txOptions) { options.isolation = isolation }
+}
+
+// ReadOnly runs a unit of work that only reads, the database refuses its writes
+func ReadOnly() TxOption {
+	return func(options *txOptions) { options.readOnly = true }
+}
+
+// ErrNestedTxOptions is returned by the WithinTx of a TxRepo given options, the
+// outermost unit of work decides them for everything in it
+var ErrNestedTxOptions = errors.New("options can only be given to the outermost unit of work")
+
+func newTxOptions(opts []TxOption) txOptions {
+	var options txOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+func (options txOptions) sql() *sql.TxOptions {
+	levels := map[Isolation]sql.IsolationLevel{
+		IsolationDefault: sql.LevelDefault,
+		ReadCommitted:    sql.LevelReadCommitted,
+		RepeatableRead:   sql.LevelRepeatableRead,
+		Serializable:     sql.LevelSerializable,
+	}
+	return &sql.TxOptions{Isolation: levels[options.isolation], ReadOnly: options.readOnly}
+}
+
+// committer ends a unit of work, either a transaction or a savepoint in one
+type committer interface {
+	Commit() error
+	Rollback() error
+}
+
+// runUnitOfWork runs fn on txRepo and commits, or rolls back when fn fails or panics
+func runUnitOfWork(c committer, txRepo TxRepo, fn func(TxRepo) error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(txRepo); err != nil {
+		if rollbackErr := c.Rollback(); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	return c.Commit()
+}
+
+// WithinTx runs fn in a database transaction, see UnitOfWork
+func (repo *Repository) WithinTx(ctx context.Context, fn func(TxRepo) error, opts ...TxOption) error {
+	tx, err := repo.DB.BeginTx(ctx, newTxOptions(opts).sql())
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return runUnitOfWork(sqlTransaction{tx}, &txRepository{repo: repo, tx: tx}, fn)
+}
+
+// txRepository is Repository within a unit of work, its queries run in tx.
+// savepoints is how deep in nested units of work it is.
+type txRepository struct {
+	repo       *Repository
+	tx         *sql.Tx
+	savepoints int
+}
+
+func (tx *txRepository) WithinTx(ctx context.Context, fn func(TxRepo) error, opts ...TxOption) error {
+	if len(opts) > 0 {
+		return ErrNestedTxOptions
+	}
+	nested := &txRepository{repo: tx.repo, tx: tx.tx, savepoints: tx.savepoints + 1}
+	savepoint := sqlSavepoint{tx: tx.tx, name: fmt.Sprintf("sp_%d", nested.savepoints)}
+	if _, err := tx.tx.ExecContext(ctx, "SAVEPOINT "+savepoint.name); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	return runUnitOfWork(savepoint, nested, fn)
+}
+
+func (tx *txRepository) exec(query string, args ...any) (sql.Result, error) {
+	return tx.repo.exec(tx.tx, query, args...)
+}
+
+func (tx *txRepository) query(query string, args ...any) (*sql.Rows, error) {
+	return tx.repo.query(tx.tx, query, args...)
+}
+
+func (tx *txRepository) queryRow(query string, args ...any) *sql.Row {
+	return tx.repo.queryRow(tx.tx, query, args...)
+}
+
+func (tx *txRepository) insert(query, idColumn string, args ...any) (int64, error) {
+	return tx.repo.insert(tx.tx, query, idColumn, args...)
+}
+
+type sqlTransaction struct {
+	tx *sql.Tx
+}
+
+func (t sqlTransaction) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (t sqlTransaction) Rollback() error {
+	if err := t.tx.Rollback(); err != nil {
+		return fmt.Errorf("failed to roll back transaction: %w", err)
+	}
+	return nil
+}
+
+// sqlSavepoint is a nested unit of work, committing it only releases the savepoint
+// and leaves what it did to the transaction around it
+type sqlSavepoint struct {
+	tx   *sql.Tx
+	name string
+}
+
+func (s sqlSavepoint) Commit() error {
+	if _, err := s.tx.Exec("RELEASE SAVEPOINT " + s.name); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return nil
+}
+
+// Rollback undoes what was done since the savepoint and then releases it, so a
+// nested unit of work run again doesn't leave savepoints of the same name behind
+func (s sqlSavepoint) Rollback() error {
+	if _, err := s.tx.Exec("ROLLBACK TO SAVEPOINT " + s.name); err != nil {
+		return fmt.Errorf("failed to roll back to savepoint: %w", err)
+	}
+	if _, err := s.tx.Exec("RELEASE SAVEPOINT " + s.name); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/audit"
+	"pismo/models"
+)
+
+// chain links the entries onto their account's chain the way the store appends them
+func chain(entries ...models.AuditEntry) []models.AuditEntry {
+	heads := map[int64]string{}
+	for i := range entries {
+		entries[i].ID = int64(i + 1)
+		entries[i].ChainID = entries[i].AccountID
+		entries[i].PrevHash = audit.GenesisHash
+		if head, ok := heads[entries[i].ChainID]; ok {
+			entries[i].PrevHash = head
+		}
+		entries[i].Hash = audit.Hash(entries[i])
+		heads[entries[i].ChainID] = entries[i].Hash
+	}
+	return entries
+}
+
+func testChain() []models.AuditEntry {
+	at := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+	return chain(
+		models.AuditEntry{OccurredAt: at, Kind: models.AuditKindRequest, PrincipalID: "pk_dev", Method: "POST", Route: "/accounts", AccountID: 1, Outcome: 200},
+		models.AuditEntry{OccurredAt: at.Add(time.Second), Kind: models.AuditKindBalanceChange, Route: "discharge", AccountID: 1, TransactionID: 4,
+			Changes: []models.BalanceChange{{TransactionID: 2, Before: -50, After: 0}, {TransactionID: 4, Before: 60, After: 10}}},
+		models.AuditEntry{OccurredAt: at.Add(2 * time.Second), Kind: models.AuditKindRequest, PrincipalID: "pk_dev", Method: "POST", Route: "/transactions", AccountID: 1, TransactionID: 4, Outcome: 200},
+		models.AuditEntry{OccurredAt: at.Add(3 * time.Second), Kind: models.AuditKindRequest, PrincipalID: "pk_dev", Method: "POST", Route: "/accounts", AccountID: 2, Outcome: 200},
+	)
+}
+
+func TestHashIsStableAcrossTimezones(t *testing.T) {
+	entry := testChain()[0]
+	sameInstant := entry
+	sameInstant.OccurredAt = entry.OccurredAt.In(time.FixedZone("BRT", -3*60*60))
+	assert.Equal(t, audit.Hash(entry), audit.Hash(sameInstant))
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name          string
+		tamper        func([]models.AuditEntry) []models.AuditEntry
+		expectedError string
+	}{
+		{
+			name:   "Intact chain",
+			tamper: func(entries []models.AuditEntry) []models.AuditEntry { return entries },
+		},
+		{
+			name: "Balance rewritten",
+			tamper: func(entries []models.AuditEntry) []models.AuditEntry {
+				entries[1].Changes[0].Before = -5
+				return entries
+			},
+			expectedError: "audit chain broken: entry 2 has been modified",
+		},
+		{
+			name: "Entry removed",
+			tamper: func(entries []models.AuditEntry) []models.AuditEntry {
+				return append(entries[:1], entries[2:]...)
+			},
+			expectedError: "audit chain broken: entry 3 does not follow the one before it",
+		},
+		{
+			name: "Entry rewritten and rehashed",
+			tamper: func(entries []models.AuditEntry) []models.AuditEntry {
+				entries[0].PrincipalID = "someone-else"
+				entries[0].Hash = audit.Hash(entries[0])
+				return entries
+			},
+			expectedError: "audit chain broken: entry 2 does not follow the one before it",
+		},
+		{
+			name: "Entry moved to another chain",
+			tamper: func(entries []models.AuditEntry) []models.AuditEntry {
+				entries[3].ChainID = 1
+				return entries
+			},
+			expectedError: "audit chain broken: entry 4 does not follow the one before it",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := tt.tamper(testChain())
+
+			last := map[int64]string{}
+			err := audit.Verify(entries, last)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				assert.True(t, errors.Is(err, audit.ErrChainBroken))
+				return
+			}
+			assert.NoError(t, err)
+			// each chain carries on from its own last entry
+			assert.Equal(t, map[int64]string{1: entries[2].Hash, 2: entries[3].Hash}, last)
+		})
+	}
+}
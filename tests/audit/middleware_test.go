@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/audit"
+	"pismo/auth"
+	"pismo/models"
+)
+
+type fakeAuditLog struct {
+	mu      sync.Mutex
+	entries []models.AuditEntry
+}
+
+func (f *fakeAuditLog) AppendAuditEntry(entry models.AuditEntry) (models.AuditEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry.ID = int64(len(f.entries) + 1)
+	f.entries = append(f.entries, entry)
+	return entry, nil
+}
+
+func newRouter(log *fakeAuditLog) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := auth.Principal{ID: "pk_dev", Roles: []string{auth.RoleAdmin}}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}, audit.NewRecorder(log).Middleware)
+
+	r.HandleFunc("/transactions", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if len(body) == 0 {
+			http.Error(w, "No Account ID provided", http.StatusBadRequest)
+			return
+		}
+		audit.RecordAccount(r.Context(), 1)
+		audit.RecordTransaction(r.Context(), 42)
+		w.Write([]byte(audit.RequestIDFromContext(r.Context())))
+	}).Methods("POST")
+	r.HandleFunc("/accounts/{id}", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+	return r
+}
+
+func TestRecorderMiddleware(t *testing.T) {
+	log := &fakeAuditLog{}
+	r := newRouter(log)
+
+	body := `{"account_id": 1, "operation_type_id": 4, "amount": 10}`
+	req := httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewBufferString(body))
+	req.Header.Set(audit.RequestIDHeader, "req-123")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "req-123", rr.Header().Get(audit.RequestIDHeader))
+	assert.Equal(t, "req-123", rr.Body.String())
+
+	sum := sha256.Sum256([]byte(body))
+	assert.Len(t, log.entries, 1)
+	entry := log.entries[0]
+	assert.Equal(t, models.AuditKindRequest, entry.Kind)
+	assert.Equal(t, "pk_dev", entry.PrincipalID)
+	assert.Equal(t, "req-123", entry.RequestID)
+	assert.Equal(t, "POST", entry.Method)
+	assert.Equal(t, "/transactions", entry.Route)
+	assert.Equal(t, int64(1), entry.AccountID)
+	assert.Equal(t, int64(42), entry.TransactionID)
+	assert.Equal(t, hex.EncodeToString(sum[:]), entry.PayloadHash)
+	assert.Equal(t, http.StatusOK, entry.Outcome)
+	assert.False(t, entry.OccurredAt.IsZero())
+
+	// failures are recorded with their status and a generated request ID
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/transactions", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Len(t, log.entries, 2)
+	assert.Equal(t, http.StatusBadRequest, log.entries[1].Outcome)
+	assert.Len(t, log.entries[1].RequestID, 32)
+	assert.Equal(t, rr.Header().Get(audit.RequestIDHeader), log.entries[1].RequestID)
+
+	// reads get a request ID but aren't audited
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/accounts/1", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get(audit.RequestIDHeader))
+	assert.Len(t, log.entries, 2)
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/auth"
+)
+
+func TestLoadIssuers(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "idp.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	tests := []struct {
+		name          string
+		config        string
+		expectedError string
+	}{
+		{
+			name: "HS256 and RS256 issuers",
+			config: `{"issuers": [
+				{"issuer": "https://hs.example.com", "algorithm": "HS256", "secret": "super-secret"},
+				{"issuer": "https://rs.example.com", "algorithm": "RS256", "public_key_file": "idp.pem", "audience": "pismo"}
+			]}`,
+		},
+		{
+			name:          "HS256 without a secret",
+			config:        `{"issuers": [{"issuer": "https://hs.example.com", "algorithm": "HS256"}]}`,
+			expectedError: "issuer https://hs.example.com: HS256 requires a secret",
+		},
+		{
+			name:          "Unsupported algorithm",
+			config:        `{"issuers": [{"issuer": "https://hs.example.com", "algorithm": "none"}]}`,
+			expectedError: `issuer https://hs.example.com: unsupported algorithm "none"`,
+		},
+		{
+			name:          "Missing public key",
+			config:        `{"issuers": [{"issuer": "https://rs.example.com", "algorithm": "RS256", "public_key_file": "missing.pem"}]}`,
+			expectedError: "issuer https://rs.example.com: failed to read public key: open " + filepath.Join(dir, "missing.pem") + ": no such file or directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "auth.json")
+			assert.NoError(t, os.WriteFile(path, []byte(tt.config), 0o600))
+
+			issuers, err := auth.LoadIssuers(path)
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, issuers, 2)
+			assert.Equal(t, []byte("super-secret"), issuers[0].Secret)
+			assert.True(t, rsaKey.PublicKey.Equal(issuers[1].PublicKey))
+			assert.Equal(t, "pismo", issuers[1].Audience)
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/auth"
+	"pismo/models"
+)
+
+type fakeAPIKeyStore map[string]models.APIKey
+
+func (f fakeAPIKeyStore) GetAPIKeyByID(keyID string) (models.APIKey, error) {
+	key, ok := f[keyID]
+	if !ok {
+		return models.APIKey{}, sql.ErrNoRows
+	}
+	return key, nil
+}
+
+type failingAPIKeyStore struct {
+	err error
+}
+
+func (f failingAPIKeyStore) GetAPIKeyByID(keyID string) (models.APIKey, error) {
+	return models.APIKey{}, f.err
+}
+
+func signHS256(t *testing.T, secret []byte, header, claims map[string]any) string {
+	input := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	input := encodeSegment(t, map[string]any{"alg": "RS256", "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	assert.NoError(t, err)
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeSegment(t *testing.T, v any) string {
+	encoded, err := json.Marshal(v)
+	assert.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func TestAuthenticatorMiddleware(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	hsSecret := []byte("super-secret")
+	revokedAt := time.Now().Add(-time.Hour)
+	keys := fakeAPIKeyStore{
+		"pk_partner": {ID: "pk_partner", ClientID: "acme", KeyHash: auth.HashAPIKeySecret("s3cret"), Roles: []string{"partner"}, TenantID: "acme"},
+		"pk_revoked": {ID: "pk_revoked", ClientID: "old", KeyHash: auth.HashAPIKeySecret("s3cret"), Roles: []string{"admin"}, RevokedAt: &revokedAt},
+	}
+	authenticator := auth.NewAuthenticator(keys, []auth.Issuer{
+		{Name: "https://hs.example.com", Algorithm: auth.AlgorithmHS256, Secret: hsSecret},
+		{Name: "https://rs.example.com", Algorithm: auth.AlgorithmRS256, PublicKey: &rsaKey.PublicKey, Audience: "pismo"},
+	})
+
+	exp := time.Now().Add(time.Hour).Unix()
+	hsHeader := map[string]any{"alg": "HS256", "typ": "JWT"}
+
+	tests := []struct {
+		name              string
+		headers           map[string]string
+		expectedStatus    int
+		expectedBody      string
+		expectedPrincipal auth.Principal
+	}{
+		{
+			name:           "No credentials",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"missing credentials, send an X-API-Key header or an Authorization: Bearer token"}` + "\n",
+		},
+		{
+			name:           "Basic auth is not accepted",
+			headers:        map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"missing credentials, send an X-API-Key header or an Authorization: Bearer token"}` + "\n",
+		},
+		{
+			name:              "Happy path: Valid API key",
+			headers:           map[string]string{"X-API-Key": "pk_partner.s3cret"},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: auth.Principal{ID: "acme", Roles: []string{"partner"}, TenantID: "acme", Method: auth.MethodAPIKey},
+		},
+		{
+			name:           "Wrong API key secret",
+			headers:        map[string]string{"X-API-Key": "pk_partner.guess"},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid API key"}` + "\n",
+		},
+		{
+			name:           "Unknown API key",
+			headers:        map[string]string{"X-API-Key": "pk_nobody.s3cret"},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid API key"}` + "\n",
+		},
+		{
+			name:           "Malformed API key",
+			headers:        map[string]string{"X-API-Key": "s3cret"},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"malformed API key"}` + "\n",
+		},
+		{
+			name:           "Revoked API key",
+			headers:        map[string]string{"X-API-Key": "pk_revoked.s3cret"},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"API key has been revoked"}` + "\n",
+		},
+		{
+			name: "Happy path: Valid HS256 token",
+			headers: map[string]string{"Authorization": "Bearer " + signHS256(t, hsSecret, hsHeader, map[string]any{
+				"iss": "https://hs.example.com", "sub": "agent-42", "exp": exp, "roles": []string{"support"},
+			})},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: auth.Principal{ID: "agent-42", Roles: []string{"support"}, Method: auth.MethodJWT},
+		},
+		{
+			name: "Happy path: Valid RS256 token with audience list",
+			headers: map[string]string{"Authorization": "bearer " + signRS256(t, rsaKey, map[string]any{
+				"iss": "https://rs.example.com", "sub": "svc-acme", "aud": []string{"other", "pismo"}, "exp": exp, "roles": []string{"partner"}, "tenant_id": "acme",
+			})},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: auth.Principal{ID: "svc-acme", Roles: []string{"partner"}, TenantID: "acme", Method: auth.MethodJWT},
+		},
+		{
+			name: "RS256 token signed by another key",
+			headers: map[string]string{"Authorization": "Bearer " + signRS256(t, otherRSAKey, map[string]any{
+				"iss": "https://rs.example.com", "sub": "svc-acme", "aud": "pismo", "exp": exp,
+			})},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid token signature"}` + "\n",
+		},
+		{
+			name: "HS256 token for an RS256 issuer is rejected",
+			headers: map[string]string{"Authorization": "Bearer " + signHS256(t, hsSecret, hsHeader, map[string]any{
+				"iss": "https://rs.example.com", "sub": "svc-acme", "aud": "pismo", "exp": exp,
+			})},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"unexpected signing algorithm HS256 for issuer https://rs.example.com"}` + "\n",
+		},
+		{
+			name: "Unsigned token is rejected",
+			headers: map[string]string{"Authorization": "Bearer " + signHS256(t, hsSecret, map[string]any{"alg": "none"}, map[string]any{
+				"iss": "https://hs.example.com", "sub": "agent-42", "exp": exp,
+			})},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"unexpected signing algorithm none for issuer https://hs.example.com"}` + "\n",
+		},
+		{
+			name: "Wrong audience",
+			headers: map[string]string{"Authorization": "Bearer " + signRS256(t, rsaKey, map[string]any{
+				"iss": "https://rs.example.com", "sub": "svc-acme", "aud": "someone-else", "exp": exp,
+			})},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"token is not intended for this audience"}` + "\n",
+		},
+		{
+			name: "Untrusted issuer",
+			headers: map[string]string{"Authorization": "Bearer " + signHS256(t, hsSecret, hsHeader, map[string]any{
+				"iss": "https://evil.example.com", "sub": "agent-42", "exp": exp,
+			})},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"untrusted token issuer: https://evil.example.com"}` + "\n",
+		},
+		{
+			name: "Expired token",
+			headers: map[string]string{"Authorization": "Bearer " + signHS256(t, hsSecret, hsHeader, map[string]any{
+				"iss": "https://hs.example.com", "sub": "agent-42", "exp": time.Now().Add(-time.Hour).Unix(),
+			})},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"token has expired"}` + "\n",
+		},
+		{
+			name: "Token without expiry",
+			headers: map[string]string{"Authorization": "Bearer " + signHS256(t, hsSecret, hsHeader, map[string]any{
+				"iss": "https://hs.example.com", "sub": "agent-42",
+			})},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"token has no expiry"}` + "\n",
+		},
+		{
+			name: "Token not valid yet",
+			headers: map[string]string{"Authorization": "Bearer " + signHS256(t, hsSecret, hsHeader, map[string]any{
+				"iss": "https://hs.example.com", "sub": "agent-42", "exp": exp, "nbf": time.Now().Add(10 * time.Minute).Unix(),
+			})},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"token is not valid yet"}` + "\n",
+		},
+		{
+			name:           "Malformed token",
+			headers:        map[string]string{"Authorization": "Bearer not-a-jwt"},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"malformed token"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPrincipal auth.Principal
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPrincipal, _ = auth.PrincipalFromContext(r.Context())
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/accounts/1", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+
+			authenticator.Middleware(next).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.Equal(t, tt.expectedPrincipal, gotPrincipal)
+			} else {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+				assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+				assert.NotEmpty(t, rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAuthenticatorMiddlewareStoreFailure(t *testing.T) {
+	authenticator := auth.NewAuthenticator(failingAPIKeyStore{err: errors.New("dial tcp 10.0.0.5:3306: connection refused")}, nil)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("request should not reach the handler")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/accounts/1", nil)
+	req.Header.Set("X-API-Key", "pk_partner.s3cret")
+	rr := httptest.NewRecorder()
+
+	authenticator.Middleware(next).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, `{"error":"could not verify credentials, try again later"}`+"\n", rr.Body.String())
+	assert.Empty(t, rr.Header().Get("WWW-Authenticate"))
+}
+
+func TestRequireAnyRole(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := auth.RequireAnyRole(auth.RoleAdmin, auth.RoleSupport)(next)
+
+	tests := []struct {
+		name           string
+		principal      *auth.Principal
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "No principal on the context",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"missing credentials, send an X-API-Key header or an Authorization: Bearer token"}` + "\n",
+		},
+		{
+			name:           "Principal without an allowed role",
+			principal:      &auth.Principal{ID: "acme", Roles: []string{"partner"}},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"principal is not allowed to access this resource"}` + "\n",
+		},
+		{
+			name:           "Principal with an allowed role",
+			principal:      &auth.Principal{ID: "agent-42", Roles: []string{"viewer", "support"}},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/accounts/1", nil)
+			if tt.principal != nil {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), *tt.principal))
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/auth"
+	"pismo/models"
+)
+
+func TestPolicyAuthorize(t *testing.T) {
+	policy := auth.NewPolicy()
+
+	admin := auth.Principal{ID: "admin-1", Roles: []string{auth.RoleAdmin}}
+	support := auth.Principal{ID: "support-1", Roles: []string{auth.RoleSupport}}
+	partner := auth.Principal{ID: "partner-1", Roles: []string{auth.RolePartner}, TenantID: "acme"}
+
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		allowed   []auth.Action
+		denied    []auth.Action
+	}{
+		{
+			name:      "Admin can do everything",
+			principal: admin,
+			allowed: []auth.Action{
+				auth.ActionReadAccount, auth.ActionCreateAccount,
+				auth.ActionReadTransaction, auth.ActionCreateTransaction, auth.ActionReverseTransaction,
+				auth.ActionCreateOperationType,
+				auth.ActionReadAuthorization, auth.ActionManageAuthorization,
+				auth.ActionReadAuditLog, auth.ActionVerifyAuditLog,
+				auth.ActionReadLedger,
+			},
+		},
+		{
+			name:      "Support can only read",
+			principal: support,
+			allowed:   []auth.Action{auth.ActionReadAccount, auth.ActionReadTransaction, auth.ActionReadAuthorization, auth.ActionReadAuditLog, auth.ActionReadLedger},
+			denied: []auth.Action{
+				auth.ActionCreateAccount, auth.ActionCreateTransaction, auth.ActionReverseTransaction,
+				auth.ActionCreateOperationType, auth.ActionManageAuthorization, auth.ActionVerifyAuditLog,
+			},
+		},
+		{
+			name:      "Partner can transact but not reverse or create operation types",
+			principal: partner,
+			allowed: []auth.Action{
+				auth.ActionReadAccount, auth.ActionCreateAccount,
+				auth.ActionReadTransaction, auth.ActionCreateTransaction,
+				auth.ActionReadAuthorization, auth.ActionManageAuthorization,
+			},
+			denied: []auth.Action{auth.ActionReverseTransaction, auth.ActionCreateOperationType, auth.ActionReadAuditLog, auth.ActionReadLedger},
+		},
+		{
+			name:      "Unknown roles grant nothing",
+			principal: auth.Principal{ID: "robot", Roles: []string{"robot"}},
+			denied:    []auth.Action{auth.ActionReadAccount, auth.ActionCreateTransaction},
+		},
+		{
+			name:      "No principal grants nothing",
+			principal: auth.Principal{},
+			denied:    []auth.Action{auth.ActionReadAccount},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, action := range tt.allowed {
+				assert.NoError(t, policy.Authorize(tt.principal, action), action)
+			}
+			for _, action := range tt.denied {
+				err := policy.Authorize(tt.principal, action)
+				assert.True(t, errors.Is(err, auth.ErrForbidden), action)
+			}
+		})
+	}
+}
+
+func TestPolicyAuthorizeAccount(t *testing.T) {
+	policy := auth.NewPolicy()
+
+	acmeAccount := models.Account{ID: 1, PublicID: "acc_01J8Z3V4W5X6Y7Z8A9B0C1D201", TenantID: "acme"}
+	globexAccount := models.Account{ID: 2, PublicID: "acc_01J8Z3V4W5X6Y7Z8A9B0C1D202", TenantID: "globex"}
+
+	tests := []struct {
+		name          string
+		principal     auth.Principal
+		action        auth.Action
+		account       models.Account
+		expectedError string
+	}{
+		{
+			name:      "Partner can transact on its own tenant's account",
+			principal: auth.Principal{ID: "partner-1", Roles: []string{auth.RolePartner}, TenantID: "acme"},
+			action:    auth.ActionCreateTransaction,
+			account:   acmeAccount,
+		},
+		{
+			name:          "Partner cannot transact on another tenant's account",
+			principal:     auth.Principal{ID: "partner-1", Roles: []string{auth.RolePartner}, TenantID: "acme"},
+			action:        auth.ActionCreateTransaction,
+			account:       globexAccount,
+			expectedError: "forbidden: partner-1 cannot access account acc_01J8Z3V4W5X6Y7Z8A9B0C1D202",
+		},
+		{
+			name:          "Partner without a tenant owns no accounts",
+			principal:     auth.Principal{ID: "partner-2", Roles: []string{auth.RolePartner}},
+			action:        auth.ActionReadAccount,
+			account:       models.Account{ID: 3, PublicID: "acc_01J8Z3V4W5X6Y7Z8A9B0C1D203"},
+			expectedError: "forbidden: partner-2 cannot access account acc_01J8Z3V4W5X6Y7Z8A9B0C1D203",
+		},
+		{
+			name:      "Support reads accounts of any tenant",
+			principal: auth.Principal{ID: "support-1", Roles: []string{auth.RoleSupport}, TenantID: "acme"},
+			action:    auth.ActionReadAccount,
+			account:   globexAccount,
+		},
+		{
+			name:          "Support still cannot transact",
+			principal:     auth.Principal{ID: "support-1", Roles: []string{auth.RoleSupport}},
+			action:        auth.ActionCreateTransaction,
+			account:       acmeAccount,
+			expectedError: "forbidden: support-1 is not allowed to transaction:create",
+		},
+		{
+			name:      "Partner that is also support reads any account but only transacts on its own",
+			principal: auth.Principal{ID: "ops-1", Roles: []string{auth.RolePartner, auth.RoleSupport}, TenantID: "acme"},
+			action:    auth.ActionReadAccount,
+			account:   globexAccount,
+		},
+		{
+			name:      "Admin is never tenant scoped",
+			principal: auth.Principal{ID: "admin-1", Roles: []string{auth.RoleAdmin}},
+			action:    auth.ActionCreateTransaction,
+			account:   globexAccount,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.AuthorizeAccount(tt.principal, tt.action, tt.account)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				assert.True(t, errors.Is(err, auth.ErrForbidden))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
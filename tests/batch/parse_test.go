@@ -0,0 +1,138 @@
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/batch"
+	"pismo/models"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name          string
+		format        string
+		input         string
+		expected      []models.BatchRow
+		expectedError string
+	}{
+		{
+			name:   "JSON array",
+			format: batch.FormatJSON,
+			input:  `[{"account_id": 1, "operation_type_id": 1, "amount": -50}, {"account_id": "acc_01J8Z3V4W5X6Y7Z8A9B0C1D201", "operation_type_id": 4, "amount": 60, "currency": "USD"}]`,
+			expected: []models.BatchRow{
+				{Row: 1, Transaction: models.Transaction{AccountPublicID: "1", OperationTypeID: 1, Amount: -50}},
+				{Row: 2, Transaction: models.Transaction{AccountPublicID: "acc_01J8Z3V4W5X6Y7Z8A9B0C1D201", OperationTypeID: 4, Amount: 60, Currency: "USD"}},
+			},
+		},
+		{
+			name:   "JSON rows are validated one by one",
+			format: batch.FormatJSON,
+			input:  `[{"account_id": 1, "operation_type_id": 1, "amount": 50}, {"account_id": 2, "operation_type_id": 4}]`,
+			expected: []models.BatchRow{
+				{Row: 1, Transaction: models.Transaction{AccountPublicID: "1", OperationTypeID: 1, Amount: 50},
+					Error: "invalid transaction amount 50.00 for the given operation type ID 1: expected Debit direction"},
+				{Row: 2, Transaction: models.Transaction{AccountPublicID: "2", OperationTypeID: 4}, Error: "No Amount provided"},
+			},
+		},
+		{
+			name:   "JSONL skips blank lines",
+			format: batch.FormatJSONL,
+			input:  "{\"account_id\": 1, \"operation_type_id\": 3, \"amount\": -20}\n\n{\"account_id\": 2, \"operation_type_id\": 4, \"amount\": 20, \"currency\": \"R$\"}\n",
+			expected: []models.BatchRow{
+				{Row: 1, Transaction: models.Transaction{AccountPublicID: "1", OperationTypeID: 3, Amount: -20}},
+				{Row: 2, Transaction: models.Transaction{AccountPublicID: "2", OperationTypeID: 4, Amount: 20, Currency: "R$"},
+					Error: "Invalid currency, must be an ISO 4217 code: R$"},
+			},
+		},
+		{
+			name:   "CSV with columns in any order",
+			format: batch.FormatCSV,
+			input:  "amount,Account_ID,operation_type_id,currency\n-50.5,1,2,BRL\n10,x,4,\n25,acc_01J8Z3V4W5X6Y7Z8A9B0C1D202,4\n",
+			expected: []models.BatchRow{
+				{Row: 1, Transaction: models.Transaction{AccountPublicID: "1", OperationTypeID: 2, Amount: -50.5, Currency: "BRL"}},
+				{Row: 2, Error: `Invalid account ID: "x"`},
+				{Row: 3, Transaction: models.Transaction{AccountPublicID: "acc_01J8Z3V4W5X6Y7Z8A9B0C1D202", OperationTypeID: 4, Amount: 25}},
+			},
+		},
+		{
+			name:   "CSV with event dates",
+			format: batch.FormatCSV,
+			input:  "account_id,operation_type_id,amount,event_date\n1,1,-50,2024-09-17T15:04:05Z\n1,1,-10,17/09/2024\n1,1,-20,\n",
+			expected: []models.BatchRow{
+				{Row: 1, Transaction: models.Transaction{AccountPublicID: "1", OperationTypeID: 1, Amount: -50, EventDate: time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)}},
+				{Row: 2, Error: `Invalid event_date, must be an RFC 3339 timestamp: "17/09/2024"`},
+				{Row: 3, Transaction: models.Transaction{AccountPublicID: "1", OperationTypeID: 1, Amount: -20}},
+			},
+		},
+		{
+			name:          "CSV without an amount column",
+			format:        batch.FormatCSV,
+			input:         "account_id,operation_type_id\n1,1\n",
+			expectedError: "CSV header is missing the amount column",
+		},
+		{
+			name:          "Unknown format",
+			format:        "xml",
+			input:         "<transactions/>",
+			expectedError: "unsupported batch format: xml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := batch.Parse(strings.NewReader(tt.input), tt.format)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, rows)
+		})
+	}
+}
+
+func TestParseMalformedJSON(t *testing.T) {
+	// a row of the wrong shape only rejects that row
+	rows, err := batch.Parse(strings.NewReader(`[{"account_id": true}, {"account_id": 1, "operation_type_id": 4, "amount": 5}]`), batch.FormatJSON)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.True(t, strings.HasPrefix(rows[0].Error, "invalid JSON: "), rows[0].Error)
+	assert.Empty(t, rows[1].Error)
+
+	// anything but an array rejects the upload
+	_, err = batch.Parse(strings.NewReader(`{"account_id": 1}`), batch.FormatJSON)
+	assert.ErrorContains(t, err, "failed to parse JSON batch, expected an array of transactions")
+}
+
+func TestParseTooManyRows(t *testing.T) {
+	var input strings.Builder
+	input.WriteString("account_id,operation_type_id,amount\n")
+	for i := 0; i <= batch.MaxRows; i++ {
+		fmt.Fprintf(&input, "1,4,%d\n", i+1)
+	}
+
+	_, err := batch.Parse(strings.NewReader(input.String()), batch.FormatCSV)
+	assert.True(t, errors.Is(err, batch.ErrTooManyRows))
+}
+
+func TestFormatFromContentType(t *testing.T) {
+	for contentType, expected := range map[string]string{
+		"application/json":                batch.FormatJSON,
+		"application/json; charset=utf-8": batch.FormatJSON,
+		"text/csv":                        batch.FormatCSV,
+		"application/x-ndjson":            batch.FormatJSONL,
+	} {
+		format, err := batch.FormatFromContentType(contentType)
+		assert.NoError(t, err, contentType)
+		assert.Equal(t, expected, format, contentType)
+	}
+
+	_, err := batch.FormatFromContentType("application/xml")
+	assert.EqualError(t, err, "unsupported batch format: application/xml")
+}
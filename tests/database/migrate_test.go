@@ -0,0 +1,264 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/database"
+)
+
+func TestSplitStatements(t *testing.T) {
+	contents := `-- a comment about the migration
+CREATE TABLE IF NOT EXISTS Things (
+    thing_id INT PRIMARY KEY
+);
+
+-- another comment
+ALTER TABLE Things ADD COLUMN name VARCHAR(10);
+INSERT INTO Things (thing_id) VALUES (1)`
+
+	statements := database.SplitStatements(contents)
+
+	assert.Equal(t, []string{
+		"CREATE TABLE IF NOT EXISTS Things (\n    thing_id INT PRIMARY KEY\n)",
+		"ALTER TABLE Things ADD COLUMN name VARCHAR(10)",
+		"INSERT INTO Things (thing_id) VALUES (1)",
+	}, statements)
+}
+
+func TestApplyMigrationsMySQL(t *testing.T) {
+	migrations := fstest.MapFS{
+		"0001_baseline.sql":               {Data: []byte("CREATE TABLE IF NOT EXISTS Accounts (account_id INT PRIMARY KEY);")},
+		"0002_unique_document_number.sql": {Data: []byte("-- one account per holder\nALTER TABLE Accounts ADD UNIQUE INDEX ux_accounts_document_number (document_number);")},
+		"README.md":                       {Data: []byte("not a migration")},
+	}
+
+	tests := []struct {
+		name          string
+		mockSetup     func(sqlmock.Sqlmock)
+		expectedError string
+	}{
+		{
+			name: "Only unapplied migrations are run",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("CREATE TABLE IF NOT EXISTS SchemaMigrations").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT version FROM SchemaMigrations").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("0001_baseline"))
+				mock.ExpectExec("ALTER TABLE Accounts ADD UNIQUE INDEX ux_accounts_document_number \\(document_number\\)").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("INSERT INTO SchemaMigrations \\(version\\) VALUES \\(\\?\\)").
+					WithArgs("0002_unique_document_number").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "Fresh database runs every migration in order",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("CREATE TABLE IF NOT EXISTS SchemaMigrations").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT version FROM SchemaMigrations").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}))
+				mock.ExpectExec("CREATE TABLE IF NOT EXISTS Accounts").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("INSERT INTO SchemaMigrations \\(version\\) VALUES \\(\\?\\)").
+					WithArgs("0001_baseline").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("ALTER TABLE Accounts ADD UNIQUE INDEX").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("INSERT INTO SchemaMigrations \\(version\\) VALUES \\(\\?\\)").
+					WithArgs("0002_unique_document_number").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "Failed migration is not recorded",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("CREATE TABLE IF NOT EXISTS SchemaMigrations").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT version FROM SchemaMigrations").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("0001_baseline"))
+				mock.ExpectExec("ALTER TABLE Accounts ADD UNIQUE INDEX").
+					WillReturnError(errors.New("Duplicate entry '123' for key 'ux_accounts_document_number'"))
+			},
+			expectedError: "failed to apply migration 0002_unique_document_number: Duplicate entry '123' for key 'ux_accounts_document_number'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			err = database.ApplyMigrations(db, database.DriverMySQL, migrations, "")
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestApplyMigrationsSeed(t *testing.T) {
+	migrations := fstest.MapFS{
+		"0001_baseline.sql":               {Data: []byte("CREATE TABLE IF NOT EXISTS Accounts (account_id INT PRIMARY KEY);")},
+		"0002_unique_document_number.sql": {Data: []byte("ALTER TABLE Accounts ADD UNIQUE INDEX ux_accounts_document_number (document_number);")},
+	}
+	seed := "-- sample data\nINSERT INTO Accounts (account_id) VALUES (1);\nINSERT INTO Accounts (account_id) VALUES (2);"
+
+	t.Run("New database is seeded right after its baseline", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS SchemaMigrations").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM SchemaMigrations").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS Accounts").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO SchemaMigrations").
+			WithArgs("0001_baseline").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO Accounts \\(account_id\\) VALUES \\(1\\)").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO Accounts \\(account_id\\) VALUES \\(2\\)").
+			WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectExec("ALTER TABLE Accounts ADD UNIQUE INDEX").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO SchemaMigrations").
+			WithArgs("0002_unique_document_number").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, database.ApplyMigrations(db, database.DriverMySQL, migrations, seed))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Database with a baseline is never seeded", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS SchemaMigrations").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM SchemaMigrations").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("0001_baseline"))
+		mock.ExpectExec("ALTER TABLE Accounts ADD UNIQUE INDEX").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO SchemaMigrations").
+			WithArgs("0002_unique_document_number").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, database.ApplyMigrations(db, database.DriverMySQL, migrations, seed))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestApplyMigrationsPostgres(t *testing.T) {
+	function := `CREATE OR REPLACE FUNCTION refuse_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;`
+	migrations := fstest.MapFS{
+		"0001_baseline.sql":    {Data: []byte("CREATE TABLE IF NOT EXISTS Accounts (account_id SERIAL PRIMARY KEY);")},
+		"0002_append_only.sql": {Data: []byte(function)},
+	}
+
+	tests := []struct {
+		name          string
+		mockSetup     func(sqlmock.Sqlmock)
+		expectedError string
+	}{
+		{
+			name: "Each file runs whole in a transaction of its own",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("CREATE TABLE IF NOT EXISTS SchemaMigrations \\(\\s+version VARCHAR\\(255\\) PRIMARY KEY,\\s+applied_at TIMESTAMP").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT version FROM SchemaMigrations").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("0001_baseline"))
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(function)).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("INSERT INTO SchemaMigrations \\(version\\) VALUES \\(\\$1\\)").
+					WithArgs("0002_append_only").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "Failed migration is rolled back and not recorded",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("CREATE TABLE IF NOT EXISTS SchemaMigrations").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT version FROM SchemaMigrations").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("0001_baseline"))
+				mock.ExpectBegin()
+				mock.ExpectExec("CREATE OR REPLACE FUNCTION refuse_change").
+					WillReturnError(errors.New("syntax error at or near \"RAISE\""))
+				mock.ExpectRollback()
+			},
+			expectedError: "failed to apply migration 0002_append_only: syntax error at or near \"RAISE\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			err = database.ApplyMigrations(db, database.DriverPostgres, migrations, "")
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMigrationsMatchAcrossDrivers(t *testing.T) {
+	mysql, err := filepath.Glob("../../database/migrations/mysql/*.sql")
+	assert.NoError(t, err)
+	postgres, err := filepath.Glob("../../database/migrations/postgres/*.sql")
+	assert.NoError(t, err)
+	sqlite, err := filepath.Glob("../../database/migrations/sqlite/*.sql")
+	assert.NoError(t, err)
+
+	names := func(paths []string) []string {
+		var names []string
+		for _, path := range paths {
+			names = append(names, filepath.Base(path))
+		}
+		return names
+	}
+	// a version missing on one side would leave that driver's schema behind
+	assert.NotEmpty(t, mysql)
+	assert.Equal(t, names(mysql), names(postgres))
+	assert.Equal(t, names(mysql), names(sqlite))
+}
+
+func TestMigrateRejectsUnknownDriver(t *testing.T) {
+	db, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.EqualError(t, database.Migrate(db, database.Config{Driver: "sqlserver"}), "unsupported database driver: sqlserver")
+}
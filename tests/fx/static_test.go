@@ -0,0 +1,126 @@
+package fx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/fx"
+)
+
+func TestStaticRateProviderRate(t *testing.T) {
+	provider := fx.NewStaticRateProvider(map[string]float64{
+		"usd/brl": 5.0,
+		"EUR/USD": 1.1,
+	})
+
+	tests := []struct {
+		name          string
+		from          string
+		to            string
+		expectedRate  float64
+		expectedError string
+	}{
+		{
+			name:         "Same currency is always 1",
+			from:         "BRL",
+			to:           "brl",
+			expectedRate: 1,
+		},
+		{
+			name:         "Direct pair",
+			from:         "USD",
+			to:           "BRL",
+			expectedRate: 5.0,
+		},
+		{
+			name:         "Inverse pair",
+			from:         "BRL",
+			to:           "USD",
+			expectedRate: 0.2,
+		},
+		{
+			name:          "Pairs are not chained through a third currency",
+			from:          "EUR",
+			to:            "BRL",
+			expectedError: "no exchange rate available: EUR/BRL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := provider.Rate(tt.from, tt.to)
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				assert.ErrorIs(t, err, fx.ErrRateNotFound)
+			} else {
+				assert.NoError(t, err)
+				assert.InDelta(t, tt.expectedRate, rate, 1e-9)
+			}
+		})
+	}
+}
+
+func TestStaticRateProviderSetRate(t *testing.T) {
+	provider := fx.NewStaticRateProvider(nil)
+
+	_, err := provider.Rate("USD", "BRL")
+	assert.ErrorIs(t, err, fx.ErrRateNotFound)
+
+	provider.SetRate("USD", "BRL", 5.42)
+	rate, err := provider.Rate("USD", "BRL")
+	assert.NoError(t, err)
+	assert.Equal(t, 5.42, rate)
+}
+
+func TestLoadStaticRateProvider(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name          string
+		contents      string
+		expectedError string
+	}{
+		{
+			name:     "Valid rates file",
+			contents: `{"USD/BRL": 5.42}`,
+		},
+		{
+			name:          "Malformed rates file",
+			contents:      `{"USD/BRL": }`,
+			expectedError: "failed to parse fx rates file: invalid character '}' looking for beginning of value",
+		},
+		{
+			name:          "Non positive rate",
+			contents:      `{"USD/BRL": 0}`,
+			expectedError: "invalid fx rate 0.000000 for USD/BRL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "rates.json")
+			assert.NoError(t, os.WriteFile(path, []byte(tt.contents), 0o600))
+
+			provider, err := fx.LoadStaticRateProvider(path)
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			rate, err := provider.Rate("USD", "BRL")
+			assert.NoError(t, err)
+			assert.Equal(t, 5.42, rate)
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	assert.Equal(t, 54.2, fx.Convert(10, 5.42))
+	assert.Equal(t, -18.45, fx.Convert(-3.4, 5.4271))
+	assert.Equal(t, 0.0, fx.Convert(0.001, 1.0))
+}
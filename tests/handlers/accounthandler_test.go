@@ -5,13 +5,19 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"pismo/auth"
 	"pismo/handlers"
 	"pismo/mocks"
 	"pismo/models"
+	"pismo/services"
+	"pismo/store"
+	"pismo/validation"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -19,13 +25,28 @@ import (
 
 func TestHandleGetAccount(t *testing.T) {
 	mockService := new(mocks.MockAccountService)
-	handler := handlers.NewAccountHandler(mockService)
+	handler := handlers.NewAccountHandler(mockService, auth.NewPolicy())
 
-	validResponse := models.Account{ID: 1, DocumentNumber: "123456789"}
+	createdAt := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+	validResponse := models.Account{
+		ID:             1,
+		PublicID:       accountRef(1),
+		DocumentNumber: "123456789",
+		DocumentType:   "CPF",
+		HolderName:     "Maria Silva",
+		Country:        "BR",
+		ProductID:      1,
+		Currency:       "BRL",
+		Status:         models.AccountActive,
+		Version:        3,
+		CreatedAt:      createdAt,
+		UpdatedAt:      createdAt,
+	}
 
 	tests := []struct {
 		name           string
 		accountID      string
+		noLegacyIDs    bool
 		mockResponse   models.Account
 		mockCalls      func()
 		expectedStatus int
@@ -39,12 +60,20 @@ func TestHandleGetAccount(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   "Invalid account ID: abc\n",
 		},
+		{
+			name:           "Public id of a transaction is not an account ID",
+			accountID:      "txn_01J8Z3V4W5X6Y7Z8A9B0C1D201",
+			mockResponse:   models.Account{},
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid account ID: txn_01J8Z3V4W5X6Y7Z8A9B0C1D201\n",
+		},
 		{
 			name:         "Account does not exist",
 			accountID:    "2",
 			mockResponse: models.Account{},
 			mockCalls: func() {
-				mockService.On("GetAccountByID", 2).Return(models.Account{}, sql.ErrNoRows)
+				mockService.On("GetAccountByRef", models.PublicID("2")).Return(models.Account{}, sql.ErrNoRows)
 			},
 			expectedStatus: http.StatusNotFound,
 			expectedBody:   "Account not found\n",
@@ -54,20 +83,37 @@ func TestHandleGetAccount(t *testing.T) {
 			accountID:    "2",
 			mockResponse: models.Account{},
 			mockCalls: func() {
-				mockService.On("GetAccountByID", 2).Return(models.Account{}, errors.New("some db error"))
+				mockService.On("GetAccountByRef", models.PublicID("2")).Return(models.Account{}, errors.New("some db error"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   "some db error\n",
 		},
 		{
 			name:         "Happy path: Successfully fetch an account",
+			accountID:    string(accountRef(1)),
+			mockResponse: validResponse,
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", accountRef(1)).Return(validResponse, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:         "Happy path: Fetch an account by its internal ID",
 			accountID:    "1",
 			mockResponse: validResponse,
 			mockCalls: func() {
-				mockService.On("GetAccountByID", 1).Return(validResponse, nil)
+				mockService.On("GetAccountByRef", models.PublicID("1")).Return(validResponse, nil)
 			},
 			expectedStatus: http.StatusOK,
-			expectedBody:   `{"id":1,"document_number":"123456789"}`,
+		},
+		{
+			name:           "Internal ID refused once legacy IDs are turned off",
+			accountID:      "1",
+			noLegacyIDs:    true,
+			mockResponse:   models.Account{},
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid account ID: 1\n",
 		},
 	}
 
@@ -75,8 +121,12 @@ func TestHandleGetAccount(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockService.ExpectedCalls = nil // clear any previous expectations
 			tt.mockCalls()
+			if tt.noLegacyIDs {
+				withoutLegacyIDs(t)
+			}
 
 			req := httptest.NewRequest(http.MethodGet, "/accounts/"+tt.accountID, nil)
+			req = asPrincipal(req, adminPrincipal)
 			req = mux.SetURLVars(req, map[string]string{"id": tt.accountID})
 
 			rr := httptest.NewRecorder()
@@ -88,7 +138,10 @@ func TestHandleGetAccount(t *testing.T) {
 				var accountResponse models.Account
 				err := json.NewDecoder(rr.Body).Decode(&accountResponse)
 				assert.NoError(t, err)
-				assert.Equal(t, tt.mockResponse, accountResponse)
+				expected := tt.mockResponse
+				expected.ID = 0 // the internal ID is never sent
+				assert.Equal(t, expected, accountResponse)
+				assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
 			} else {
 				assert.Equal(t, tt.expectedBody, rr.Body.String())
 			}
@@ -98,14 +151,327 @@ func TestHandleGetAccount(t *testing.T) {
 	}
 }
 
+func TestHandleGetAccountBalance(t *testing.T) {
+	mockService := new(mocks.MockAccountService)
+	handler := handlers.NewAccountHandler(mockService, auth.NewPolicy())
+
+	asOf := time.Date(2024, 9, 17, 12, 0, 0, 0, time.UTC)
+	balance := models.AccountBalance{
+		AccountID: 1,
+		AsOf:      asOf,
+		Balance:   -50,
+		OpenDebt:  50,
+		Transactions: []models.TransactionBalance{
+			{TransactionID: 1, PublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D201", OperationTypeID: 1, EventDate: asOf.Add(-time.Hour), Amount: -50, Balance: -50},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		principal      auth.Principal
+		accountID      string
+		query          string
+		mockCalls      func()
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Invalid as_of",
+			principal:      supportPrincipal,
+			accountID:      "1",
+			query:          "?as_of=yesterday",
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid as_of, must be an RFC 3339 timestamp: yesterday\n",
+		},
+		{
+			name:      "Account does not exist",
+			principal: supportPrincipal,
+			accountID: "2",
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", models.PublicID("2")).Return(models.Account{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "Account not found\n",
+		},
+		{
+			name:      "Partner cannot read another tenant's balance",
+			principal: partnerPrincipal,
+			accountID: "1",
+			query:     "?as_of=2024-09-17T12:00:00Z",
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", models.PublicID("1")).Return(models.Account{ID: 1, PublicID: accountRef(1), TenantID: "globex"}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: partner-1 cannot access account acc_01J8Z3V4W5X6Y7Z8A9B0C1D201"}` + "\n",
+		},
+		{
+			name:      "Happy path: Balance as of a point in time",
+			principal: supportPrincipal,
+			accountID: string(accountRef(1)),
+			query:     "?as_of=2024-09-17T12:00:00Z",
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", accountRef(1)).Return(models.Account{ID: 1, PublicID: accountRef(1)}, nil)
+				mockService.On("GetAccountBalance", int64(1), asOf).Return(balance, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"account_id":"acc_01J8Z3V4W5X6Y7Z8A9B0C1D201","as_of":"2024-09-17T12:00:00Z","balance":-50,"open_debt":50,"open_credit":0,` +
+				`"transactions":[{"transaction_id":"txn_01J8Z3V4W5X6Y7Z8A9B0C1D201","operation_type_id":1,"event_date":"2024-09-17T11:00:00Z","amount":-50,"balance":-50}]}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.ExpectedCalls = nil // clear any previous expectations
+			tt.mockCalls()
+
+			req := httptest.NewRequest(http.MethodGet, "/accounts/"+tt.accountID+"/balance"+tt.query, nil)
+			req = asPrincipal(req, tt.principal)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.accountID})
+
+			rr := httptest.NewRecorder()
+			handler.HandleGetAccountBalance(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleGetAccountTotals(t *testing.T) {
+	mockService := new(mocks.MockAccountService)
+	handler := handlers.NewAccountHandler(mockService, auth.NewPolicy())
+
+	updatedAt := time.Date(2024, 9, 17, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		principal      auth.Principal
+		accountID      string
+		mockCalls      func()
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Invalid account ID",
+			principal:      supportPrincipal,
+			accountID:      "abc",
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid account ID: abc\n",
+		},
+		{
+			name:      "Account does not exist",
+			principal: supportPrincipal,
+			accountID: "2",
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", models.PublicID("2")).Return(models.Account{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "Account not found\n",
+		},
+		{
+			name:      "Partner cannot read another tenant's totals",
+			principal: partnerPrincipal,
+			accountID: "1",
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", models.PublicID("1")).Return(models.Account{ID: 1, PublicID: accountRef(1), TenantID: "globex"}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: partner-1 cannot access account acc_01J8Z3V4W5X6Y7Z8A9B0C1D201"}` + "\n",
+		},
+		{
+			name:      "Happy path: Running totals",
+			principal: supportPrincipal,
+			accountID: string(accountRef(1)),
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", accountRef(1)).Return(models.Account{ID: 1, PublicID: accountRef(1)}, nil)
+				mockService.On("GetAccountTotals", int64(1)).Return(models.AccountTotals{AccountID: 1, TotalDebt: 140, AvailableLimit: 860, Version: 7, UpdatedAt: updatedAt}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"account_id":"acc_01J8Z3V4W5X6Y7Z8A9B0C1D201","total_debt":140,"total_credit":0,"available_limit":860,"version":7,"updated_at":"2024-09-17T12:00:00Z"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.ExpectedCalls = nil // clear any previous expectations
+			tt.mockCalls()
+
+			req := httptest.NewRequest(http.MethodGet, "/accounts/"+tt.accountID+"/totals", nil)
+			req = asPrincipal(req, tt.principal)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.accountID})
+
+			rr := httptest.NewRecorder()
+			handler.HandleGetAccountTotals(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleUpdateAccount(t *testing.T) {
+	mockService := new(mocks.MockAccountService)
+	handler := handlers.NewAccountHandler(mockService, auth.NewPolicy())
+
+	current := models.Account{ID: 1, PublicID: accountRef(1), HolderName: "Maria Silva", Status: models.AccountActive, TenantID: "acme", Version: 3}
+	limit := 2500.0
+	blocked := models.AccountBlocked
+
+	tests := []struct {
+		name           string
+		principal      auth.Principal
+		ifMatch        string
+		requestBody    string
+		mockCalls      func()
+		expectedStatus int
+		expectedETag   string
+		expectedBody   string
+	}{
+		{
+			name:           "If-Match is required",
+			principal:      adminPrincipal,
+			requestBody:    `{"status":"BLOCKED"}`,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusPreconditionRequired,
+			expectedBody:   "If-Match with the ETag of the version being updated is required\n",
+		},
+		{
+			name:           "Weak ETags never match",
+			principal:      adminPrincipal,
+			ifMatch:        `W/"3"`,
+			requestBody:    `{"status":"BLOCKED"}`,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedBody:   "If-Match doesn't match the current version\n",
+		},
+		{
+			name:           "Fields that can't be changed are rejected",
+			principal:      adminPrincipal,
+			ifMatch:        `"3"`,
+			requestBody:    `{"document_number":"987654321"}`,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "document_number can't be changed, only holder_name, status and credit_limit can\n",
+		},
+		{
+			name:        "Stale version gets 412 and the current ETag",
+			principal:   adminPrincipal,
+			ifMatch:     `"2"`,
+			requestBody: `{"status":"BLOCKED"}`,
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", accountRef(1)).Return(current, nil)
+			},
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedETag:   `"3"`,
+			expectedBody:   "If-Match doesn't match the current version\n",
+		},
+		{
+			name:        "Lost the race to a concurrent update",
+			principal:   adminPrincipal,
+			ifMatch:     `"3"`,
+			requestBody: `{"status":"BLOCKED"}`,
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", accountRef(1)).Return(current, nil)
+				mockService.On("UpdateAccount", int64(1), models.AccountUpdate{Status: &blocked}, int64(3)).
+					Return(models.Account{}, fmt.Errorf("%w: account 1 is no longer at version 3", store.ErrVersionMismatch))
+			},
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedBody:   "If-Match doesn't match the current version\n",
+		},
+		{
+			name:        "Invalid status",
+			principal:   adminPrincipal,
+			ifMatch:     `"3"`,
+			requestBody: `{"status":"FROZEN"}`,
+			mockCalls: func() {
+				frozen := "FROZEN"
+				mockService.On("GetAccountByRef", accountRef(1)).Return(current, nil)
+				mockService.On("UpdateAccount", int64(1), models.AccountUpdate{Status: &frozen}, int64(3)).
+					Return(models.Account{}, fmt.Errorf("%w: unknown status FROZEN", services.ErrInvalidAccountUpdate))
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid account update: unknown status FROZEN\n",
+		},
+		{
+			name:        "Partners can't update accounts of other tenants",
+			principal:   partnerPrincipal,
+			ifMatch:     `"3"`,
+			requestBody: `{"status":"BLOCKED"}`,
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", accountRef(1)).Return(models.Account{ID: 1, PublicID: accountRef(1), TenantID: "globex", Version: 3}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: partner-1 cannot access account acc_01J8Z3V4W5X6Y7Z8A9B0C1D201"}` + "\n",
+		},
+		{
+			name:        "Happy path: Credit limit changed",
+			principal:   partnerPrincipal,
+			ifMatch:     `"3"`,
+			requestBody: `{"credit_limit":2500}`,
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", accountRef(1)).Return(current, nil)
+				mockService.On("UpdateAccount", int64(1), models.AccountUpdate{CreditLimit: &limit}, int64(3)).
+					Return(models.Account{ID: 1, PublicID: accountRef(1), HolderName: "Maria Silva", Status: models.AccountActive, TenantID: "acme", CreditLimit: &limit, Version: 4}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedETag:   `"4"`,
+			expectedBody:   `{"account_id":"acc_01J8Z3V4W5X6Y7Z8A9B0C1D201","document_number":"","document_type":"","holder_name":"Maria Silva","country":"","product_id":0,"currency":"","tenant_id":"acme","status":"ACTIVE","credit_limit":2500,"version":4,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}` + "\n",
+		},
+		{
+			name:        "Null credit limit goes back to the product's",
+			principal:   adminPrincipal,
+			ifMatch:     `"3"`,
+			requestBody: `{"credit_limit":null}`,
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", accountRef(1)).Return(current, nil)
+				mockService.On("UpdateAccount", int64(1), models.AccountUpdate{ClearCreditLimit: true}, int64(3)).
+					Return(models.Account{ID: 1, PublicID: accountRef(1), HolderName: "Maria Silva", Status: models.AccountActive, TenantID: "acme", Version: 4}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedETag:   `"4"`,
+			expectedBody:   `{"account_id":"acc_01J8Z3V4W5X6Y7Z8A9B0C1D201","document_number":"","document_type":"","holder_name":"Maria Silva","country":"","product_id":0,"currency":"","tenant_id":"acme","status":"ACTIVE","version":4,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.ExpectedCalls = nil // clear any previous expectations
+			tt.mockCalls()
+
+			req := httptest.NewRequest(http.MethodPatch, "/accounts/"+string(accountRef(1)), bytes.NewBufferString(tt.requestBody))
+			req = asPrincipal(req, tt.principal)
+			req = mux.SetURLVars(req, map[string]string{"id": string(accountRef(1))})
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.HandleUpdateAccount(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedETag, rr.Header().Get("ETag"))
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
 func TestHandleCreateAccount(t *testing.T) {
 	mockService := new(mocks.MockAccountService)
-	handler := handlers.NewAccountHandler(mockService)
+	handler := handlers.NewAccountHandler(mockService, auth.NewPolicy())
 
 	tests := []struct {
 		name           string
+		principal      *auth.Principal
 		requestBody    string
-		mockResponse   int64
+		mockResponse   models.Account
 		mockError      error
 		expectedStatus int
 		expectedBody   string
@@ -126,28 +492,136 @@ func TestHandleCreateAccount(t *testing.T) {
 			mockCalls:      func() {},
 		},
 		{
-			name:           "Non stringified int for document number provided",
-			requestBody:    `{"document_number": "abc123"}`,
+			name:           "No holder name provided",
+			requestBody:    `{"document_number": "123456789"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "No holder name provided\n",
+			mockCalls:      func() {},
+		},
+		{
+			name:           "Document number rejected by the service",
+			requestBody:    `{"document_number": "abc123", "holder_name": "Maria Silva"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid document number: unexpected character 'a'\n",
+			mockCalls: func() {
+				mockService.On("CreateAccount", models.Account{DocumentNumber: "abc123", HolderName: "Maria Silva"}).
+					Return(models.Account{}, fmt.Errorf("%w: unexpected character 'a'", validation.ErrInvalidDocument))
+			},
+		},
+		{
+			name:           "Unknown document type provided",
+			requestBody:    `{"document_number": "123456789", "document_type": "SSN", "holder_name": "Maria Silva"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "unsupported document type: SSN\n",
+			mockCalls: func() {
+				mockService.On("CreateAccount", models.Account{DocumentNumber: "123456789", DocumentType: "SSN", HolderName: "Maria Silva"}).
+					Return(models.Account{}, fmt.Errorf("%w: SSN", validation.ErrUnsupportedDocumentType))
+			},
+		},
+		{
+			name:           "Invalid country provided",
+			requestBody:    `{"document_number": "123456789", "holder_name": "Maria Silva", "country": "BRA"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid country, must be an ISO 3166 alpha-2 code: BRA\n",
+			mockCalls:      func() {},
+		},
+		{
+			name:           "Invalid currency provided",
+			requestBody:    `{"document_number": "123456789", "holder_name": "Maria Silva", "currency": "R$"}`,
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   "Invalid document number in payload, must be of type int/long: abc123\n",
+			expectedBody:   "Invalid currency, must be an ISO 4217 code: R$\n",
 			mockCalls:      func() {},
 		},
+		{
+			name:           "Product does not exist",
+			requestBody:    `{"document_number": "123456789", "holder_name": "Maria Silva", "product_id": 9}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "account product not found\n",
+			mockCalls: func() {
+				mockService.On("CreateAccount", models.Account{DocumentNumber: "123456789", HolderName: "Maria Silva", ProductID: 9}).
+					Return(models.Account{}, services.ErrProductNotFound)
+			},
+		},
+		{
+			name:           "Account with the same document number already exists",
+			requestBody:    `{"document_number": "123456789", "holder_name": "Maria Silva"}`,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"error":"an account with that document number already exists","account_id":"acc_01J8Z3V4W5X6Y7Z8A9B0C1D207"}` + "\n",
+			mockCalls: func() {
+				mockService.On("CreateAccount", models.Account{DocumentNumber: "123456789", HolderName: "Maria Silva"}).
+					Return(models.Account{}, &store.ConflictError{Entity: "account", Key: "document number", ExistingID: 7, ExistingPublicID: accountRef(7)})
+				mockService.On("GetAccountByID", int64(7)).Return(models.Account{ID: 7, PublicID: accountRef(7), TenantID: "globex"}, nil)
+			},
+		},
+		{
+			name:           "Partner gets the existing account of its own tenant",
+			principal:      &partnerPrincipal,
+			requestBody:    `{"document_number": "123456789", "holder_name": "Maria Silva"}`,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"error":"an account with that document number already exists","account_id":"acc_01J8Z3V4W5X6Y7Z8A9B0C1D207"}` + "\n",
+			mockCalls: func() {
+				mockService.On("CreateAccount", models.Account{DocumentNumber: "123456789", HolderName: "Maria Silva", TenantID: "acme"}).
+					Return(models.Account{}, &store.ConflictError{Entity: "account", Key: "document number", ExistingID: 7, ExistingPublicID: accountRef(7)})
+				mockService.On("GetAccountByID", int64(7)).Return(models.Account{ID: 7, PublicID: accountRef(7), TenantID: "acme"}, nil)
+			},
+		},
+		{
+			name:           "Partner is not told the account of another tenant",
+			principal:      &partnerPrincipal,
+			requestBody:    `{"document_number": "123456789", "holder_name": "Maria Silva"}`,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"error":"an account with that document number already exists"}` + "\n",
+			mockCalls: func() {
+				mockService.On("CreateAccount", models.Account{DocumentNumber: "123456789", HolderName: "Maria Silva", TenantID: "acme"}).
+					Return(models.Account{}, &store.ConflictError{Entity: "account", Key: "document number", ExistingID: 7, ExistingPublicID: accountRef(7)})
+				mockService.On("GetAccountByID", int64(7)).Return(models.Account{ID: 7, PublicID: accountRef(7), TenantID: "globex"}, nil)
+			},
+		},
+		{
+			name:           "Existing account can't be loaded",
+			requestBody:    `{"document_number": "123456789", "holder_name": "Maria Silva"}`,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"error":"an account with that document number already exists"}` + "\n",
+			mockCalls: func() {
+				mockService.On("CreateAccount", models.Account{DocumentNumber: "123456789", HolderName: "Maria Silva"}).
+					Return(models.Account{}, &store.ConflictError{Entity: "account", Key: "document number", ExistingID: 7, ExistingPublicID: accountRef(7)})
+				mockService.On("GetAccountByID", int64(7)).Return(models.Account{}, errors.New("some db error"))
+			},
+		},
 		{
 			name:           "Db error",
-			requestBody:    `{"document_number": "123456789"}`,
+			requestBody:    `{"document_number": "123456789", "holder_name": "Maria Silva"}`,
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   "some db error\n",
 			mockCalls: func() {
-				mockService.On("CreateAccount", "123456789").Return(int64(0), errors.New("some db error"))
+				mockService.On("CreateAccount", models.Account{DocumentNumber: "123456789", HolderName: "Maria Silva"}).
+					Return(models.Account{}, errors.New("some db error"))
+			},
+		},
+		{
+			name:           "Happy path: Passport holder with alphanumeric document number",
+			requestBody:    `{"document_number": "X1234567", "document_type": "PASSPORT", "holder_name": "John Doe", "country": "US", "currency": "USD", "product_id": 2}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "\"successfully created new account with ID acc_01J8Z3V4W5X6Y7Z8A9B0C1D202\"\n",
+			mockCalls: func() {
+				mockService.On("CreateAccount", models.Account{
+					DocumentNumber: "X1234567",
+					DocumentType:   "PASSPORT",
+					HolderName:     "John Doe",
+					Country:        "US",
+					ProductID:      2,
+					Currency:       "USD",
+				}).Return(models.Account{ID: 2, PublicID: accountRef(2)}, nil)
 			},
 		},
 		{
 			name:           "Happy path: Account successfully created",
-			requestBody:    `{"document_number": "123456789"}`,
+			requestBody:    `{"document_number": "123456789", "holder_name": "Maria Silva"}`,
 			expectedStatus: http.StatusOK,
-			expectedBody:   "\"successfully created new account with ID 1\"\n",
+			expectedBody:   "\"successfully created new account with ID acc_01J8Z3V4W5X6Y7Z8A9B0C1D201\"\n",
 			mockCalls: func() {
-				mockService.On("CreateAccount", "123456789").Return(int64(1), nil)
+				mockService.On("CreateAccount", models.Account{DocumentNumber: "123456789", HolderName: "Maria Silva"}).
+					Return(models.Account{ID: 1, PublicID: accountRef(1)}, nil)
 			},
 		},
 	}
@@ -157,7 +631,12 @@ func TestHandleCreateAccount(t *testing.T) {
 			mockService.ExpectedCalls = nil // clear any previous expectations
 			tt.mockCalls()
 
+			principal := adminPrincipal
+			if tt.principal != nil {
+				principal = *tt.principal
+			}
 			req := httptest.NewRequest(http.MethodPost, "/accounts", bytes.NewBufferString(tt.requestBody))
+			req = asPrincipal(req, principal)
 			req.Header.Set("Content-Type", "application/json")
 
 			rr := httptest.NewRecorder()
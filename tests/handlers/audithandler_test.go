@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/auth"
+	"pismo/handlers"
+	"pismo/mocks"
+	"pismo/models"
+	"pismo/services"
+)
+
+func TestHandleListAuditEntries(t *testing.T) {
+	mockService := new(mocks.MockAuditService)
+	handler := handlers.NewAuditHandler(mockService, auth.NewPolicy())
+
+	occurredAt := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+	entry := models.AuditEntry{ID: 3, ChainID: 1, OccurredAt: occurredAt, Kind: models.AuditKindRequest, PrincipalID: "pk_dev", Method: "POST", Route: "/transactions", AccountID: 1, Outcome: 200, PrevHash: "aa", Hash: "bb"}
+
+	tests := []struct {
+		name           string
+		principal      auth.Principal
+		query          string
+		mockCalls      func()
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Partners cannot read the audit log",
+			principal:      partnerPrincipal,
+			query:          "",
+			mockCalls:      func() {},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: partner-1 is not allowed to audit_log:read"}` + "\n",
+		},
+		{
+			name:           "Invalid from",
+			principal:      supportPrincipal,
+			query:          "?from=yesterday",
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid from, must be an RFC 3339 timestamp: yesterday\n",
+		},
+		{
+			name:      "Happy path: Entries for an account within a time range",
+			principal: supportPrincipal,
+			query:     "?account_id=1&from=2024-09-01T00:00:00Z&to=2024-10-01T00:00:00Z&limit=50",
+			mockCalls: func() {
+				mockService.On("ListEntries", models.AuditFilter{
+					AccountID: 1,
+					From:      time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC),
+					To:        time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+					Limit:     50,
+				}).Return([]models.AuditEntry{entry}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"audit_id":3,"chain_id":1,"occurred_at":"2024-09-17T15:04:05Z","kind":"request","principal_id":"pk_dev","method":"POST","route":"/transactions","account_id":1,"outcome":200,"prev_hash":"aa","hash":"bb"}]` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.ExpectedCalls = nil // clear any previous expectations
+			tt.mockCalls()
+
+			req := httptest.NewRequest(http.MethodGet, "/audit-log"+tt.query, nil)
+			req = asPrincipal(req, tt.principal)
+			rr := httptest.NewRecorder()
+
+			handler.HandleListAuditEntries(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleVerifyAuditLog(t *testing.T) {
+	mockService := new(mocks.MockAuditService)
+	handler := handlers.NewAuditHandler(mockService, auth.NewPolicy())
+
+	// support may read entries but walking the whole chain is for admins
+	rr := httptest.NewRecorder()
+	handler.HandleVerifyAuditLog(rr, asPrincipal(httptest.NewRequest(http.MethodGet, "/audit-log/verify", nil), supportPrincipal))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	mockService.On("VerifyChain").Return(services.AuditVerification{Valid: true, Entries: 2, Chains: 1}, nil)
+	rr = httptest.NewRecorder()
+	handler.HandleVerifyAuditLog(rr, asPrincipal(httptest.NewRequest(http.MethodGet, "/audit-log/verify", nil), adminPrincipal))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"valid":true,"entries":2,"chains":1}`+"\n", rr.Body.String())
+	mockService.AssertExpectations(t)
+}
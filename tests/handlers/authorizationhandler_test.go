@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/auth"
+	"pismo/handlers"
+	"pismo/mocks"
+	"pismo/models"
+	"pismo/services"
+)
+
+func TestHandleCreateAuthorization(t *testing.T) {
+	mockService := new(mocks.MockAuthorizationService)
+	mockAccounts := new(mocks.MockAccountService)
+	handler := handlers.NewAuthorizationHandler(mockService, mockAccounts, auth.NewPolicy())
+
+	tests := []struct {
+		name           string
+		requestBody    string
+		mockCalls      func()
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Invalid request payload",
+			requestBody:    `{`,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "unexpected EOF\n",
+		},
+		{
+			name:           "No account ID provided",
+			requestBody:    `{"operation_type_id": 1, "amount": -50}`,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "No Account ID provided\n",
+		},
+		{
+			name:           "Invalid account ID",
+			requestBody:    `{"account_id": "acc_1", "operation_type_id": 1, "amount": -50}`,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid account ID: acc_1\n",
+		},
+		{
+			name:           "No amount provided",
+			requestBody:    `{"account_id": 1, "operation_type_id": 1}`,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "No Amount provided\n",
+		},
+		{
+			name:        "Insufficient credit",
+			requestBody: `{"account_id": 1, "operation_type_id": 1, "amount": -5000}`,
+			mockCalls: func() {
+				mockService.On("Authorize", models.Authorization{AccountID: 1, AccountPublicID: "1", OperationTypeID: 1, Amount: -5000}).
+					Return(models.Authorization{}, fmt.Errorf("%w: requested 5000.00, available 100.00", services.ErrInsufficientCredit))
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedBody:   "insufficient available credit: requested 5000.00, available 100.00\n",
+		},
+		{
+			name:        "Happy path: Hold created",
+			requestBody: `{"account_id": "acc_01J8Z3V4W5X6Y7Z8A9B0C1D201", "operation_type_id": 1, "amount": -50}`,
+			mockCalls: func() {
+				mockAccounts.On("GetAccountByRef", accountRef(1)).Return(models.Account{ID: 1, PublicID: accountRef(1)}, nil)
+				mockService.On("Authorize", models.Authorization{AccountID: 1, AccountPublicID: accountRef(1), OperationTypeID: 1, Amount: -50}).
+					Return(models.Authorization{ID: 7, PublicID: holdRef(7), AccountID: 1, AccountPublicID: accountRef(1), OperationTypeID: 1, Amount: -50, Status: "PENDING", Version: 1}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"authorization_id":"auth_01J8Z3V4W5X6Y7Z8A9B0C1D207","account_id":"acc_01J8Z3V4W5X6Y7Z8A9B0C1D201","operation_type_id":1,"amount":-50,"currency":"","original_amount":0,"original_currency":"","fx_rate":0,"status":"PENDING","expires_at":"0001-01-01T00:00:00Z","version":1,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.ExpectedCalls = nil // clear any previous expectations
+			mockAccounts.ExpectedCalls = nil
+			tt.mockCalls()
+
+			req := httptest.NewRequest(http.MethodPost, "/authorizations", bytes.NewBufferString(tt.requestBody))
+			req = asPrincipal(req, adminPrincipal)
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			handler.HandleCreateAuthorization(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+
+			mockService.AssertExpectations(t)
+			mockAccounts.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleCaptureAndVoidAuthorization(t *testing.T) {
+	mockService := new(mocks.MockAuthorizationService)
+	handler := handlers.NewAuthorizationHandler(mockService, new(mocks.MockAccountService), auth.NewPolicy())
+
+	pending := models.Authorization{ID: 3, PublicID: holdRef(3), AccountID: 1, AccountPublicID: accountRef(1), Status: models.AuthorizationPending}
+
+	tests := []struct {
+		name           string
+		authorization  string
+		requestBody    string
+		chunked        bool
+		noLegacyIDs    bool
+		handle         func(http.ResponseWriter, *http.Request)
+		mockCalls      func()
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Invalid authorization ID",
+			authorization:  "abc",
+			handle:         handler.HandleCaptureAuthorization,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid authorization ID: abc\n",
+		},
+		{
+			name:           "Public id of an account is not an authorization ID",
+			authorization:  string(accountRef(3)),
+			handle:         handler.HandleCaptureAuthorization,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid authorization ID: acc_01J8Z3V4W5X6Y7Z8A9B0C1D203\n",
+		},
+		{
+			name:          "Void a hold by its internal ID",
+			authorization: "3",
+			handle:        handler.HandleVoidAuthorization,
+			mockCalls: func() {
+				mockService.On("GetAuthorizationByRef", models.PublicID("3")).Return(pending, nil)
+				mockService.On("Void", int64(3)).Return(models.Authorization{ID: 3, PublicID: holdRef(3), Status: "VOIDED"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Internal ID refused once legacy IDs are turned off",
+			authorization:  "3",
+			noLegacyIDs:    true,
+			handle:         handler.HandleVoidAuthorization,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid authorization ID: 3\n",
+		},
+		{
+			name:          "Capture without a body captures the full hold",
+			authorization: string(holdRef(3)),
+			handle:        handler.HandleCaptureAuthorization,
+			mockCalls: func() {
+				mockService.On("GetAuthorizationByRef", holdRef(3)).Return(pending, nil)
+				mockService.On("Capture", int64(3), 0.0).Return(models.Authorization{ID: 3, Status: "CAPTURED", TransactionID: 12, TransactionPublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D212"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:          "Capture with an empty chunked body captures the full hold",
+			authorization: string(holdRef(3)),
+			chunked:       true,
+			handle:        handler.HandleCaptureAuthorization,
+			mockCalls: func() {
+				mockService.On("GetAuthorizationByRef", holdRef(3)).Return(pending, nil)
+				mockService.On("Capture", int64(3), 0.0).Return(models.Authorization{ID: 3, Status: "CAPTURED", TransactionID: 12, TransactionPublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D212"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:          "Capture a different amount in a chunked body",
+			authorization: string(holdRef(3)),
+			requestBody:   `{"amount": -8.5}`,
+			chunked:       true,
+			handle:        handler.HandleCaptureAuthorization,
+			mockCalls: func() {
+				mockService.On("GetAuthorizationByRef", holdRef(3)).Return(pending, nil)
+				mockService.On("Capture", int64(3), -8.5).Return(models.Authorization{ID: 3, Status: "CAPTURED", TransactionID: 13, TransactionPublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D213"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:          "Capture body too large",
+			authorization: string(holdRef(3)),
+			requestBody:   `{"amount": -8.5, "note": "` + strings.Repeat("x", 2048) + `"}`,
+			chunked:       true,
+			handle:        handler.HandleCaptureAuthorization,
+			mockCalls: func() {
+				mockService.On("GetAuthorizationByRef", holdRef(3)).Return(pending, nil)
+			},
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:          "Capture a different amount",
+			authorization: string(holdRef(3)),
+			requestBody:   `{"amount": -8.5}`,
+			handle:        handler.HandleCaptureAuthorization,
+			mockCalls: func() {
+				mockService.On("GetAuthorizationByRef", holdRef(3)).Return(pending, nil)
+				mockService.On("Capture", int64(3), -8.5).Return(models.Authorization{ID: 3, Status: "CAPTURED", TransactionID: 13, TransactionPublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D213"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:          "Capture an expired hold",
+			authorization: string(holdRef(3)),
+			handle:        handler.HandleCaptureAuthorization,
+			mockCalls: func() {
+				mockService.On("GetAuthorizationByRef", holdRef(3)).Return(pending, nil)
+				mockService.On("Capture", int64(3), 0.0).Return(models.Authorization{}, services.ErrAuthorizationExpired)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedBody:   "authorization has expired\n",
+		},
+		{
+			name:          "Capture more than the hold",
+			authorization: string(holdRef(3)),
+			requestBody:   `{"amount": -12}`,
+			handle:        handler.HandleCaptureAuthorization,
+			mockCalls: func() {
+				mockService.On("GetAuthorizationByRef", holdRef(3)).Return(pending, nil)
+				mockService.On("Capture", int64(3), -12.0).Return(models.Authorization{}, fmt.Errorf("%w: 12.00 is more than the 10.00 held", services.ErrInvalidCapture))
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid capture amount: 12.00 is more than the 10.00 held\n",
+		},
+		{
+			name:          "Void unknown authorization",
+			authorization: string(holdRef(3)),
+			handle:        handler.HandleVoidAuthorization,
+			mockCalls: func() {
+				mockService.On("GetAuthorizationByRef", holdRef(3)).Return(models.Authorization{}, services.ErrAuthorizationNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "Authorization not found\n",
+		},
+		{
+			name:          "Void database error",
+			authorization: string(holdRef(3)),
+			handle:        handler.HandleVoidAuthorization,
+			mockCalls: func() {
+				mockService.On("GetAuthorizationByRef", holdRef(3)).Return(pending, nil)
+				mockService.On("Void", int64(3)).Return(models.Authorization{}, errors.New("some db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   "some db error\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.ExpectedCalls = nil // clear any previous expectations
+			tt.mockCalls()
+			if tt.noLegacyIDs {
+				withoutLegacyIDs(t)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/authorizations/"+tt.authorization, bytes.NewBufferString(tt.requestBody))
+			if tt.chunked {
+				req.ContentLength = -1 // as the server sees a Transfer-Encoding: chunked request
+			}
+			req = asPrincipal(req, adminPrincipal)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.authorization})
+			rr := httptest.NewRecorder()
+
+			tt.handle(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
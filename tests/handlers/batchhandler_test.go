@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/auth"
+	"pismo/handlers"
+	"pismo/mocks"
+	"pismo/models"
+)
+
+func TestHandleCreateTransactionBatch(t *testing.T) {
+	mockService := new(mocks.MockBatchService)
+	mockAccounts := new(mocks.MockAccountService)
+	handler := handlers.NewBatchHandler(mockService, mockAccounts, auth.NewPolicy())
+
+	report := models.BatchReport{Total: 1, Created: 1, Results: []models.BatchResult{{Row: 1, AccountPublicID: accountRef(1), Status: models.BatchRowCreated, TransactionPublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D207"}}}
+
+	tests := []struct {
+		name           string
+		principal      auth.Principal
+		contentType    string
+		query          string
+		requestBody    string
+		mockCalls      func()
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Support cannot post transactions",
+			principal:      supportPrincipal,
+			contentType:    "text/csv",
+			requestBody:    "account_id,operation_type_id,amount\n1,4,10\n",
+			mockCalls:      func() {},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: support-1 is not allowed to transaction:create"}` + "\n",
+		},
+		{
+			name:           "Unsupported content type",
+			principal:      adminPrincipal,
+			contentType:    "application/xml",
+			requestBody:    "<transactions/>",
+			mockCalls:      func() {},
+			expectedStatus: http.StatusUnsupportedMediaType,
+			expectedBody:   "unsupported batch format: application/xml\n",
+		},
+		{
+			name:           "Malformed CSV header",
+			principal:      adminPrincipal,
+			contentType:    "text/csv",
+			requestBody:    "account_id,amount\n1,10\n",
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "CSV header is missing the operation_type_id column\n",
+		},
+		{
+			name:        "Happy path: CSV upload",
+			principal:   adminPrincipal,
+			contentType: "text/csv",
+			requestBody: "account_id,operation_type_id,amount\n1,4,10\n",
+			mockCalls: func() {
+				mockService.On("ProcessBatch", []models.BatchRow{
+					{Row: 1, Transaction: models.Transaction{AccountPublicID: "1", OperationTypeID: 4, Amount: 10}},
+				}).Return(report)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"total":1,"created":1,"rejected":0,"failed":0,"results":[{"row":1,"account_id":"acc_01J8Z3V4W5X6Y7Z8A9B0C1D201","status":"created","transaction_id":"txn_01J8Z3V4W5X6Y7Z8A9B0C1D207"}]}` + "\n",
+		},
+		{
+			name:        "Happy path: format query parameter wins over the content type",
+			principal:   adminPrincipal,
+			contentType: "text/plain",
+			query:       "?format=jsonl",
+			requestBody: `{"account_id": 1, "operation_type_id": 4, "amount": 10}`,
+			mockCalls: func() {
+				mockService.On("ProcessBatch", []models.BatchRow{
+					{Row: 1, Transaction: models.Transaction{AccountPublicID: "1", OperationTypeID: 4, Amount: 10}},
+				}).Return(report)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"total":1,"created":1,"rejected":0,"failed":0,"results":[{"row":1,"account_id":"acc_01J8Z3V4W5X6Y7Z8A9B0C1D201","status":"created","transaction_id":"txn_01J8Z3V4W5X6Y7Z8A9B0C1D207"}]}` + "\n",
+		},
+		{
+			name:        "Partner rows for other tenants are rejected without posting",
+			principal:   partnerPrincipal,
+			contentType: "application/json",
+			requestBody: `[{"account_id": "acc_01J8Z3V4W5X6Y7Z8A9B0C1D201", "operation_type_id": 4, "amount": 10}, {"account_id": 2, "operation_type_id": 4, "amount": 10}, {"account_id": "acc_01J8Z3V4W5X6Y7Z8A9B0C1D201", "operation_type_id": 4, "amount": 5}]`,
+			mockCalls: func() {
+				mockAccounts.On("GetAccountByRef", accountRef(1)).Return(models.Account{ID: 1, PublicID: accountRef(1), TenantID: "acme"}, nil).Once()
+				mockAccounts.On("GetAccountByRef", models.PublicID("2")).Return(models.Account{ID: 2, PublicID: accountRef(2), TenantID: "globex"}, nil).Once()
+				mockService.On("ProcessBatch", []models.BatchRow{
+					{Row: 1, Transaction: models.Transaction{AccountPublicID: accountRef(1), OperationTypeID: 4, Amount: 10}},
+					{Row: 2, Transaction: models.Transaction{AccountPublicID: "2", OperationTypeID: 4, Amount: 10}, Error: "forbidden: partner-1 cannot access account acc_01J8Z3V4W5X6Y7Z8A9B0C1D202"},
+					{Row: 3, Transaction: models.Transaction{AccountPublicID: accountRef(1), OperationTypeID: 4, Amount: 5}},
+				}).Return(report)
+			},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.ExpectedCalls = nil // clear any previous expectations
+			mockAccounts.ExpectedCalls = nil
+			tt.mockCalls()
+
+			req := httptest.NewRequest(http.MethodPost, "/transactions/batch"+tt.query, bytes.NewBufferString(tt.requestBody))
+			req.Header.Set("Content-Type", tt.contentType)
+			req = asPrincipal(req, tt.principal)
+			rr := httptest.NewRecorder()
+
+			handler.HandleCreateTransactionBatch(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+
+			mockService.AssertExpectations(t)
+			mockAccounts.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/auth"
+	"pismo/handlers"
+	"pismo/mocks"
+	"pismo/models"
+)
+
+func TestHandleGetTrialBalance(t *testing.T) {
+	mockService := new(mocks.MockLedgerService)
+	handler := handlers.NewLedgerHandler(mockService, auth.NewPolicy())
+
+	tests := []struct {
+		name           string
+		principal      auth.Principal
+		mockCalls      func()
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Partners cannot read the ledger",
+			principal:      partnerPrincipal,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: partner-1 is not allowed to ledger:read"}` + "\n",
+		},
+		{
+			name:      "Database error",
+			principal: adminPrincipal,
+			mockCalls: func() {
+				mockService.On("TrialBalance").Return([]models.TrialBalance(nil), errors.New("some db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   "some db error\n",
+		},
+		{
+			name:      "Happy path: Trial balance per currency",
+			principal: supportPrincipal,
+			mockCalls: func() {
+				mockService.On("TrialBalance").Return([]models.TrialBalance{{
+					Currency: "BRL",
+					Lines: []models.TrialBalanceLine{
+						{Code: "customer:1", Type: models.LedgerAsset, AccountID: 1, Debits: 50, Balance: 50},
+						{Code: "settlement:BRL", Type: models.LedgerLiability, Credits: 50, Balance: -50},
+					},
+					TotalDebits:  50,
+					TotalCredits: 50,
+					Balanced:     true,
+				}}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `[{"currency":"BRL","lines":[{"code":"customer:1","type":"ASSET","account_id":1,"debits":50,"credits":0,"balance":50},` +
+				`{"code":"settlement:BRL","type":"LIABILITY","debits":0,"credits":50,"balance":-50}],"total_debits":50,"total_credits":50,"balanced":true}]` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.ExpectedCalls = nil // clear any previous expectations
+			tt.mockCalls()
+
+			req := httptest.NewRequest(http.MethodGet, "/ledger/trial-balance", nil)
+			req = asPrincipal(req, tt.principal)
+			rr := httptest.NewRecorder()
+
+			handler.HandleGetTrialBalance(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
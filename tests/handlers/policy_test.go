@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"pismo/auth"
+	"pismo/handlers"
+	"pismo/mocks"
+	"pismo/models"
+)
+
+var (
+	adminPrincipal   = auth.Principal{ID: "admin-1", Roles: []string{auth.RoleAdmin}, Method: auth.MethodAPIKey}
+	supportPrincipal = auth.Principal{ID: "support-1", Roles: []string{auth.RoleSupport}, Method: auth.MethodJWT}
+	partnerPrincipal = auth.Principal{ID: "partner-1", Roles: []string{auth.RolePartner}, TenantID: "acme", Method: auth.MethodAPIKey}
+)
+
+// accountRef is the public id of the account with the internal id in these tests
+func accountRef(id int) models.PublicID {
+	return models.PublicID(fmt.Sprintf("acc_01J8Z3V4W5X6Y7Z8A9B0C1D2%02d", id))
+}
+
+// holdRef is the public id of the hold with the internal id in these tests
+func holdRef(id int) models.PublicID {
+	return models.PublicID(fmt.Sprintf("auth_01J8Z3V4W5X6Y7Z8A9B0C1D2%02d", id))
+}
+
+// withoutLegacyIDs turns off internal account IDs for the rest of the test
+func withoutLegacyIDs(t *testing.T) {
+	models.AcceptLegacyIDs = false
+	t.Cleanup(func() { models.AcceptLegacyIDs = true })
+}
+
+// asPrincipal attaches the principal the auth middleware would have placed on the request
+func asPrincipal(req *http.Request, principal auth.Principal) *http.Request {
+	return req.WithContext(auth.WithPrincipal(req.Context(), principal))
+}
+
+func TestAccountHandlerPolicy(t *testing.T) {
+	mockService := new(mocks.MockAccountService)
+	handler := handlers.NewAccountHandler(mockService, auth.NewPolicy())
+
+	ownAccount := models.Account{ID: 1, PublicID: accountRef(1), DocumentNumber: "12345678909", TenantID: "acme"}
+	otherAccount := models.Account{ID: 2, PublicID: accountRef(2), DocumentNumber: "11144477735", TenantID: "globex"}
+
+	tests := []struct {
+		name           string
+		principal      auth.Principal
+		method         string
+		accountID      string
+		requestBody    string
+		mockCalls      func()
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Request without a principal is forbidden",
+			principal:      auth.Principal{},
+			method:         http.MethodGet,
+			accountID:      "1",
+			mockCalls:      func() {},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: no authenticated principal"}` + "\n",
+		},
+		{
+			name:      "Support can read any account",
+			principal: supportPrincipal,
+			method:    http.MethodGet,
+			accountID: string(accountRef(2)),
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", accountRef(2)).Return(otherAccount, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Support cannot create accounts",
+			principal:      supportPrincipal,
+			method:         http.MethodPost,
+			requestBody:    `{"document_number": "12345678909", "holder_name": "Maria Silva"}`,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: support-1 is not allowed to account:create"}` + "\n",
+		},
+		{
+			name:      "Partner can read its own tenant's account",
+			principal: partnerPrincipal,
+			method:    http.MethodGet,
+			accountID: string(accountRef(1)),
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", accountRef(1)).Return(ownAccount, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:      "Partner cannot read another tenant's account",
+			principal: partnerPrincipal,
+			method:    http.MethodGet,
+			accountID: string(accountRef(2)),
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", accountRef(2)).Return(otherAccount, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: partner-1 cannot access account acc_01J8Z3V4W5X6Y7Z8A9B0C1D202"}` + "\n",
+		},
+		{
+			// the internal id is no way around the tenant check
+			name:      "Partner cannot read another tenant's account by its internal id",
+			principal: partnerPrincipal,
+			method:    http.MethodGet,
+			accountID: "2",
+			mockCalls: func() {
+				mockService.On("GetAccountByRef", models.PublicID("2")).Return(otherAccount, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: partner-1 cannot access account acc_01J8Z3V4W5X6Y7Z8A9B0C1D202"}` + "\n",
+		},
+		{
+			name:        "Partner accounts are always opened under its own tenant",
+			principal:   partnerPrincipal,
+			method:      http.MethodPost,
+			requestBody: `{"document_number": "12345678909", "holder_name": "Maria Silva", "tenant_id": "globex"}`,
+			mockCalls: func() {
+				mockService.On("CreateAccount", models.Account{DocumentNumber: "12345678909", HolderName: "Maria Silva", TenantID: "acme"}).
+					Return(models.Account{ID: 3, PublicID: accountRef(3), TenantID: "acme"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "\"successfully created new account with ID acc_01J8Z3V4W5X6Y7Z8A9B0C1D203\"\n",
+		},
+		{
+			name:        "Admin can open an account for any tenant",
+			principal:   adminPrincipal,
+			method:      http.MethodPost,
+			requestBody: `{"document_number": "12345678909", "holder_name": "Maria Silva", "tenant_id": "globex"}`,
+			mockCalls: func() {
+				mockService.On("CreateAccount", models.Account{DocumentNumber: "12345678909", HolderName: "Maria Silva", TenantID: "globex"}).
+					Return(models.Account{ID: 4, PublicID: accountRef(4), TenantID: "globex"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "\"successfully created new account with ID acc_01J8Z3V4W5X6Y7Z8A9B0C1D204\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.ExpectedCalls = nil // clear any previous expectations
+			tt.mockCalls()
+
+			var req *http.Request
+			if tt.method == http.MethodGet {
+				req = httptest.NewRequest(http.MethodGet, "/accounts/"+tt.accountID, nil)
+				req = mux.SetURLVars(req, map[string]string{"id": tt.accountID})
+			} else {
+				req = httptest.NewRequest(http.MethodPost, "/accounts", bytes.NewBufferString(tt.requestBody))
+			}
+			req = asPrincipal(req, tt.principal)
+			rr := httptest.NewRecorder()
+
+			if tt.method == http.MethodGet {
+				handler.HandleGetAccount(rr, req)
+			} else {
+				handler.HandleCreateAccount(rr, req)
+			}
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestTransactionHandlerPolicy(t *testing.T) {
+	mockService := new(mocks.MockTransactionService)
+	mockAccounts := new(mocks.MockAccountService)
+	handler := handlers.NewTransactionHandler(mockService, mockAccounts, auth.NewPolicy())
+
+	tests := []struct {
+		name           string
+		principal      auth.Principal
+		requestBody    string
+		mockCalls      func()
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Support cannot create transactions",
+			principal:      supportPrincipal,
+			requestBody:    `{"account_id": 1, "operation_type_id": 4, "amount": 50}`,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: support-1 is not allowed to transaction:create"}` + "\n",
+		},
+		{
+			name:        "Partner can create transactions for its own accounts",
+			principal:   partnerPrincipal,
+			requestBody: `{"account_id": "acc_01J8Z3V4W5X6Y7Z8A9B0C1D201", "operation_type_id": 4, "amount": 50}`,
+			mockCalls: func() {
+				mockAccounts.On("GetAccountByRef", accountRef(1)).Return(models.Account{ID: 1, PublicID: accountRef(1), TenantID: "acme"}, nil)
+				mockService.On("CreateTransaction", mock.Anything).Return(models.Transaction{ID: 5, PublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D205"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "\"successfully created new transaction with ID txn_01J8Z3V4W5X6Y7Z8A9B0C1D205\"\n",
+		},
+		{
+			name:        "Partner cannot create transactions for another tenant's account",
+			principal:   partnerPrincipal,
+			requestBody: `{"account_id": 2, "operation_type_id": 4, "amount": 50}`,
+			mockCalls: func() {
+				mockAccounts.On("GetAccountByRef", models.PublicID("2")).Return(models.Account{ID: 2, PublicID: accountRef(2), TenantID: "globex"}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"forbidden: partner-1 cannot access account acc_01J8Z3V4W5X6Y7Z8A9B0C1D202"}` + "\n",
+		},
+		{
+			name:        "Admin is not limited to a tenant",
+			principal:   adminPrincipal,
+			requestBody: `{"account_id": 2, "operation_type_id": 4, "amount": 50}`,
+			mockCalls: func() {
+				mockService.On("CreateTransaction", mock.Anything).Return(models.Transaction{ID: 6, PublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D206"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "\"successfully created new transaction with ID txn_01J8Z3V4W5X6Y7Z8A9B0C1D206\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.ExpectedCalls = nil // clear any previous expectations
+			mockAccounts.ExpectedCalls = nil
+			tt.mockCalls()
+
+			req := httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewBufferString(tt.requestBody))
+			req = asPrincipal(req, tt.principal)
+			rr := httptest.NewRecorder()
+
+			handler.HandleCreateTransaction(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+
+			mockService.AssertExpectations(t)
+			mockAccounts.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthorizationHandlerPolicy(t *testing.T) {
+	mockService := new(mocks.MockAuthorizationService)
+	mockAccounts := new(mocks.MockAccountService)
+	handler := handlers.NewAuthorizationHandler(mockService, mockAccounts, auth.NewPolicy())
+
+	hold := models.Authorization{ID: 7, PublicID: holdRef(7), AccountID: 2, AccountPublicID: accountRef(2), Status: models.AuthorizationPending}
+
+	t.Run("Support cannot void holds", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/authorizations/"+string(holdRef(7))+"/void", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": string(holdRef(7))})
+		req = asPrincipal(req, supportPrincipal)
+		rr := httptest.NewRecorder()
+
+		handler.HandleVoidAuthorization(rr, req)
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("Partner cannot void a hold on another tenant's account", func(t *testing.T) {
+		mockService.On("GetAuthorizationByRef", holdRef(7)).Return(hold, nil)
+		mockAccounts.On("GetAccountByRef", accountRef(2)).Return(models.Account{ID: 2, PublicID: accountRef(2), TenantID: "globex"}, nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/authorizations/"+string(holdRef(7))+"/void", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": string(holdRef(7))})
+		req = asPrincipal(req, partnerPrincipal)
+		rr := httptest.NewRecorder()
+
+		handler.HandleVoidAuthorization(rr, req)
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Equal(t, `{"error":"forbidden: partner-1 cannot access account acc_01J8Z3V4W5X6Y7Z8A9B0C1D202"}`+"\n", rr.Body.String())
+		mockService.AssertExpectations(t)
+		mockAccounts.AssertExpectations(t)
+	})
+}
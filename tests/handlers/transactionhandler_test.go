@@ -3,12 +3,17 @@ package handlers
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"pismo/auth"
+	"pismo/fx"
 	"pismo/handlers"
 	"pismo/mocks"
+	"pismo/models"
+	"pismo/services"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -16,12 +21,12 @@ import (
 
 func TestHandleCreateTransaction(t *testing.T) {
 	mockService := new(mocks.MockTransactionService)
-	handler := handlers.NewTransactionHandler(mockService)
+	handler := handlers.NewTransactionHandler(mockService, new(mocks.MockAccountService), auth.NewPolicy())
 
 	tests := []struct {
 		name           string
 		requestBody    string
-		mockResponse   int64
+		mockResponse   models.Transaction
 		mockError      error
 		mockCalls      func()
 		expectedStatus int
@@ -41,6 +46,13 @@ func TestHandleCreateTransaction(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   "No Account ID provided\n",
 		},
+		{
+			name:           "Invalid account ID provided",
+			requestBody:    `{"account_id": "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2"}`,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid account ID: acc_01J8Z3V4W5X6Y7Z8A9B0C1D2\n",
+		},
 		{
 			name:           "No operation type ID provided",
 			requestBody:    `{"account_id": 1, "operation_type_id": 0}`,
@@ -55,11 +67,45 @@ func TestHandleCreateTransaction(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   "No Amount provided\n",
 		},
+		{
+			name:           "Invalid currency provided",
+			requestBody:    `{"account_id": 1, "operation_type_id": 2, "amount": -12.34, "currency": "US"}`,
+			mockCalls:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid currency, must be an ISO 4217 code: US\n",
+		},
+		{
+			name:        "Account does not exist",
+			requestBody: `{"account_id": 9, "operation_type_id": 2, "amount": -12.34}`,
+			mockCalls: func() {
+				mockService.On("CreateTransaction", mock.Anything).Return(models.Transaction{}, services.ErrAccountNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "Account not found\n",
+		},
+		{
+			name:        "No exchange rate for the transaction currency",
+			requestBody: `{"account_id": 1, "operation_type_id": 2, "amount": -12.34, "currency": "JPY"}`,
+			mockCalls: func() {
+				mockService.On("CreateTransaction", mock.Anything).Return(models.Transaction{}, fmt.Errorf("%w: JPY/BRL", fx.ErrRateNotFound))
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "no exchange rate available: JPY/BRL\n",
+		},
+		{
+			name:        "Event date outside the backdating window",
+			requestBody: `{"account_id": 1, "operation_type_id": 2, "amount": -12.34, "event_date": "2000-01-01T00:00:00Z"}`,
+			mockCalls: func() {
+				mockService.On("CreateTransaction", mock.Anything).Return(models.Transaction{}, fmt.Errorf("%w: 2000-01-01T00:00:00Z is more than 720h0m0s in the past", services.ErrInvalidEventDate))
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid event_date: 2000-01-01T00:00:00Z is more than 720h0m0s in the past\n",
+		},
 		{
 			name:        "Database error during transaction creation",
 			requestBody: `{"account_id": 1, "operation_type_id": 2, "amount": 12.34}`,
 			mockCalls: func() {
-				mockService.On("CreateTransaction", mock.Anything).Return(int64(0), errors.New("some db error"))
+				mockService.On("CreateTransaction", mock.Anything).Return(models.Transaction{}, errors.New("some db error"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   "some db error\n",
@@ -68,10 +114,11 @@ func TestHandleCreateTransaction(t *testing.T) {
 			name:        "Happy path: Successfully create a transaction",
 			requestBody: `{"account_id": 1, "operation_type_id": 2, "amount": 12.34}`,
 			mockCalls: func() {
-				mockService.On("CreateTransaction", mock.Anything).Return(int64(1), nil)
+				mockService.On("CreateTransaction", models.Transaction{AccountID: 1, AccountPublicID: "1", OperationTypeID: 2, Amount: 12.34}).
+					Return(models.Transaction{ID: 1, PublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D201"}, nil)
 			},
 			expectedStatus: http.StatusOK,
-			expectedBody:   "\"successfully created new transaction with ID 1\"\n",
+			expectedBody:   "\"successfully created new transaction with ID txn_01J8Z3V4W5X6Y7Z8A9B0C1D201\"\n",
 		},
 	}
 
@@ -81,6 +128,7 @@ func TestHandleCreateTransaction(t *testing.T) {
 			tt.mockCalls()
 
 			req := httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewBufferString(tt.requestBody))
+			req = asPrincipal(req, adminPrincipal)
 			req.Header.Set("Content-Type", "application/json")
 			rr := httptest.NewRecorder()
 
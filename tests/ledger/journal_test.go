@@ -0,0 +1,122 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/ledger"
+	"pismo/models"
+)
+
+func TestForTransaction(t *testing.T) {
+	tests := []struct {
+		name          string
+		transaction   models.Transaction
+		debit         string
+		credit        string
+		amount        float64
+		description   string
+		expectedError string
+	}{
+		{
+			name:        "Purchase is owed by the customer to the card network",
+			transaction: models.Transaction{ID: 1, AccountID: 7, OperationTypeID: 1, Amount: -50.25, Currency: "BRL"},
+			debit:       "customer:7",
+			credit:      "settlement:BRL",
+			amount:      50.25,
+			description: "purchase",
+		},
+		{
+			name:        "Withdrawal is paid out in cash",
+			transaction: models.Transaction{ID: 2, AccountID: 7, OperationTypeID: 3, Amount: -20, Currency: "USD"},
+			debit:       "customer:7",
+			credit:      "cash:USD",
+			amount:      20,
+			description: "withdrawal",
+		},
+		{
+			name:        "Payment pays the customer's debt back",
+			transaction: models.Transaction{ID: 3, AccountID: 7, OperationTypeID: 4, Amount: 60, Currency: "BRL"},
+			debit:       "cash:BRL",
+			credit:      "customer:7",
+			amount:      60,
+			description: "payment",
+		},
+		{
+			name:          "Unknown operation type",
+			transaction:   models.Transaction{ID: 4, AccountID: 7, OperationTypeID: 9, Amount: 60, Currency: "BRL"},
+			expectedError: "invalid journal entry: no postings for operation type 9",
+		},
+		{
+			name:          "Zero amount",
+			transaction:   models.Transaction{ID: 5, AccountID: 7, OperationTypeID: 4, Amount: 0.001, Currency: "BRL"},
+			expectedError: "invalid journal entry: posting to cash:BRL has no amount",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := ledger.ForTransaction(tt.transaction)
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				assert.True(t, errors.Is(err, ledger.ErrInvalidEntry))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.transaction.ID, entry.TransactionID)
+			assert.Equal(t, tt.description, entry.Description)
+			assert.Equal(t, tt.transaction.Currency, entry.Currency)
+			assert.Len(t, entry.Postings, 2)
+			assert.Equal(t, tt.debit, entry.Postings[0].LedgerAccount.Code)
+			assert.Equal(t, tt.amount, entry.Postings[0].Amount)
+			assert.Equal(t, tt.credit, entry.Postings[1].LedgerAccount.Code)
+			assert.Equal(t, -tt.amount, entry.Postings[1].Amount)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	customer := ledger.CustomerAccount(1, "BRL")
+	cash := ledger.CashAccount("BRL")
+
+	tests := []struct {
+		name          string
+		postings      []models.Posting
+		expectedError string
+	}{
+		{
+			name:     "Balanced to the cent despite float rounding",
+			postings: []models.Posting{{LedgerAccount: customer, Amount: 0.1}, {LedgerAccount: customer, Amount: 0.2}, {LedgerAccount: cash, Amount: -0.3}},
+		},
+		{
+			name:          "Single posting",
+			postings:      []models.Posting{{LedgerAccount: customer, Amount: 10}},
+			expectedError: "invalid journal entry: needs at least two postings, has 1",
+		},
+		{
+			name:          "Off balance",
+			postings:      []models.Posting{{LedgerAccount: customer, Amount: 10}, {LedgerAccount: cash, Amount: -9.99}},
+			expectedError: "invalid journal entry: postings are off balance by 0.01",
+		},
+		{
+			name:          "Mixed currencies",
+			postings:      []models.Posting{{LedgerAccount: customer, Amount: 10}, {LedgerAccount: ledger.CashAccount("USD"), Amount: -10}},
+			expectedError: "invalid journal entry: posting to cash:USD is in USD, entry is in BRL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ledger.Validate(models.JournalEntry{Currency: "BRL", Postings: tt.postings})
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package loadtest
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/loadtest"
+	"pismo/models"
+	"pismo/store"
+)
+
+// memoryBook keeps transactions in memory and discharges credits the way the
+// store does. With loseUpdates set it reads the open debits and writes them back
+// in two separate critical sections, like a SELECT without FOR UPDATE, so
+// concurrent credits overwrite each other's discharges.
+type memoryBook struct {
+	store.ReconciliationRepositoryer
+
+	mu           sync.Mutex
+	loseUpdates  bool
+	transactions []models.Transaction
+	allocations  []models.DischargeAllocation
+	totals       map[int64]models.AccountTotals
+}
+
+func (b *memoryBook) CreateTransaction(transaction models.Transaction) (models.Transaction, error) {
+	b.mu.Lock()
+	transaction.ID = int64(len(b.transactions) + 1)
+	transaction.Balance = transaction.Amount
+	transaction.PostingDate = time.Now()
+	b.transactions = append(b.transactions, transaction)
+	if transaction.OperationTypeID < 4 {
+		totals := b.totals[transaction.AccountID]
+		totals.TotalDebt -= transaction.Amount
+		b.totals[transaction.AccountID] = totals
+	}
+	b.mu.Unlock()
+
+	if transaction.OperationTypeID == 4 {
+		b.discharge(transaction)
+	}
+	return transaction, nil
+}
+
+func (b *memoryBook) discharge(credit models.Transaction) {
+	b.mu.Lock()
+	type debt struct {
+		index   int
+		balance float64
+	}
+	var owing []debt
+	for i, transaction := range b.transactions {
+		if transaction.AccountID == credit.AccountID && transaction.OperationTypeID < 4 && transaction.Balance < 0 {
+			owing = append(owing, debt{i, transaction.Balance})
+		}
+	}
+	if b.loseUpdates {
+		b.mu.Unlock()
+		time.Sleep(time.Millisecond)
+		b.mu.Lock()
+	}
+	defer b.mu.Unlock()
+
+	remaining := credit.Amount
+	for _, d := range owing {
+		if remaining <= 0 {
+			break
+		}
+		applied := math.Min(remaining, -d.balance)
+		b.transactions[d.index].Balance = d.balance + applied
+		remaining -= applied
+		b.allocations = append(b.allocations, models.DischargeAllocation{
+			AccountID:           credit.AccountID,
+			DebitTransactionID:  b.transactions[d.index].ID,
+			CreditTransactionID: credit.ID,
+			Amount:              applied,
+		})
+	}
+	b.transactions[credit.ID-1].Balance = remaining
+
+	totals := b.totals[credit.AccountID]
+	totals.TotalDebt -= credit.Amount - remaining
+	totals.TotalCredit += remaining
+	b.totals[credit.AccountID] = totals
+}
+
+func (b *memoryBook) GetAccountTotals(accountID int64) (models.AccountTotals, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.totals[accountID], nil
+}
+
+func (b *memoryBook) ListAccountTransactions(accountID int64) ([]models.Transaction, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var transactions []models.Transaction
+	for _, transaction := range b.transactions {
+		if transaction.AccountID == accountID {
+			transactions = append(transactions, transaction)
+		}
+	}
+	return transactions, nil
+}
+
+func (b *memoryBook) ListAccountAllocations(accountID int64) ([]models.DischargeAllocation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var allocations []models.DischargeAllocation
+	for _, allocation := range b.allocations {
+		if allocation.AccountID == accountID {
+			allocations = append(allocations, allocation)
+		}
+	}
+	return allocations, nil
+}
+
+type balancedLedger struct{}
+
+func (balancedLedger) TrialBalance() ([]models.TrialBalance, error) {
+	return []models.TrialBalance{{Currency: "BRL", Balanced: true}}, nil
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		loseUpdates bool
+		passed      bool
+	}{
+		{
+			name:   "Serialized discharges keep every invariant",
+			passed: true,
+		},
+		{
+			name:        "Lost updates are reported",
+			loseUpdates: true,
+			passed:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := &memoryBook{loseUpdates: tt.loseUpdates, totals: map[int64]models.AccountTotals{}}
+			config := loadtest.Config{Accounts: []int64{1, 2}, Workers: 8, Operations: 400, CreditRatio: 0.5, MaxAmount: 50, Seed: 7}
+
+			report, err := loadtest.Run(config, book, book, balancedLedger{})
+
+			assert.NoError(t, err)
+			assert.Equal(t, 400, report.Operations)
+			assert.Equal(t, 400, report.Created)
+			assert.Len(t, report.Accounts, 2)
+			assert.True(t, report.LedgerBalanced)
+			assert.Equal(t, tt.passed, report.Passed)
+			if tt.passed {
+				assert.Empty(t, report.Violations)
+				for _, account := range report.Accounts {
+					assert.Equal(t, account.Credited, account.Discharged)
+					assert.Equal(t, account.Posted, account.Transactions)
+				}
+			} else {
+				assert.NotEmpty(t, report.Violations)
+			}
+		})
+	}
+}
+
+func TestRunWithoutAccounts(t *testing.T) {
+	book := &memoryBook{totals: map[int64]models.AccountTotals{}}
+
+	_, err := loadtest.Run(loadtest.Config{}, book, book, balancedLedger{})
+
+	assert.ErrorIs(t, err, loadtest.ErrNoAccounts)
+}
+
+func TestWorkload(t *testing.T) {
+	config := loadtest.Config{Accounts: []int64{1, 2, 3}, Operations: 200, CreditRatio: 0.25, MaxAmount: 10, Seed: 42}
+
+	workload := loadtest.Workload(config)
+
+	assert.Len(t, workload, 200)
+	assert.Equal(t, workload, loadtest.Workload(config), "the same seed must give the same workload")
+	for _, transaction := range workload {
+		assert.Contains(t, []int64{1, 2, 3}, transaction.AccountID)
+		if transaction.OperationTypeID == 4 {
+			assert.True(t, transaction.Amount > 0 && transaction.Amount <= 10)
+		} else {
+			assert.Contains(t, []int{1, 2, 3}, transaction.OperationTypeID)
+			assert.True(t, transaction.Amount < 0 && transaction.Amount >= -10)
+		}
+	}
+}
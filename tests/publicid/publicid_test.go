@@ -0,0 +1,57 @@
+package publicid
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/publicid"
+)
+
+func TestNew(t *testing.T) {
+	id := publicid.New(publicid.Account)
+	assert.True(t, strings.HasPrefix(id, "acc_"))
+	assert.Len(t, id, len("acc_")+26)
+	assert.True(t, publicid.Valid(id, publicid.Account))
+	assert.False(t, publicid.Valid(id, publicid.Transaction))
+
+	// ids made later sort after, the time comes first
+	first := publicid.New(publicid.Transaction)
+	time.Sleep(2 * time.Millisecond)
+	ids := []string{publicid.New(publicid.Transaction), first}
+	sort.Strings(ids)
+	assert.Equal(t, first, ids[0])
+
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		id := publicid.New(publicid.Transaction)
+		assert.False(t, seen[id], "duplicate id %s", id)
+		seen[id] = true
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		expected bool
+	}{
+		{name: "ULID", id: "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", expected: true},
+		{name: "Backfilled by a migration", id: "acc_9F3A0C1B2D4E5F60718293A4B5", expected: true},
+		{name: "Other prefix", id: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", expected: false},
+		{name: "No prefix", id: "01J8Z3V4W5X6Y7Z8A9B0C1D2E3", expected: false},
+		{name: "Internal id", id: "1", expected: false},
+		{name: "Too short", id: "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E", expected: false},
+		{name: "Lowercase", id: "acc_01j8z3v4w5x6y7z8a9b0c1d2e3", expected: false},
+		{name: "Outside the alphabet", id: "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2EU", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, publicid.Valid(tt.id, publicid.Account))
+		})
+	}
+}
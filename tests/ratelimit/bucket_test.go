@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/ratelimit"
+)
+
+func TestLimiterAllowAt(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Limit{Rate: 2, Burst: 3})
+	start := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+
+	// the burst is available straight away
+	for i := 0; i < 3; i++ {
+		allowed, _ := limiter.AllowAt("client-a", start)
+		assert.True(t, allowed)
+	}
+
+	allowed, wait := limiter.AllowAt("client-a", start)
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// other keys have their own bucket
+	allowed, _ = limiter.AllowAt("client-b", start)
+	assert.True(t, allowed)
+
+	// refills at the rate, one token every half second
+	allowed, _ = limiter.AllowAt("client-a", start.Add(500*time.Millisecond))
+	assert.True(t, allowed)
+	allowed, wait = limiter.AllowAt("client-a", start.Add(600*time.Millisecond))
+	assert.False(t, allowed)
+	assert.Equal(t, 400*time.Millisecond, wait)
+
+	// never refills past the burst
+	for i := 0; i < 3; i++ {
+		allowed, _ = limiter.AllowAt("client-a", start.Add(time.Hour))
+		assert.True(t, allowed)
+	}
+	allowed, _ = limiter.AllowAt("client-a", start.Add(time.Hour))
+	assert.False(t, allowed)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name          string
+		config        string
+		expected      ratelimit.Config
+		expectedError string
+	}{
+		{
+			name:   "Default and per route limits",
+			config: `{"default": {"client": {"rate": 10, "burst": 20}}, "routes": {"POST /transactions": {"account": {"rate": 1, "burst": 2}}}}`,
+			expected: ratelimit.Config{
+				Default: ratelimit.RouteLimits{Client: &ratelimit.Limit{Rate: 10, Burst: 20}},
+				Routes: map[string]ratelimit.RouteLimits{
+					"POST /transactions": {Account: &ratelimit.Limit{Rate: 1, Burst: 2}},
+				},
+			},
+		},
+		{
+			name:   "Limit per address",
+			config: `{"ip": {"rate": 100, "burst": 200}}`,
+			expected: ratelimit.Config{
+				IP: &ratelimit.Limit{Rate: 100, Burst: 200},
+			},
+		},
+		{
+			name:          "Negative rate per address",
+			config:        `{"ip": {"rate": -1, "burst": 1}}`,
+			expectedError: "ip: rate must be positive and burst at least 1",
+		},
+		{
+			name:          "Zero burst",
+			config:        `{"routes": {"POST /transactions": {"client": {"rate": 1, "burst": 0}}}}`,
+			expectedError: "route POST /transactions: rate must be positive and burst at least 1",
+		},
+		{
+			name:          "Invalid JSON",
+			config:        `{`,
+			expectedError: "failed to parse rate limit config: unexpected end of JSON input",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "ratelimit.json")
+			assert.NoError(t, os.WriteFile(path, []byte(tt.config), 0o600))
+
+			config, err := ratelimit.LoadConfig(path)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, config)
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/auth"
+	"pismo/models"
+	"pismo/ratelimit"
+)
+
+// accounts resolves the public id of account 1, the only account there is
+type accounts struct{}
+
+func (accounts) GetAccountByRef(ref models.PublicID) (models.Account, error) {
+	if ref == "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3" {
+		return models.Account{ID: 1, PublicID: ref}, nil
+	}
+	return models.Account{}, sql.ErrNoRows
+}
+
+// newRouter wires the throttler the same way main does, around a stub auth
+// middleware that authenticates every request as the client in X-Client and
+// turns away those without one
+func newRouter(config ratelimit.Config) *mux.Router {
+	throttler := ratelimit.NewThrottler(config, accounts{})
+	r := mux.NewRouter()
+	r.Use(throttler.IPMiddleware, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Client") == "" {
+				auth.WriteError(w, http.StatusUnauthorized, "missing credentials")
+				return
+			}
+			principal := auth.Principal{ID: r.Header.Get("X-Client"), Roles: []string{auth.RoleAdmin}}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}, throttler.Middleware)
+
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}
+	r.HandleFunc("/transactions", echo).Methods("POST")
+	r.HandleFunc("/accounts/{id}", echo).Methods("GET")
+	return r
+}
+
+func send(r http.Handler, method, path, client, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("X-Client", client)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestThrottlerPerClient(t *testing.T) {
+	r := newRouter(ratelimit.Config{
+		Default: ratelimit.RouteLimits{Client: &ratelimit.Limit{Rate: 0.5, Burst: 2}},
+	})
+
+	assert.Equal(t, http.StatusOK, send(r, http.MethodGet, "/accounts/1", "client-a", "").Code)
+	assert.Equal(t, http.StatusOK, send(r, http.MethodGet, "/accounts/2", "client-a", "").Code)
+
+	rr := send(r, http.MethodGet, "/accounts/3", "client-a", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+	assert.Equal(t, `{"error":"rate limit exceeded for client, retry later"}`+"\n", rr.Body.String())
+
+	// another client is unaffected
+	assert.Equal(t, http.StatusOK, send(r, http.MethodGet, "/accounts/3", "client-b", "").Code)
+}
+
+func TestThrottlerPerAccount(t *testing.T) {
+	r := newRouter(ratelimit.Config{
+		Routes: map[string]ratelimit.RouteLimits{
+			"POST /transactions": {Account: &ratelimit.Limit{Rate: 1, Burst: 1}},
+		},
+	})
+
+	before := throttledCount("POST /transactions account")
+
+	body := `{"account_id": 1, "operation_type_id": 4, "amount": 10}`
+	rr := send(r, http.MethodPost, "/transactions", "client-a", body)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// the handler still gets the whole body after the throttler peeked at it
+	assert.Equal(t, body, rr.Body.String())
+
+	// same account from a different client is still limited
+	rr = send(r, http.MethodPost, "/transactions", "client-b", body)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	// other accounts are not
+	rr = send(r, http.MethodPost, "/transactions", "client-b", `{"account_id": 2, "operation_type_id": 4, "amount": 10}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// named by its public id it's the same account, and the same bucket
+	body = `{"account_id": "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", "operation_type_id": 4, "amount": 10}`
+	assert.Equal(t, http.StatusTooManyRequests, send(r, http.MethodPost, "/transactions", "client-a", body).Code)
+
+	// public ids that don't resolve get a bucket of their own
+	body = `{"account_id": "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E9", "operation_type_id": 4, "amount": 10}`
+	assert.Equal(t, http.StatusOK, send(r, http.MethodPost, "/transactions", "client-a", body).Code)
+	assert.Equal(t, http.StatusTooManyRequests, send(r, http.MethodPost, "/transactions", "client-b", body).Code)
+
+	// routes without limits are never throttled
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, send(r, http.MethodGet, "/accounts/1", "client-a", "").Code)
+	}
+
+	assert.Equal(t, before+3, throttledCount("POST /transactions account"))
+}
+
+func TestThrottlerBodyTooLarge(t *testing.T) {
+	r := newRouter(ratelimit.Config{
+		Routes: map[string]ratelimit.RouteLimits{
+			"POST /transactions": {Account: &ratelimit.Limit{Rate: 1, Burst: 1}},
+		},
+	})
+
+	body := `{"account_id": 1, "description": "` + strings.Repeat("a", 1<<20) + `"}`
+	rr := send(r, http.MethodPost, "/transactions", "client-a", body)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Equal(t, `{"error":"request body is over 1048576 bytes"}`+"\n", rr.Body.String())
+}
+
+func TestThrottlerPerIP(t *testing.T) {
+	r := newRouter(ratelimit.Config{
+		IP: &ratelimit.Limit{Rate: 1, Burst: 2},
+	})
+
+	// callers that fail to authenticate use up the address's tokens too
+	assert.Equal(t, http.StatusUnauthorized, send(r, http.MethodGet, "/accounts/1", "", "").Code)
+	assert.Equal(t, http.StatusOK, send(r, http.MethodGet, "/accounts/1", "client-a", "").Code)
+
+	rr := send(r, http.MethodGet, "/accounts/1", "client-b", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, `{"error":"rate limit exceeded for ip, retry later"}`+"\n", rr.Body.String())
+
+	// other addresses are not
+	req := httptest.NewRequest(http.MethodGet, "/accounts/1", nil)
+	req.RemoteAddr = "203.0.113.7:4242"
+	req.Header.Set("X-Client", "client-b")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func throttledCount(key string) int64 {
+	if v := ratelimit.Throttled.Get(key); v != nil {
+		return v.(interface{ Value() int64 }).Value()
+	}
+	return 0
+}
@@ -3,13 +3,21 @@ package services
 import (
 	"database/sql"
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
-	
+	"github.com/stretchr/testify/mock"
+
 	"pismo/mocks"
 	"pismo/models"
+	"pismo/publicid"
 	"pismo/services"
+	"pismo/store"
 )
 
 func TestGetAccountByID(t *testing.T) {
@@ -18,7 +26,7 @@ func TestGetAccountByID(t *testing.T) {
 
 	tests := []struct {
 		name           string
-		accountID      int
+		accountID      int64
 		mockResponse   models.Account
 		mockError      error
 		mockCalls      func()
@@ -29,7 +37,7 @@ func TestGetAccountByID(t *testing.T) {
 			name:      "Account not found",
 			accountID: 2,
 			mockCalls: func() {
-				mockRepo.On("GetAccountByID", 2).Return(models.Account{}, sql.ErrNoRows)
+				mockRepo.On("GetAccountByID", int64(2)).Return(models.Account{}, sql.ErrNoRows)
 			},
 			expectedResult: models.Account{},
 			expectedError:  sql.ErrNoRows,
@@ -38,7 +46,7 @@ func TestGetAccountByID(t *testing.T) {
 			name:      "Database error",
 			accountID: 2,
 			mockCalls: func() {
-				mockRepo.On("GetAccountByID", 2).Return(models.Account{}, errors.New("some db error"))
+				mockRepo.On("GetAccountByID", int64(2)).Return(models.Account{}, errors.New("some db error"))
 			},
 			expectedResult: models.Account{},
 			expectedError:  errors.New("some db error"),
@@ -47,7 +55,7 @@ func TestGetAccountByID(t *testing.T) {
 			name:      "Successfully fetched account",
 			accountID: 1,
 			mockCalls: func() {
-				mockRepo.On("GetAccountByID", 1).Return(models.Account{ID: 1, DocumentNumber: "123456789"}, nil)
+				mockRepo.On("GetAccountByID", int64(1)).Return(models.Account{ID: 1, DocumentNumber: "123456789"}, nil)
 			},
 			expectedResult: models.Account{ID: 1, DocumentNumber: "123456789"},
 			expectedError:  nil,
@@ -77,51 +85,129 @@ func TestCreateAccount(t *testing.T) {
 	mockRepo := new(mocks.MockRepository)
 	service := services.NewAccountService(mockRepo)
 
+	standardProduct := models.Product{ID: 1, Name: "Standard", CreditLimit: 1000.0}
+	// the account as it is persisted once the service has applied its defaults
+	defaultedAccount := models.Account{
+		DocumentNumber: "52998224725",
+		DocumentType:   "CPF",
+		HolderName:     "Maria Silva",
+		Country:        "BR",
+		ProductID:      1,
+		Currency:       "BRL",
+	}
+	// the public id is made by the service, anything that looks like one will do
+	persisted := func(expected models.Account) any {
+		return mock.MatchedBy(func(account models.Account) bool {
+			valid := publicid.Valid(string(account.PublicID), publicid.Account)
+			account.PublicID = ""
+			return valid && assert.ObjectsAreEqual(expected, account)
+		})
+	}
+
 	tests := []struct {
 		name           string
-		documentNumber string
+		account        models.Account
 		mockCalls      func()
 		expectedResult int64
 		expectedError  error
 	}{
 		{
-			name:           "Db error when checking if account already exists",
-			documentNumber: "123456789",
+			name:           "Product does not exist",
+			account:        models.Account{DocumentNumber: "12345678909", HolderName: "Maria Silva", ProductID: 9},
+			expectedResult: 0,
+			expectedError:  services.ErrProductNotFound,
+			mockCalls: func() {
+				mockRepo.On("GetProductByID", 9).Return(models.Product{}, sql.ErrNoRows)
+			},
+		},
+		{
+			name:           "CPF with invalid check digits",
+			account:        models.Account{DocumentNumber: "12345678900", HolderName: "Maria Silva"},
+			expectedResult: 0,
+			expectedError:  errors.New("invalid document number: CPF check digits do not match"),
+			mockCalls:      func() {},
+		},
+		{
+			name:           "Unsupported document type",
+			account:        models.Account{DocumentNumber: "123456789", DocumentType: "SSN", HolderName: "John Doe"},
+			expectedResult: 0,
+			expectedError:  errors.New("unsupported document type: SSN"),
+			mockCalls:      func() {},
+		},
+		{
+			name:           "Db error when fetching product",
+			account:        models.Account{DocumentNumber: "12345678909", HolderName: "Maria Silva"},
 			expectedResult: 0,
 			expectedError:  errors.New("some db error"),
 			mockCalls: func() {
-				mockRepo.On("GetAccountByDocumentNumber", "123456789").Return(models.Account{}, errors.New("some db error"))
+				mockRepo.On("GetProductByID", 1).Return(models.Product{}, errors.New("some db error"))
 			},
 		},
 		{
 			name:           "Account with same document number already exists",
-			documentNumber: "123456789",
+			account:        models.Account{DocumentNumber: "52998224725", HolderName: "Maria Silva"},
 			expectedResult: 0,
 			expectedError:  errors.New("an account with that document number already exists"), // Make sure this matches exactly with your actual error message
 			mockCalls: func() {
-				mockRepo.On("GetAccountByDocumentNumber", "123456789").Return(models.Account{ID: 1, DocumentNumber: "123456789"}, nil)
+				mockRepo.On("GetProductByID", 1).Return(standardProduct, nil)
+				mockRepo.On("CreateAccount", persisted(defaultedAccount)).
+					Return(int64(0), &store.ConflictError{Entity: "account", Key: "document number", ExistingID: 1})
 			},
 		},
 		{
-			name:           "Db error when creating account",
-			documentNumber: "987654321",
+			name:    "Db error when creating account",
+			account: models.Account{DocumentNumber: "52998224725", HolderName: "Maria Silva"},
 			mockCalls: func() {
-				mockRepo.On("GetAccountByDocumentNumber", "987654321").Return(models.Account{}, sql.ErrNoRows)
-				mockRepo.On("CreateAccount", "987654321").Return(int64(0), errors.New("database error during creation"))
+				mockRepo.On("GetProductByID", 1).Return(standardProduct, nil)
+				mockRepo.On("CreateAccount", persisted(defaultedAccount)).Return(int64(0), errors.New("database error during creation"))
 			},
 			expectedResult: 0,
 			expectedError:  errors.New("database error during creation"),
 		},
 		{
-			name:           "Successfully created account",
-			documentNumber: "987654321",
+			name:    "Successfully created account with defaults",
+			account: models.Account{DocumentNumber: "52998224725", HolderName: "Maria Silva"},
+			mockCalls: func() {
+				mockRepo.On("GetProductByID", 1).Return(standardProduct, nil)
+				mockRepo.On("CreateAccount", persisted(defaultedAccount)).Return(int64(1), nil)
+			},
+			expectedResult: 1,
+			expectedError:  nil,
+		},
+		{
+			name:    "Successfully created account from a formatted CPF",
+			account: models.Account{DocumentNumber: "529.982.247-25", HolderName: "Maria Silva"},
 			mockCalls: func() {
-				mockRepo.On("GetAccountByDocumentNumber", "987654321").Return(models.Account{}, sql.ErrNoRows)
-				mockRepo.On("CreateAccount", "987654321").Return(int64(1), nil)
+				mockRepo.On("GetProductByID", 1).Return(standardProduct, nil)
+				mockRepo.On("CreateAccount", persisted(defaultedAccount)).Return(int64(1), nil)
 			},
 			expectedResult: 1,
 			expectedError:  nil,
 		},
+		{
+			name: "Successfully created account with holder data normalised",
+			account: models.Account{
+				DocumentNumber: "X1234567",
+				DocumentType:   "passport",
+				HolderName:     "John Doe",
+				Country:        "us",
+				ProductID:      2,
+				Currency:       "usd",
+			},
+			mockCalls: func() {
+				mockRepo.On("GetProductByID", 2).Return(models.Product{ID: 2, Name: "Gold", CreditLimit: 5000.0}, nil)
+				mockRepo.On("CreateAccount", persisted(models.Account{
+					DocumentNumber: "X1234567",
+					DocumentType:   "PASSPORT",
+					HolderName:     "John Doe",
+					Country:        "US",
+					ProductID:      2,
+					Currency:       "USD",
+				})).Return(int64(2), nil)
+			},
+			expectedResult: 2,
+			expectedError:  nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -129,9 +215,12 @@ func TestCreateAccount(t *testing.T) {
 			mockRepo.ExpectedCalls = nil // Clear previous expectations
 
 			tt.mockCalls()
-			result, err := service.CreateAccount(tt.documentNumber)
+			result, err := service.CreateAccount(tt.account)
 
-			assert.Equal(t, tt.expectedResult, result)
+			assert.Equal(t, tt.expectedResult, result.ID)
+			if err == nil {
+				assert.True(t, publicid.Valid(string(result.PublicID), publicid.Account))
+			}
 			if tt.expectedError != nil {
 				assert.EqualError(t, err, tt.expectedError.Error())
 			} else {
@@ -142,3 +231,297 @@ func TestCreateAccount(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateAccountDuplicateDocument(t *testing.T) {
+	accountColumns := []string{"account_id", "public_id", "document_number", "document_type", "holder_name", "country", "product_id", "currency", "tenant_id", "status", "credit_limit", "version", "created_at", "updated_at"}
+	createdAt := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+	existingPublicID := "acc_01J8Z3V4W5X6Y7Z8A9B0C1D207"
+
+	tests := []struct {
+		name         string
+		dialect      store.Dialect
+		expectInsert func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:    "MySQL duplicate entry",
+			dialect: store.MySQL,
+			expectInsert: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`INSERT INTO Accounts`).
+					WillReturnError(&mysql.MySQLError{Number: store.ErrCodeDuplicateEntry, Message: "Duplicate entry '52998224725' for key 'ux_accounts_document_number'"})
+			},
+		},
+		{
+			name:    "Postgres unique violation",
+			dialect: store.Postgres,
+			expectInsert: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`INSERT INTO Accounts .* RETURNING account_id`).
+					WillReturnError(&pq.Error{Code: store.SQLStateUniqueViolation, Message: "duplicate key value violates unique constraint"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery(`SELECT product_id, name, credit_limit FROM Products`).
+				WillReturnRows(sqlmock.NewRows([]string{"product_id", "name", "credit_limit"}).AddRow(1, "Standard", 1000.0))
+			tt.expectInsert(mock)
+			// the account that got there first
+			mock.ExpectQuery(`SELECT account_id, .* FROM Accounts WHERE document_number`).
+				WithArgs("52998224725").
+				WillReturnRows(sqlmock.NewRows(accountColumns).
+					AddRow(7, existingPublicID, "52998224725", "CPF", "Maria Silva", "BR", 1, "BRL", "", "ACTIVE", nil, 1, createdAt, createdAt))
+
+			service := services.NewAccountService(store.NewRepository(db, tt.dialect))
+			_, err = service.CreateAccount(models.Account{DocumentNumber: "529.982.247-25", DocumentType: "CPF", HolderName: "Maria Silva"})
+
+			assert.Equal(t, &store.ConflictError{Entity: "account", Key: "document number", ExistingID: 7, ExistingPublicID: models.PublicID(existingPublicID)}, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// uniqueDocumentRepository stands in for the unique index on Accounts.document_number,
+// everything it doesn't override falls through to the testify mock
+type uniqueDocumentRepository struct {
+	*mocks.MockRepository
+	mu       sync.Mutex
+	accounts map[string]int64
+	creates  int
+}
+
+func (r *uniqueDocumentRepository) GetProductByID(id int) (models.Product, error) {
+	return models.Product{ID: id, Name: "Standard", CreditLimit: 1000.0}, nil
+}
+
+func (r *uniqueDocumentRepository) CreateAccount(account models.Account) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existingID, ok := r.accounts[account.DocumentNumber]; ok {
+		return 0, &store.ConflictError{Entity: "account", Key: "document number", ExistingID: existingID}
+	}
+	r.creates++
+	r.accounts[account.DocumentNumber] = int64(r.creates)
+	return int64(r.creates), nil
+}
+
+func TestCreateAccountConcurrently(t *testing.T) {
+	repo := &uniqueDocumentRepository{MockRepository: new(mocks.MockRepository), accounts: map[string]int64{}}
+	service := services.NewAccountService(repo)
+
+	const numCreates = 50
+	var wg sync.WaitGroup
+	results := make(chan error, numCreates)
+	createdIDs := make(chan int64, numCreates)
+
+	for i := 0; i < numCreates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// alternate formatted and bare numbers, both normalise to the same key
+			documentNumber := "52998224725"
+			if i%2 == 0 {
+				documentNumber = "529.982.247-25"
+			}
+			account, err := service.CreateAccount(models.Account{DocumentNumber: documentNumber, HolderName: "Maria Silva"})
+			if err == nil {
+				createdIDs <- account.ID
+			}
+			results <- err
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+	close(createdIDs)
+
+	var conflicts []*store.ConflictError
+	for err := range results {
+		var conflict *store.ConflictError
+		if errors.As(err, &conflict) {
+			conflicts = append(conflicts, conflict)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	assert.Equal(t, 1, repo.creates, "only one account should have been created")
+	assert.Len(t, createdIDs, 1)
+	assert.Len(t, conflicts, numCreates-1)
+	winner := <-createdIDs
+	for _, conflict := range conflicts {
+		assert.Equal(t, winner, conflict.ExistingID)
+	}
+}
+
+func TestGetAccountBalance(t *testing.T) {
+	mockRepo := new(mocks.MockRepository)
+	service := services.NewAccountService(mockRepo)
+
+	asOf := time.Date(2024, 9, 17, 12, 0, 0, 0, time.UTC)
+	transactions := []models.TransactionBalance{
+		{TransactionID: 1, OperationTypeID: 1, Amount: -50, Balance: 0},
+		{TransactionID: 2, OperationTypeID: 1, Amount: -23.5, Balance: -13.5},
+		{TransactionID: 3, OperationTypeID: 4, Amount: 60, Balance: 0},
+		{TransactionID: 4, OperationTypeID: 4, Amount: 0.1, Balance: 0.1},
+		{TransactionID: 5, OperationTypeID: 4, Amount: 0.2, Balance: 0.2},
+	}
+	mockRepo.On("GetTransactionBalancesAsOf", int64(1), asOf).Return(transactions, nil)
+
+	balance, err := service.GetAccountBalance(1, asOf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.AccountBalance{
+		AccountID:    1,
+		AsOf:         asOf,
+		Balance:      -13.2,
+		OpenDebt:     13.5,
+		OpenCredit:   0.3,
+		Transactions: transactions,
+	}, balance)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUpdateAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := services.NewAccountService(store.NewRepository(db, store.MySQL))
+	accountColumns := []string{"account_id", "public_id", "document_number", "document_type", "holder_name", "country", "product_id", "currency", "tenant_id", "status", "credit_limit", "version", "created_at", "updated_at"}
+	now := time.Now()
+
+	expectLock := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT .* FROM AccountBalances WHERE account_id = \? FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "total_debt", "total_credit", "available_limit", "version", "updated_at"}).
+				AddRow(1, 300.0, 0.0, 700.0, 8, now))
+	}
+	expectAccount := func(status string, creditLimit any, version int64) {
+		mock.ExpectQuery(`SELECT .* FROM Accounts WHERE account_id = \?`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(accountColumns).
+				AddRow(1, "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", "12345678909", "CPF", "Maria Silva", "BR", 1, "BRL", "acme", status, creditLimit, version, now, now))
+	}
+
+	limit := 2500.0
+	negative := -1.0
+	blocked := "blocked"
+	active := models.AccountActive
+
+	tests := []struct {
+		name            string
+		update          models.AccountUpdate
+		version         int64
+		mockSetup       func()
+		expectedStatus  string
+		expectedLimit   *float64
+		expectedVersion int64
+		expectedError   string
+	}{
+		{
+			name:    "New credit limit moves the available limit along",
+			update:  models.AccountUpdate{CreditLimit: &limit},
+			version: 3,
+			mockSetup: func() {
+				expectLock()
+				expectAccount("ACTIVE", nil, 3)
+				mock.ExpectExec(`UPDATE Accounts SET .* WHERE account_id = \? AND version = \?`).
+					WithArgs("Maria Silva", "ACTIVE", 2500.0, 1, 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE AccountBalances\s+SET`).
+					WithArgs(300.0, 0.0, 300.0, 1, 8).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAccount("ACTIVE", 2500.0, 4)
+				mock.ExpectCommit()
+			},
+			expectedStatus:  models.AccountActive,
+			expectedLimit:   &limit,
+			expectedVersion: 4,
+		},
+		{
+			name:    "Status change leaves the balance alone",
+			update:  models.AccountUpdate{Status: &blocked},
+			version: 3,
+			mockSetup: func() {
+				expectLock()
+				expectAccount("ACTIVE", nil, 3)
+				mock.ExpectExec(`UPDATE Accounts SET .* WHERE account_id = \? AND version = \?`).
+					WithArgs("Maria Silva", "BLOCKED", nil, 1, 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAccount("BLOCKED", nil, 4)
+				mock.ExpectCommit()
+			},
+			expectedStatus:  models.AccountBlocked,
+			expectedVersion: 4,
+		},
+		{
+			name:    "Stale version changes nothing",
+			update:  models.AccountUpdate{Status: &blocked},
+			version: 2,
+			mockSetup: func() {
+				expectLock()
+				expectAccount("ACTIVE", nil, 3)
+				mock.ExpectRollback()
+			},
+			expectedError: "version mismatch: account 1 is at version 3, not 2",
+		},
+		{
+			name:    "Negative credit limit",
+			update:  models.AccountUpdate{CreditLimit: &negative},
+			version: 3,
+			mockSetup: func() {
+				expectLock()
+				expectAccount("ACTIVE", nil, 3)
+				mock.ExpectRollback()
+			},
+			expectedError: "invalid account update: credit_limit can't be negative",
+		},
+		{
+			name:    "Closed accounts stay closed",
+			update:  models.AccountUpdate{Status: &active},
+			version: 3,
+			mockSetup: func() {
+				expectLock()
+				expectAccount("CLOSED", nil, 3)
+				mock.ExpectRollback()
+			},
+			expectedError: "invalid account update: account acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3 is closed",
+		},
+		{
+			name:    "Account does not exist",
+			update:  models.AccountUpdate{Status: &blocked},
+			version: 3,
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT .* FROM AccountBalances WHERE account_id = \? FOR UPDATE`).
+					WithArgs(1).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			expectedError: sql.ErrNoRows.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockSetup()
+
+			account, err := service.UpdateAccount(1, tt.update, tt.version)
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedStatus, account.Status)
+				assert.Equal(t, tt.expectedLimit, account.CreditLimit)
+				assert.Equal(t, tt.expectedVersion, account.Version)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
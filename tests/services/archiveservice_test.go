@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/mocks"
+	"pismo/models"
+	"pismo/services"
+)
+
+func TestArchiveAccount(t *testing.T) {
+	postedBefore := time.Date(2024, 9, 17, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Archives a batch at a time until nothing is left", func(t *testing.T) {
+		mockRepo := new(mocks.MockRepository)
+		mockRepo.On("LockAccountBalance", int64(1)).Return(models.AccountTotals{AccountID: 1}, nil).Times(3)
+		mockRepo.On("ArchiveTransactions", int64(1), postedBefore, 500).Return(500, nil).Once()
+		mockRepo.On("ArchiveTransactions", int64(1), postedBefore, 500).Return(20, nil).Once()
+		mockRepo.On("ArchiveTransactions", int64(1), postedBefore, 500).Return(0, nil).Once()
+
+		archived, err := services.NewArchiveService(mockRepo).ArchiveAccount(1, postedBefore)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 520, archived)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("Stops at the batch that fails", func(t *testing.T) {
+		mockRepo := new(mocks.MockRepository)
+		mockRepo.On("LockAccountBalance", int64(1)).Return(models.AccountTotals{AccountID: 1}, nil).Twice()
+		mockRepo.On("ArchiveTransactions", int64(1), postedBefore, 500).Return(500, nil).Once()
+		mockRepo.On("ArchiveTransactions", int64(1), postedBefore, 500).Return(0, errors.New("lock wait timeout exceeded")).Once()
+
+		archived, err := services.NewArchiveService(mockRepo).ArchiveAccount(1, postedBefore)
+
+		assert.EqualError(t, err, "lock wait timeout exceeded")
+		assert.Equal(t, 500, archived)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestArchive(t *testing.T) {
+	postedBefore := time.Date(2024, 9, 17, 12, 0, 0, 0, time.UTC)
+
+	mockRepo := new(mocks.MockRepository)
+	mockRepo.On("ListTransactionAccountIDs").Return([]int64{1, 2}, nil)
+	mockRepo.On("LockAccountBalance", int64(1)).Return(models.AccountTotals{}, errors.New("account 1 has no balance row"))
+	mockRepo.On("LockAccountBalance", int64(2)).Return(models.AccountTotals{AccountID: 2}, nil)
+	mockRepo.On("ArchiveTransactions", int64(2), postedBefore, 500).Return(3, nil).Once()
+	mockRepo.On("ArchiveTransactions", int64(2), postedBefore, 500).Return(0, nil).Once()
+
+	report, err := services.NewArchiveService(mockRepo).Archive(postedBefore)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ArchiveReport{
+		PostedBefore: postedBefore,
+		Accounts:     1,
+		Transactions: 3,
+		Errors:       map[int64]string{1: "account 1 has no balance row"},
+	}, report)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/audit"
+	"pismo/models"
+	"pismo/services"
+)
+
+// chainedAuditLog is an audit repository over a slice, paged the way the store pages
+type chainedAuditLog struct {
+	entries []models.AuditEntry
+	heads   map[int64]string
+}
+
+func newChainedAuditLog(count int) *chainedAuditLog {
+	log := &chainedAuditLog{heads: map[int64]string{0: audit.GenesisHash}}
+	at := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+	for i := 0; i < count; i++ {
+		log.AppendAuditEntry(models.AuditEntry{OccurredAt: at.Add(time.Duration(i) * time.Second), Kind: models.AuditKindRequest, Route: "/transactions", AccountID: int64(1 + i%3)})
+	}
+	return log
+}
+
+func (l *chainedAuditLog) AppendAuditEntry(entry models.AuditEntry) (models.AuditEntry, error) {
+	entry.ID = int64(len(l.entries) + 1)
+	entry.ChainID = entry.AccountID
+	entry.PrevHash = audit.GenesisHash
+	if head, ok := l.heads[entry.ChainID]; ok {
+		entry.PrevHash = head
+	}
+	entry.Hash = audit.Hash(entry)
+	l.heads[entry.ChainID] = entry.Hash
+	l.entries = append(l.entries, entry)
+	return entry, nil
+}
+
+func (l *chainedAuditLog) ListAuditEntries(filter models.AuditFilter) ([]models.AuditEntry, error) {
+	var page []models.AuditEntry
+	for _, entry := range l.entries {
+		if entry.ID > filter.AfterID && (filter.AccountID == 0 || entry.AccountID == filter.AccountID) && len(page) < filter.Limit {
+			page = append(page, entry)
+		}
+	}
+	return page, nil
+}
+
+func (l *chainedAuditLog) ListAuditChainHeads() (map[int64]string, error) {
+	return l.heads, nil
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	tests := []struct {
+		name            string
+		tamper          func(*chainedAuditLog)
+		expectedValid   bool
+		expectedEntries int64
+		expectedChains  int
+		expectedProblem string
+	}{
+		{
+			name:            "Intact chains spanning several pages",
+			tamper:          func(*chainedAuditLog) {},
+			expectedValid:   true,
+			expectedEntries: 1200,
+			expectedChains:  4,
+		},
+		{
+			name: "Entry modified on the second page",
+			tamper: func(l *chainedAuditLog) {
+				l.entries[700].Outcome = 500
+			},
+			expectedEntries: 500,
+			expectedProblem: "audit chain broken: entry 701 has been modified",
+		},
+		{
+			name: "Latest entries cut off",
+			tamper: func(l *chainedAuditLog) {
+				l.entries = l.entries[:1100]
+			},
+			expectedEntries: 1100,
+			expectedProblem: "audit chain broken: chain 1 ends before its head, entries were removed",
+		},
+		{
+			name: "Whole chain removed",
+			tamper: func(l *chainedAuditLog) {
+				var kept []models.AuditEntry
+				for _, entry := range l.entries {
+					if entry.ChainID != 2 {
+						kept = append(kept, entry)
+					}
+				}
+				l.entries = kept
+			},
+			expectedEntries: 800,
+			expectedProblem: "audit chain broken: chain 2 ends before its head, entries were removed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := newChainedAuditLog(1200)
+			tt.tamper(log)
+
+			result, err := services.NewAuditService(log).VerifyChain()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedValid, result.Valid)
+			assert.Equal(t, tt.expectedEntries, result.Entries)
+			assert.Equal(t, tt.expectedChains, result.Chains)
+			assert.Equal(t, tt.expectedProblem, result.Problem)
+		})
+	}
+}
+
+func TestListAuditEntriesRejectsEmptyRange(t *testing.T) {
+	at := time.Date(2024, 9, 17, 0, 0, 0, 0, time.UTC)
+	_, err := services.NewAuditService(newChainedAuditLog(1)).ListEntries(models.AuditFilter{From: at, To: at})
+	assert.EqualError(t, err, "invalid audit filter: from must be before to")
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"pismo/fx"
+	"pismo/mocks"
+	"pismo/models"
+	"pismo/publicid"
+	"pismo/services"
+	"pismo/store"
+)
+
+// authorizationPublicIDArg matches the public id Authorize makes for a new hold
+type authorizationPublicIDArg struct{}
+
+func (authorizationPublicIDArg) Match(v driver.Value) bool {
+	id, ok := v.(string)
+	return ok && publicid.Valid(id, publicid.Authorization)
+}
+
+// anyTx matches the unit of work a capture posts its transaction in
+var anyTx = mock.Anything
+
+var authorizationColumns = []string{"authorization_id", "public_id", "account_id", "account_public_id", "operation_type_id", "amount", "currency", "original_amount", "original_currency", "fx_rate", "status", "transaction_id", "transaction_public_id", "expires_at", "version", "created_at", "updated_at"}
+
+func TestAuthorize(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	repo := store.NewRepository(db, store.MySQL)
+	rates := fx.NewStaticRateProvider(map[string]float64{"USD/BRL": 5.0})
+	service := services.NewAuthorizationService(repo, new(mocks.MockTransactionService), rates, time.Hour)
+
+	expectAccount := func(accountID int64) {
+		mock.ExpectQuery(`SELECT .* FROM Accounts WHERE account_id = \?`).
+			WithArgs(accountID).
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "public_id", "document_number", "document_type", "holder_name", "country", "product_id", "currency", "tenant_id", "status", "credit_limit", "version", "created_at", "updated_at"}).
+				AddRow(accountID, "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", "12345678909", "CPF", "Maria Silva", "BR", 1, "BRL", "", "ACTIVE", nil, 1, time.Now(), time.Now()))
+	}
+
+	tests := []struct {
+		name           string
+		authorization  models.Authorization
+		mockSetup      func()
+		expectedResult models.Authorization
+		expectedError  string
+	}{
+		{
+			name:          "Successful hold within the available credit",
+			authorization: models.Authorization{AccountID: 1, OperationTypeID: 1, Amount: -10.0, Currency: "USD"},
+			mockSetup: func() {
+				expectAccount(1)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT .* FROM AccountBalances WHERE account_id = \? FOR UPDATE`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"account_id", "total_debt", "total_credit", "available_limit", "version", "updated_at"}).
+						AddRow(1, 0.0, 0.0, 100.0, 0, time.Now()))
+				mock.ExpectQuery(`SELECT b.available_limit`).
+					WithArgs(sqlmock.AnyArg(), 1).
+					WillReturnRows(sqlmock.NewRows([]string{"available"}).AddRow(100.0))
+				mock.ExpectExec(`INSERT INTO Authorizations`).
+					WithArgs(authorizationPublicIDArg{}, 1, "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", 1, -50.0, "BRL", -10.0, "USD", 5.0, "PENDING", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(7, 1))
+				mock.ExpectCommit()
+			},
+			expectedResult: models.Authorization{ID: 7, AccountID: 1, AccountPublicID: "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", OperationTypeID: 1, Amount: -50.0, Currency: "BRL", OriginalAmount: -10.0, OriginalCurrency: "USD", FXRate: 5.0, Status: "PENDING", Version: 1},
+		},
+		{
+			name:          "Blocked account can't place holds",
+			authorization: models.Authorization{AccountID: 2, OperationTypeID: 1, Amount: -10.0},
+			mockSetup: func() {
+				mock.ExpectQuery(`SELECT .* FROM Accounts WHERE account_id = \?`).
+					WithArgs(2).
+					WillReturnRows(sqlmock.NewRows([]string{"account_id", "public_id", "document_number", "document_type", "holder_name", "country", "product_id", "currency", "tenant_id", "status", "credit_limit", "version", "created_at", "updated_at"}).
+						AddRow(2, "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", "12345678909", "CPF", "Maria Silva", "BR", 1, "BRL", "", "BLOCKED", nil, 4, time.Now(), time.Now()))
+			},
+			expectedError: "account not active: account acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3 is BLOCKED",
+		},
+		{
+			name:          "Hold larger than the available credit",
+			authorization: models.Authorization{AccountID: 1, OperationTypeID: 1, Amount: -150.0},
+			mockSetup: func() {
+				expectAccount(1)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT .* FROM AccountBalances WHERE account_id = \? FOR UPDATE`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"account_id", "total_debt", "total_credit", "available_limit", "version", "updated_at"}).
+						AddRow(1, 0.0, 0.0, 100.0, 0, time.Now()))
+				mock.ExpectQuery(`SELECT b.available_limit`).
+					WithArgs(sqlmock.AnyArg(), 1).
+					WillReturnRows(sqlmock.NewRows([]string{"available"}).AddRow(100.0))
+				mock.ExpectRollback()
+			},
+			expectedError: "insufficient available credit: requested 150.00, available 100.00",
+		},
+		{
+			name:          "Credits cannot be authorized",
+			authorization: models.Authorization{AccountID: 1, OperationTypeID: 4, Amount: 10.0},
+			mockSetup:     func() {},
+			expectedError: "only debit operations can be authorized",
+		},
+		{
+			name:          "Account does not exist",
+			authorization: models.Authorization{AccountID: 9, OperationTypeID: 1, Amount: -10.0},
+			mockSetup: func() {
+				mock.ExpectQuery(`SELECT .* FROM Accounts WHERE account_id = \?`).
+					WithArgs(9).
+					WillReturnError(sql.ErrNoRows)
+			},
+			expectedError: "account not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockSetup()
+
+			result, err := service.Authorize(tt.authorization)
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.WithinDuration(t, time.Now().Add(time.Hour), result.ExpiresAt, time.Minute)
+				assert.True(t, publicid.Valid(string(result.PublicID), publicid.Authorization))
+				result.ExpiresAt = time.Time{}
+				result.PublicID = ""
+			}
+			assert.Equal(t, tt.expectedResult, result)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetAuthorizationByRef(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	repo := store.NewRepository(db, store.MySQL)
+	service := services.NewAuthorizationService(repo, new(mocks.MockTransactionService), fx.NewStaticRateProvider(nil), time.Hour)
+
+	now := time.Now().UTC()
+	row := func() *sqlmock.Rows {
+		return sqlmock.NewRows(authorizationColumns).
+			AddRow(3, "auth_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", 1, "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", 1, -54.2, "BRL", -10.0, "USD", 5.42, "PENDING", nil, nil, now, 1, now, now)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM Authorizations WHERE public_id = \?`).
+		WithArgs("auth_01J8Z3V4W5X6Y7Z8A9B0C1D2E3").
+		WillReturnRows(row())
+	hold, err := service.GetAuthorizationByRef("auth_01J8Z3V4W5X6Y7Z8A9B0C1D2E3")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), hold.ID)
+
+	// while legacy ids are accepted a number is the internal id
+	mock.ExpectQuery(`SELECT .* FROM Authorizations WHERE authorization_id = \?`).
+		WithArgs(int64(3)).
+		WillReturnRows(row())
+	hold, err = service.GetAuthorizationByRef("3")
+	assert.NoError(t, err)
+	assert.Equal(t, models.PublicID("auth_01J8Z3V4W5X6Y7Z8A9B0C1D2E3"), hold.PublicID)
+
+	mock.ExpectQuery(`SELECT .* FROM Authorizations WHERE public_id = \?`).
+		WithArgs("auth_01J8Z3V4W5X6Y7Z8A9B0C1D2E4").
+		WillReturnError(sql.ErrNoRows)
+	_, err = service.GetAuthorizationByRef("auth_01J8Z3V4W5X6Y7Z8A9B0C1D2E4")
+	assert.ErrorIs(t, err, services.ErrAuthorizationNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCaptureAndVoid(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	repo := store.NewRepository(db, store.MySQL)
+	mockTransactions := new(mocks.MockTransactionService)
+	service := services.NewAuthorizationService(repo, mockTransactions, fx.NewStaticRateProvider(nil), time.Hour)
+
+	now := time.Now().UTC()
+	authorizationRow := func(status string, expiresAt time.Time) *sqlmock.Rows {
+		return sqlmock.NewRows(authorizationColumns).
+			AddRow(3, "auth_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", 1, "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", 1, -54.2, "BRL", -10.0, "USD", 5.42, status, nil, nil, expiresAt, 1, now, now)
+	}
+	expectLockedAuthorization := func(status string, expiresAt time.Time) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT .* FROM Authorizations WHERE authorization_id = \? FOR UPDATE`).
+			WithArgs(int64(3)).
+			WillReturnRows(authorizationRow(status, expiresAt))
+	}
+	// a capture looks the hold up to lock its account first, then the hold itself
+	expectCaptureLocks := func(status string, expiresAt time.Time) {
+		mock.ExpectQuery(`SELECT .* FROM Authorizations WHERE authorization_id = \?$`).
+			WithArgs(int64(3)).
+			WillReturnRows(authorizationRow("PENDING", now.Add(time.Hour)))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT .* FROM AccountBalances WHERE account_id = \? FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "total_debt", "total_credit", "available_limit", "version", "updated_at"}).
+				AddRow(1, 0.0, 0.0, 1000.0, 1, now))
+		mock.ExpectQuery(`SELECT .* FROM Authorizations WHERE authorization_id = \? FOR UPDATE`).
+			WithArgs(int64(3)).
+			WillReturnRows(authorizationRow(status, expiresAt))
+	}
+	expectStatus := func(status string, transactionID sql.NullInt64) {
+		var transactionPublicID sql.NullString
+		if transactionID.Valid {
+			transactionPublicID = sql.NullString{String: fmt.Sprintf("txn_01J8Z3V4W5X6Y7Z8A9B0C1D2%02d", transactionID.Int64), Valid: true}
+		}
+		mock.ExpectExec(`UPDATE Authorizations SET status = \?, transaction_id = \?, transaction_public_id = \?, version = version \+ 1 WHERE authorization_id = \?`).
+			WithArgs(status, transactionID, transactionPublicID, int64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	tests := []struct {
+		name           string
+		action         func() (models.Authorization, error)
+		mockSetup      func()
+		expectedStatus string
+		expectedTxnID  int64
+		expectedTxnRef models.PublicID
+		expectedError  string
+	}{
+		{
+			name:   "Full capture posts the original amount in the original currency",
+			action: func() (models.Authorization, error) { return service.Capture(3, 0) },
+			mockSetup: func() {
+				expectCaptureLocks("PENDING", now.Add(time.Hour))
+				mockTransactions.On("CreateTransactionWithin", anyTx, models.Transaction{AccountID: 1, OperationTypeID: 1, Amount: -10.0, Currency: "USD"}).
+					Return(models.Transaction{ID: 12, PublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D212"}, nil)
+				expectStatus("CAPTURED", sql.NullInt64{Int64: 12, Valid: true})
+				mock.ExpectCommit()
+			},
+			expectedStatus: "CAPTURED",
+			expectedTxnID:  12,
+			expectedTxnRef: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D212",
+		},
+		{
+			name:   "Partial capture posts the captured amount",
+			action: func() (models.Authorization, error) { return service.Capture(3, -8.5) },
+			mockSetup: func() {
+				expectCaptureLocks("PENDING", now.Add(time.Hour))
+				mockTransactions.On("CreateTransactionWithin", anyTx, models.Transaction{AccountID: 1, OperationTypeID: 1, Amount: -8.5, Currency: "USD"}).
+					Return(models.Transaction{ID: 13, PublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D213"}, nil)
+				expectStatus("CAPTURED", sql.NullInt64{Int64: 13, Valid: true})
+				mock.ExpectCommit()
+			},
+			expectedStatus: "CAPTURED",
+			expectedTxnID:  13,
+			expectedTxnRef: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D213",
+		},
+		{
+			name:   "Failed posting leaves the hold pending",
+			action: func() (models.Authorization, error) { return service.Capture(3, 0) },
+			mockSetup: func() {
+				expectCaptureLocks("PENDING", now.Add(time.Hour))
+				mockTransactions.On("CreateTransactionWithin", anyTx, models.Transaction{AccountID: 1, OperationTypeID: 1, Amount: -10.0, Currency: "USD"}).
+					Return(models.Transaction{}, errors.New("some db error"))
+				mock.ExpectRollback()
+			},
+			expectedError: "some db error",
+		},
+		{
+			name:   "Capturing more than the hold is refused",
+			action: func() (models.Authorization, error) { return service.Capture(3, -12) },
+			mockSetup: func() {
+				expectCaptureLocks("PENDING", now.Add(time.Hour))
+				mock.ExpectRollback()
+			},
+			expectedError: "invalid capture amount: 12.00 is more than the 10.00 held",
+		},
+		{
+			name:   "Capturing a credit is refused",
+			action: func() (models.Authorization, error) { return service.Capture(3, 5) },
+			mockSetup: func() {
+				expectCaptureLocks("PENDING", now.Add(time.Hour))
+				mock.ExpectRollback()
+			},
+			expectedError: "invalid capture amount: invalid transaction amount 5.00 for the given operation type ID 1: expected Debit direction",
+		},
+		{
+			name:   "Capturing an expired hold expires it",
+			action: func() (models.Authorization, error) { return service.Capture(3, 0) },
+			mockSetup: func() {
+				expectCaptureLocks("PENDING", now.Add(-time.Minute))
+				expectStatus("EXPIRED", sql.NullInt64{})
+				mock.ExpectCommit()
+			},
+			expectedError: "authorization has expired",
+		},
+		{
+			name:   "Capturing twice is rejected",
+			action: func() (models.Authorization, error) { return service.Capture(3, 0) },
+			mockSetup: func() {
+				expectCaptureLocks("CAPTURED", now.Add(time.Hour))
+				mock.ExpectRollback()
+			},
+			expectedError: "authorization is no longer pending: authorization 3 is CAPTURED",
+		},
+		{
+			name:   "Capture unknown authorization",
+			action: func() (models.Authorization, error) { return service.Capture(3, 0) },
+			mockSetup: func() {
+				mock.ExpectQuery(`SELECT .* FROM Authorizations WHERE authorization_id = \?$`).
+					WithArgs(int64(3)).
+					WillReturnError(sql.ErrNoRows)
+			},
+			expectedError: "authorization not found",
+		},
+		{
+			name:   "Void releases the hold",
+			action: func() (models.Authorization, error) { return service.Void(3) },
+			mockSetup: func() {
+				expectLockedAuthorization("PENDING", now.Add(time.Hour))
+				expectStatus("VOIDED", sql.NullInt64{})
+				mock.ExpectCommit()
+			},
+			expectedStatus: "VOIDED",
+		},
+		{
+			name:   "Void unknown authorization",
+			action: func() (models.Authorization, error) { return service.Void(3) },
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT .* FROM Authorizations WHERE authorization_id = \? FOR UPDATE`).
+					WithArgs(int64(3)).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			expectedError: "authorization not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTransactions.ExpectedCalls = nil
+			tt.mockSetup()
+
+			result, err := tt.action()
+
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedStatus, result.Status)
+				assert.Equal(t, tt.expectedTxnID, result.TransactionID)
+				assert.Equal(t, tt.expectedTxnRef, result.TransactionPublicID)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+			mockTransactions.AssertExpectations(t)
+		})
+	}
+}
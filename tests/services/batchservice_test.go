@@ -0,0 +1,146 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/mocks"
+	"pismo/models"
+	"pismo/services"
+)
+
+// recordingTransactionService posts every transaction successfully, except to
+// accounts 98 and 99, remembering the order each account saw them in
+type recordingTransactionService struct {
+	mu        sync.Mutex
+	nextID    int64
+	byAccount map[int64][]float64
+	inFlight  map[int64]bool
+	overlaps  int
+	active    int
+	maxActive int
+}
+
+func (s *recordingTransactionService) CreateTransaction(transaction models.Transaction) (models.Transaction, error) {
+	s.mu.Lock()
+	if transaction.AccountID == 99 {
+		s.mu.Unlock()
+		return models.Transaction{}, services.ErrAccountNotFound
+	}
+	if transaction.AccountID == 98 {
+		s.mu.Unlock()
+		return models.Transaction{}, errors.New("lock wait timeout exceeded")
+	}
+	if s.inFlight[transaction.AccountID] {
+		s.overlaps++
+	}
+	s.inFlight[transaction.AccountID] = true
+	s.active++
+	if s.active > s.maxActive {
+		s.maxActive = s.active
+	}
+	s.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight[transaction.AccountID] = false
+	s.active--
+	s.nextID++
+	s.byAccount[transaction.AccountID] = append(s.byAccount[transaction.AccountID], transaction.Amount)
+	transaction.ID = s.nextID
+	transaction.PublicID = models.PublicID(fmt.Sprintf("txn_%026d", s.nextID))
+	transaction.AccountPublicID = accountPublicID(transaction.AccountID)
+	return transaction, nil
+}
+
+// accountPublicID is the public id of the account in these tests
+func accountPublicID(accountID int64) models.PublicID {
+	return models.PublicID(fmt.Sprintf("acc_%026d", accountID))
+}
+
+// legacyRef is how a client still on internal ids names the account
+func legacyRef(accountID int64) models.PublicID {
+	return models.PublicID(strconv.FormatInt(accountID, 10))
+}
+
+func TestProcessBatch(t *testing.T) {
+	transactions := &recordingTransactionService{byAccount: map[int64][]float64{}, inFlight: map[int64]bool{}}
+	accounts := new(mocks.MockAccountService)
+	service := services.NewBatchService(transactions, accounts, 3)
+
+	// odd accounts are named by their public ids, even ones by their internal ids
+	for account := int64(1); account <= 6; account += 2 {
+		accounts.On("GetAccountByRef", accountPublicID(account)).Return(models.Account{ID: account, PublicID: accountPublicID(account)}, nil).Once()
+	}
+	accounts.On("GetAccountByRef", accountPublicID(97)).Return(models.Account{}, sql.ErrNoRows).Once()
+	ref := func(account int64) models.PublicID {
+		if account%2 == 1 {
+			return accountPublicID(account)
+		}
+		return legacyRef(account)
+	}
+
+	// 6 accounts, 10 rows each, interleaved
+	var rows []models.BatchRow
+	for i := 0; i < 60; i++ {
+		rows = append(rows, models.BatchRow{
+			Row:         len(rows) + 1,
+			Transaction: models.Transaction{AccountPublicID: ref(int64(1 + i%6)), OperationTypeID: 4, Amount: float64(i + 1)},
+		})
+	}
+	rows = append(rows,
+		models.BatchRow{Row: 61, Transaction: models.Transaction{AccountPublicID: legacyRef(1)}, Error: "No Amount provided"},
+		models.BatchRow{Row: 62, Transaction: models.Transaction{AccountPublicID: legacyRef(99), OperationTypeID: 4, Amount: 5}},
+		models.BatchRow{Row: 63, Transaction: models.Transaction{AccountPublicID: legacyRef(98), OperationTypeID: 4, Amount: 5}},
+		models.BatchRow{Row: 64, Transaction: models.Transaction{AccountPublicID: accountPublicID(97), OperationTypeID: 4, Amount: 5}},
+		models.BatchRow{Row: 65, Transaction: models.Transaction{AccountPublicID: accountPublicID(97), OperationTypeID: 4, Amount: 5}},
+	)
+
+	report := service.ProcessBatch(rows)
+
+	assert.Equal(t, 65, report.Total)
+	assert.Equal(t, 60, report.Created)
+	assert.Equal(t, 4, report.Rejected)
+	assert.Equal(t, 1, report.Failed)
+	assert.Len(t, report.Results, 65)
+
+	// results come back in the order rows were sent, with the public ids of what
+	// was posted whichever way the account was named
+	for i, result := range report.Results[:60] {
+		assert.Equal(t, i+1, result.Row)
+		assert.Equal(t, models.BatchRowCreated, result.Status)
+		assert.Equal(t, accountPublicID(int64(1+i%6)), result.AccountPublicID)
+		assert.NotEmpty(t, result.TransactionPublicID)
+	}
+	assert.Equal(t, models.BatchResult{Row: 61, AccountPublicID: "1", Status: models.BatchRowRejected, Error: "No Amount provided"}, report.Results[60])
+	assert.Equal(t, models.BatchResult{Row: 62, AccountPublicID: "99", Status: models.BatchRowRejected, Error: "account not found"}, report.Results[61])
+	assert.Equal(t, models.BatchResult{Row: 63, AccountPublicID: "98", Status: models.BatchRowFailed, Error: "lock wait timeout exceeded"}, report.Results[62])
+	// an unknown public id is looked up once however many rows name it
+	for _, result := range report.Results[63:] {
+		assert.Equal(t, models.BatchRowRejected, result.Status)
+		assert.Equal(t, "account not found", result.Error)
+	}
+	accounts.AssertExpectations(t)
+
+	// each account saw its rows in order and never two at once
+	for account := 1; account <= 6; account++ {
+		var expected []float64
+		for i := account - 1; i < 60; i += 6 {
+			expected = append(expected, float64(i+1))
+		}
+		assert.Equal(t, expected, transactions.byAccount[int64(account)], fmt.Sprintf("account %d", account))
+	}
+	assert.Zero(t, transactions.overlaps)
+	// different accounts were posted concurrently, but never more than allowed
+	assert.LessOrEqual(t, transactions.maxActive, 3)
+	assert.Greater(t, transactions.maxActive, 1)
+}
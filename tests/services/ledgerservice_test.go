@@ -0,0 +1,52 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/models"
+	"pismo/services"
+	"pismo/store"
+)
+
+func TestTrialBalance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT la.currency, la.code, la.type, la.account_id`).
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "code", "type", "account_id", "debits", "credits"}).
+			AddRow("BRL", "cash:BRL", models.LedgerAsset, nil, 0.3, 0.0).
+			AddRow("BRL", "customer:1", models.LedgerAsset, 1, 0.1, 0.4).
+			AddRow("USD", "cash:USD", models.LedgerAsset, nil, 0.0, 20.0).
+			AddRow("USD", "customer:2", models.LedgerAsset, 2, 19.99, 0.0))
+
+	balances, err := services.NewLedgerService(store.NewRepository(db, store.MySQL)).TrialBalance()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []models.TrialBalance{
+		{
+			Currency: "BRL",
+			Lines: []models.TrialBalanceLine{
+				{Code: "cash:BRL", Type: models.LedgerAsset, Debits: 0.3, Balance: 0.3},
+				{Code: "customer:1", Type: models.LedgerAsset, AccountID: 1, Debits: 0.1, Credits: 0.4, Balance: -0.3},
+			},
+			TotalDebits:  0.4,
+			TotalCredits: 0.4,
+			Balanced:     true,
+		},
+		{
+			Currency: "USD",
+			Lines: []models.TrialBalanceLine{
+				{Code: "cash:USD", Type: models.LedgerAsset, Credits: 20, Balance: -20},
+				{Code: "customer:2", Type: models.LedgerAsset, AccountID: 2, Debits: 19.99, Balance: 19.99},
+			},
+			TotalDebits:  19.99,
+			TotalCredits: 20,
+			Balanced:     false,
+		},
+	}, balances)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/fx"
+	"pismo/models"
+	"pismo/services"
+	"pismo/store"
+)
+
+// newMemoryRepository holds the products of init.sql and no accounts
+func newMemoryRepository() *store.MemoryRepository {
+	return store.NewMemoryRepository(
+		models.Product{ID: 1, Name: "Standard", CreditLimit: 1000},
+		models.Product{ID: 2, Name: "Gold", CreditLimit: 5000},
+	)
+}
+
+func TestMemoryDischarge(t *testing.T) {
+	repo := newMemoryRepository()
+	transactions := services.NewTransactionService(repo, fx.NewStaticRateProvider(nil), services.DefaultBackdatingWindow)
+	accounts := services.NewAccountService(repo)
+
+	account, err := accounts.CreateAccount(models.Account{DocumentNumber: "123.456.789-09", HolderName: "Maria Silva"})
+	assert.NoError(t, err)
+
+	for _, amount := range []float64{-50, -25, -15} {
+		_, err := transactions.CreateTransaction(models.Transaction{AccountID: account.ID, OperationTypeID: 1, Amount: amount})
+		assert.NoError(t, err)
+	}
+
+	// pays off the 50 and 10 of the 25, in the order they were posted
+	_, err = transactions.CreateTransaction(models.Transaction{AccountID: account.ID, OperationTypeID: 4, Amount: 60})
+	assert.NoError(t, err)
+
+	balance, err := accounts.GetAccountBalance(account.ID, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 30.0, balance.OpenDebt)
+	assert.Equal(t, 0.0, balance.OpenCredit)
+
+	totals, err := accounts.GetAccountTotals(account.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 30.0, totals.TotalDebt)
+	assert.Equal(t, 970.0, totals.AvailableLimit)
+
+	// more than is owed, what is left over stays on the payment
+	_, err = transactions.CreateTransaction(models.Transaction{AccountID: account.ID, OperationTypeID: 4, Amount: 100})
+	assert.NoError(t, err)
+
+	totals, err = accounts.GetAccountTotals(account.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, totals.TotalDebt)
+	assert.Equal(t, 70.0, totals.TotalCredit)
+	assert.Equal(t, 1000.0, totals.AvailableLimit)
+
+	_, err = transactions.CreateTransaction(models.Transaction{AccountID: 9, OperationTypeID: 4, Amount: 100})
+	assert.ErrorIs(t, err, services.ErrAccountNotFound)
+}
+
+func TestMemoryCapture(t *testing.T) {
+	repo := newMemoryRepository()
+	transactions := services.NewTransactionService(repo, fx.NewStaticRateProvider(nil), services.DefaultBackdatingWindow)
+	accounts := services.NewAccountService(repo)
+	authorizations := services.NewAuthorizationService(repo, transactions, fx.NewStaticRateProvider(nil), time.Hour)
+
+	account, err := accounts.CreateAccount(models.Account{DocumentNumber: "123.456.789-09", HolderName: "Maria Silva"})
+	assert.NoError(t, err)
+	hold, err := authorizations.Authorize(models.Authorization{AccountID: account.ID, OperationTypeID: 1, Amount: -40, Currency: "BRL"})
+	assert.NoError(t, err)
+
+	// more than the hold is refused and nothing of it is kept
+	_, err = authorizations.Capture(hold.ID, -50)
+	assert.ErrorIs(t, err, services.ErrInvalidCapture)
+	pending, err := authorizations.GetAuthorizationByRef(hold.PublicID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.AuthorizationPending, pending.Status)
+
+	captured, err := authorizations.Capture(hold.ID, -30)
+	assert.NoError(t, err)
+	assert.Equal(t, models.AuthorizationCaptured, captured.Status)
+
+	// the hold is linked to the purchase it posted
+	stored, err := authorizations.GetAuthorizationByRef(hold.PublicID)
+	assert.NoError(t, err)
+	assert.Equal(t, captured.TransactionPublicID, stored.TransactionPublicID)
+	totals, err := accounts.GetAccountTotals(account.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 30.0, totals.TotalDebt)
+	assert.Equal(t, 970.0, totals.AvailableLimit)
+}
+
+func TestMemoryConcurrentTransactions(t *testing.T) {
+	repo := newMemoryRepository()
+	transactions := services.NewTransactionService(repo, fx.NewStaticRateProvider(nil), services.DefaultBackdatingWindow)
+	accounts := services.NewAccountService(repo)
+
+	const perAccount = 20
+	documents := []string{"52998224725", "11144477735"}
+	var wg sync.WaitGroup
+	for _, document := range documents {
+		account, err := accounts.CreateAccount(models.Account{DocumentNumber: document, HolderName: "Maria Silva"})
+		assert.NoError(t, err)
+
+		// purchases and payments of every account interleave
+		for i := 0; i < perAccount; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				transaction := models.Transaction{AccountID: account.ID, OperationTypeID: 1, Amount: -10}
+				if i%2 == 1 {
+					transaction.OperationTypeID, transaction.Amount = 4, 5
+				}
+				_, err := transactions.CreateTransaction(transaction)
+				assert.NoError(t, err)
+			}(i)
+		}
+	}
+	wg.Wait()
+
+	// 10 purchases of 10 and 10 payments of 5, whatever order they were posted in
+	for i := range documents {
+		accountID := int64(i + 1)
+		balance, err := accounts.GetAccountBalance(accountID, time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, -50.0, balance.Balance)
+
+		totals, err := accounts.GetAccountTotals(accountID)
+		assert.NoError(t, err)
+		assert.Equal(t, balance.OpenDebt, totals.TotalDebt)
+		assert.Equal(t, balance.OpenCredit, totals.TotalCredit)
+		assert.Equal(t, 1000.0-totals.TotalDebt, totals.AvailableLimit)
+		assert.Equal(t, int64(perAccount), totals.Version)
+	}
+}
+
+func TestMemoryArchive(t *testing.T) {
+	repo := newMemoryRepository()
+	transactions := services.NewTransactionService(repo, fx.NewStaticRateProvider(nil), services.DefaultBackdatingWindow)
+	accounts := services.NewAccountService(repo)
+
+	account, err := accounts.CreateAccount(models.Account{DocumentNumber: "123.456.789-09", HolderName: "Maria Silva"})
+	assert.NoError(t, err)
+
+	// the 50 is paid off by the first credit, the 25 only partly by the second
+	for _, transaction := range []models.Transaction{
+		{OperationTypeID: 1, Amount: -50},
+		{OperationTypeID: 1, Amount: -25},
+		{OperationTypeID: 4, Amount: 50},
+		{OperationTypeID: 4, Amount: 10},
+	} {
+		transaction.AccountID = account.ID
+		_, err := transactions.CreateTransaction(transaction)
+		assert.NoError(t, err)
+	}
+	before, err := accounts.GetAccountBalance(account.ID, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+
+	archived, err := services.NewArchiveService(repo).ArchiveAccount(account.ID, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, archived)
+
+	hot, err := repo.ListAccountTransactions(account.ID)
+	assert.NoError(t, err)
+	assert.Len(t, hot, 2)
+
+	// what is left still replays to what it has, and past balances still see everything
+	result, err := services.NewReconciliationService(repo).ReconcileAccount(account.ID, false)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Discrepancies)
+	assert.False(t, result.StaleAllocations)
+	assert.False(t, result.StaleTotals)
+
+	after, err := accounts.GetAccountBalance(account.ID, before.AsOf)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.Equal(t, 15.0, after.OpenDebt)
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/audit"
+	"pismo/models"
+	"pismo/services"
+	"pismo/store"
+)
+
+func TestReplayBalances(t *testing.T) {
+	tests := []struct {
+		name         string
+		transactions []models.Transaction
+		expected     []float64
+	}{
+		{
+			name: "Credit pays off the oldest debts first",
+			transactions: []models.Transaction{
+				{ID: 1, OperationTypeID: 1, Amount: -50},
+				{ID: 2, OperationTypeID: 1, Amount: -23.5},
+				{ID: 3, OperationTypeID: 1, Amount: -18.7},
+				{ID: 4, OperationTypeID: 4, Amount: 60},
+				{ID: 5, OperationTypeID: 4, Amount: 100},
+			},
+			expected: []float64{0, 0, 0, 0, 67.8},
+		},
+		{
+			name: "Leftover credit is not spent on later debts",
+			transactions: []models.Transaction{
+				{ID: 1, OperationTypeID: 4, Amount: 100},
+				{ID: 2, OperationTypeID: 3, Amount: -40},
+			},
+			expected: []float64{100, -40},
+		},
+		{
+			name: "Exact payment settles the debt",
+			transactions: []models.Transaction{
+				{ID: 1, OperationTypeID: 2, Amount: -0.3},
+				{ID: 2, OperationTypeID: 4, Amount: 0.1},
+				{ID: 3, OperationTypeID: 4, Amount: 0.2},
+			},
+			expected: []float64{0, 0, 0},
+		},
+		{
+			name:         "No transactions",
+			transactions: nil,
+			expected:     []float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, services.ReplayBalances(tt.transactions))
+		})
+	}
+}
+
+func TestReconcileAccount(t *testing.T) {
+	columns := []string{"transaction_id", "public_id", "account_id", "operation_type_id", "amount", "balance", "event_date", "posting_date", "currency", "original_amount", "original_currency", "fx_rate"}
+	eventDate := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+	// a debt of 50 paid off by 60, but the debt was edited back to -50 by hand
+	corrupted := func() *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow(1, "txn_01J8Z3V4W5X6Y7Z8A9B0C1D201", 1, 1, -50.0, -50.0, eventDate, eventDate, "BRL", -50.0, "BRL", 1.0).
+			AddRow(2, "txn_01J8Z3V4W5X6Y7Z8A9B0C1D202", 1, 4, 60.0, 10.0, eventDate.Add(time.Hour), eventDate.Add(time.Hour), "BRL", 60.0, "BRL", 1.0)
+	}
+	discrepancy := models.BalanceDiscrepancy{AccountID: 1, TransactionID: 1, OperationTypeID: 1, EventDate: eventDate, Stored: -50, Expected: 0}
+	allocationColumns := []string{"allocation_id", "account_id", "debit_transaction_id", "credit_transaction_id", "amount", "allocated_at"}
+	// the allocation discharge recorded when the 60 was posted
+	recorded := func() *sqlmock.Rows {
+		return sqlmock.NewRows(allocationColumns).AddRow(1, 1, 1, 2, 50.0, eventDate.Add(time.Hour))
+	}
+	totalsColumns := []string{"account_id", "total_debt", "total_credit", "available_limit", "version", "updated_at"}
+	// the account's running totals, at version 3
+	expectLock := func(mock sqlmock.Sqlmock, debt, credit float64) {
+		mock.ExpectQuery(`SELECT account_id, total_debt, total_credit, available_limit, version, updated_at FROM AccountBalances WHERE account_id = \? FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(totalsColumns).AddRow(1, debt, credit, 1000.0-debt, 3, eventDate))
+	}
+
+	t.Run("Check only reports", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		// read from one snapshot
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT .* FROM Transactions WHERE account_id = \? ORDER BY posting_date ASC, transaction_id ASC$`).
+			WithArgs(1).
+			WillReturnRows(corrupted())
+		mock.ExpectQuery(`SELECT .* FROM DischargeAllocations WHERE account_id = \? ORDER BY allocation_id ASC`).
+			WithArgs(1).
+			WillReturnRows(recorded())
+		mock.ExpectQuery(`SELECT account_id, total_debt, total_credit, available_limit, version, updated_at FROM AccountBalances WHERE account_id = \?$`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(totalsColumns).AddRow(1, 50.0, 10.0, 950.0, 3, eventDate))
+		mock.ExpectCommit()
+
+		result, err := services.NewReconciliationService(store.NewRepository(db, store.MySQL)).ReconcileAccount(1, false)
+
+		assert.NoError(t, err)
+		assert.Equal(t, models.AccountReconciliation{AccountID: 1, Transactions: 2, Discrepancies: []models.BalanceDiscrepancy{discrepancy}, StaleTotals: true}, result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Repair overwrites the balance and audits it", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		expectLock(mock, 50.0, 10.0)
+		mock.ExpectQuery(`SELECT .* FROM Transactions WHERE account_id = \? ORDER BY posting_date ASC, transaction_id ASC FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(corrupted())
+		mock.ExpectQuery(`SELECT .* FROM DischargeAllocations WHERE account_id = \? ORDER BY allocation_id ASC`).
+			WithArgs(1).
+			WillReturnRows(recorded())
+		mock.ExpectExec(`UPDATE Transactions SET balance = \? WHERE account_id = \? AND transaction_id = \?`).
+			WithArgs(0.0, 1, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT last_hash FROM AuditChainHead WHERE chain_id = \? FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"last_hash"}).AddRow(audit.GenesisHash))
+		mock.ExpectExec(`INSERT INTO AuditLog`).
+			WithArgs(1, sqlmock.AnyArg(), models.AuditKindBalanceChange, "", "", "", "reconcile", 1, nil, "", 0, `[{"transaction_id":1,"before":-50,"after":0}]`, audit.GenesisHash, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE AuditChainHead SET last_hash = \? WHERE chain_id = \?`).
+			WithArgs(sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE AccountBalances\s+SET total_debt = \?, total_credit = \?`).
+			WithArgs(0.0, 10.0, 0.0, 1, 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		result, err := services.NewReconciliationService(store.NewRepository(db, store.MySQL)).ReconcileAccount(1, true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, models.AccountReconciliation{AccountID: 1, Transactions: 2, Discrepancies: []models.BalanceDiscrepancy{discrepancy}, StaleTotals: true}, result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Repair rebuilds a missing allocation history", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		expectLock(mock, 0, 10.0)
+		mock.ExpectQuery(`SELECT .* FROM Transactions WHERE account_id = \? ORDER BY posting_date ASC, transaction_id ASC FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "txn_01J8Z3V4W5X6Y7Z8A9B0C1D201", 1, 1, -50.0, 0.0, eventDate, eventDate, "BRL", -50.0, "BRL", 1.0).
+				AddRow(2, "txn_01J8Z3V4W5X6Y7Z8A9B0C1D202", 1, 4, 60.0, 10.0, eventDate.Add(time.Hour), eventDate.Add(time.Hour), "BRL", 60.0, "BRL", 1.0))
+		mock.ExpectQuery(`SELECT .* FROM DischargeAllocations WHERE account_id = \? ORDER BY allocation_id ASC`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(allocationColumns))
+		mock.ExpectExec(`DELETE FROM DischargeAllocations WHERE account_id = \?`).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO DischargeAllocations`).
+			WithArgs(1, int64(1), int64(2), 50.0, eventDate.Add(time.Hour)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		result, err := services.NewReconciliationService(store.NewRepository(db, store.MySQL)).ReconcileAccount(1, true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, models.AccountReconciliation{AccountID: 1, Transactions: 2, Discrepancies: []models.BalanceDiscrepancy{}, StaleAllocations: true}, result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Failed repair rolls back", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		expectLock(mock, 50.0, 10.0)
+		mock.ExpectQuery(`SELECT .* FROM Transactions WHERE account_id = \? ORDER BY posting_date ASC, transaction_id ASC FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(corrupted())
+		mock.ExpectQuery(`SELECT .* FROM DischargeAllocations WHERE account_id = \? ORDER BY allocation_id ASC`).
+			WithArgs(1).
+			WillReturnRows(recorded())
+		mock.ExpectExec(`UPDATE Transactions SET balance = \? WHERE account_id = \? AND transaction_id = \?`).
+			WillReturnError(errors.New("lock wait timeout exceeded"))
+		mock.ExpectRollback()
+
+		_, err = services.NewReconciliationService(store.NewRepository(db, store.MySQL)).ReconcileAccount(1, true)
+
+		assert.EqualError(t, err, "failed to update balance for transaction 1: lock wait timeout exceeded")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{"transaction_id", "public_id", "account_id", "operation_type_id", "amount", "balance", "event_date", "posting_date", "currency", "original_amount", "original_currency", "fx_rate"}
+	mock.ExpectQuery(`SELECT DISTINCT account_id FROM Transactions ORDER BY account_id ASC`).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id"}).AddRow(1).AddRow(2))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM Transactions WHERE account_id = \?`).
+		WithArgs(1).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM Transactions WHERE account_id = \?`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(3, "txn_01J8Z3V4W5X6Y7Z8A9B0C1D203", 2, 1, -10.0, -10.0, time.Now(), time.Now(), "BRL", nil, nil, 1.0))
+	mock.ExpectQuery(`SELECT .* FROM DischargeAllocations WHERE account_id = \?`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"allocation_id", "account_id", "debit_transaction_id", "credit_transaction_id", "amount", "allocated_at"}).
+			AddRow(1, 2, 3, 4, 5.0, time.Now()))
+	mock.ExpectQuery(`SELECT .* FROM AccountBalances WHERE account_id = \?`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "total_debt", "total_credit", "available_limit", "version", "updated_at"}).
+			AddRow(2, 10.0, 0.0, 990.0, 1, time.Now()))
+	mock.ExpectCommit()
+
+	report, err := services.NewReconciliationService(store.NewRepository(db, store.MySQL)).Reconcile(false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ReconciliationReport{
+		Accounts:         1,
+		Transactions:     1,
+		Discrepancies:    []models.BalanceDiscrepancy{},
+		StaleAllocations: []int64{2},
+		StaleTotals:      []int64{},
+		Errors:           map[int64]string{1: "failed to query transactions: connection reset"},
+	}, report)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReplayAllocations(t *testing.T) {
+	paidAt := time.Date(2024, 9, 18, 0, 0, 0, 0, time.UTC)
+	transactions := []models.Transaction{
+		{ID: 1, AccountID: 1, OperationTypeID: 1, Amount: -50},
+		{ID: 2, AccountID: 1, OperationTypeID: 1, Amount: -23.5},
+		{ID: 3, AccountID: 1, OperationTypeID: 4, Amount: 60, EventDate: paidAt.Add(-48 * time.Hour), PostingDate: paidAt},
+	}
+
+	assert.Equal(t, []models.DischargeAllocation{
+		{AccountID: 1, DebitTransactionID: 1, CreditTransactionID: 3, Amount: 50, AllocatedAt: paidAt},
+		{AccountID: 1, DebitTransactionID: 2, CreditTransactionID: 3, Amount: 10, AllocatedAt: paidAt},
+	}, services.ReplayAllocations(transactions))
+}
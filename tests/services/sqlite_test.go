@@ -0,0 +1,169 @@
+package services
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/database"
+	"pismo/fx"
+	"pismo/models"
+	"pismo/publicid"
+	"pismo/services"
+	"pismo/store"
+)
+
+// newSQLiteRepository migrates a new SQLite database seeded with init.sql, whose
+// account 1 owes 50, 25 and 15 from three purchases
+func newSQLiteRepository(t *testing.T) *store.Repository {
+	config := database.Config{
+		Driver:   database.DriverSQLite,
+		DSN:      "file:" + filepath.Join(t.TempDir(), "pismo.db"),
+		SeedFile: "../../init.sql",
+	}
+	conn, err := database.Connect(config)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	assert.NoError(t, database.Migrate(conn, config))
+	return store.NewRepository(conn, store.SQLite)
+}
+
+func balancesOf(t *testing.T, repo *store.Repository, accountID int64) []float64 {
+	transactions, err := repo.ListAccountTransactions(accountID)
+	assert.NoError(t, err)
+	balances := []float64{}
+	for _, transaction := range transactions {
+		balances = append(balances, transaction.Balance)
+	}
+	return balances
+}
+
+func TestSQLiteDischarge(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	transactions := services.NewTransactionService(repo, fx.NewStaticRateProvider(nil), services.DefaultBackdatingWindow)
+	accounts := services.NewAccountService(repo)
+
+	// pays off the 50 and 10 of the 25, in the order they were posted
+	payment, err := transactions.CreateTransaction(models.Transaction{AccountID: 1, OperationTypeID: 4, Amount: 60})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), payment.ID)
+	assert.True(t, publicid.Valid(string(payment.PublicID), publicid.Transaction))
+	assert.Equal(t, []float64{0, -15, -15, 0}, balancesOf(t, repo, 1))
+
+	totals, err := accounts.GetAccountTotals(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 30.0, totals.TotalDebt)
+	assert.Equal(t, 0.0, totals.TotalCredit)
+	assert.Equal(t, 970.0, totals.AvailableLimit)
+
+	// more than is owed, what is left over stays on the payment
+	_, err = transactions.CreateTransaction(models.Transaction{AccountID: 1, OperationTypeID: 4, Amount: 100})
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{0, 0, 0, 0, 70}, balancesOf(t, repo, 1))
+
+	totals, err = accounts.GetAccountTotals(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, totals.TotalDebt)
+	assert.Equal(t, 70.0, totals.TotalCredit)
+	assert.Equal(t, 1000.0, totals.AvailableLimit)
+
+	// purchases stay open next to the credit left over, only credits discharge
+	_, err = transactions.CreateTransaction(models.Transaction{AccountID: 1, OperationTypeID: 1, Amount: -20})
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{0, 0, 0, 0, 70, -20}, balancesOf(t, repo, 1))
+
+	balance, err := accounts.GetAccountBalance(1, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 20.0, balance.OpenDebt)
+	assert.Equal(t, 70.0, balance.OpenCredit)
+
+	// what was written adds up to a replay of the transactions
+	reconciliation, err := services.NewReconciliationService(repo).ReconcileAccount(1, false)
+	assert.NoError(t, err)
+	assert.Empty(t, reconciliation.Discrepancies)
+	assert.False(t, reconciliation.StaleTotals)
+}
+
+func TestSQLiteUpdateAccount(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	accounts := services.NewAccountService(repo)
+
+	limit := 500.0
+	account, err := accounts.UpdateAccount(1, models.AccountUpdate{CreditLimit: &limit}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), account.Version)
+	assert.Equal(t, &limit, account.CreditLimit)
+
+	// the override replaces the product limit, less the 90 owed
+	totals, err := accounts.GetAccountTotals(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 410.0, totals.AvailableLimit)
+
+	blocked := models.AccountBlocked
+	_, err = accounts.UpdateAccount(1, models.AccountUpdate{Status: &blocked}, 1)
+	assert.ErrorIs(t, err, store.ErrVersionMismatch)
+}
+
+func TestSQLitePublicIDs(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	accounts := services.NewAccountService(repo)
+
+	// the seeded account got its public id from the migration
+	seeded, err := accounts.GetAccountByRef("1")
+	assert.NoError(t, err)
+	assert.True(t, publicid.Valid(string(seeded.PublicID), publicid.Account))
+	byPublicID, err := accounts.GetAccountByRef(seeded.PublicID)
+	assert.NoError(t, err)
+	assert.Equal(t, seeded, byPublicID)
+
+	created, err := accounts.CreateAccount(models.Account{DocumentNumber: "52998224725", HolderName: "Maria Silva"})
+	assert.NoError(t, err)
+	found, err := accounts.GetAccountByRef(created.PublicID)
+	assert.NoError(t, err)
+	assert.Equal(t, created.ID, found.ID)
+
+	_, err = accounts.GetAccountByRef("acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestSQLiteAuthorizationPublicIDs(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	transactions := services.NewTransactionService(repo, fx.NewStaticRateProvider(nil), services.DefaultBackdatingWindow)
+	authorizations := services.NewAuthorizationService(repo, transactions, fx.NewStaticRateProvider(nil), time.Hour)
+
+	hold, err := authorizations.Authorize(models.Authorization{AccountID: 1, OperationTypeID: 1, Amount: -40, Currency: "BRL"})
+	assert.NoError(t, err)
+	assert.True(t, publicid.Valid(string(hold.PublicID), publicid.Authorization))
+
+	byPublicID, err := authorizations.GetAuthorizationByRef(hold.PublicID)
+	assert.NoError(t, err)
+	assert.Equal(t, hold.ID, byPublicID.ID)
+	byID, err := authorizations.GetAuthorizationByRef(models.PublicID(strconv.FormatInt(hold.ID, 10)))
+	assert.NoError(t, err)
+	assert.Equal(t, hold.PublicID, byID.PublicID)
+
+	_, err = authorizations.GetAuthorizationByRef("auth_01J8Z3V4W5X6Y7Z8A9B0C1D2E3")
+	assert.ErrorIs(t, err, services.ErrAuthorizationNotFound)
+}
+
+func TestSQLiteLegacyIDsTurnedOff(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	accounts := services.NewAccountService(repo)
+
+	models.AcceptLegacyIDs = false
+	t.Cleanup(func() { models.AcceptLegacyIDs = true })
+
+	// the seeded account can only be found by its public id now
+	_, err := accounts.GetAccountByRef("1")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	seeded, err := repo.GetAccountByID(1)
+	assert.NoError(t, err)
+	found, err := accounts.GetAccountByRef(seeded.PublicID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), found.ID)
+}
@@ -1,17 +1,34 @@
 package services
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
 
+	"pismo/audit"
+	"pismo/fx"
+	"pismo/mocks"
 	"pismo/models"
+	"pismo/publicid"
 	"pismo/services"
 	"pismo/store"
 )
 
+// transactionPublicIDArg matches the public id the service makes for a transaction
+type transactionPublicIDArg struct{}
+
+func (transactionPublicIDArg) Match(v driver.Value) bool {
+	id, ok := v.(string)
+	return ok && publicid.Valid(id, publicid.Transaction)
+}
+
 func TestCreateTransaction(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -19,8 +36,59 @@ func TestCreateTransaction(t *testing.T) {
 	}
 	defer db.Close()
 
-	repo := store.NewRepository(db)
-	service := services.NewTransactionService(repo)
+	repo := store.NewRepository(db, store.MySQL)
+	rates := fx.NewStaticRateProvider(map[string]float64{"USD/BRL": 5.0})
+	service := services.NewTransactionService(repo, rates, services.DefaultBackdatingWindow)
+
+	// every transaction is looked up against its account to find the account currency
+	expectAccount := func(mock sqlmock.Sqlmock, accountID int64, currency string) {
+		mock.ExpectQuery(`SELECT .* FROM Accounts WHERE account_id = \?`).
+			WithArgs(accountID).
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "public_id", "document_number", "document_type", "holder_name", "country", "product_id", "currency", "tenant_id", "status", "credit_limit", "version", "created_at", "updated_at"}).
+				AddRow(accountID, "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", "12345678909", "CPF", "Maria Silva", "BR", 1, currency, "", "ACTIVE", nil, 1, time.Now(), time.Now()))
+	}
+	// every attempt starts by locking the account's running totals, at version 3
+	expectBeginWithLock := func(mock sqlmock.Sqlmock, accountID int64, debt float64) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT account_id, total_debt, total_credit, available_limit, version, updated_at FROM AccountBalances WHERE account_id = \? FOR UPDATE`).
+			WithArgs(accountID).
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "total_debt", "total_credit", "available_limit", "version", "updated_at"}).
+				AddRow(accountID, debt, 0.0, 1000.0-debt, 3, time.Now()))
+	}
+	// and ends by moving them along
+	expectTotals := func(mock sqlmock.Sqlmock, accountID int64, debt, credit float64) {
+		mock.ExpectExec(`UPDATE AccountBalances\s+SET total_debt = \?, total_credit = \?, available_limit = \(.*COALESCE\(a.credit_limit, p.credit_limit\).*\) - \?, version = version \+ 1\s+WHERE account_id = \? AND version = \?`).
+			WithArgs(debt, credit, debt, accountID, 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	// discharges record the balances they changed in the audit log, in the same transaction
+	expectAuditAppend := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT last_hash FROM AuditChainHead WHERE chain_id = \? FOR UPDATE`).
+			WillReturnRows(sqlmock.NewRows([]string{"last_hash"}).AddRow(audit.GenesisHash))
+		mock.ExpectExec(`INSERT INTO AuditLog`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE AuditChainHead SET last_hash = \? WHERE chain_id = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	// every transaction is recorded in the ledger as a debit and a credit, in the same transaction
+	expectJournalEntry := func(mock sqlmock.Sqlmock, transactionID int64, debit, credit string, amount float64) {
+		mock.ExpectQuery(`SELECT ledger_account_id FROM LedgerAccounts WHERE code = \? FOR SHARE`).
+			WithArgs(debit).
+			WillReturnRows(sqlmock.NewRows([]string{"ledger_account_id"}).AddRow(1))
+		mock.ExpectQuery(`SELECT ledger_account_id FROM LedgerAccounts WHERE code = \? FOR SHARE`).
+			WithArgs(credit).
+			WillReturnRows(sqlmock.NewRows([]string{"ledger_account_id"}).AddRow(2))
+		mock.ExpectExec(`INSERT INTO JournalEntries \(transaction_id, description, currency, posted_at\) VALUES \(\?, \?, \?, \?\)`).
+			WithArgs(transactionID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(transactionID, 1))
+		mock.ExpectExec(`INSERT INTO Postings \(journal_entry_id, ledger_account_id, amount, currency\) VALUES \(\?, \?, \?, \?\)`).
+			WithArgs(transactionID, 1, amount, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO Postings \(journal_entry_id, ledger_account_id, amount, currency\) VALUES \(\?, \?, \?, \?\)`).
+			WithArgs(transactionID, 2, -amount, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(2, 1))
+	}
+
+	backdated := time.Now().UTC().Add(-72 * time.Hour).Truncate(time.Second)
 
 	tests := []struct {
 		name           string
@@ -30,23 +98,20 @@ func TestCreateTransaction(t *testing.T) {
 		expectedError  string
 	}{
 		{
-			name: "Successful deposit transaction",
+			name: "Deposit without debt skips the discharge",
 			transaction: models.Transaction{
 				AccountID:       1,
 				OperationTypeID: 4, // Deposit
 				Amount:          100.0,
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectExec(`INSERT INTO Transactions \(account_id, operation_type_id, amount, balance\) VALUES \(\?, \?, \?, \?\)`).
-					WithArgs(1, 4, 100.0, 100.0).
+				expectAccount(mock, 1, "BRL")
+				expectBeginWithLock(mock, 1, 0)
+				mock.ExpectExec(`INSERT INTO Transactions \(public_id, account_id, operation_type_id, amount, balance, currency, original_amount, original_currency, fx_rate, event_date, posting_date\) VALUES \(\?, \?, \?, \?, \?, \?, \?, \?, \?, \?, \?\)`).
+					WithArgs(transactionPublicIDArg{}, 1, 4, 100.0, 100.0, "BRL", 100.0, "BRL", 1.0, sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY event_date ASC`).
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "balance"}))
-				mock.ExpectExec(`UPDATE Transactions SET balance = \? WHERE transaction_id = \?`).
-					WithArgs(100.0, 1).
-					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectJournalEntry(mock, 1, "cash:BRL", "customer:1", 100.0)
+				expectTotals(mock, 1, 0, 100.0)
 				mock.ExpectCommit()
 			},
 			expectedResult: 1,
@@ -60,10 +125,13 @@ func TestCreateTransaction(t *testing.T) {
 				Amount:          -50.0,
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectExec(`INSERT INTO Transactions \(account_id, operation_type_id, amount, balance\) VALUES \(\?, \?, \?, \?\)`).
-					WithArgs(1, 1, -50.0, -50.0).
+				expectAccount(mock, 1, "BRL")
+				expectBeginWithLock(mock, 1, 0)
+				mock.ExpectExec(`INSERT INTO Transactions \(public_id, account_id, operation_type_id, amount, balance, currency, original_amount, original_currency, fx_rate, event_date, posting_date\) VALUES \(\?, \?, \?, \?, \?, \?, \?, \?, \?, \?, \?\)`).
+					WithArgs(transactionPublicIDArg{}, 1, 1, -50.0, -50.0, "BRL", -50.0, "BRL", 1.0, sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(2, 1))
+				expectJournalEntry(mock, 2, "customer:1", "settlement:BRL", 50.0)
+				expectTotals(mock, 1, 50.0, 0)
 				mock.ExpectCommit()
 			},
 			expectedResult: 2,
@@ -80,6 +148,151 @@ func TestCreateTransaction(t *testing.T) {
 			expectedResult: 0,
 			expectedError:  "invalid transaction amount -100.00 for the given operation type ID 4: expected Credit direction",
 		},
+		{
+			name: "Foreign currency purchase is converted into the account currency",
+			transaction: models.Transaction{
+				AccountID:       1,
+				OperationTypeID: 1,
+				Amount:          -10.0,
+				Currency:        "usd",
+			},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccount(mock, 1, "BRL")
+				expectBeginWithLock(mock, 1, 0)
+				mock.ExpectExec(`INSERT INTO Transactions`).
+					WithArgs(transactionPublicIDArg{}, 1, 1, -50.0, -50.0, "BRL", -10.0, "USD", 5.0, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(5, 1))
+				expectJournalEntry(mock, 5, "customer:1", "settlement:BRL", 50.0)
+				expectTotals(mock, 1, 50.0, 0)
+				mock.ExpectCommit()
+			},
+			expectedResult: 5,
+			expectedError:  "",
+		},
+		{
+			name: "Foreign currency deposit discharges in the account currency",
+			transaction: models.Transaction{
+				AccountID:       1,
+				OperationTypeID: 4,
+				Amount:          50.0,
+				Currency:        "BRL",
+			},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccount(mock, 1, "USD")
+				expectBeginWithLock(mock, 1, 4.0)
+				mock.ExpectExec(`INSERT INTO Transactions`).
+					WithArgs(transactionPublicIDArg{}, 1, 4, 10.0, 10.0, "USD", 50.0, "BRL", 0.2, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(6, 1))
+				expectJournalEntry(mock, 6, "cash:USD", "customer:1", 10.0)
+				mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY posting_date ASC, transaction_id ASC`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "balance"}).AddRow(2, -4.0))
+				mock.ExpectExec(`UPDATE Transactions SET balance = \? WHERE account_id = \? AND transaction_id = \?`).
+					WithArgs(0.0, 1, 2).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE Transactions SET balance = \? WHERE account_id = \? AND transaction_id = \?`).
+					WithArgs(6.0, 1, 6).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`INSERT INTO DischargeAllocations`).
+					WithArgs(1, 2, 6, 4.0, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectAuditAppend(mock)
+				expectTotals(mock, 1, 0, 6.0)
+				mock.ExpectCommit()
+			},
+			expectedResult: 6,
+			expectedError:  "",
+		},
+		{
+			name: "No rate for the currency pair",
+			transaction: models.Transaction{
+				AccountID:       1,
+				OperationTypeID: 1,
+				Amount:          -10.0,
+				Currency:        "JPY",
+			},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccount(mock, 1, "BRL")
+			},
+			expectedResult: 0,
+			expectedError:  "no exchange rate available: JPY/BRL",
+		},
+		{
+			name: "Event date in the future",
+			transaction: models.Transaction{
+				AccountID:       1,
+				OperationTypeID: 1,
+				Amount:          -10.0,
+				EventDate:       time.Date(2999, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			mockSetup:      func(mock sqlmock.Sqlmock) {},
+			expectedResult: 0,
+			expectedError:  "invalid event_date: 2999-01-01T00:00:00Z is in the future",
+		},
+		{
+			name: "Event date before the backdating window",
+			transaction: models.Transaction{
+				AccountID:       1,
+				OperationTypeID: 1,
+				Amount:          -10.0,
+				EventDate:       time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			mockSetup:      func(mock sqlmock.Sqlmock) {},
+			expectedResult: 0,
+			expectedError:  "invalid event_date: 2000-01-01T00:00:00Z is more than 720h0m0s in the past",
+		},
+		{
+			name: "Backdated purchase keeps its event date",
+			transaction: models.Transaction{
+				AccountID:       1,
+				OperationTypeID: 1,
+				Amount:          -50.0,
+				EventDate:       backdated,
+			},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccount(mock, 1, "BRL")
+				expectBeginWithLock(mock, 1, 0)
+				mock.ExpectExec(`INSERT INTO Transactions`).
+					WithArgs(transactionPublicIDArg{}, 1, 1, -50.0, -50.0, "BRL", -50.0, "BRL", 1.0, backdated, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(7, 1))
+				expectJournalEntry(mock, 7, "customer:1", "settlement:BRL", 50.0)
+				expectTotals(mock, 1, 50.0, 0)
+				mock.ExpectCommit()
+			},
+			expectedResult: 7,
+			expectedError:  "",
+		},
+		{
+			name: "Account does not exist",
+			transaction: models.Transaction{
+				AccountID:       9,
+				OperationTypeID: 1,
+				Amount:          -10.0,
+			},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT .* FROM Accounts WHERE account_id = \?`).
+					WithArgs(9).
+					WillReturnError(sql.ErrNoRows)
+			},
+			expectedResult: 0,
+			expectedError:  "account not found",
+		},
+		{
+			name: "Blocked account takes no purchases",
+			transaction: models.Transaction{
+				AccountID:       2,
+				OperationTypeID: 1,
+				Amount:          -10.0,
+			},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT .* FROM Accounts WHERE account_id = \?`).
+					WithArgs(2).
+					WillReturnRows(sqlmock.NewRows([]string{"account_id", "public_id", "document_number", "document_type", "holder_name", "country", "product_id", "currency", "tenant_id", "status", "credit_limit", "version", "created_at", "updated_at"}).
+						AddRow(2, "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3", "12345678909", "CPF", "Maria Silva", "BR", 1, "BRL", "", "BLOCKED", nil, 4, time.Now(), time.Now()))
+			},
+			expectedResult: 0,
+			expectedError:  "account not active: account acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3 is BLOCKED",
+		},
 		{
 			name: "Begin transaction error",
 			transaction: models.Transaction{
@@ -88,6 +301,7 @@ func TestCreateTransaction(t *testing.T) {
 				Amount:          100.0,
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectAccount(mock, 1, "BRL")
 				mock.ExpectBegin().WillReturnError(errors.New("db error"))
 			},
 			expectedResult: 0,
@@ -101,9 +315,10 @@ func TestCreateTransaction(t *testing.T) {
 				Amount:          100.0,
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectExec(`INSERT INTO Transactions \(account_id, operation_type_id, amount, balance\) VALUES \(\?, \?, \?, \?\)`).
-					WithArgs(1, 4, 100.0, 100.0).
+				expectAccount(mock, 1, "BRL")
+				expectBeginWithLock(mock, 1, 0)
+				mock.ExpectExec(`INSERT INTO Transactions \(public_id, account_id, operation_type_id, amount, balance, currency, original_amount, original_currency, fx_rate, event_date, posting_date\) VALUES \(\?, \?, \?, \?, \?, \?, \?, \?, \?, \?, \?\)`).
+					WithArgs(transactionPublicIDArg{}, 1, 4, 100.0, 100.0, "BRL", 100.0, "BRL", 1.0, sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnError(errors.New("db error"))
 				mock.ExpectRollback()
 			},
@@ -118,15 +333,17 @@ func TestCreateTransaction(t *testing.T) {
 				Amount:          100.0,
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectExec(`INSERT INTO Transactions \(account_id, operation_type_id, amount, balance\) VALUES \(\?, \?, \?, \?\)`).
-					WithArgs(1, 4, 100.0, 100.0).
+				expectAccount(mock, 1, "BRL")
+				expectBeginWithLock(mock, 1, 10.0)
+				mock.ExpectExec(`INSERT INTO Transactions \(public_id, account_id, operation_type_id, amount, balance, currency, original_amount, original_currency, fx_rate, event_date, posting_date\) VALUES \(\?, \?, \?, \?, \?, \?, \?, \?, \?, \?, \?\)`).
+					WithArgs(transactionPublicIDArg{}, 1, 4, 100.0, 100.0, "BRL", 100.0, "BRL", 1.0, sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(3, 1))
-				mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY event_date ASC`).
+				expectJournalEntry(mock, 3, "cash:BRL", "customer:1", 100.0)
+				mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY posting_date ASC, transaction_id ASC`).
 					WithArgs(1).
 					WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "balance"}))
-				mock.ExpectExec(`UPDATE Transactions SET balance = \? WHERE transaction_id = \?`).
-					WithArgs(100.0, 3).
+				mock.ExpectExec(`UPDATE Transactions SET balance = \? WHERE account_id = \? AND transaction_id = \?`).
+					WithArgs(100.0, 1, 3).
 					WillReturnError(errors.New("discharge error"))
 				mock.ExpectRollback()
 			},
@@ -141,16 +358,13 @@ func TestCreateTransaction(t *testing.T) {
 				Amount:          100.0,
 			},
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectExec(`INSERT INTO Transactions \(account_id, operation_type_id, amount, balance\) VALUES \(\?, \?, \?, \?\)`).
-					WithArgs(1, 4, 100.0, 100.0).
+				expectAccount(mock, 1, "BRL")
+				expectBeginWithLock(mock, 1, 0)
+				mock.ExpectExec(`INSERT INTO Transactions \(public_id, account_id, operation_type_id, amount, balance, currency, original_amount, original_currency, fx_rate, event_date, posting_date\) VALUES \(\?, \?, \?, \?, \?, \?, \?, \?, \?, \?, \?\)`).
+					WithArgs(transactionPublicIDArg{}, 1, 4, 100.0, 100.0, "BRL", 100.0, "BRL", 1.0, sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(4, 1))
-				mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY event_date ASC`).
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "balance"}))
-				mock.ExpectExec(`UPDATE Transactions SET balance = \? WHERE transaction_id = \?`).
-					WithArgs(100.0, 4).
-					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectJournalEntry(mock, 4, "cash:BRL", "customer:1", 100.0)
+				expectTotals(mock, 1, 0, 100.0)
 				mock.ExpectCommit().WillReturnError(errors.New("commit error"))
 			},
 			expectedResult: 0,
@@ -164,11 +378,12 @@ func TestCreateTransaction(t *testing.T) {
 
 			result, err := service.CreateTransaction(tt.transaction)
 
-			assert.Equal(t, tt.expectedResult, result)
+			assert.Equal(t, tt.expectedResult, result.ID)
 			if tt.expectedError != "" {
 				assert.EqualError(t, err, tt.expectedError)
 			} else {
 				assert.NoError(t, err)
+				assert.True(t, publicid.Valid(string(result.PublicID), publicid.Transaction))
 			}
 
 			if err := mock.ExpectationsWereMet(); err != nil {
@@ -176,4 +391,89 @@ func TestCreateTransaction(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+// lockingRepository posts credits without a database, counting how often two
+// transactions of the same account were in flight at once and how many accounts
+// were being posted to at the same time
+type lockingRepository struct {
+	*mocks.MockRepository
+
+	mu        sync.Mutex
+	nextID    int64
+	inFlight  map[int64]bool
+	overlaps  int
+	active    int
+	maxActive int
+}
+
+func (r *lockingRepository) GetAccountByID(id int64) (models.Account, error) {
+	return models.Account{ID: id, Currency: "BRL"}, nil
+}
+
+func (r *lockingRepository) WithinTx(ctx context.Context, fn func(store.TxRepo) error, opts ...store.TxOption) error {
+	return fn(r)
+}
+
+func (r *lockingRepository) LockAccountBalance(accountID int64) (models.AccountTotals, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inFlight[accountID] {
+		r.overlaps++
+	}
+	r.inFlight[accountID] = true
+	r.active++
+	if r.active > r.maxActive {
+		r.maxActive = r.active
+	}
+	// some debt so every credit goes through discharge
+	return models.AccountTotals{AccountID: accountID, TotalDebt: 100}, nil
+}
+
+func (r *lockingRepository) CreateTransaction(transaction models.Transaction) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	return r.nextID, nil
+}
+
+func (r *lockingRepository) CreateJournalEntry(entry models.JournalEntry) (int64, error) {
+	return entry.TransactionID, nil
+}
+
+func (r *lockingRepository) ProcessDischargeTransaction(transaction models.Transaction) (float64, error) {
+	time.Sleep(2 * time.Millisecond)
+	return transaction.Amount, nil
+}
+
+func (r *lockingRepository) UpdateAccountBalance(totals models.AccountTotals) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlight[totals.AccountID] = false
+	r.active--
+	return nil
+}
+
+func TestCreateTransactionSerializesPerAccount(t *testing.T) {
+	const accounts, perAccount = 4, 10
+	repo := &lockingRepository{MockRepository: new(mocks.MockRepository), inFlight: map[int64]bool{}}
+	service := services.NewTransactionService(repo, fx.NewStaticRateProvider(nil), services.DefaultBackdatingWindow)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, accounts*perAccount)
+	for i := 0; i < accounts*perAccount; i++ {
+		wg.Add(1)
+		go func(accountID int64) {
+			defer wg.Done()
+			_, err := service.CreateTransaction(models.Transaction{AccountID: accountID, OperationTypeID: 4, Amount: 10})
+			errs <- err
+		}(int64(i%accounts + 1))
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 0, repo.overlaps, "transactions of the same account must be posted one at a time")
+	assert.Greater(t, repo.maxActive, 1, "different accounts should be posted to in parallel")
+}
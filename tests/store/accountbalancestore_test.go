@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/models"
+	"pismo/store"
+)
+
+func TestLockAccountBalance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		updatedAt := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT account_id, total_debt, total_credit, available_limit, version, updated_at FROM AccountBalances WHERE account_id = \? FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "total_debt", "total_credit", "available_limit", "version", "updated_at"}).
+				AddRow(1, 140.0, 0.0, 860.0, 7, updatedAt))
+		mock.ExpectCommit()
+
+		var totals models.AccountTotals
+		err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+			var err error
+			totals, err = tx.LockAccountBalance(1)
+			return err
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, models.AccountTotals{AccountID: 1, TotalDebt: 140, AvailableLimit: 860, Version: 7, UpdatedAt: updatedAt}, totals)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUpdateAccountBalance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		totals := models.AccountTotals{AccountID: 1, TotalDebt: 90, TotalCredit: 0, Version: 7}
+
+		tests := []struct {
+			name          string
+			updated       int64
+			expectedError string
+		}{
+			{
+				name:    "Totals at the locked version are written",
+				updated: 1,
+			},
+			{
+				name:          "Totals moved on since they were read",
+				updated:       0,
+				expectedError: "version mismatch: balance of account 1 is no longer at version 7",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE AccountBalances\s+SET total_debt = \?, total_credit = \?, available_limit = \(\s+SELECT COALESCE\(a.credit_limit, p.credit_limit\) FROM Accounts a JOIN Products p ON p.product_id = a.product_id\s+WHERE a.account_id = AccountBalances.account_id\s+\) - \?, version = version \+ 1\s+WHERE account_id = \? AND version = \?`).
+					WithArgs(90.0, 0.0, 90.0, 1, int64(7)).
+					WillReturnResult(sqlmock.NewResult(0, tt.updated))
+				mock.ExpectEnd(tt.expectedError != "")
+
+				err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+					return tx.UpdateAccountBalance(totals)
+				})
+
+				if tt.expectedError != "" {
+					assert.EqualError(t, err, tt.expectedError)
+				} else {
+					assert.NoError(t, err)
+				}
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
+}
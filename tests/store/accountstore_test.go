@@ -1,9 +1,11 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -12,196 +14,338 @@ import (
 	"pismo/store"
 )
 
+var (
+	accountColumns = []string{"account_id", "public_id", "document_number", "document_type", "holder_name", "country", "product_id", "currency", "tenant_id", "status", "credit_limit", "version", "created_at", "updated_at"}
+	createdAt      = time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+	testAccount    = models.Account{
+		ID:             1,
+		PublicID:       accountPublicID,
+		DocumentNumber: "123456789",
+		DocumentType:   "CPF",
+		HolderName:     "Maria Silva",
+		Country:        "BR",
+		ProductID:      1,
+		Currency:       "BRL",
+		TenantID:       "acme",
+		Status:         "ACTIVE",
+		Version:        1,
+		CreatedAt:      createdAt,
+		UpdatedAt:      createdAt,
+	}
+)
+
 func TestGetAccountByID(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	repo := &store.Repository{DB: db}
-
-	tests := []struct {
-		name           string
-		accountID      int
-		mockSetup      func()
-		expectedResult models.Account
-		expectedError  error
-	}{
-		{
-			name:      "Account not found",
-			accountID: 2,
-			mockSetup: func() {
-				mock.ExpectQuery("SELECT \\* FROM Accounts WHERE account_id = ?").
-					WithArgs(2).
-					WillReturnError(sql.ErrNoRows)
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		tests := []struct {
+			name           string
+			accountID      int64
+			mockSetup      func()
+			expectedResult models.Account
+			expectedError  error
+		}{
+			{
+				name:      "Account not found",
+				accountID: 2,
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT account_id, public_id, document_number, document_type, holder_name, country, product_id, currency, tenant_id, status, credit_limit, version, created_at, updated_at FROM Accounts WHERE account_id = ?").
+						WithArgs(2).
+						WillReturnError(sql.ErrNoRows)
+				},
+				expectedResult: models.Account{},
+				expectedError:  sql.ErrNoRows,
 			},
-			expectedResult: models.Account{},
-			expectedError:  sql.ErrNoRows,
-		},
-		{
-			name:      "Database error",
-			accountID: 2,
-			mockSetup: func() {
-				mock.ExpectQuery("SELECT \\* FROM Accounts WHERE account_id = ?").
-					WithArgs(2).
-					WillReturnError(errors.New("some db error"))
+			{
+				name:      "Database error",
+				accountID: 2,
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT account_id, public_id, document_number, document_type, holder_name, country, product_id, currency, tenant_id, status, credit_limit, version, created_at, updated_at FROM Accounts WHERE account_id = ?").
+						WithArgs(2).
+						WillReturnError(errors.New("some db error"))
+				},
+				expectedResult: models.Account{},
+				expectedError:  errors.New("some db error"),
 			},
-			expectedResult: models.Account{},
-			expectedError:  errors.New("some db error"),
-		},
-		{
-			name:      "Successfully fetched account",
-			accountID: 1,
-			mockSetup: func() {
-				rows := sqlmock.NewRows([]string{"account_id", "document_number"}).
-					AddRow(1, "123456789")
-				mock.ExpectQuery("SELECT \\* FROM Accounts WHERE account_id = ?").
-					WithArgs(1).
-					WillReturnRows(rows)
+			{
+				name:      "Successfully fetched account",
+				accountID: 1,
+				mockSetup: func() {
+					rows := sqlmock.NewRows(accountColumns).
+						AddRow(1, accountPublicID, "123456789", "CPF", "Maria Silva", "BR", 1, "BRL", "acme", "ACTIVE", nil, 1, createdAt, createdAt)
+					mock.ExpectQuery("SELECT account_id, public_id, document_number, document_type, holder_name, country, product_id, currency, tenant_id, status, credit_limit, version, created_at, updated_at FROM Accounts WHERE account_id = ?").
+						WithArgs(1).
+						WillReturnRows(rows)
+				},
+				expectedResult: testAccount,
+				expectedError:  nil,
 			},
-			expectedResult: models.Account{ID: 1, DocumentNumber: "123456789"},
-			expectedError:  nil,
-		},
-	}
+		}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.mockSetup()
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.mockSetup()
 
-			result, err := repo.GetAccountByID(tt.accountID)
+				result, err := repo.GetAccountByID(tt.accountID)
 
-			assert.Equal(t, tt.expectedResult, result)
-			if tt.expectedError != nil {
-				assert.EqualError(t, err, tt.expectedError.Error())
-			} else {
-				assert.NoError(t, err)
-			}
+				assert.Equal(t, tt.expectedResult, result)
+				if tt.expectedError != nil {
+					assert.EqualError(t, err, tt.expectedError.Error())
+				} else {
+					assert.NoError(t, err)
+				}
 
-			assert.NoError(t, mock.ExpectationsWereMet())
-		})
-	}
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
+}
+
+func TestGetAccountByPublicID(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		tests := []struct {
+			name           string
+			publicID       models.PublicID
+			mockSetup      func()
+			expectedResult models.Account
+			expectedError  error
+		}{
+			{
+				name:     "Account not found",
+				publicID: "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E7",
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT account_id, public_id, document_number, .* FROM Accounts WHERE public_id = ?").
+						WithArgs("acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E7").
+						WillReturnError(sql.ErrNoRows)
+				},
+				expectedResult: models.Account{},
+				expectedError:  sql.ErrNoRows,
+			},
+			{
+				name:     "Successfully fetched account",
+				publicID: accountPublicID,
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT account_id, public_id, document_number, .* FROM Accounts WHERE public_id = ?").
+						WithArgs(accountPublicID).
+						WillReturnRows(accountRow())
+				},
+				expectedResult: testAccount,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.mockSetup()
+
+				result, err := repo.GetAccountByPublicID(tt.publicID)
+
+				assert.Equal(t, tt.expectedResult, result)
+				if tt.expectedError != nil {
+					assert.EqualError(t, err, tt.expectedError.Error())
+				} else {
+					assert.NoError(t, err)
+				}
+
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
 }
 
 func TestGetAccountByDocumentNumber(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	repo := &store.Repository{DB: db}
-
-	tests := []struct {
-		name           string
-		documentNumber string
-		mockSetup      func()
-		expectedResult models.Account
-		expectedError  error
-	}{
-		{
-			name:           "Account not found",
-			documentNumber: "123456789",
-			mockSetup: func() {
-				mock.ExpectQuery("SELECT account_id, document_number FROM Accounts WHERE document_number = ?").
-					WithArgs("123456789").
-					WillReturnError(sql.ErrNoRows)
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		tests := []struct {
+			name           string
+			documentNumber string
+			mockSetup      func()
+			expectedResult models.Account
+			expectedError  error
+		}{
+			{
+				name:           "Account not found",
+				documentNumber: "123456789",
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT account_id, public_id, document_number, document_type, holder_name, country, product_id, currency, tenant_id, status, credit_limit, version, created_at, updated_at FROM Accounts WHERE document_number = ?").
+						WithArgs("123456789").
+						WillReturnError(sql.ErrNoRows)
+				},
+				expectedResult: models.Account{},
+				expectedError:  sql.ErrNoRows,
 			},
-			expectedResult: models.Account{},
-			expectedError:  sql.ErrNoRows,
-		},
-		{
-			name:           "Database error",
-			documentNumber: "123456789",
-			mockSetup: func() {
-				mock.ExpectQuery("SELECT account_id, document_number FROM Accounts WHERE document_number = ?").
-					WithArgs("123456789").
-					WillReturnError(errors.New("some db error"))
+			{
+				name:           "Database error",
+				documentNumber: "123456789",
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT account_id, public_id, document_number, document_type, holder_name, country, product_id, currency, tenant_id, status, credit_limit, version, created_at, updated_at FROM Accounts WHERE document_number = ?").
+						WithArgs("123456789").
+						WillReturnError(errors.New("some db error"))
+				},
+				expectedResult: models.Account{},
+				expectedError:  errors.New("some db error"),
 			},
-			expectedResult: models.Account{},
-			expectedError:  errors.New("some db error"),
-		},
-		{
-			name:           "Successfully fetched account",
-			documentNumber: "123456789",
-			mockSetup: func() {
-				rows := sqlmock.NewRows([]string{"account_id", "document_number"}).
-					AddRow(1, "123456789")
-				mock.ExpectQuery("SELECT account_id, document_number FROM Accounts WHERE document_number = ?").
-					WithArgs("123456789").
-					WillReturnRows(rows)
+			{
+				name:           "Successfully fetched account",
+				documentNumber: "123456789",
+				mockSetup: func() {
+					rows := sqlmock.NewRows(accountColumns).
+						AddRow(1, accountPublicID, "123456789", "CPF", "Maria Silva", "BR", 1, "BRL", "acme", "ACTIVE", nil, 1, createdAt, createdAt)
+					mock.ExpectQuery("SELECT account_id, public_id, document_number, document_type, holder_name, country, product_id, currency, tenant_id, status, credit_limit, version, created_at, updated_at FROM Accounts WHERE document_number = ?").
+						WithArgs("123456789").
+						WillReturnRows(rows)
+				},
+				expectedResult: testAccount,
+				expectedError:  nil,
 			},
-			expectedResult: models.Account{ID: 1, DocumentNumber: "123456789"},
-			expectedError:  nil,
-		},
-	}
+		}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.mockSetup()
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.mockSetup()
 
-			result, err := repo.GetAccountByDocumentNumber(tt.documentNumber)
+				result, err := repo.GetAccountByDocumentNumber(tt.documentNumber)
 
-			assert.Equal(t, tt.expectedResult, result)
-			if tt.expectedError != nil {
-				assert.EqualError(t, err, tt.expectedError.Error())
-			} else {
-				assert.NoError(t, err)
-			}
+				assert.Equal(t, tt.expectedResult, result)
+				if tt.expectedError != nil {
+					assert.EqualError(t, err, tt.expectedError.Error())
+				} else {
+					assert.NoError(t, err)
+				}
 
-			assert.NoError(t, mock.ExpectationsWereMet())
-		})
-	}
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
 }
 
 func TestCreateAccount(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	repo := &store.Repository{DB: db}
-
-	tests := []struct {
-		name           string
-		documentNumber string
-		mockSetup      func()
-		expectedResult int64
-		expectedError  error
-	}{
-		{
-			name:           "Database error during creation",
-			documentNumber: "123456789",
-			mockSetup: func() {
-				mock.ExpectExec("INSERT INTO Accounts \\(document_number\\) VALUES \\(\\?\\)").
-					WithArgs("123456789").
-					WillReturnError(errors.New("some db error"))
+	insertAccountQuery := `INSERT INTO Accounts \(public_id, document_number, document_type, holder_name, country, product_id, currency, tenant_id\) VALUES \(\?, \?, \?, \?, \?, \?, \?, \?\)`
+
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		tests := []struct {
+			name           string
+			account        models.Account
+			mockSetup      func()
+			expectedResult int64
+			expectedError  error
+		}{
+			{
+				name:    "Database error during creation",
+				account: testAccount,
+				mockSetup: func() {
+					mock.ExpectInsert(insertAccountQuery, "account_id").
+						WithArgs(accountPublicID, "123456789", "CPF", "Maria Silva", "BR", 1, "BRL", "acme").
+						WillReturnError(errors.New("some db error"))
+				},
+				expectedResult: 0,
+				expectedError:  errors.New("some db error"),
 			},
-			expectedResult: 0,
-			expectedError:  errors.New("some db error"),
-		},
-		{
-			name:           "Successfully created account",
-			documentNumber: "987654321",
-			mockSetup: func() {
-				mock.ExpectExec("INSERT INTO Accounts \\(document_number\\) VALUES \\(\\?\\)").
-					WithArgs("987654321").
-					WillReturnResult(sqlmock.NewResult(1, 1))
+			{
+				name:    "Duplicate document number is reported as a conflict with the existing account",
+				account: testAccount,
+				mockSetup: func() {
+					mock.ExpectInsert(insertAccountQuery, "account_id").
+						WithArgs(accountPublicID, "123456789", "CPF", "Maria Silva", "BR", 1, "BRL", "acme").
+						WillReturnError(mock.DuplicateEntryError("ux_accounts_document_number"))
+					mock.ExpectQuery("SELECT .* FROM Accounts WHERE document_number = ?").
+						WithArgs("123456789").
+						WillReturnRows(sqlmock.NewRows(accountColumns).
+							AddRow(7, "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E7", "123456789", "CPF", "Maria Silva", "BR", 1, "BRL", "acme", "ACTIVE", nil, 1, createdAt, createdAt))
+				},
+				expectedResult: 0,
+				expectedError:  &store.ConflictError{Entity: "account", Key: "document number", ExistingID: 7, ExistingPublicID: "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E7"},
 			},
-			expectedResult: 1,
-			expectedError:  nil,
-		},
-	}
+			{
+				name:    "Successfully created account",
+				account: models.Account{PublicID: accountPublicID, DocumentNumber: "987654321", DocumentType: "PASSPORT", HolderName: "John Doe", Country: "US", ProductID: 2, Currency: "USD"},
+				mockSetup: func() {
+					mock.ExpectInsert(insertAccountQuery, "account_id").
+						WithArgs(accountPublicID, "987654321", "PASSPORT", "John Doe", "US", 2, "USD", "").
+						WillReturnID(1)
+				},
+				expectedResult: 1,
+				expectedError:  nil,
+			},
+		}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.mockSetup()
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.mockSetup()
 
-			result, err := repo.CreateAccount(tt.documentNumber)
+				result, err := repo.CreateAccount(tt.account)
 
-			assert.Equal(t, tt.expectedResult, result)
-			if tt.expectedError != nil {
-				assert.EqualError(t, err, tt.expectedError.Error())
-			} else {
-				assert.NoError(t, err)
-			}
+				assert.Equal(t, tt.expectedResult, result)
+				var conflict *store.ConflictError
+				if errors.As(tt.expectedError, &conflict) {
+					assert.Equal(t, tt.expectedError, err)
+				} else if tt.expectedError != nil {
+					assert.EqualError(t, err, tt.expectedError.Error())
+				} else {
+					assert.NoError(t, err)
+				}
 
-			assert.NoError(t, mock.ExpectationsWereMet())
-		})
-	}
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
+}
+
+func TestUpdateAccount(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		limit := 2500.0
+		updateQuery := `UPDATE Accounts SET holder_name = \?, status = \?, credit_limit = \?, version = version \+ 1 WHERE account_id = \? AND version = \?`
+
+		tests := []struct {
+			name          string
+			account       models.Account
+			mockSetup     func()
+			expectedError string
+		}{
+			{
+				name:    "Updated on top of the version it was read at",
+				account: models.Account{ID: 1, HolderName: "Maria Silva", Status: "BLOCKED", CreditLimit: &limit, Version: 3},
+				mockSetup: func() {
+					mock.ExpectExec(updateQuery).
+						WithArgs("Maria Silva", "BLOCKED", 2500.0, 1, 3).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				},
+			},
+			{
+				name:    "No credit limit is stored as NULL",
+				account: models.Account{ID: 1, HolderName: "Maria Silva", Status: "ACTIVE", Version: 3},
+				mockSetup: func() {
+					mock.ExpectExec(updateQuery).
+						WithArgs("Maria Silva", "ACTIVE", nil, 1, 3).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				},
+			},
+			{
+				name:    "Account moved on since it was read",
+				account: models.Account{ID: 1, HolderName: "Maria Silva", Status: "ACTIVE", Version: 3},
+				mockSetup: func() {
+					mock.ExpectExec(updateQuery).
+						WithArgs("Maria Silva", "ACTIVE", nil, 1, 3).
+						WillReturnResult(sqlmock.NewResult(0, 0))
+				},
+				expectedError: "version mismatch: account 1 is no longer at version 3",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				mock.ExpectBegin()
+				tt.mockSetup()
+				mock.ExpectEnd(tt.expectedError != "")
+
+				err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+					return tx.UpdateAccount(tt.account)
+				})
+
+				if tt.expectedError != "" {
+					assert.EqualError(t, err, tt.expectedError)
+					assert.ErrorIs(t, err, store.ErrVersionMismatch)
+				} else {
+					assert.NoError(t, err)
+				}
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
 }
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/models"
+	"pismo/store"
+)
+
+func TestGetTransactionBalancesAsOf(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		asOf := time.Date(2024, 9, 17, 12, 0, 0, 0, time.UTC)
+		eventDate := asOf.Add(-time.Hour)
+
+		mock.ExpectQuery(`SELECT transaction_id, public_id, operation_type_id, event_date, amount, balance FROM \( SELECT t.transaction_id, t.public_id, t.operation_type_id, t.event_date, t.amount, .* FROM DischargeAllocations a .* a.allocated_at <= \? .* FROM Transactions t WHERE t.account_id = \? AND t.posting_date <= \? UNION ALL SELECT .* FROM DischargeAllocationsArchive a .* FROM TransactionsArchive t WHERE t.account_id = \? AND t.posting_date <= \? \) balances ORDER BY posting_date ASC, transaction_id ASC`).
+			WithArgs(asOf, 1, asOf, asOf, 1, asOf).
+			WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "public_id", "operation_type_id", "event_date", "amount", "balance"}).
+				AddRow(1, "txn_01J8Z3V4W5X6Y7Z8A9B0C1D2E1", 1, eventDate, -50.0, -20.0).
+				AddRow(2, "txn_01J8Z3V4W5X6Y7Z8A9B0C1D2E2", 4, eventDate, 30.0, 0.0))
+
+		balances, err := repo.GetTransactionBalancesAsOf(1, asOf)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []models.TransactionBalance{
+			{TransactionID: 1, PublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D2E1", OperationTypeID: 1, EventDate: eventDate, Amount: -50, Balance: -20},
+			{TransactionID: 2, PublicID: "txn_01J8Z3V4W5X6Y7Z8A9B0C1D2E2", OperationTypeID: 4, EventDate: eventDate, Amount: 30, Balance: 0},
+		}, balances)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReplaceAccountAllocations(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		allocatedAt := time.Date(2024, 9, 17, 12, 0, 0, 0, time.UTC)
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM DischargeAllocations WHERE account_id = \?`).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec(`INSERT INTO DischargeAllocations \(account_id, debit_transaction_id, credit_transaction_id, amount, allocated_at\) VALUES \(\?, \?, \?, \?, \?\)`).
+			WithArgs(1, int64(1), int64(2), 50.0, allocatedAt).
+			WillReturnResult(sqlmock.NewResult(4, 1))
+		mock.ExpectCommit()
+
+		err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+			return tx.ReplaceAccountAllocations(1, []models.DischargeAllocation{
+				{AccountID: 1, DebitTransactionID: 1, CreditTransactionID: 2, Amount: 50, AllocatedAt: allocatedAt},
+			})
+		})
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/models"
+	"pismo/store"
+)
+
+func TestGetAPIKeyByID(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		columns := []string{"key_id", "client_id", "key_hash", "roles", "tenant_id", "created_at", "revoked_at"}
+		ts := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+
+		tests := []struct {
+			name           string
+			mockSetup      func()
+			expectedResult models.APIKey
+			expectedError  error
+		}{
+			{
+				name: "Key not found",
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT .* FROM ApiKeys WHERE key_id = ?").
+						WithArgs("pk_acme").
+						WillReturnError(sql.ErrNoRows)
+				},
+				expectedResult: models.APIKey{},
+				expectedError:  sql.ErrNoRows,
+			},
+			{
+				name: "Active key with several roles",
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT .* FROM ApiKeys WHERE key_id = ?").
+						WithArgs("pk_acme").
+						WillReturnRows(sqlmock.NewRows(columns).AddRow("pk_acme", "acme", "abc123", "partner,support", "acme", ts, nil))
+				},
+				expectedResult: models.APIKey{ID: "pk_acme", ClientID: "acme", KeyHash: "abc123", Roles: []string{"partner", "support"}, TenantID: "acme", CreatedAt: ts},
+			},
+			{
+				name: "Revoked key without roles",
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT .* FROM ApiKeys WHERE key_id = ?").
+						WithArgs("pk_acme").
+						WillReturnRows(sqlmock.NewRows(columns).AddRow("pk_acme", "acme", "abc123", "", "", ts, ts))
+				},
+				expectedResult: models.APIKey{ID: "pk_acme", ClientID: "acme", KeyHash: "abc123", CreatedAt: ts, RevokedAt: &ts},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.mockSetup()
+
+				result, err := repo.GetAPIKeyByID("pk_acme")
+
+				assert.Equal(t, tt.expectedResult, result)
+				if tt.expectedError != nil {
+					assert.EqualError(t, err, tt.expectedError.Error())
+				} else {
+					assert.NoError(t, err)
+				}
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/store"
+)
+
+var (
+	archiveTransactionColumns = []string{"transaction_id", "public_id", "account_id", "operation_type_id", "amount", "balance", "event_date", "posting_date", "currency", "original_amount", "original_currency", "fx_rate"}
+	archiveAllocationColumns  = []string{"allocation_id", "account_id", "debit_transaction_id", "credit_transaction_id", "amount", "allocated_at"}
+)
+
+func TestArchiveTransactions(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		cutoff := time.Date(2024, 9, 17, 12, 0, 0, 0, time.UTC)
+		postedAt := cutoff.Add(-48 * time.Hour)
+
+		settled := func(id int64, operationTypeID int, amount float64) []driver.Value {
+			return []driver.Value{id, fmt.Sprintf("txn_01J8Z3V4W5X6Y7Z8A9B0C1D2%02d", id), 1, operationTypeID, amount, 0.0, postedAt, postedAt, "BRL", amount, "BRL", 1.0}
+		}
+		expectCandidates := func(rows ...[]driver.Value) {
+			result := sqlmock.NewRows(archiveTransactionColumns)
+			for _, row := range rows {
+				result.AddRow(row...)
+			}
+			mock.ExpectQuery(`SELECT .* FROM Transactions WHERE account_id = \? AND balance = 0 AND posting_date < \? ORDER BY posting_date ASC, transaction_id ASC`).
+				WithArgs(1, cutoff).
+				WillReturnRows(result)
+		}
+
+		tests := []struct {
+			name             string
+			limit            int
+			mockSetup        func()
+			expectedArchived int
+		}{
+			{
+				// 4 paid 10 towards 2, which still owes, so neither goes
+				name:  "Moves settled transactions with the allocations between them",
+				limit: 500,
+				mockSetup: func() {
+					expectCandidates(settled(1, 1, -50), settled(3, 4, 50), settled(4, 4, 10))
+					mock.ExpectQuery(`SELECT .* FROM DischargeAllocations WHERE account_id = \? ORDER BY allocation_id ASC`).
+						WithArgs(1).
+						WillReturnRows(sqlmock.NewRows(archiveAllocationColumns).
+							AddRow(1, 1, 1, 3, 50.0, postedAt).
+							AddRow(2, 1, 2, 4, 10.0, postedAt))
+					mock.ExpectExec(`INSERT INTO DischargeAllocationsArchive \(allocation_id, .*\) SELECT allocation_id, .* FROM DischargeAllocations WHERE account_id = \? AND allocation_id IN \(\?\)`).
+						WithArgs(1, int64(1)).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectExec(`DELETE FROM DischargeAllocations WHERE account_id = \? AND allocation_id IN \(\?\)`).
+						WithArgs(1, int64(1)).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectExec(`INSERT INTO TransactionsArchive \(transaction_id, .*\) SELECT transaction_id, .* FROM Transactions WHERE account_id = \? AND transaction_id IN \(\?, \?\)`).
+						WithArgs(1, int64(1), int64(3)).
+						WillReturnResult(sqlmock.NewResult(0, 2))
+					mock.ExpectExec(`DELETE FROM Transactions WHERE account_id = \? AND transaction_id IN \(\?, \?\)`).
+						WithArgs(1, int64(1), int64(3)).
+						WillReturnResult(sqlmock.NewResult(0, 2))
+				},
+				expectedArchived: 2,
+			},
+			{
+				name:  "Takes whole groups up to the limit",
+				limit: 3,
+				mockSetup: func() {
+					expectCandidates(settled(1, 1, -50), settled(2, 4, 50), settled(3, 1, -20), settled(4, 4, 20))
+					mock.ExpectQuery(`SELECT .* FROM DischargeAllocations WHERE account_id = \? ORDER BY allocation_id ASC`).
+						WithArgs(1).
+						WillReturnRows(sqlmock.NewRows(archiveAllocationColumns).
+							AddRow(1, 1, 1, 2, 50.0, postedAt).
+							AddRow(2, 1, 3, 4, 20.0, postedAt))
+					mock.ExpectExec(`INSERT INTO DischargeAllocationsArchive`).
+						WithArgs(1, int64(1)).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectExec(`DELETE FROM DischargeAllocations`).
+						WithArgs(1, int64(1)).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectExec(`INSERT INTO TransactionsArchive`).
+						WithArgs(1, int64(1), int64(2)).
+						WillReturnResult(sqlmock.NewResult(0, 2))
+					mock.ExpectExec(`DELETE FROM Transactions`).
+						WithArgs(1, int64(1), int64(2)).
+						WillReturnResult(sqlmock.NewResult(0, 2))
+				},
+				expectedArchived: 2,
+			},
+			{
+				// allocations were never recorded for it, it can't be told what it paid
+				name:  "Leaves transactions without their allocations",
+				limit: 500,
+				mockSetup: func() {
+					expectCandidates(settled(1, 4, 50))
+					mock.ExpectQuery(`SELECT .* FROM DischargeAllocations WHERE account_id = \? ORDER BY allocation_id ASC`).
+						WithArgs(1).
+						WillReturnRows(sqlmock.NewRows(archiveAllocationColumns))
+				},
+				expectedArchived: 0,
+			},
+			{
+				name:  "Nothing settled",
+				limit: 500,
+				mockSetup: func() {
+					expectCandidates()
+				},
+				expectedArchived: 0,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				mock.ExpectBegin()
+				tt.mockSetup()
+				mock.ExpectCommit()
+
+				var archived int
+				err := repo.WithinTx(context.Background(), func(tx store.TxRepo) (err error) {
+					archived, err = tx.ArchiveTransactions(1, cutoff, tt.limit)
+					return err
+				})
+
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedArchived, archived)
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		db, mock := newDialectMock(t, store.SQLite)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		err := store.NewRepository(db, store.SQLite).WithinTx(context.Background(), func(tx store.TxRepo) error {
+			_, err := tx.ArchiveTransactions(1, time.Now(), 500)
+			return err
+		})
+
+		assert.ErrorIs(t, err, store.ErrArchiveUnsupported)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
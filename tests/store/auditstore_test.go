@@ -0,0 +1,173 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/audit"
+	"pismo/models"
+	"pismo/store"
+)
+
+var auditColumns = []string{"audit_id", "chain_id", "occurred_at", "kind", "principal_id", "request_id", "method", "route", "account_id", "transaction_id", "payload_hash", "outcome", "changes", "prev_hash", "hash"}
+
+// expectAuditAppend expects the balance change entry a discharge chains onto its
+// account's audit chain
+func expectAuditAppend(mock *dialectMock, accountID int64, transactionID int64, changes string) {
+	mock.ExpectQuery(`SELECT last_hash FROM AuditChainHead WHERE chain_id = \? FOR UPDATE`).
+		WithArgs(accountID).
+		WillReturnRows(sqlmock.NewRows([]string{"last_hash"}).AddRow(audit.GenesisHash))
+	mock.ExpectInsert(`INSERT INTO AuditLog`, "audit_id").
+		WithArgs(accountID, sqlmock.AnyArg(), models.AuditKindBalanceChange, "", "", "", "discharge", accountID, transactionID, "", 0, changes, audit.GenesisHash, sqlmock.AnyArg()).
+		WillReturnID(1)
+	mock.ExpectExec(`UPDATE AuditChainHead SET last_hash = \? WHERE chain_id = \?`).
+		WithArgs(sqlmock.AnyArg(), accountID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestAppendAuditEntry(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		occurredAt := time.Date(2024, 9, 17, 15, 4, 5, 123456789, time.UTC)
+		entry := models.AuditEntry{
+			OccurredAt:  occurredAt,
+			Kind:        models.AuditKindRequest,
+			PrincipalID: "pk_dev",
+			RequestID:   "req-1",
+			Method:      "POST",
+			Route:       "/accounts",
+			AccountID:   1,
+			PayloadHash: "ab",
+			Outcome:     200,
+		}
+		// chained onto the previous entry with the timestamp as the database keeps it
+		expected := entry
+		expected.ID = 8
+		expected.ChainID = 1
+		expected.OccurredAt = occurredAt.Truncate(time.Microsecond)
+		expected.PrevHash = "f00d"
+		expected.Hash = audit.Hash(expected)
+
+		t.Run("Chains the entry onto the head", func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT last_hash FROM AuditChainHead WHERE chain_id = \? FOR UPDATE`).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"last_hash"}).AddRow("f00d"))
+			mock.ExpectInsert(`INSERT INTO AuditLog \(chain_id, occurred_at, kind, principal_id, request_id, method, route, account_id, transaction_id, payload_hash, outcome, changes, prev_hash, hash\) VALUES`, "audit_id").
+				WithArgs(1, expected.OccurredAt, "request", "pk_dev", "req-1", "POST", "/accounts", 1, nil, "ab", 200, nil, "f00d", expected.Hash).
+				WillReturnID(8)
+			mock.ExpectExec(`UPDATE AuditChainHead SET last_hash = \? WHERE chain_id = \?`).
+				WithArgs(expected.Hash, 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			appended, err := repo.AppendAuditEntry(entry)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, appended)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+
+		t.Run("Starts the chain of an account on its first entry", func(t *testing.T) {
+			first := expected
+			first.PrevHash = audit.GenesisHash
+			first.Hash = audit.Hash(first)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT last_hash FROM AuditChainHead WHERE chain_id = \? FOR UPDATE`).
+				WithArgs(1).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectExec(`INSERT (IGNORE |OR IGNORE )?INTO AuditChainHead \(chain_id, last_hash\) VALUES \(\?, \?\)( ON CONFLICT DO NOTHING)?`).
+				WithArgs(1, audit.GenesisHash).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(`SELECT last_hash FROM AuditChainHead WHERE chain_id = \? FOR UPDATE`).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"last_hash"}).AddRow(audit.GenesisHash))
+			mock.ExpectInsert(`INSERT INTO AuditLog`, "audit_id").
+				WillReturnID(8)
+			mock.ExpectExec(`UPDATE AuditChainHead SET last_hash = \? WHERE chain_id = \?`).
+				WithArgs(first.Hash, 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			appended, err := repo.AppendAuditEntry(entry)
+			assert.NoError(t, err)
+			assert.Equal(t, first, appended)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+
+		t.Run("Rolls back when the insert fails", func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT last_hash FROM AuditChainHead WHERE chain_id = \? FOR UPDATE`).
+				WillReturnRows(sqlmock.NewRows([]string{"last_hash"}).AddRow("f00d"))
+			mock.ExpectInsert(`INSERT INTO AuditLog`, "audit_id").
+				WillReturnError(errors.New("insert error"))
+			mock.ExpectRollback()
+
+			_, err := repo.AppendAuditEntry(entry)
+			assert.EqualError(t, err, "failed to insert audit entry: insert error")
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	})
+}
+
+func TestListAuditEntries(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		from := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+		to := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+
+		tests := []struct {
+			name      string
+			filter    models.AuditFilter
+			mockSetup func()
+			expected  []models.AuditEntry
+		}{
+			{
+				name:   "No filter",
+				filter: models.AuditFilter{},
+				mockSetup: func() {
+					mock.ExpectQuery(`SELECT .* FROM AuditLog ORDER BY audit_id ASC LIMIT \?`).
+						WithArgs(1000).
+						WillReturnRows(sqlmock.NewRows(auditColumns))
+				},
+				expected: []models.AuditEntry{},
+			},
+			{
+				name:   "By account and time range",
+				filter: models.AuditFilter{AccountID: 1, From: from, To: to, Limit: 10},
+				mockSetup: func() {
+					mock.ExpectQuery(`SELECT .* FROM AuditLog WHERE account_id = \? AND occurred_at >= \? AND occurred_at < \? ORDER BY audit_id ASC LIMIT \?`).
+						WithArgs(1, from, to, 10).
+						WillReturnRows(sqlmock.NewRows(auditColumns).
+							AddRow(3, 1, from, "balance_change", "", "", "", "discharge", 1, 9, "", 0, `[{"transaction_id":2,"before":-50,"after":0}]`, "aa", "bb"))
+				},
+				expected: []models.AuditEntry{{
+					ID:            3,
+					ChainID:       1,
+					OccurredAt:    from,
+					Kind:          models.AuditKindBalanceChange,
+					Route:         "discharge",
+					AccountID:     1,
+					TransactionID: 9,
+					Changes:       []models.BalanceChange{{TransactionID: 2, Before: -50, After: 0}},
+					PrevHash:      "aa",
+					Hash:          "bb",
+				}},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.mockSetup()
+
+				entries, err := repo.ListAuditEntries(tt.filter)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, entries)
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/models"
+	"pismo/store"
+)
+
+var authorizationColumns = []string{"authorization_id", "public_id", "account_id", "account_public_id", "operation_type_id", "amount", "currency", "original_amount", "original_currency", "fx_rate", "status", "transaction_id", "transaction_public_id", "expires_at", "version", "created_at", "updated_at"}
+
+// public ids of account 1, its transaction 12 and hold 3
+const (
+	accountPublicID       = "acc_01J8Z3V4W5X6Y7Z8A9B0C1D2E3"
+	transactionPublicID   = "txn_01J8Z3V4W5X6Y7Z8A9B0C1D2E4"
+	authorizationPublicID = "auth_01J8Z3V4W5X6Y7Z8A9B0C1D2E5"
+)
+
+func TestGetAvailableCredit(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		at := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+
+		tests := []struct {
+			name           string
+			mockSetup      func()
+			expectedResult float64
+			expectedError  string
+		}{
+			{
+				name: "Available limit minus pending holds",
+				mockSetup: func() {
+					mock.ExpectBegin()
+					mock.ExpectQuery(`SELECT b.available_limit .* FROM AccountBalances b WHERE b.account_id = \?`).
+						WithArgs(at, 1).
+						WillReturnRows(sqlmock.NewRows([]string{"available"}).AddRow(860.0))
+				},
+				expectedResult: 860.0,
+			},
+			{
+				name: "Account does not exist",
+				mockSetup: func() {
+					mock.ExpectBegin()
+					mock.ExpectQuery(`SELECT b.available_limit`).
+						WithArgs(at, 1).
+						WillReturnError(sql.ErrNoRows)
+				},
+				expectedError: sql.ErrNoRows.Error(),
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.mockSetup()
+				mock.ExpectEnd(tt.expectedError != "")
+
+				var result float64
+				err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+					var err error
+					result, err = tx.GetAvailableCredit(1, at)
+					return err
+				})
+
+				assert.Equal(t, tt.expectedResult, result)
+				if tt.expectedError != "" {
+					assert.EqualError(t, err, tt.expectedError)
+				} else {
+					assert.NoError(t, err)
+				}
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
+}
+
+func TestCreateAuthorization(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		expiresAt := time.Date(2024, 9, 24, 15, 4, 5, 0, time.UTC)
+		authorization := models.Authorization{
+			PublicID:         authorizationPublicID,
+			AccountID:        1,
+			AccountPublicID:  accountPublicID,
+			OperationTypeID:  1,
+			Amount:           -54.2,
+			Currency:         "BRL",
+			OriginalAmount:   -10.0,
+			OriginalCurrency: "USD",
+			FXRate:           5.42,
+			Status:           models.AuthorizationPending,
+			ExpiresAt:        expiresAt,
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectInsert(`INSERT INTO Authorizations \(public_id, account_id, account_public_id, operation_type_id, amount, currency, original_amount, original_currency, fx_rate, status, expires_at\)`, "authorization_id").
+			WithArgs(authorizationPublicID, 1, accountPublicID, 1, -54.2, "BRL", -10.0, "USD", 5.42, "PENDING", expiresAt).
+			WillReturnID(3)
+		mock.ExpectCommit()
+
+		var id int64
+		err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+			var err error
+			id, err = tx.CreateAuthorization(authorization)
+			return err
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), id)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetAuthorizationByID(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		ts := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+
+		tests := []struct {
+			name           string
+			mockSetup      func()
+			expectedResult models.Authorization
+			expectedError  string
+		}{
+			{
+				name: "Pending authorization has no transaction",
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT .* FROM Authorizations WHERE authorization_id = ?").
+						WithArgs(int64(3)).
+						WillReturnRows(sqlmock.NewRows(authorizationColumns).
+							AddRow(3, authorizationPublicID, 1, accountPublicID, 1, -50.0, "BRL", -50.0, "BRL", 1.0, "PENDING", nil, nil, ts, 1, ts, ts))
+				},
+				expectedResult: models.Authorization{ID: 3, PublicID: authorizationPublicID, AccountID: 1, AccountPublicID: accountPublicID, OperationTypeID: 1, Amount: -50.0, Currency: "BRL", OriginalAmount: -50.0, OriginalCurrency: "BRL", FXRate: 1.0, Status: "PENDING", ExpiresAt: ts, Version: 1, CreatedAt: ts, UpdatedAt: ts},
+			},
+			{
+				name: "Captured authorization links its transaction",
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT .* FROM Authorizations WHERE authorization_id = ?").
+						WithArgs(int64(3)).
+						WillReturnRows(sqlmock.NewRows(authorizationColumns).
+							AddRow(3, authorizationPublicID, 1, accountPublicID, 1, -50.0, "BRL", -50.0, "BRL", 1.0, "CAPTURED", 12, transactionPublicID, ts, 2, ts, ts))
+				},
+				expectedResult: models.Authorization{ID: 3, PublicID: authorizationPublicID, AccountID: 1, AccountPublicID: accountPublicID, OperationTypeID: 1, Amount: -50.0, Currency: "BRL", OriginalAmount: -50.0, OriginalCurrency: "BRL", FXRate: 1.0, Status: "CAPTURED", TransactionID: 12, TransactionPublicID: transactionPublicID, ExpiresAt: ts, Version: 2, CreatedAt: ts, UpdatedAt: ts},
+			},
+			{
+				name: "Database error",
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT .* FROM Authorizations WHERE authorization_id = ?").
+						WithArgs(int64(3)).
+						WillReturnError(errors.New("some db error"))
+				},
+				expectedResult: models.Authorization{},
+				expectedError:  "some db error",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.mockSetup()
+
+				result, err := repo.GetAuthorizationByID(3)
+
+				assert.Equal(t, tt.expectedResult, result)
+				if tt.expectedError != "" {
+					assert.EqualError(t, err, tt.expectedError)
+				} else {
+					assert.NoError(t, err)
+				}
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
+}
+
+func TestGetAuthorizationByPublicID(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		ts := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+
+		mock.ExpectQuery("SELECT .* FROM Authorizations WHERE public_id = ?").
+			WithArgs(authorizationPublicID).
+			WillReturnRows(sqlmock.NewRows(authorizationColumns).
+				AddRow(3, authorizationPublicID, 1, accountPublicID, 1, -50.0, "BRL", -50.0, "BRL", 1.0, "PENDING", nil, nil, ts, 1, ts, ts))
+		mock.ExpectQuery("SELECT .* FROM Authorizations WHERE public_id = ?").
+			WithArgs("auth_01J8Z3V4W5X6Y7Z8A9B0C1D2E6").
+			WillReturnError(sql.ErrNoRows)
+
+		result, err := repo.GetAuthorizationByPublicID(authorizationPublicID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), result.ID)
+		assert.Equal(t, models.PublicID(authorizationPublicID), result.PublicID)
+
+		_, err = repo.GetAuthorizationByPublicID("auth_01J8Z3V4W5X6Y7Z8A9B0C1D2E6")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUpdateAuthorizationStatus(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE Authorizations SET status = \?, transaction_id = \?, transaction_public_id = \?, version = version \+ 1 WHERE authorization_id = \?`).
+			WithArgs("CAPTURED", sql.NullInt64{Int64: 12, Valid: true}, sql.NullString{String: transactionPublicID, Valid: true}, int64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE Authorizations SET status = \?, transaction_id = \?, transaction_public_id = \?, version = version \+ 1 WHERE authorization_id = \?`).
+			WithArgs("VOIDED", sql.NullInt64{}, sql.NullString{}, int64(4)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		mock.ExpectCommit()
+
+		err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+			if err := tx.UpdateAuthorizationStatus(3, models.AuthorizationCaptured, models.Transaction{ID: 12, PublicID: transactionPublicID}); err != nil {
+				return err
+			}
+			return tx.UpdateAuthorizationStatus(4, models.AuthorizationVoided, models.Transaction{})
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestExpireAuthorizations(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		at := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+
+		mock.ExpectExec(`UPDATE Authorizations SET status = 'EXPIRED', version = version \+ 1 WHERE status = 'PENDING' AND expires_at <= \?`).
+			WithArgs(at).
+			WillReturnResult(sqlmock.NewResult(0, 4))
+
+		expired, err := repo.ExpireAuthorizations(at)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), expired)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
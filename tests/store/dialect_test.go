@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/store"
+)
+
+// every store test runs once per dialect. Expectations are written with ? placeholders,
+// Postgres queries are matched against them with their $n placeholders read as ?
+var dialects = []store.Dialect{store.MySQL, store.Postgres}
+
+var numberedPlaceholder = regexp.MustCompile(`\$\d+`)
+
+// dialectMock is a sqlmock that knows what the dialect under test sends for the
+// statements that differ between databases
+type dialectMock struct {
+	sqlmock.Sqlmock
+	dialect store.Dialect
+}
+
+func forEachDialect(t *testing.T, test func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository)) {
+	for _, dialect := range dialects {
+		t.Run(dialect.Name(), func(t *testing.T) {
+			db, mock := newDialectMock(t, dialect)
+			test(t, db, mock, &store.Repository{DB: db, Dialect: dialect})
+		})
+	}
+}
+
+// newDialectMock is a sqlmock database of the dialect, closed when the test ends
+func newDialectMock(t *testing.T, dialect store.Dialect) (*sql.DB, *dialectMock) {
+	matcher := sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+		return sqlmock.QueryMatcherRegexp.Match(expectedSQL, numberedPlaceholder.ReplaceAllString(actualSQL, "?"))
+	})
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(matcher))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db, &dialectMock{Sqlmock: mock, dialect: dialect}
+}
+
+// expectedInsert is an insert that generates an id, MySQL hands it back as the last
+// insert id and Postgres through RETURNING
+type expectedInsert struct {
+	exec     *sqlmock.ExpectedExec
+	query    *sqlmock.ExpectedQuery
+	idColumn string
+}
+
+func (m *dialectMock) ExpectInsert(query, idColumn string) *expectedInsert {
+	if m.dialect == store.Postgres {
+		return &expectedInsert{query: m.ExpectQuery(query + `.* RETURNING ` + idColumn + `$`), idColumn: idColumn}
+	}
+	return &expectedInsert{exec: m.ExpectExec(query)}
+}
+
+func (e *expectedInsert) WithArgs(args ...driver.Value) *expectedInsert {
+	if e.query != nil {
+		e.query.WithArgs(args...)
+	} else {
+		e.exec.WithArgs(args...)
+	}
+	return e
+}
+
+func (e *expectedInsert) WillReturnID(id int64) {
+	if e.query != nil {
+		e.query.WillReturnRows(sqlmock.NewRows([]string{e.idColumn}).AddRow(id))
+	} else {
+		e.exec.WillReturnResult(sqlmock.NewResult(id, 1))
+	}
+}
+
+func (e *expectedInsert) WillReturnError(err error) {
+	if e.query != nil {
+		e.query.WillReturnError(err)
+	} else {
+		e.exec.WillReturnError(err)
+	}
+}
+
+// ExpectEnd expects the unit of work to be rolled back when it fails and
+// committed otherwise
+func (m *dialectMock) ExpectEnd(fails bool) {
+	if fails {
+		m.ExpectRollback()
+	} else {
+		m.ExpectCommit()
+	}
+}
+
+// DuplicateEntryError is what the driver of the dialect returns on a unique key violation
+func (m *dialectMock) DuplicateEntryError(key string) error {
+	if m.dialect == store.Postgres {
+		return &pq.Error{Code: "23505", Message: `duplicate key value violates unique constraint "` + key + `"`}
+	}
+	return &mysql.MySQLError{Number: 1062, Message: "Duplicate entry for key '" + key + "'"}
+}
+
+func TestDialectFor(t *testing.T) {
+	dialect, err := store.DialectFor("postgres")
+	assert.NoError(t, err)
+	assert.Equal(t, store.Postgres, dialect)
+
+	dialect, err = store.DialectFor("mysql")
+	assert.NoError(t, err)
+	assert.Equal(t, store.MySQL, dialect)
+
+	dialect, err = store.DialectFor("sqlite")
+	assert.NoError(t, err)
+	assert.Equal(t, store.SQLite, dialect)
+
+	_, err = store.DialectFor("sqlserver")
+	assert.EqualError(t, err, "unsupported database driver: sqlserver")
+}
+
+func TestPostgresPlaceholders(t *testing.T) {
+	// no placeholder matching here, the query has to reach the driver numbered
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	at := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`h.status = 'PENDING' AND h.expires_at > \$1\), 0\)\s+FROM AccountBalances b WHERE b.account_id = \$2$`).
+		WithArgs(at, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"available"}).AddRow(860.0))
+	mock.ExpectCommit()
+
+	var available float64
+	repo := &store.Repository{DB: db, Dialect: store.Postgres}
+	err = repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		available, err = tx.GetAvailableCredit(1, at)
+		return err
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 860.0, available)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "MySQL deadlock", err: &mysql.MySQLError{Number: 1213}, retryable: true},
+		{name: "MySQL duplicate entry", err: &mysql.MySQLError{Number: 1062}},
+		{name: "Postgres deadlock", err: &pq.Error{Code: "40P01"}, retryable: true},
+		{name: "Postgres serialization failure", err: &pq.Error{Code: "40001"}, retryable: true},
+		{name: "Postgres unique violation", err: &pq.Error{Code: "23505"}},
+		{name: "Wrapped deadlock", err: fmt.Errorf("failed to lock account 1: %w", &pq.Error{Code: "40P01"}), retryable: true},
+		{name: "Anything else", err: errors.New("connection refused")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.retryable, store.IsRetryable(tt.err))
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/ledger"
+	"pismo/models"
+	"pismo/store"
+)
+
+func TestCreateJournalEntry(t *testing.T) {
+	postedAt := time.Date(2024, 9, 17, 15, 4, 5, 0, time.UTC)
+	entry := models.JournalEntry{
+		TransactionID: 9,
+		Description:   "purchase",
+		Currency:      "BRL",
+		PostedAt:      postedAt,
+		Postings: []models.Posting{
+			{LedgerAccount: ledger.CustomerAccount(1, "BRL"), Amount: 50},
+			{LedgerAccount: ledger.SettlementAccount("BRL"), Amount: -50},
+		},
+	}
+
+	t.Run("Creates the customer's ledger account on first use", func(t *testing.T) {
+		forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+			// a concurrent insert of the same code is skipped rather than failing the entry
+			insertLedgerAccount := `INSERT IGNORE INTO LedgerAccounts \(code, type, account_id, currency\) VALUES \(\?, \?, \?, \?\)`
+			if mock.dialect == store.Postgres {
+				insertLedgerAccount = `INSERT INTO LedgerAccounts \(code, type, account_id, currency\) VALUES \(\?, \?, \?, \?\) ON CONFLICT DO NOTHING`
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT ledger_account_id FROM LedgerAccounts WHERE code = \? FOR SHARE`).
+				WithArgs("customer:1").
+				WillReturnRows(sqlmock.NewRows([]string{"ledger_account_id"}))
+			mock.ExpectExec(insertLedgerAccount).
+				WithArgs("customer:1", models.LedgerAsset, int64(1), "BRL").
+				WillReturnResult(sqlmock.NewResult(3, 1))
+			mock.ExpectQuery(`SELECT ledger_account_id FROM LedgerAccounts WHERE code = \? FOR SHARE`).
+				WithArgs("customer:1").
+				WillReturnRows(sqlmock.NewRows([]string{"ledger_account_id"}).AddRow(3))
+			mock.ExpectQuery(`SELECT ledger_account_id FROM LedgerAccounts WHERE code = \? FOR SHARE`).
+				WithArgs("settlement:BRL").
+				WillReturnRows(sqlmock.NewRows([]string{"ledger_account_id"}).AddRow(1))
+			mock.ExpectInsert(`INSERT INTO JournalEntries \(transaction_id, description, currency, posted_at\) VALUES \(\?, \?, \?, \?\)`, "journal_entry_id").
+				WithArgs(int64(9), "purchase", "BRL", postedAt).
+				WillReturnID(11)
+			mock.ExpectExec(`INSERT INTO Postings \(journal_entry_id, ledger_account_id, amount, currency\) VALUES \(\?, \?, \?, \?\)`).
+				WithArgs(int64(11), int64(3), 50.0, "BRL").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(`INSERT INTO Postings \(journal_entry_id, ledger_account_id, amount, currency\) VALUES \(\?, \?, \?, \?\)`).
+				WithArgs(int64(11), int64(1), -50.0, "BRL").
+				WillReturnResult(sqlmock.NewResult(2, 1))
+			mock.ExpectCommit()
+
+			var id int64
+			err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+				var err error
+				id, err = tx.CreateJournalEntry(entry)
+				return err
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, int64(11), id)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Unbalanced entries are never written", func(t *testing.T) {
+		forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			unbalanced := entry
+			unbalanced.Postings = []models.Posting{entry.Postings[0], {LedgerAccount: ledger.SettlementAccount("BRL"), Amount: -49}}
+
+			err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+				_, err := tx.CreateJournalEntry(unbalanced)
+				return err
+			})
+
+			assert.EqualError(t, err, "invalid journal entry: postings are off balance by 1.00")
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	})
+}
+
+func TestGetTrialBalance(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		mock.ExpectQuery(`SELECT la.currency, la.code, la.type, la.account_id, .* FROM LedgerAccounts la JOIN Postings p`).
+			WillReturnRows(sqlmock.NewRows([]string{"currency", "code", "type", "account_id", "debits", "credits"}).
+				AddRow("BRL", "cash:BRL", models.LedgerAsset, nil, 60.0, 0.0).
+				AddRow("BRL", "customer:1", models.LedgerAsset, 1, 50.0, 60.0).
+				AddRow("BRL", "settlement:BRL", models.LedgerLiability, nil, 0.0, 50.0).
+				AddRow("USD", "cash:USD", models.LedgerAsset, nil, 0.0, 20.0).
+				AddRow("USD", "customer:2", models.LedgerAsset, 2, 20.0, 0.0))
+
+		balances, err := repo.GetTrialBalance()
+
+		assert.NoError(t, err)
+		assert.Equal(t, []models.TrialBalance{
+			{Currency: "BRL", Lines: []models.TrialBalanceLine{
+				{Code: "cash:BRL", Type: models.LedgerAsset, Debits: 60},
+				{Code: "customer:1", Type: models.LedgerAsset, AccountID: 1, Debits: 50, Credits: 60},
+				{Code: "settlement:BRL", Type: models.LedgerLiability, Credits: 50},
+			}},
+			{Currency: "USD", Lines: []models.TrialBalanceLine{
+				{Code: "cash:USD", Type: models.LedgerAsset, Credits: 20},
+				{Code: "customer:2", Type: models.LedgerAsset, AccountID: 2, Debits: 20},
+			}},
+		}, balances)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
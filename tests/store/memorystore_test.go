@@ -0,0 +1,309 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/ledger"
+	"pismo/models"
+	"pismo/store"
+)
+
+// newMemoryRepository holds the products of init.sql and its account 1, which owes
+// 50, 25 and 15 from three purchases posted a minute apart
+func newMemoryRepository(t *testing.T) *store.MemoryRepository {
+	repo := store.NewMemoryRepository(
+		models.Product{ID: 1, Name: "Standard", CreditLimit: 1000},
+		models.Product{ID: 2, Name: "Gold", CreditLimit: 5000},
+	)
+	_, err := repo.CreateAccount(models.Account{DocumentNumber: "12345678909", DocumentType: "CPF", HolderName: "Maria Silva", Country: "BR", ProductID: 1, Currency: "BRL"})
+	assert.NoError(t, err)
+
+	err = repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		postedAt := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+		for i, amount := range []float64{-50, -25, -15} {
+			purchase := models.Transaction{AccountID: 1, OperationTypeID: 1, Amount: amount, Balance: amount, Currency: "BRL", PostingDate: postedAt.Add(time.Duration(i) * time.Minute)}
+			purchase.EventDate = purchase.PostingDate
+			if _, err := tx.CreateTransaction(purchase); err != nil {
+				return err
+			}
+		}
+		totals, err := tx.LockAccountBalance(1)
+		if err != nil {
+			return err
+		}
+		totals.TotalDebt = 90
+		return tx.UpdateAccountBalance(totals)
+	})
+	assert.NoError(t, err)
+	return repo
+}
+
+func TestMemoryCreateAccount(t *testing.T) {
+	repo := newMemoryRepository(t)
+
+	id, err := repo.CreateAccount(models.Account{DocumentNumber: "98765432100", DocumentType: "CPF", HolderName: "John Doe", Country: "BR", ProductID: 2, Currency: "BRL"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), id)
+
+	account, err := repo.GetAccountByID(2)
+	assert.NoError(t, err)
+	assert.Equal(t, models.AccountActive, account.Status)
+	assert.Equal(t, int64(1), account.Version)
+	assert.Nil(t, account.CreditLimit)
+
+	// opened with the full limit of its product
+	totals, err := repo.GetAccountTotals(2)
+	assert.NoError(t, err)
+	assert.Equal(t, models.AccountTotals{AccountID: 2, AvailableLimit: 5000, UpdatedAt: totals.UpdatedAt}, totals)
+
+	_, err = repo.CreateAccount(models.Account{DocumentNumber: "98765432100", ProductID: 1})
+	assert.Equal(t, &store.ConflictError{Entity: "account", Key: "document number", ExistingID: 2}, err)
+
+	_, err = repo.CreateAccount(models.Account{DocumentNumber: "11144477735", ProductID: 9})
+	assert.EqualError(t, err, "failed to create account: product 9 does not exist")
+
+	_, err = repo.GetAccountByID(9)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestMemoryProcessDischargeTransaction(t *testing.T) {
+	repo := newMemoryRepository(t)
+
+	payment := models.Transaction{AccountID: 1, OperationTypeID: 4, Amount: 60, Balance: 60, Currency: "BRL", PostingDate: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)}
+	payment.EventDate = payment.PostingDate
+	var used float64
+	err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		var err error
+		if payment.ID, err = tx.CreateTransaction(payment); err != nil {
+			return err
+		}
+		// pays off the 50 and 10 of the 25, in the order they were posted
+		used, err = tx.ProcessDischargeTransaction(payment)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 60.0, used)
+
+	balances, err := repo.GetTransactionBalancesAsOf(1, payment.PostingDate)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{0, -15, -15, 0}, openBalances(balances))
+
+	// before the payment the purchases were still owed in full
+	balances, err = repo.GetTransactionBalancesAsOf(1, payment.PostingDate.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{-50, -25, -15}, openBalances(balances))
+
+	// the discharge is on the audit chain
+	entries, err := repo.ListAuditEntries(models.AuditFilter{AccountID: 1})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, payment.ID, entries[0].TransactionID)
+}
+
+func TestMemoryBackdatedPosting(t *testing.T) {
+	repo := newMemoryRepository(t)
+	asOf := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+
+	// happened before asOf but was only posted after it
+	purchase := models.Transaction{AccountID: 1, OperationTypeID: 1, Amount: -40, Balance: -40, Currency: "BRL", EventDate: asOf.Add(-time.Hour), PostingDate: asOf.Add(time.Hour)}
+	err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		_, err := tx.CreateTransaction(purchase)
+		return err
+	})
+	assert.NoError(t, err)
+
+	balances, err := repo.GetTransactionBalancesAsOf(1, asOf)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{-50, -25, -15}, openBalances(balances))
+
+	balances, err = repo.GetTransactionBalancesAsOf(1, purchase.PostingDate)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{-50, -25, -15, -40}, openBalances(balances))
+}
+
+func TestMemoryRollback(t *testing.T) {
+	repo := newMemoryRepository(t)
+	failed := errors.New("failed")
+
+	payment := models.Transaction{AccountID: 1, OperationTypeID: 4, Amount: 100, Balance: 100, Currency: "BRL"}
+	var finished store.TxRepo
+	err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		finished = tx
+		var err error
+		if payment.ID, err = tx.CreateTransaction(payment); err != nil {
+			return err
+		}
+		entry, err := ledger.ForTransaction(payment)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateJournalEntry(entry); err != nil {
+			return err
+		}
+		if _, err := tx.ProcessDischargeTransaction(payment); err != nil {
+			return err
+		}
+		totals, err := tx.LockAccountBalance(1)
+		if err != nil {
+			return err
+		}
+		totals.TotalDebt, totals.TotalCredit = 0, 10
+		if err := tx.UpdateAccountBalance(totals); err != nil {
+			return err
+		}
+		return failed
+	})
+	assert.Equal(t, failed, err)
+
+	// none of it was kept
+	balances, err := repo.GetTransactionBalancesAsOf(1, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{-50, -25, -15}, openBalances(balances))
+	totals, err := repo.GetAccountTotals(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 90.0, totals.TotalDebt)
+	assert.Equal(t, 910.0, totals.AvailableLimit)
+	assert.Equal(t, int64(1), totals.Version)
+	entries, err := repo.ListAuditEntries(models.AuditFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// ids aren't handed out again
+	err = repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		id, err := tx.CreateTransaction(payment)
+		assert.Equal(t, int64(5), id)
+		return err
+	})
+	assert.NoError(t, err)
+
+	// a finished unit of work can't be used again
+	_, err = finished.LockAccountBalance(1)
+	assert.ErrorIs(t, err, sql.ErrTxDone)
+}
+
+func TestMemoryRollbackAfterAppend(t *testing.T) {
+	repo := newMemoryRepository(t)
+	failed := errors.New("failed")
+
+	err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		if err := tx.UpdateTransactionBalance(1, 1, 0); err != nil {
+			return err
+		}
+		// enough appends for the transactions to be moved to a bigger array
+		for i := 0; i < 10; i++ {
+			if _, err := tx.CreateTransaction(models.Transaction{AccountID: 1, OperationTypeID: 1, Amount: -10, Balance: -10}); err != nil {
+				return err
+			}
+		}
+		return failed
+	})
+	assert.Equal(t, failed, err)
+
+	// the balance went back on the transactions as they are now
+	transactions, err := repo.ListAccountTransactions(1)
+	assert.NoError(t, err)
+	if assert.Len(t, transactions, 3) {
+		assert.Equal(t, -50.0, transactions[0].Balance)
+	}
+}
+
+func TestMemoryPanicRollsBack(t *testing.T) {
+	repo := newMemoryRepository(t)
+
+	assert.PanicsWithValue(t, "boom", func() {
+		repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+			if _, err := tx.CreateTransaction(models.Transaction{AccountID: 1, OperationTypeID: 1, Amount: -10, Balance: -10}); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	})
+
+	// rolled back and the store was let go of
+	transactions, err := repo.ListAccountTransactions(1)
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 3)
+}
+
+func TestMemoryNestedUnitOfWork(t *testing.T) {
+	repo := newMemoryRepository(t)
+	failed := errors.New("failed")
+
+	err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		purchase := models.Transaction{AccountID: 1, OperationTypeID: 1, Amount: -10, Balance: -10}
+		if _, err := tx.CreateTransaction(purchase); err != nil {
+			return err
+		}
+
+		// only what the savepoint did is rolled back
+		err := tx.WithinTx(context.Background(), func(nested store.TxRepo) error {
+			if _, err := nested.CreateTransaction(purchase); err != nil {
+				return err
+			}
+			return failed
+		})
+		assert.Equal(t, failed, err)
+
+		assert.Equal(t, store.ErrNestedTxOptions, tx.WithinTx(context.Background(), func(store.TxRepo) error { return nil }, store.ReadOnly()))
+
+		return tx.WithinTx(context.Background(), func(nested store.TxRepo) error {
+			_, err := nested.CreateTransaction(purchase)
+			return err
+		})
+	})
+	assert.NoError(t, err)
+
+	transactions, err := repo.ListAccountTransactions(1)
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 5)
+}
+
+func TestMemoryUpdateAccount(t *testing.T) {
+	repo := newMemoryRepository(t)
+
+	err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		account, err := tx.GetAccountByID(1)
+		assert.NoError(t, err)
+		limit := 500.0
+		account.CreditLimit = &limit
+		assert.NoError(t, tx.UpdateAccount(account))
+
+		// the account moved on to version 2
+		err = tx.UpdateAccount(account)
+		assert.ErrorIs(t, err, store.ErrVersionMismatch)
+		assert.EqualError(t, err, "version mismatch: account 1 is no longer at version 1")
+
+		// the override replaces the product limit, less the 90 owed
+		totals, err := tx.LockAccountBalance(1)
+		assert.NoError(t, err)
+		return tx.UpdateAccountBalance(totals)
+	})
+	assert.NoError(t, err)
+
+	totals, err := repo.GetAccountTotals(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 410.0, totals.AvailableLimit)
+}
+
+func TestMemoryCanceledContext(t *testing.T) {
+	repo := newMemoryRepository(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := repo.WithinTx(ctx, func(store.TxRepo) error { return nil })
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func openBalances(balances []models.TransactionBalance) []float64 {
+	open := []float64{}
+	for _, balance := range balances {
+		open = append(open, balance.Balance)
+	}
+	return open
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/models"
+	"pismo/store"
+)
+
+func TestGetProductByID(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		tests := []struct {
+			name           string
+			productID      int
+			mockSetup      func()
+			expectedResult models.Product
+			expectedError  error
+		}{
+			{
+				name:      "Product not found",
+				productID: 9,
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT product_id, name, credit_limit FROM Products WHERE product_id = ?").
+						WithArgs(9).
+						WillReturnError(sql.ErrNoRows)
+				},
+				expectedResult: models.Product{},
+				expectedError:  sql.ErrNoRows,
+			},
+			{
+				name:      "Database error",
+				productID: 1,
+				mockSetup: func() {
+					mock.ExpectQuery("SELECT product_id, name, credit_limit FROM Products WHERE product_id = ?").
+						WithArgs(1).
+						WillReturnError(errors.New("some db error"))
+				},
+				expectedResult: models.Product{},
+				expectedError:  errors.New("some db error"),
+			},
+			{
+				name:      "Successfully fetched product",
+				productID: 1,
+				mockSetup: func() {
+					rows := sqlmock.NewRows([]string{"product_id", "name", "credit_limit"}).
+						AddRow(1, "Standard", 1000.0)
+					mock.ExpectQuery("SELECT product_id, name, credit_limit FROM Products WHERE product_id = ?").
+						WithArgs(1).
+						WillReturnRows(rows)
+				},
+				expectedResult: models.Product{ID: 1, Name: "Standard", CreditLimit: 1000.0},
+				expectedError:  nil,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.mockSetup()
+
+				result, err := repo.GetProductByID(tt.productID)
+
+				assert.Equal(t, tt.expectedResult, result)
+				if tt.expectedError != nil {
+					assert.EqualError(t, err, tt.expectedError.Error())
+				} else {
+					assert.NoError(t, err)
+				}
+
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
+}
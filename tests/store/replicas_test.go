@@ -0,0 +1,188 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/store"
+)
+
+const selectAccount = `SELECT account_id, .* FROM Accounts WHERE account_id = \?`
+
+// ExpectLag expects the replica to be asked how far behind its primary it is, a
+// nil lag being a replica whose replication has stopped
+func (m *dialectMock) ExpectLag(lag *time.Duration) *sqlmock.ExpectedQuery {
+	if m.dialect == store.Postgres {
+		rows := sqlmock.NewRows([]string{"lag"})
+		if lag != nil {
+			rows.AddRow(lag.Seconds())
+		} else {
+			rows.AddRow(nil)
+		}
+		return m.ExpectQuery(`SELECT CASE\s+WHEN NOT pg_is_in_recovery\(\)`).WillReturnRows(rows)
+	}
+	rows := sqlmock.NewRows([]string{"Replica_IO_State", "Seconds_Behind_Source"})
+	if lag != nil {
+		rows.AddRow("Waiting for source to send event", int64(lag.Seconds()))
+	} else {
+		rows.AddRow("", nil)
+	}
+	return m.ExpectQuery(`SHOW REPLICA STATUS`).WillReturnRows(rows)
+}
+
+func accountRow() *sqlmock.Rows {
+	return sqlmock.NewRows(accountColumns).
+		AddRow(1, accountPublicID, "123456789", "CPF", "Maria Silva", "BR", 1, "BRL", "acme", "ACTIVE", nil, 1, createdAt, createdAt)
+}
+
+func lagOf(d time.Duration) *time.Duration {
+	return &d
+}
+
+func TestReplicaReads(t *testing.T) {
+	for _, dialect := range dialects {
+		t.Run(dialect.Name(), func(t *testing.T) {
+			// each case gets a new repository, so the lag of its replica is measured afresh
+			newRepository := func(t *testing.T) (primary, replica *dialectMock, repo *store.Repository) {
+				primaryDB, primary := newDialectMock(t, dialect)
+				replicaDB, replica := newDialectMock(t, dialect)
+				return primary, replica, store.NewRepository(primaryDB, dialect, store.WithReplicas(time.Minute, replicaDB))
+			}
+			expectationsMet := func(t *testing.T, mocks ...*dialectMock) {
+				for _, mock := range mocks {
+					assert.NoError(t, mock.ExpectationsWereMet())
+				}
+			}
+
+			t.Run("Read from a replica close enough behind", func(t *testing.T) {
+				primary, replica, repo := newRepository(t)
+				replica.ExpectLag(lagOf(2 * time.Second))
+				replica.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(accountRow())
+				// measured once, until half the tolerance has gone by
+				replica.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(accountRow())
+
+				for i := 0; i < 2; i++ {
+					account, err := repo.GetAccountByID(1)
+					assert.NoError(t, err)
+					assert.Equal(t, testAccount, account)
+				}
+				expectationsMet(t, primary, replica)
+			})
+
+			t.Run("Replicas too far behind are left out", func(t *testing.T) {
+				primary, replica, repo := newRepository(t)
+				replica.ExpectLag(lagOf(2 * time.Minute))
+				primary.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(accountRow())
+
+				account, err := repo.GetAccountByID(1)
+				assert.NoError(t, err)
+				assert.Equal(t, testAccount, account)
+				expectationsMet(t, primary, replica)
+			})
+
+			t.Run("Reads don't wait on the lag being measured again", func(t *testing.T) {
+				primaryDB, primary := newDialectMock(t, dialect)
+				replicaDB, replica := newDialectMock(t, dialect)
+				repo := store.NewRepository(primaryDB, dialect, store.WithReplicas(time.Second, replicaDB))
+				replica.ExpectLag(lagOf(0))
+				replica.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(accountRow())
+				_, err := repo.GetAccountByID(1)
+				assert.NoError(t, err)
+
+				// the lag is measured again alongside the reads, in whatever order they get there
+				replica.MatchExpectationsInOrder(false)
+				replica.ExpectLag(lagOf(0)).WillDelayFor(300 * time.Millisecond)
+				for i := 0; i < 2; i++ {
+					replica.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(accountRow())
+				}
+
+				// half the tolerance has gone by, the read goes on while it's measured
+				time.Sleep(510 * time.Millisecond)
+				start := time.Now()
+				_, err = repo.GetAccountByID(1)
+				assert.NoError(t, err)
+				assert.Less(t, time.Since(start), 300*time.Millisecond)
+
+				// past the tolerance of the first measurement, but not of the second
+				time.Sleep(400 * time.Millisecond)
+				_, err = repo.GetAccountByID(1)
+				assert.NoError(t, err)
+				expectationsMet(t, primary, replica)
+			})
+
+			t.Run("Replicas that stopped replicating are left out", func(t *testing.T) {
+				primary, replica, repo := newRepository(t)
+				replica.ExpectLag(nil)
+				primary.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(accountRow())
+
+				_, err := repo.GetAccountByID(1)
+				assert.NoError(t, err)
+				expectationsMet(t, primary, replica)
+			})
+
+			t.Run("A failed read is retried on the primary", func(t *testing.T) {
+				primary, replica, repo := newRepository(t)
+				replica.ExpectLag(lagOf(0))
+				replica.ExpectQuery(selectAccount).WithArgs(1).WillReturnError(errors.New("connection refused"))
+				primary.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(accountRow())
+				// and the replica is left out until it's measured again
+				primary.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(accountRow())
+
+				for i := 0; i < 2; i++ {
+					account, err := repo.GetAccountByID(1)
+					assert.NoError(t, err)
+					assert.Equal(t, testAccount, account)
+				}
+				expectationsMet(t, primary, replica)
+			})
+
+			t.Run("What the replica doesn't have yet is asked of the primary", func(t *testing.T) {
+				primary, replica, repo := newRepository(t)
+				replica.ExpectLag(lagOf(0))
+				replica.ExpectQuery(selectAccount).WithArgs(2).WillReturnError(sql.ErrNoRows)
+				primary.ExpectQuery(selectAccount).WithArgs(2).WillReturnError(sql.ErrNoRows)
+				// a miss doesn't count against the replica
+				replica.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(accountRow())
+
+				_, err := repo.GetAccountByID(2)
+				assert.ErrorIs(t, err, sql.ErrNoRows)
+				_, err = repo.GetAccountByID(1)
+				assert.NoError(t, err)
+				expectationsMet(t, primary, replica)
+			})
+
+			t.Run("Read your writes from the primary", func(t *testing.T) {
+				primary, replica, repo := newRepository(t)
+				primary.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(accountRow())
+
+				account, err := repo.ReadYourWrites().GetAccountByID(1)
+				assert.NoError(t, err)
+				assert.Equal(t, testAccount, account)
+				expectationsMet(t, primary, replica)
+			})
+		})
+	}
+}
+
+func TestMySQLReplicaLagRoundsUp(t *testing.T) {
+	primaryDB, primary := newDialectMock(t, store.MySQL)
+	replicaDB, replica := newDialectMock(t, store.MySQL)
+	repo := store.NewRepository(primaryDB, store.MySQL, store.WithReplicas(1500*time.Millisecond, replicaDB))
+
+	// reported a second behind, it may be up to two behind, past the bound of one
+	// and a half, so the replica's stale copy of the account isn't read
+	replica.ExpectLag(lagOf(time.Second))
+	replica.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(sqlmock.NewRows(accountColumns).
+		AddRow(1, accountPublicID, "123456789", "CPF", "Maria Souza", "BR", 1, "BRL", "acme", "ACTIVE", nil, 1, createdAt, createdAt))
+	primary.ExpectQuery(selectAccount).WithArgs(1).WillReturnRows(accountRow())
+
+	account, err := repo.GetAccountByID(1)
+	assert.NoError(t, err)
+	assert.Equal(t, testAccount, account)
+	assert.NoError(t, primary.ExpectationsWereMet())
+}
@@ -0,0 +1,241 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/audit"
+	"pismo/database"
+	"pismo/ledger"
+	"pismo/models"
+	"pismo/publicid"
+	"pismo/store"
+)
+
+// these run the store against a real SQLite database instead of sqlmock, so the
+// SQL itself is checked and not only what gets sent
+
+// newSQLiteRepository migrates a new SQLite database seeded with init.sql, whose
+// account 1 owes 50, 25 and 15 from three purchases
+func newSQLiteRepository(t *testing.T) *store.Repository {
+	config := database.Config{
+		Driver:   database.DriverSQLite,
+		DSN:      "file:" + filepath.Join(t.TempDir(), "pismo.db"),
+		SeedFile: "../../init.sql",
+	}
+	conn, err := database.Connect(config)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	assert.NoError(t, database.Migrate(conn, config))
+	return store.NewRepository(conn, store.SQLite)
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	repo := newSQLiteRepository(t)
+
+	// the backfills ran over the seeded purchases
+	totals, err := repo.GetAccountTotals(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 90.0, totals.TotalDebt)
+	assert.Equal(t, 910.0, totals.AvailableLimit)
+
+	balances, err := repo.GetTrialBalance()
+	assert.NoError(t, err)
+	assert.Len(t, balances, 1)
+	assert.Equal(t, []models.TrialBalanceLine{
+		{Code: "customer:1", Type: models.LedgerAsset, AccountID: 1, Debits: 90},
+		{Code: "settlement:BRL", Type: models.LedgerLiability, Credits: 90},
+	}, balances[0].Lines)
+
+	// applied migrations are skipped, the seed isn't run again
+	assert.NoError(t, database.Migrate(repo.DB, database.Config{Driver: database.DriverSQLite, SeedFile: "../../init.sql"}))
+}
+
+func TestSQLiteCreateAccount(t *testing.T) {
+	repo := newSQLiteRepository(t)
+
+	id, err := repo.CreateAccount(models.Account{DocumentNumber: "98765432100", DocumentType: "CPF", HolderName: "John Doe", Country: "BR", ProductID: 2, Currency: "BRL"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), id)
+
+	// opened with the full limit of its product
+	totals, err := repo.GetAccountTotals(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 5000.0, totals.AvailableLimit)
+
+	_, err = repo.CreateAccount(models.Account{DocumentNumber: "98765432100", DocumentType: "CPF", ProductID: 1, Currency: "BRL"})
+	assert.Equal(t, &store.ConflictError{Entity: "account", Key: "document number", ExistingID: 2}, err)
+}
+
+func TestSQLiteConcurrentCreateAccount(t *testing.T) {
+	repo := newSQLiteRepository(t)
+
+	// every goroutine opens an account for the same holder, the unique index
+	// has to let exactly one through however the inserts interleave
+	const attempts = 8
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = repo.CreateAccount(models.Account{DocumentNumber: "98765432100", DocumentType: "CPF", HolderName: "John Doe", Country: "BR", ProductID: 2, Currency: "BRL"})
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+		var conflict *store.ConflictError
+		if assert.ErrorAs(t, err, &conflict) {
+			assert.Equal(t, int64(2), conflict.ExistingID)
+		}
+	}
+	assert.Equal(t, 1, created)
+}
+
+func TestSQLiteProcessDischargeTransaction(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	before := time.Now().UTC()
+	postedAt := before.Add(time.Second)
+
+	deposit := models.Transaction{AccountID: 1, OperationTypeID: 4, Amount: 60, Balance: 60, Currency: "BRL", OriginalAmount: 60, OriginalCurrency: "BRL", FXRate: 1, EventDate: postedAt, PostingDate: postedAt}
+	var used float64
+	err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		var err error
+		if deposit.ID, err = tx.CreateTransaction(deposit); err != nil {
+			return err
+		}
+		used, err = tx.ProcessDischargeTransaction(deposit)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 60.0, used)
+
+	transactions, err := repo.ListAccountTransactions(1)
+	assert.NoError(t, err)
+	var balances []float64
+	for _, transaction := range transactions {
+		balances = append(balances, transaction.Balance)
+	}
+	assert.Equal(t, []float64{0, -15, -15, 0}, balances)
+
+	allocations, err := repo.ListAccountAllocations(1)
+	assert.NoError(t, err)
+	assert.Len(t, allocations, 2)
+
+	// balances as they were before the deposit are rebuilt from the allocations
+	asOf, err := repo.GetTransactionBalancesAsOf(1, before)
+	assert.NoError(t, err)
+	var earlier []float64
+	for _, balance := range asOf {
+		earlier = append(earlier, balance.Balance)
+	}
+	assert.Equal(t, []float64{-50, -25, -15}, earlier)
+
+	// the discharge chained its balance changes onto the audit log
+	// onto the account's own chain
+	heads, err := repo.ListAuditChainHeads()
+	assert.NoError(t, err)
+	entries, err := repo.ListAuditEntries(models.AuditFilter{AccountID: 1})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, int64(1), entries[0].ChainID)
+	assert.Equal(t, audit.GenesisHash, entries[0].PrevHash)
+	assert.Equal(t, map[int64]string{0: audit.GenesisHash, 1: entries[0].Hash}, heads)
+}
+
+func TestSQLiteBackdatedPosting(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	asOf := time.Now().UTC()
+
+	// happened before asOf but was only posted after it
+	purchase := models.Transaction{AccountID: 1, OperationTypeID: 1, Amount: -40, Balance: -40, Currency: "BRL", OriginalAmount: -40, OriginalCurrency: "BRL", FXRate: 1, EventDate: asOf.Add(-time.Hour), PostingDate: asOf.Add(time.Second)}
+	err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		_, err := tx.CreateTransaction(purchase)
+		return err
+	})
+	assert.NoError(t, err)
+
+	balances, err := repo.GetTransactionBalancesAsOf(1, asOf)
+	assert.NoError(t, err)
+	assert.Len(t, balances, 3)
+
+	balances, err = repo.GetTransactionBalancesAsOf(1, purchase.PostingDate)
+	assert.NoError(t, err)
+	assert.Len(t, balances, 4)
+}
+
+func TestSQLiteJournalIsAppendOnly(t *testing.T) {
+	repo := newSQLiteRepository(t)
+
+	err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		_, err := tx.CreateJournalEntry(models.JournalEntry{
+			TransactionID: 1,
+			Description:   "purchase",
+			Currency:      "BRL",
+			PostedAt:      time.Now().UTC(),
+			Postings: []models.Posting{
+				{LedgerAccount: ledger.CustomerAccount(1, "BRL"), Amount: 50},
+				{LedgerAccount: ledger.SettlementAccount("BRL"), Amount: -50},
+			},
+		})
+		return err
+	})
+	// the seed's purchase 1 has its journal entry already
+	assert.Error(t, err)
+
+	_, err = repo.DB.Exec("UPDATE JournalEntries SET description = 'edited'")
+	assert.ErrorContains(t, err, "JournalEntries are append only")
+}
+
+func TestSQLiteNestedUnitOfWork(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	failed := errors.New("failed")
+	purchase := models.Transaction{AccountID: 1, OperationTypeID: 1, Amount: -10, Balance: -10, Currency: "BRL", OriginalAmount: -10, OriginalCurrency: "BRL", FXRate: 1, EventDate: time.Now().UTC(), PostingDate: time.Now().UTC()}
+
+	err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+		if _, err := tx.CreateTransaction(withPublicID(purchase)); err != nil {
+			return err
+		}
+
+		// rolled back to the savepoint, the purchase before it is kept
+		err := tx.WithinTx(context.Background(), func(nested store.TxRepo) error {
+			if _, err := nested.CreateTransaction(withPublicID(purchase)); err != nil {
+				return err
+			}
+			return failed
+		})
+		assert.Equal(t, failed, err)
+
+		// savepoints nest, and release into the transaction around them
+		return tx.WithinTx(context.Background(), func(nested store.TxRepo) error {
+			return nested.WithinTx(context.Background(), func(deeper store.TxRepo) error {
+				_, err := deeper.CreateTransaction(withPublicID(purchase))
+				return err
+			})
+		})
+	})
+	assert.NoError(t, err)
+
+	transactions, err := repo.ListAccountTransactions(1)
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 5)
+}
+
+// withPublicID is the transaction with a public id of its own, as the service
+// gives every transaction it posts
+func withPublicID(t models.Transaction) models.Transaction {
+	t.PublicID = models.PublicID(publicid.New(publicid.Transaction))
+	return t
+}
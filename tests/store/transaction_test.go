@@ -1,7 +1,10 @@
 package store
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -11,204 +14,267 @@ import (
 	"pismo/store"
 )
 
-func TestCreateTransactionWithTx(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
-
-	repo := &store.Repository{DB: db}
-
-	tests := []struct {
-		name           string
-		transaction    models.Transaction
-		mockSetup      func(sqlmock.Sqlmock)
-		expectedID     int64
-		expectedError  string
-	}{
-		{
-			name: "Successful transaction creation",
-			transaction: models.Transaction{
-				AccountID:       1,
-				OperationTypeID: 4,
-				Amount:          100.0,
-				Balance:         100.0,
+func TestCreateTransaction(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		tests := []struct {
+			name          string
+			transaction   models.Transaction
+			mockSetup     func(*dialectMock)
+			expectedID    int64
+			expectedError string
+		}{
+			{
+				name: "Successful transaction creation",
+				transaction: models.Transaction{
+					PublicID:         transactionPublicID,
+					AccountID:        1,
+					OperationTypeID:  4,
+					Amount:           100.0,
+					Balance:          100.0,
+					Currency:         "BRL",
+					OriginalAmount:   100.0,
+					OriginalCurrency: "BRL",
+					FXRate:           1.0,
+				},
+				mockSetup: func(mock *dialectMock) {
+					mock.ExpectBegin()
+					mock.ExpectInsert(`INSERT INTO Transactions \(public_id, account_id, operation_type_id, amount, balance, currency, original_amount, original_currency, fx_rate, event_date, posting_date\) VALUES \(\?, \?, \?, \?, \?, \?, \?, \?, \?, \?, \?\)`, "transaction_id").
+						WithArgs(transactionPublicID, 1, 4, 100.0, 100.0, "BRL", 100.0, "BRL", 1.0, sqlmock.AnyArg(), sqlmock.AnyArg()).
+						WillReturnID(1)
+				},
+				expectedID:    1,
+				expectedError: "",
 			},
-			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectExec(`INSERT INTO Transactions \(account_id, operation_type_id, amount, balance\) VALUES \(\?, \?, \?, \?\)`).
-					WithArgs(1, 4, 100.0, 100.0).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+			{
+				name: "Database error",
+				transaction: models.Transaction{
+					PublicID:         transactionPublicID,
+					AccountID:        2,
+					OperationTypeID:  1,
+					Amount:           -50.0,
+					Balance:          -50.0,
+					Currency:         "BRL",
+					OriginalAmount:   -50.0,
+					OriginalCurrency: "BRL",
+					FXRate:           1.0,
+				},
+				mockSetup: func(mock *dialectMock) {
+					mock.ExpectBegin()
+					mock.ExpectInsert(`INSERT INTO Transactions \(public_id, account_id, operation_type_id, amount, balance, currency, original_amount, original_currency, fx_rate, event_date, posting_date\) VALUES \(\?, \?, \?, \?, \?, \?, \?, \?, \?, \?, \?\)`, "transaction_id").
+						WithArgs(transactionPublicID, 2, 1, -50.0, -50.0, "BRL", -50.0, "BRL", 1.0, sqlmock.AnyArg(), sqlmock.AnyArg()).
+						WillReturnError(errors.New("db error"))
+				},
+				expectedID:    0,
+				expectedError: "db error",
 			},
-			expectedID:    1,
-			expectedError: "",
-		},
-		{
-			name: "Database error",
-			transaction: models.Transaction{
-				AccountID:       2,
-				OperationTypeID: 1,
-				Amount:          -50.0,
-				Balance:         -50.0,
-			},
-			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectExec(`INSERT INTO Transactions \(account_id, operation_type_id, amount, balance\) VALUES \(\?, \?, \?, \?\)`).
-					WithArgs(2, 1, -50.0, -50.0).
-					WillReturnError(errors.New("db error"))
-			},
-			expectedID:    0,
-			expectedError: "db error",
-		},
-	}
+		}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.mockSetup(mock)
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.mockSetup(mock)
+				mock.ExpectEnd(tt.expectedError != "")
 
-			tx, err := db.Begin()
-			assert.NoError(t, err)
+				var id int64
+				err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+					var err error
+					id, err = tx.CreateTransaction(tt.transaction)
+					return err
+				})
 
-			id, err := repo.CreateTransactionWithTx(tx, tt.transaction)
+				if tt.expectedError != "" {
+					assert.EqualError(t, err, tt.expectedError)
+				} else {
+					assert.NoError(t, err)
+				}
+				assert.Equal(t, tt.expectedID, id)
 
-			if tt.expectedError != "" {
-				assert.EqualError(t, err, tt.expectedError)
-			} else {
-				assert.NoError(t, err)
-			}
-			assert.Equal(t, tt.expectedID, id)
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Errorf("there were unfulfilled expectations: %s", err)
+				}
+			})
+		}
+	})
+}
 
-			if err := mock.ExpectationsWereMet(); err != nil {
-				t.Errorf("there were unfulfilled expectations: %s", err)
-			}
-		})
-	}
+// expectAllocation expects discharge to record how much of the credit went to the debit
+func expectAllocation(mock *dialectMock, accountID int64, debitID, creditID int64, amount float64) {
+	mock.ExpectExec(`INSERT INTO DischargeAllocations \(account_id, debit_transaction_id, credit_transaction_id, amount, allocated_at\) VALUES \(\?, \?, \?, \?, \?\)`).
+		WithArgs(accountID, debitID, creditID, amount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 }
 
-func TestProcessDischargeTransactionWithTx(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
-
-	repo := &store.Repository{DB: db}
-
-	tests := []struct {
-		name               string
-		depositTransaction models.Transaction
-		mockSetup          func(sqlmock.Sqlmock)
-		expectedError      string
-	}{
-		{
-			name: "Successful discharge - single transaction",
-			depositTransaction: models.Transaction{
-				ID:              1,
-				AccountID:       1,
-				OperationTypeID: 4,
-				Amount:          100.0,
-			},
-			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY event_date ASC`).
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "balance"}).AddRow(2, -50.0))
-				mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE transaction_id = \\?").
-					WithArgs(0.0, 2).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-				mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE transaction_id = \\?").
-					WithArgs(50.0, 1).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-			},
-			expectedError: "",
-		},
-		{
-			name: "Successful discharge - multiple transactions",
-			depositTransaction: models.Transaction{
-				ID:              1,
-				AccountID:       1,
-				OperationTypeID: 4,
-				Amount:          100.0,
+func TestProcessDischargeTransaction(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		tests := []struct {
+			name               string
+			depositTransaction models.Transaction
+			mockSetup          func(*dialectMock)
+			expectedDischarged float64
+			expectedError      string
+		}{
+			{
+				name: "Successful discharge - single transaction",
+				depositTransaction: models.Transaction{
+					ID:              1,
+					AccountID:       1,
+					OperationTypeID: 4,
+					Amount:          100.0,
+				},
+				mockSetup: func(mock *dialectMock) {
+					mock.ExpectBegin()
+					mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY posting_date ASC, transaction_id ASC`).
+						WithArgs(1).
+						WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "balance"}).AddRow(2, -50.0))
+					mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE account_id = \\? AND transaction_id = \\?").
+						WithArgs(0.0, 1, 2).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE account_id = \\? AND transaction_id = \\?").
+						WithArgs(50.0, 1, 1).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					expectAllocation(mock, 1, 2, 1, 50.0)
+					expectAuditAppend(mock, 1, 1, `[{"transaction_id":2,"before":-50,"after":0},{"transaction_id":1,"before":100,"after":50}]`)
+				},
+				expectedDischarged: 50.0,
+				expectedError:      "",
 			},
-			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY event_date ASC`).
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "balance"}).
-						AddRow(2, -30.0).
-						AddRow(3, -50.0).
-						AddRow(4, -40.0))
-				mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE transaction_id = \\?").
-					WithArgs(0.0, 2).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-				mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE transaction_id = \\?").
-					WithArgs(0.0, 3).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-				mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE transaction_id = \\?").
-					WithArgs(-20.0, 4).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-				mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE transaction_id = \\?").
-					WithArgs(0.0, 1).
-					WillReturnResult(sqlmock.NewResult(0, 1))
+			{
+				name: "Successful discharge - multiple transactions",
+				depositTransaction: models.Transaction{
+					ID:              1,
+					AccountID:       1,
+					OperationTypeID: 4,
+					Amount:          100.0,
+				},
+				mockSetup: func(mock *dialectMock) {
+					mock.ExpectBegin()
+					mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY posting_date ASC, transaction_id ASC`).
+						WithArgs(1).
+						WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "balance"}).
+							AddRow(2, -30.0).
+							AddRow(3, -50.0).
+							AddRow(4, -40.0))
+					mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE account_id = \\? AND transaction_id = \\?").
+						WithArgs(0.0, 1, 2).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE account_id = \\? AND transaction_id = \\?").
+						WithArgs(0.0, 1, 3).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE account_id = \\? AND transaction_id = \\?").
+						WithArgs(-20.0, 1, 4).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE account_id = \\? AND transaction_id = \\?").
+						WithArgs(0.0, 1, 1).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					expectAllocation(mock, 1, 2, 1, 30.0)
+					expectAllocation(mock, 1, 3, 1, 50.0)
+					expectAllocation(mock, 1, 4, 1, 20.0)
+					expectAuditAppend(mock, 1, 1, `[{"transaction_id":2,"before":-30,"after":0},{"transaction_id":3,"before":-50,"after":0},{"transaction_id":4,"before":-40,"after":-20},{"transaction_id":1,"before":100,"after":0}]`)
+				},
+				expectedDischarged: 100.0,
+				expectedError:      "",
 			},
-			expectedError: "",
-		},
-		{
-			name: "Query error",
-			depositTransaction: models.Transaction{
-				ID:              1,
-				AccountID:       1,
-				OperationTypeID: 4,
-				Amount:          100.0,
+			{
+				name: "Query error",
+				depositTransaction: models.Transaction{
+					ID:              1,
+					AccountID:       1,
+					OperationTypeID: 4,
+					Amount:          100.0,
+				},
+				mockSetup: func(mock *dialectMock) {
+					mock.ExpectBegin()
+					mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY posting_date ASC, transaction_id ASC`).
+						WithArgs(1).
+						WillReturnError(errors.New("query error"))
+				},
+				expectedError: "failed to query transactions: query error",
 			},
-			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY event_date ASC`).
-					WithArgs(1).
-					WillReturnError(errors.New("query error"))
+			{
+				name: "Update error",
+				depositTransaction: models.Transaction{
+					ID:              1,
+					AccountID:       1,
+					OperationTypeID: 4,
+					Amount:          100.0,
+				},
+				mockSetup: func(mock *dialectMock) {
+					mock.ExpectBegin()
+					mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY posting_date ASC, transaction_id ASC`).
+						WithArgs(1).
+						WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "balance"}).AddRow(2, -50.0))
+					mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE account_id = \\? AND transaction_id = \\?").
+						WithArgs(0.0, 1, 2).
+						WillReturnError(errors.New("update error"))
+				},
+				expectedError: "failed to update balance for transaction 2: update error",
 			},
-			expectedError: "failed to query transactions: query error",
-		},
-		{
-			name: "Update error",
-			depositTransaction: models.Transaction{
-				ID:              1,
-				AccountID:       1,
-				OperationTypeID: 4,
-				Amount:          100.0,
-			},
-			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0 ORDER BY event_date ASC`).
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "balance"}).AddRow(2, -50.0))
-				mock.ExpectExec("UPDATE Transactions SET balance = \\? WHERE transaction_id = \\?").
-					WithArgs(0.0, 2).
-					WillReturnError(errors.New("update error"))
-			},
-			expectedError: "failed to update balance for transaction 2: update error",
-		},
-	}
+		}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.mockSetup(mock)
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.mockSetup(mock)
+				mock.ExpectEnd(tt.expectedError != "")
 
-			tx, err := db.Begin()
-			assert.NoError(t, err)
+				var discharged float64
+				err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+					var err error
+					discharged, err = tx.ProcessDischargeTransaction(tt.depositTransaction)
+					return err
+				})
 
-			err = repo.ProcessDischargeTransactionWithTx(tx, tt.depositTransaction)
+				assert.Equal(t, tt.expectedDischarged, discharged)
+				if tt.expectedError != "" {
+					assert.EqualError(t, err, tt.expectedError)
+				} else {
+					assert.NoError(t, err)
+				}
 
-			if tt.expectedError != "" {
-				assert.EqualError(t, err, tt.expectedError)
-			} else {
-				assert.NoError(t, err)
-			}
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Errorf("there were unfulfilled expectations: %s", err)
+				}
+			})
+		}
+	})
+}
 
-			if err := mock.ExpectationsWereMet(); err != nil {
-				t.Errorf("there were unfulfilled expectations: %s", err)
+func TestDischargesOnDifferentAccountsDontBlock(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		// a credit of 100 paying off a debt of 50 on the account
+		expectDischarge := func(accountID int64, creditID, debitID int64) {
+			mock.ExpectQuery(`SELECT transaction_id, balance FROM Transactions WHERE account_id = \? AND operation_type_id < 4 AND balance < 0`).
+				WithArgs(accountID).
+				WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "balance"}).AddRow(debitID, -50.0))
+			mock.ExpectExec(`UPDATE Transactions SET balance = \? WHERE account_id = \? AND transaction_id = \?`).
+				WithArgs(0.0, accountID, debitID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(`UPDATE Transactions SET balance = \? WHERE account_id = \? AND transaction_id = \?`).
+				WithArgs(50.0, accountID, creditID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAllocation(mock, accountID, debitID, creditID, 50.0)
+			expectAuditAppend(mock, accountID, creditID, fmt.Sprintf(`[{"transaction_id":%d,"before":-50,"after":0},{"transaction_id":%d,"before":100,"after":50}]`, debitID, creditID))
+		}
+
+		// the second discharge runs start to finish while the first still holds
+		// the head of its account's audit chain. sqlmock has no row locks, what
+		// matters is that it locks nothing the first one holds, the head of
+		// chain 2 rather than one shared by every account.
+		mock.ExpectBegin()
+		expectDischarge(1, 1, 2)
+		mock.ExpectBegin()
+		expectDischarge(2, 3, 4)
+		mock.ExpectCommit()
+		mock.ExpectCommit()
+
+		err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+			if _, err := tx.ProcessDischargeTransaction(models.Transaction{ID: 1, AccountID: 1, OperationTypeID: 4, Amount: 100.0}); err != nil {
+				return err
 			}
+			return repo.WithinTx(context.Background(), func(other store.TxRepo) error {
+				_, err := other.ProcessDischargeTransaction(models.Transaction{ID: 3, AccountID: 2, OperationTypeID: 4, Amount: 100.0})
+				return err
+			})
 		})
-	}
-}
\ No newline at end of file
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"pismo/store"
+)
+
+func TestWithinTx(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *sql.DB, mock *dialectMock, repo *store.Repository) {
+		failed := errors.New("failed")
+
+		t.Run("Commits when fn succeeds", func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectCommit()
+
+			err := repo.WithinTx(context.Background(), func(store.TxRepo) error { return nil }, store.WithIsolation(store.Serializable))
+			assert.NoError(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+
+		t.Run("Rolls back when fn fails", func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			err := repo.WithinTx(context.Background(), func(store.TxRepo) error { return failed })
+			assert.Equal(t, failed, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+
+		t.Run("Rolls back and panics again when fn panics", func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			assert.PanicsWithValue(t, "boom", func() {
+				repo.WithinTx(context.Background(), func(store.TxRepo) error { panic("boom") })
+			})
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+
+		t.Run("Fails to begin", func(t *testing.T) {
+			mock.ExpectBegin().WillReturnError(errors.New("connection refused"))
+
+			err := repo.WithinTx(context.Background(), func(store.TxRepo) error {
+				t.Fatal("fn ran without a transaction")
+				return nil
+			})
+			assert.EqualError(t, err, "failed to begin transaction: connection refused")
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+
+		t.Run("Fails to commit", func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectCommit().WillReturnError(errors.New("connection reset"))
+
+			err := repo.WithinTx(context.Background(), func(store.TxRepo) error { return nil })
+			assert.EqualError(t, err, "failed to commit transaction: connection reset")
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+
+		t.Run("Fails to roll back", func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectRollback().WillReturnError(errors.New("connection reset"))
+
+			err := repo.WithinTx(context.Background(), func(store.TxRepo) error { return failed })
+			assert.ErrorIs(t, err, failed)
+			assert.ErrorContains(t, err, "failed to roll back transaction: connection reset")
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+
+		t.Run("Nested units of work run in savepoints", func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectExec(`SAVEPOINT sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`ROLLBACK TO SAVEPOINT sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`RELEASE SAVEPOINT sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`SAVEPOINT sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`SAVEPOINT sp_2`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`RELEASE SAVEPOINT sp_2`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`RELEASE SAVEPOINT sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+
+			err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+				// the failure stays inside its savepoint
+				err := tx.WithinTx(context.Background(), func(store.TxRepo) error { return failed })
+				assert.Equal(t, failed, err)
+
+				return tx.WithinTx(context.Background(), func(nested store.TxRepo) error {
+					return nested.WithinTx(context.Background(), func(store.TxRepo) error { return nil })
+				})
+			})
+			assert.NoError(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+
+		t.Run("Nested units of work take no options", func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			err := repo.WithinTx(context.Background(), func(tx store.TxRepo) error {
+				return tx.WithinTx(context.Background(), func(store.TxRepo) error { return nil }, store.ReadOnly())
+			})
+			assert.ErrorIs(t, err, store.ErrNestedTxOptions)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	})
+}
@@ -0,0 +1,315 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pismo/validation"
+)
+
+func TestValidateCPF(t *testing.T) {
+	tests := []struct {
+		name           string
+		documentNumber string
+		expectedResult string
+		expectedError  string
+	}{
+		{
+			name:           "Happy path: Bare CPF",
+			documentNumber: "12345678909",
+			expectedResult: "12345678909",
+		},
+		{
+			name:           "Happy path: Formatted CPF",
+			documentNumber: "529.982.247-25",
+			expectedResult: "52998224725",
+		},
+		{
+			name:           "Happy path: Surrounding whitespace is trimmed",
+			documentNumber: "  529.982.247-25 ",
+			expectedResult: "52998224725",
+		},
+		{
+			name:           "Happy path: Check digit remainder below two maps to zero",
+			documentNumber: "00000000191",
+			expectedResult: "00000000191",
+		},
+		{
+			name:           "Invalid first check digit",
+			documentNumber: "12345678919",
+			expectedError:  "invalid document number: CPF check digits do not match",
+		},
+		{
+			name:           "Invalid second check digit",
+			documentNumber: "12345678900",
+			expectedError:  "invalid document number: CPF check digits do not match",
+		},
+		{
+			name:           "Too short",
+			documentNumber: "1234567890",
+			expectedError:  "invalid document number: CPF must have 11 digits, got 10",
+		},
+		{
+			name:           "Too long, would overflow an int32",
+			documentNumber: "123456789091",
+			expectedError:  "invalid document number: CPF must have 11 digits, got 12",
+		},
+		{
+			name:           "Empty",
+			documentNumber: "",
+			expectedError:  "invalid document number: CPF must have 11 digits, got 0",
+		},
+		{
+			name:           "Repeated digits pass the checksum but are not valid",
+			documentNumber: "111.111.111-11",
+			expectedError:  "invalid document number: CPF cannot be a repeated digit sequence",
+		},
+		{
+			name:           "Letters are rejected",
+			documentNumber: "123.456.789-0X",
+			expectedError:  "invalid document number: unexpected character 'X'",
+		},
+		{
+			name:           "CNPJ separators are rejected",
+			documentNumber: "123.456.789/09",
+			expectedError:  "invalid document number: unexpected character '/'",
+		},
+		{
+			name:           "Negative numbers are rejected",
+			documentNumber: "+12345678909",
+			expectedError:  "invalid document number: unexpected character '+'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := validation.ValidateCPF(tt.documentNumber)
+
+			assert.Equal(t, tt.expectedResult, result)
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.expectedError)
+				assert.True(t, errors.Is(err, validation.ErrInvalidDocument))
+			}
+		})
+	}
+}
+
+func TestValidateCPFRejectsEveryWrongCheckDigit(t *testing.T) {
+	valid := "52998224725"
+	for position := 9; position < 11; position++ {
+		for digit := '0'; digit <= '9'; digit++ {
+			if rune(valid[position]) == digit {
+				continue
+			}
+			candidate := valid[:position] + string(digit) + valid[position+1:]
+			_, err := validation.ValidateCPF(candidate)
+			assert.ErrorIs(t, err, validation.ErrInvalidDocument, candidate)
+		}
+	}
+}
+
+func TestValidateCNPJ(t *testing.T) {
+	tests := []struct {
+		name           string
+		documentNumber string
+		expectedResult string
+		expectedError  string
+	}{
+		{
+			name:           "Happy path: Bare CNPJ",
+			documentNumber: "11222333000181",
+			expectedResult: "11222333000181",
+		},
+		{
+			name:           "Happy path: Formatted CNPJ",
+			documentNumber: "11.444.777/0001-61",
+			expectedResult: "11444777000161",
+		},
+		{
+			name:           "Invalid first check digit",
+			documentNumber: "11222333000191",
+			expectedError:  "invalid document number: CNPJ check digits do not match",
+		},
+		{
+			name:           "Invalid second check digit",
+			documentNumber: "11222333000182",
+			expectedError:  "invalid document number: CNPJ check digits do not match",
+		},
+		{
+			name:           "CPF length is rejected",
+			documentNumber: "12345678909",
+			expectedError:  "invalid document number: CNPJ must have 14 digits, got 11",
+		},
+		{
+			name:           "Repeated digits",
+			documentNumber: "00.000.000/0000-00",
+			expectedError:  "invalid document number: CNPJ cannot be a repeated digit sequence",
+		},
+		{
+			name:           "Unexpected separator",
+			documentNumber: "11_222_333/0001-81",
+			expectedError:  "invalid document number: unexpected character '_'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := validation.ValidateCNPJ(tt.documentNumber)
+
+			assert.Equal(t, tt.expectedResult, result)
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.expectedError)
+				assert.True(t, errors.Is(err, validation.ErrInvalidDocument))
+			}
+		})
+	}
+}
+
+func TestValidateCNPJRejectsEveryWrongCheckDigit(t *testing.T) {
+	valid := "11444777000161"
+	for position := 12; position < 14; position++ {
+		for digit := '0'; digit <= '9'; digit++ {
+			if rune(valid[position]) == digit {
+				continue
+			}
+			candidate := valid[:position] + string(digit) + valid[position+1:]
+			_, err := validation.ValidateCNPJ(candidate)
+			assert.ErrorIs(t, err, validation.ErrInvalidDocument, candidate)
+		}
+	}
+}
+
+func TestValidatePassport(t *testing.T) {
+	tests := []struct {
+		name           string
+		documentNumber string
+		expectedResult string
+		expectedError  string
+	}{
+		{
+			name:           "Happy path: Alphanumeric passport",
+			documentNumber: "X1234567",
+			expectedResult: "X1234567",
+		},
+		{
+			name:           "Happy path: Lower case and spaces are normalised",
+			documentNumber: " fd 123456 ",
+			expectedResult: "FD123456",
+		},
+		{
+			name:           "Too short",
+			documentNumber: "X1234",
+			expectedError:  "invalid document number: passport number must have between 6 and 9 characters",
+		},
+		{
+			name:           "Too long",
+			documentNumber: "X123456789",
+			expectedError:  "invalid document number: passport number must have between 6 and 9 characters",
+		},
+		{
+			name:           "Punctuation is rejected",
+			documentNumber: "X123-4567",
+			expectedError:  "invalid document number: passport number must be alphanumeric",
+		},
+		{
+			name:           "Non ascii letters are rejected",
+			documentNumber: "Ñ1234567",
+			expectedError:  "invalid document number: passport number must be alphanumeric",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := validation.ValidatePassport(tt.documentNumber)
+
+			assert.Equal(t, tt.expectedResult, result)
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func TestNormalizeDocument(t *testing.T) {
+	tests := []struct {
+		name           string
+		documentType   string
+		documentNumber string
+		expectedResult string
+		expectedError  error
+	}{
+		{
+			name:           "CPF",
+			documentType:   "CPF",
+			documentNumber: "123.456.789-09",
+			expectedResult: "12345678909",
+		},
+		{
+			name:           "Document type is case insensitive",
+			documentType:   "cnpj",
+			documentNumber: "11.222.333/0001-81",
+			expectedResult: "11222333000181",
+		},
+		{
+			name:           "Passport",
+			documentType:   "PASSPORT",
+			documentNumber: "x1234567",
+			expectedResult: "X1234567",
+		},
+		{
+			name:           "Invalid CPF",
+			documentType:   "CPF",
+			documentNumber: "12345678900",
+			expectedError:  validation.ErrInvalidDocument,
+		},
+		{
+			name:           "Unsupported document type",
+			documentType:   "SSN",
+			documentNumber: "123456789",
+			expectedError:  validation.ErrUnsupportedDocumentType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := validation.NormalizeDocument(tt.documentType, tt.documentNumber)
+
+			assert.Equal(t, tt.expectedResult, result)
+			if tt.expectedError == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func TestRegisterDocumentType(t *testing.T) {
+	_, err := validation.NormalizeDocument("RUT", "12.345.678-5")
+	assert.ErrorIs(t, err, validation.ErrUnsupportedDocumentType)
+
+	validation.RegisterDocumentType("rut", func(documentNumber string) (string, error) {
+		normalized := strings.NewReplacer(".", "", "-", "").Replace(documentNumber)
+		if len(normalized) != 9 {
+			return "", fmt.Errorf("%w: RUT must have 9 characters", validation.ErrInvalidDocument)
+		}
+		return normalized, nil
+	})
+
+	result, err := validation.NormalizeDocument("RUT", "12.345.678-5")
+	assert.NoError(t, err)
+	assert.Equal(t, "123456785", result)
+
+	_, err = validation.NormalizeDocument("RUT", "1234")
+	assert.ErrorIs(t, err, validation.ErrInvalidDocument)
+}
@@ -0,0 +1,142 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"pismo/models"
+)
+
+var (
+	ErrInvalidDocument         = errors.New("invalid document number")
+	ErrUnsupportedDocumentType = errors.New("unsupported document type")
+)
+
+// DocumentValidator validates a raw document number and returns it in its
+// normalised form, which is the form that gets stored and compared
+type DocumentValidator func(documentNumber string) (string, error)
+
+// the validator of each document type, new ones are added through RegisterDocumentType
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]DocumentValidator{
+		models.DocumentTypeCPF:      ValidateCPF,
+		models.DocumentTypeCNPJ:     ValidateCNPJ,
+		models.DocumentTypePassport: ValidatePassport,
+	}
+)
+
+// RegisterDocumentType adds (or replaces) the validator used for a document type
+func RegisterDocumentType(documentType string, validator DocumentValidator) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToUpper(documentType)] = validator
+}
+
+// NormalizeDocument validates the document number against the rules of its
+// type and returns the normalised number
+func NormalizeDocument(documentType, documentNumber string) (string, error) {
+	registryMu.RLock()
+	validator, ok := registry[strings.ToUpper(documentType)]
+	registryMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedDocumentType, documentType)
+	}
+	return validator(documentNumber)
+}
+
+// ValidateCPF accepts a CPF either bare ("12345678909") or formatted
+// ("123.456.789-09") and verifies both check digits
+func ValidateCPF(documentNumber string) (string, error) {
+	digits, err := stripFormatting(documentNumber, ".-")
+	if err != nil {
+		return "", err
+	}
+	if len(digits) != 11 {
+		return "", fmt.Errorf("%w: CPF must have 11 digits, got %d", ErrInvalidDocument, len(digits))
+	}
+	if allSameDigit(digits) {
+		return "", fmt.Errorf("%w: CPF cannot be a repeated digit sequence", ErrInvalidDocument)
+	}
+
+	first := checkDigit(digits[:9], []int{10, 9, 8, 7, 6, 5, 4, 3, 2})
+	second := checkDigit(digits[:10], []int{11, 10, 9, 8, 7, 6, 5, 4, 3, 2})
+	if digits[9] != first || digits[10] != second {
+		return "", fmt.Errorf("%w: CPF check digits do not match", ErrInvalidDocument)
+	}
+	return digits, nil
+}
+
+// ValidateCNPJ accepts a CNPJ either bare ("11222333000181") or formatted
+// ("11.222.333/0001-81") and verifies both check digits
+func ValidateCNPJ(documentNumber string) (string, error) {
+	digits, err := stripFormatting(documentNumber, "./-")
+	if err != nil {
+		return "", err
+	}
+	if len(digits) != 14 {
+		return "", fmt.Errorf("%w: CNPJ must have 14 digits, got %d", ErrInvalidDocument, len(digits))
+	}
+	if allSameDigit(digits) {
+		return "", fmt.Errorf("%w: CNPJ cannot be a repeated digit sequence", ErrInvalidDocument)
+	}
+
+	first := checkDigit(digits[:12], []int{5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2})
+	second := checkDigit(digits[:13], []int{6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2})
+	if digits[12] != first || digits[13] != second {
+		return "", fmt.Errorf("%w: CNPJ check digits do not match", ErrInvalidDocument)
+	}
+	return digits, nil
+}
+
+// ValidatePassport only checks the shape of the number, passports have no
+// check digit we can verify without the full machine readable zone
+func ValidatePassport(documentNumber string) (string, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(documentNumber), " ", ""))
+	if len(normalized) < 6 || len(normalized) > 9 {
+		return "", fmt.Errorf("%w: passport number must have between 6 and 9 characters", ErrInvalidDocument)
+	}
+	for _, c := range normalized {
+		if c > unicode.MaxASCII || !(unicode.IsDigit(c) || unicode.IsLetter(c)) {
+			return "", fmt.Errorf("%w: passport number must be alphanumeric", ErrInvalidDocument)
+		}
+	}
+	return normalized, nil
+}
+
+// stripFormatting removes surrounding whitespace and the given separators,
+// anything else that isn't a digit is rejected
+func stripFormatting(documentNumber, separators string) (string, error) {
+	var b strings.Builder
+	for _, c := range strings.TrimSpace(documentNumber) {
+		switch {
+		case c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case strings.ContainsRune(separators, c):
+			continue
+		default:
+			return "", fmt.Errorf("%w: unexpected character %q", ErrInvalidDocument, c)
+		}
+	}
+	return b.String(), nil
+}
+
+func allSameDigit(digits string) bool {
+	return strings.Count(digits, digits[:1]) == len(digits)
+}
+
+// checkDigit computes a mod 11 check digit over the digits using the given weights
+func checkDigit(digits string, weights []int) byte {
+	sum := 0
+	for i, w := range weights {
+		sum += int(digits[i]-'0') * w
+	}
+	remainder := sum % 11
+	if remainder < 2 {
+		return '0'
+	}
+	return byte('0' + 11 - remainder)
+}